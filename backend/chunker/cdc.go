@@ -0,0 +1,676 @@
+package chunker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// In the content-defined chunking (cdc) mode files are split at
+// boundaries found by a rolling hash of their contents, so inserting
+// or removing data only changes the chunks around the edit.
+//
+// Data chunks are named by the SHA-256 of their contents and kept in
+// a chunk pool shared by all files on the wrapped remote, so equal
+// chunks are stored only once. The pool is a directory (by default
+// `.rclone_chunk_pool` in the root of the wrapped remote) with data
+// chunks named like `ab/abcdef...` after their hash.
+//
+// A composite file has a meta object of version 3 with the `cdc` field
+// set and a control chunk of type "cdc" next to it listing the pool
+// chunks in order. The list can be big, that's why it doesn't fit in
+// the meta object. Small files are stored as usual non-chunked files.
+//
+// Removing a file removes its meta object and list but leaves its
+// data chunks in the pool as other files may share them. The "gc"
+// backend command removes chunks that no list refers to and which
+// haven't been used recently. While a file is uploaded its list is
+// kept up to date under a temporary name and the chunks it shares
+// with other files have their modification time refreshed, so gc
+// leaves alone the chunks of uploads in progress.
+
+// control chunk type of the chunk list
+const ctrlTypeCDC = "cdc"
+
+// hash used to name chunks in the pool, kept in metadata
+const cdcHashType = "sha256"
+
+// Version of the chunk list format
+const cdcListVersion = 1
+
+// Smallest average chunk size allowed in the cdc mode
+const minCDCChunkSize = 1024
+
+// How often the temporary chunk list of an upload is rewritten
+var cdcListInterval = time.Minute
+
+// Chunks in the cdc mode are at least cdcMinRatio times smaller
+// and at most cdcMaxRatio times larger than average.
+const (
+	cdcMinRatio = 4
+	cdcMaxRatio = 4
+)
+
+// cdcRef refers to a data chunk in the pool
+type cdcRef struct {
+	Hash string `json:"h"` // SHA-256 of the chunk in hex
+	Size int64  `json:"s"` // size of the chunk
+}
+
+// cdcList is the contents of the chunk list control chunk
+type cdcList struct {
+	Version int      `json:"ver"`
+	Chunks  []cdcRef `json:"chunks"`
+}
+
+// cdcGear holds the random values of the gear rolling hash.
+//
+// They are made by a fixed generator so must never be changed,
+// chunks of files already in the pool would stop being shared.
+var cdcGear = func() (gear [256]uint64) {
+	// splitmix64
+	seed := uint64(0x72636c6f6e65) // "rclone"
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+	return gear
+}()
+
+// cdcSplitter splits a stream in content-defined chunks using
+// the gear hash with normalized chunking as in FastCDC.
+type cdcSplitter struct {
+	in      io.Reader
+	buf     []byte
+	n       int    // bytes in buf
+	cut     int    // bytes at the start of buf returned by the last next
+	eof     bool   // set when in is exhausted
+	minSize int    // no boundary is looked for before this
+	avgSize int    // the harder mask is used before this
+	maskS   uint64 // mask used before avgSize
+	maskL   uint64 // mask used after avgSize
+}
+
+// newCDCSplitter makes a splitter for chunks of avgSize on average
+func newCDCSplitter(in io.Reader, avgSize int64) *cdcSplitter {
+	level := bits.Len64(uint64(avgSize)) - 1 // log2 of avgSize
+	return &cdcSplitter{
+		in:      in,
+		buf:     make([]byte, avgSize*cdcMaxRatio),
+		minSize: int(avgSize / cdcMinRatio),
+		avgSize: int(avgSize),
+		maskS:   math.MaxUint64 << (64 - level - 1),
+		maskL:   math.MaxUint64 << (64 - level + 1),
+	}
+}
+
+// next returns the next chunk which is valid until the next call
+// or io.EOF at the end of the stream.
+func (s *cdcSplitter) next() ([]byte, error) {
+	s.n = copy(s.buf, s.buf[s.cut:s.n])
+	s.cut = 0
+	if !s.eof {
+		// always fill the buffer so boundaries don't depend on read sizes
+		n, err := io.ReadFull(s.in, s.buf[s.n:])
+		s.n += n
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			s.eof = true
+		default:
+			return nil, err
+		}
+	}
+	if s.n == 0 {
+		return nil, io.EOF
+	}
+	s.cut = s.boundary(s.buf[:s.n])
+	return s.buf[:s.cut], nil
+}
+
+// boundary returns the length of the first chunk of data
+func (s *cdcSplitter) boundary(data []byte) int {
+	size := len(data)
+	if size <= s.minSize {
+		return size
+	}
+	normal := s.avgSize
+	if normal > size {
+		normal = size
+	}
+	var h uint64
+	i := s.minSize
+	for ; i < normal; i++ {
+		h = (h << 1) + cdcGear[data[i]]
+		if h&s.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < size; i++ {
+		h = (h << 1) + cdcGear[data[i]]
+		if h&s.maskL == 0 {
+			return i + 1
+		}
+	}
+	return size
+}
+
+// setChunkMode must be called *after* setMetaFormat.
+func (f *Fs) setChunkMode(chunkMode string) error {
+	switch chunkMode {
+	case "fixed":
+		f.useCDC = false
+	case "cdc":
+		if !f.useMeta {
+			return errors.New("cdc chunk mode requires metadata")
+		}
+		if f.opt.CDCChunkSize < minCDCChunkSize {
+			return fmt.Errorf("cdc chunk size must be at least %v", fs.SizeSuffix(minCDCChunkSize))
+		}
+		f.useCDC = true
+	default:
+		return fmt.Errorf("unsupported chunk mode '%s'", chunkMode)
+	}
+	return nil
+}
+
+// poolDirIn returns the path of the chunk pool relative to the
+// wrapped remote at root or "" if the pool is not inside it.
+func (f *Fs) poolDirIn(root string) string {
+	poolPath := fspath.JoinRootPath(f.basePath, f.opt.CDCPool)
+	prefix := root
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if !strings.HasPrefix(poolPath, prefix) {
+		return ""
+	}
+	return poolPath[len(prefix):]
+}
+
+// isPoolPath returns true if remote is the chunk pool or inside it
+func (f *Fs) isPoolPath(remote string) bool {
+	return f.poolDir != "" && (remote == f.poolDir || strings.HasPrefix(remote, f.poolDir+"/"))
+}
+
+// getPool returns the chunk pool, making it on first use
+func (f *Fs) getPool(ctx context.Context) (fs.Fs, error) {
+	f.poolMu.Lock()
+	defer f.poolMu.Unlock()
+	if f.pool != nil {
+		return f.pool, nil
+	}
+	poolRemote := f.baseName + fspath.JoinRootPath(f.basePath, f.opt.CDCPool)
+	pool, err := cache.Get(ctx, poolRemote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make chunk pool %q: %w", poolRemote, err)
+	}
+	cache.Pin(pool) // unpinned by Shutdown
+	f.pool = pool
+	return pool, nil
+}
+
+// cdcChunkPath returns the path of a chunk in the pool
+func cdcChunkPath(chunkHash string) string {
+	return chunkHash[:2] + "/" + chunkHash
+}
+
+// isCDCHash checks that name looks like a chunk in the pool
+func isCDCHash(name string) bool {
+	if len(name) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// putPoolChunk uploads data to the pool unless an equal chunk is
+// there, in which case it refreshes its modification time so gc
+// doesn't remove it before the chunk list is written.
+func (f *Fs) putPoolChunk(ctx context.Context, pool fs.Fs, data []byte) (cdcRef, error) {
+	sum := sha256.Sum256(data)
+	ref := cdcRef{
+		Hash: hex.EncodeToString(sum[:]),
+		Size: int64(len(data)),
+	}
+	remote := cdcChunkPath(ref.Hash)
+	modTime := time.Now()
+	info := object.NewStaticObjectInfo(remote, modTime, ref.Size, true, nil, nil)
+	existing, err := pool.NewObject(ctx, remote)
+	switch {
+	case err == nil && existing.Size() == ref.Size:
+		err = existing.SetModTime(ctx, modTime)
+		if err == nil {
+			return ref, nil
+		}
+		fs.Debugf(existing, "Uploading pool chunk again as can't refresh its modification time: %v", err)
+		if err = existing.Update(ctx, bytes.NewReader(data), info); err != nil {
+			return ref, fmt.Errorf("failed to update pool chunk: %w", err)
+		}
+		return ref, nil
+	case err == nil:
+		fs.Errorf(existing, "Replacing pool chunk of wrong size %d != %d", existing.Size(), ref.Size)
+	case err != fs.ErrorObjectNotFound:
+		return ref, err
+	}
+	if _, err = pool.Put(ctx, bytes.NewReader(data), info); err != nil {
+		return ref, fmt.Errorf("failed to put pool chunk: %w", err)
+	}
+	return ref, nil
+}
+
+// putCDC implements put in the cdc chunk mode
+func (f *Fs) putCDC(ctx context.Context, in io.Reader, src fs.ObjectInfo, remote string, basePut putFn) (obj fs.Object, err error) {
+	pool, err := f.getPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c := f.newChunkingReader(src)
+	c.chunkLimit = math.MaxInt64 // chunk boundaries are defined by content
+	wrapIn := c.wrapStream(ctx, in, src)
+	splitter := newCDCSplitter(wrapIn, int64(f.opt.CDCChunkSize))
+
+	var (
+		listObject fs.Object
+		tempRemote string
+		refs       []cdcRef
+	)
+	defer func() {
+		if err != nil && listObject != nil {
+			silentlyRemove(ctx, listObject)
+		}
+	}()
+
+	// writeList writes the chunk list under a temporary name so gc
+	// can see the chunks used so far
+	writeList := func() error {
+		if tempRemote == "" {
+			xactID, err := f.newXactID(ctx, remote)
+			if err != nil {
+				return err
+			}
+			tempRemote = f.makeChunkName(remote, -1, ctrlTypeCDC, xactID)
+		}
+		list, err := json.Marshal(&cdcList{Version: cdcListVersion, Chunks: refs})
+		if err != nil {
+			return err
+		}
+		listInfo := f.wrapInfo(src, tempRemote, int64(len(list)))
+		if listObject == nil {
+			listObject, err = basePut(ctx, bytes.NewReader(list), listInfo)
+			return err
+		}
+		return listObject.Update(ctx, bytes.NewReader(list), listInfo)
+	}
+
+	// Transfer chunks to the pool. The last chunk is held back
+	// so that single chunk files can be stored as usual files.
+	var pending []byte
+	listWritten := time.Now()
+	for {
+		data, errNext := splitter.next()
+		if errNext == io.EOF {
+			break
+		}
+		if errNext != nil {
+			return nil, errNext
+		}
+		if pending != nil {
+			if len(refs) >= maxSafeChunkNumber {
+				return nil, ErrChunkOverflow
+			}
+			ref, errPut := f.putPoolChunk(ctx, pool, pending)
+			if errPut != nil {
+				return nil, errPut
+			}
+			refs = append(refs, ref)
+			if time.Since(listWritten) >= cdcListInterval {
+				if err = writeList(); err != nil {
+					return nil, err
+				}
+				listWritten = time.Now()
+			}
+		}
+		pending = append(pending[:0], data...)
+	}
+
+	// Validate uploaded size
+	if c.sizeTotal != -1 && c.readCount != c.sizeTotal {
+		return nil, fmt.Errorf("incorrect upload size %d != %d", c.readCount, c.sizeTotal)
+	}
+
+	if len(refs) == 0 {
+		// Check for input that looks like valid metadata
+		needMeta := false
+		if len(pending) <= maxMetadataSize {
+			_, needMeta, _ = unmarshalSimpleJSON(ctx, nil, pending)
+		}
+
+		// Finalize small object as non-chunked.
+		if !needMeta && !f.hashAll {
+			f.removeOldChunks(ctx, remote)
+			info := f.wrapInfo(src, remote, int64(len(pending)))
+			smallObject, err := basePut(ctx, bytes.NewReader(pending), info)
+			if err != nil {
+				return nil, err
+			}
+			return f.newObject("", smallObject, nil), nil
+		}
+	}
+	ref, err := f.putPoolChunk(ctx, pool, pending)
+	if err != nil {
+		return nil, err
+	}
+	refs = append(refs, ref)
+
+	// Upload the complete chunk list under the temporary name
+	if err = writeList(); err != nil {
+		return nil, err
+	}
+
+	// If previous object was chunked, remove its chunks and list
+	f.removeOldChunks(ctx, remote)
+
+	listRemote := f.makeChunkName(remote, -1, ctrlTypeCDC, "")
+	listMoved, err := f.baseMove(ctx, listObject, listRemote, delFailed)
+	if err != nil {
+		return nil, err
+	}
+	listObject = listMoved
+
+	// Update meta object
+	c.updateHashes()
+	metadata, err := marshalSimpleJSON(ctx, c.readCount, len(refs), c.md5, c.sha1, "", true)
+	if err != nil {
+		return nil, err
+	}
+	metaInfo := f.wrapInfo(src, remote, int64(len(metadata)))
+	metaObject, err := basePut(ctx, bytes.NewReader(metadata), metaInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	o := f.newObject("", metaObject, nil)
+	o.cdcList = listObject
+	o.cdcRefs = refs
+	o.size = c.readCount
+	return o, nil
+}
+
+// readCDCList reads the chunk list from its control chunk
+func readCDCList(ctx context.Context, listObject fs.Object) ([]cdcRef, error) {
+	reader, err := listObject.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(reader)
+	_ = reader.Close() // ensure file handle is freed on windows
+	if err != nil {
+		return nil, err
+	}
+	var list cdcList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid chunk list: %w", err)
+	}
+	if list.Version < 1 || list.Version > cdcListVersion {
+		return nil, ErrMetaUnknown
+	}
+	for _, ref := range list.Chunks {
+		if !isCDCHash(ref.Hash) || ref.Size < 0 {
+			return nil, errors.New("invalid chunk list entry")
+		}
+	}
+	return list.Chunks, nil
+}
+
+// readCDCRefs reads and caches the pool chunks of a composite
+// file in the cdc mode, checking them against its metadata.
+func (o *Object) readCDCRefs(ctx context.Context) ([]cdcRef, error) {
+	if o.cdcRefs != nil {
+		return o.cdcRefs, nil
+	}
+	refs, err := readCDCList(ctx, o.cdcList)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, ref := range refs {
+		size += ref.Size
+	}
+	if len(refs) != o.cdcCount || size != o.size {
+		return nil, errors.New("chunk list doesn't match metadata")
+	}
+	o.cdcRefs = refs
+	return refs, nil
+}
+
+// poolChunk opens a data chunk in the pool on demand
+type poolChunk struct {
+	pool fs.Fs
+	ref  cdcRef
+}
+
+// Size returns the size of the chunk
+func (c *poolChunk) Size() int64 {
+	return c.ref.Size
+}
+
+// Open opens the chunk for read
+func (c *poolChunk) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	chunk, err := c.pool.NewObject(ctx, cdcChunkPath(c.ref.Hash))
+	if err != nil {
+		return nil, fmt.Errorf("can't find pool chunk %s: %w", c.ref.Hash, err)
+	}
+	if chunk.Size() != c.ref.Size {
+		return nil, fmt.Errorf("pool chunk %s has wrong size %d != %d", c.ref.Hash, chunk.Size(), c.ref.Size)
+	}
+	return chunk.Open(ctx, options...)
+}
+
+// cdcChunks returns the pool chunks of a composite file in the cdc mode
+func (o *Object) cdcChunks(ctx context.Context) ([]chunkOpener, error) {
+	refs, err := o.readCDCRefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := o.f.getPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]chunkOpener, len(refs))
+	for i, ref := range refs {
+		chunks[i] = &poolChunk{pool: pool, ref: ref}
+	}
+	return chunks, nil
+}
+
+// copyOrMoveCDC implements copy or move of a composite file in the
+// cdc mode. Only the list and meta object are copied or moved, data
+// chunks stay shared in the pool.
+func (f *Fs) copyOrMoveCDC(ctx context.Context, o *Object, remote string, do copyMoveFn, opName string) (fs.Object, error) {
+	fs.Debugf(o, "%s chunk list...", opName)
+	f.removeOldChunks(ctx, remote)
+	listRemote := f.makeChunkName(remote, -1, ctrlTypeCDC, "")
+	newList, err := do(ctx, o.cdcList, listRemote)
+	if err != nil {
+		return nil, err
+	}
+	newMeta, err := do(ctx, o.main, remote)
+	if err != nil {
+		silentlyRemove(ctx, newList)
+		return nil, err
+	}
+	newObj := f.newObject(remote, newMeta, nil)
+	newObj.cdcList = newList
+	newObj.cdcRefs = o.cdcRefs
+	newObj.cdcCount = o.cdcCount
+	newObj.size = o.size
+	newObj.md5 = o.md5
+	newObj.sha1 = o.sha1
+	newObj.isFull = true
+	return newObj, nil
+}
+
+// GCStats is returned by the gc command
+type GCStats struct {
+	Lists        int   `json:"lists"`         // chunk lists read
+	Chunks       int   `json:"chunks"`        // chunks found in the pool
+	Referenced   int   `json:"referenced"`    // chunks referred to by a list
+	Young        int   `json:"young"`         // unreferenced chunks kept as newer than min-age
+	Removed      int   `json:"removed"`       // unreferenced chunks removed
+	RemovedBytes int64 `json:"removed_bytes"` // size of the removed chunks
+}
+
+// gc removes chunks from the pool which are not referred to by any
+// chunk list on the wrapped remote and were last used more than
+// minAge before it started reading the lists.
+func (f *Fs) gc(ctx context.Context, minAge time.Duration) (stats GCStats, err error) {
+	pool, err := f.getPool(ctx)
+	if err != nil {
+		return stats, err
+	}
+	baseRemote := f.baseName + f.basePath
+	baseRoot, err := cache.Get(ctx, baseRemote)
+	if err != nil {
+		return stats, fmt.Errorf("failed to make remote %q to scan: %w", baseRemote, err)
+	}
+	poolDir := f.poolDirIn(f.basePath)
+
+	// Chunks used by an upload after this are newer than the
+	// cutoff even if its list isn't seen.
+	cutoff := time.Now().Add(-minAge)
+
+	// Mark chunks referred to by lists, including temporary lists
+	// of uploads which may be running now.
+	referenced := make(map[string]bool)
+	err = walk.ListR(ctx, baseRoot, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			listObject, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			remote := listObject.Remote()
+			if poolDir != "" && strings.HasPrefix(remote, poolDir+"/") {
+				continue
+			}
+			if _, _, ctrlType, _ := f.parseChunkName(remote); ctrlType != ctrlTypeCDC {
+				continue
+			}
+			refs, err := readCDCList(ctx, listObject)
+			if err != nil {
+				return fmt.Errorf("can't read chunk list %q: %w", remote, err)
+			}
+			stats.Lists++
+			for _, ref := range refs {
+				referenced[ref.Hash] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// Sweep the pool
+	err = walk.ListR(ctx, pool, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			chunk, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			chunkHash := path.Base(chunk.Remote())
+			if !isCDCHash(chunkHash) || chunk.Remote() != cdcChunkPath(chunkHash) {
+				fs.Debugf(chunk, "Ignoring unknown file in the chunk pool")
+				continue
+			}
+			stats.Chunks++
+			switch {
+			case referenced[chunkHash]:
+				stats.Referenced++
+			case chunk.ModTime(ctx).After(cutoff):
+				stats.Young++
+			default:
+				if err := operations.DeleteFile(ctx, chunk); err != nil {
+					return err
+				}
+				stats.Removed++
+				stats.RemovedBytes += chunk.Size()
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "gc",
+	Short: "Remove unused chunks from the chunk pool",
+	Long: `In the cdc chunk mode removing or updating a file leaves its data
+chunks in the chunk pool as other files may share them. This reads the
+chunk lists of all the files on the wrapped remote and removes chunks
+from the pool which none of them refer to.
+
+    rclone backend gc chunker:
+    rclone backend gc chunker: -o min-age=24h
+    rclone rc backend/command command=gc fs=chunker:
+
+Chunks used more recently than min-age (default 1h) are kept as they
+may belong to an upload which hasn't written its chunk list yet.
+Uploads write their chunk list under a temporary name at least every
+minute, so min-age shouldn't be less than that while files are being
+uploaded. Use --dry-run to see what would be removed.
+
+It returns the number of chunk lists read, chunks found in the pool,
+chunks referenced, chunks kept for being newer than min-age, and the
+number and size of the chunks removed.
+`,
+	Opts: map[string]string{
+		"min-age": "Only remove chunks older than this (default 1h)",
+	},
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "gc":
+		minAge := time.Hour
+		if value, ok := opt["min-age"]; ok {
+			minAge, err = fs.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid min-age: %w", err)
+			}
+		}
+		return f.gc(ctx, minAge)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
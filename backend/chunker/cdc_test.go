@@ -0,0 +1,263 @@
+package chunker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func splitAll(t *testing.T, in io.Reader, avgSize int64) (chunks [][]byte) {
+	s := newCDCSplitter(in, avgSize)
+	for {
+		chunk, err := s.next()
+		if err == io.EOF {
+			return chunks
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+func TestCDCSplitter(t *testing.T) {
+	const avgSize = 4096
+	data := randomData(1, 1024*1024)
+
+	chunks := splitAll(t, bytes.NewReader(data), avgSize)
+	assert.Equal(t, data, bytes.Join(chunks, nil))
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), avgSize*cdcMaxRatio)
+		if i < len(chunks)-1 {
+			assert.Greater(t, len(chunk), avgSize/cdcMinRatio)
+		}
+	}
+	mean := len(data) / len(chunks)
+	assert.Greater(t, mean, avgSize/2)
+	assert.Less(t, mean, avgSize*2)
+
+	// boundaries must not depend on the size of reads
+	assert.Equal(t, chunks, splitAll(t, iotest.OneByteReader(bytes.NewReader(data)), avgSize))
+
+	// inserting data at the start changes only the first chunks
+	shifted := append([]byte("inserted"), data...)
+	seen := make(map[string]bool)
+	for _, chunk := range chunks {
+		seen[string(chunk)] = true
+	}
+	shared := 0
+	for _, chunk := range splitAll(t, bytes.NewReader(shifted), avgSize) {
+		if seen[string(chunk)] {
+			shared++
+		}
+	}
+	assert.GreaterOrEqual(t, shared, len(chunks)-2)
+
+	// empty input has no chunks
+	assert.Empty(t, splitAll(t, bytes.NewReader(nil), avgSize))
+}
+
+func countPoolChunks(ctx context.Context, t *testing.T, f *Fs) (count int) {
+	pool, err := f.getPool(ctx)
+	require.NoError(t, err)
+	err = walk.ListR(ctx, pool, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		count += len(entries)
+		return nil
+	})
+	if err == fs.ErrorDirNotFound {
+		err = nil
+	}
+	require.NoError(t, err)
+	return count
+}
+
+func checkObjectContents(ctx context.Context, t *testing.T, obj fs.Object, want []byte) {
+	r, err := obj.Open(ctx)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, want, got)
+
+	r, err = obj.Open(ctx, &fs.RangeOption{Start: 10000, End: 30000})
+	require.NoError(t, err)
+	got, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, want[10000:30001], got)
+}
+
+func TestCDCMode(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	fsys, err := fs.NewFs(ctx, ":chunker,remote='"+tempDir+"',chunk_mode=cdc,cdc_chunk_size=4k,hash_type=sha1:")
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+	require.True(t, f.useCDC)
+	assert.Equal(t, ".rclone_chunk_pool", f.poolDir)
+
+	data := randomData(2, 256*1024)
+	shifted := append([]byte("!"), data...)
+
+	put := func(remote string, contents []byte) fs.Object {
+		item := fstest.Item{Path: remote, ModTime: mtime1}
+		obj := fstests.PutTestContents(ctx, t, f, &item, string(contents), true)
+		require.NotNil(t, obj)
+		return obj
+	}
+
+	// Put a file and a copy with a byte inserted at the start
+	objA := put("a", data)
+	poolA := countPoolChunks(ctx, t, f)
+	assert.Greater(t, poolA, 10)
+	objB := put("dir/b", shifted)
+	assert.Less(t, countPoolChunks(ctx, t, f), poolA+4, "chunks must be shared")
+
+	// The files read back in full and in part, with hashes
+	objB, err = f.NewObject(ctx, "dir/b")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(shifted)), objB.Size())
+	assert.NotNil(t, objB.(*Object).cdcList)
+	checkObjectContents(ctx, t, objA, data)
+	checkObjectContents(ctx, t, objB, shifted)
+	sum, err := objB.Hash(ctx, hash.SHA1)
+	require.NoError(t, err)
+	assert.Len(t, sum, 40)
+
+	// The pool is hidden from listings
+	fstest.CheckListingWithPrecision(t, f, []fstest.Item{
+		fstest.NewItem("a", string(data), mtime1),
+		fstest.NewItem("dir/b", string(shifted), mtime1),
+	}, []string{"dir"}, fs.ModTimeNotSupported)
+
+	// Server-side copy shares all the chunks
+	poolAB := countPoolChunks(ctx, t, f)
+	objC, err := operations.Copy(ctx, f, nil, "c", objA)
+	require.NoError(t, err)
+	checkObjectContents(ctx, t, objC, data)
+	assert.Equal(t, poolAB, countPoolChunks(ctx, t, f))
+
+	// Small files are stored as usual
+	objSmall := put("small", []byte("small"))
+	assert.False(t, objSmall.(*Object).isComposite())
+
+	// Removing a file keeps chunks other files use
+	require.NoError(t, objA.Remove(ctx))
+	stats, err := f.gc(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Removed)
+	assert.Equal(t, poolAB, stats.Referenced+stats.Removed)
+	checkObjectContents(ctx, t, objC, data)
+
+	// Unused chunks are only removed when old enough
+	require.NoError(t, objC.Remove(ctx))
+	stats, err = f.gc(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Removed)
+	assert.Greater(t, stats.Young, 0)
+	stats, err = f.gc(ctx, 0)
+	require.NoError(t, err)
+	assert.Greater(t, stats.Removed, 0)
+	assert.Equal(t, poolAB, stats.Referenced+stats.Removed)
+	checkObjectContents(ctx, t, objB, shifted)
+
+	require.NoError(t, objB.Remove(ctx))
+	require.NoError(t, objSmall.Remove(ctx))
+	_, err = f.gc(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, countPoolChunks(ctx, t, f))
+}
+
+// hookReader calls hook once when reading passes offset at
+type hookReader struct {
+	in   io.Reader
+	read int
+	at   int
+	hook func()
+}
+
+func (r *hookReader) Read(p []byte) (n int, err error) {
+	if r.hook != nil && r.read >= r.at {
+		r.hook()
+		r.hook = nil
+	}
+	if len(p) > 4096 {
+		p = p[:4096]
+	}
+	n, err = r.in.Read(p)
+	r.read += n
+	return n, err
+}
+
+// setPoolModTime sets the modification time of all the chunks in the pool
+func setPoolModTime(ctx context.Context, t *testing.T, f *Fs, modTime time.Time) {
+	pool, err := f.getPool(ctx)
+	require.NoError(t, err)
+	err = walk.ListR(ctx, pool, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			if err := entry.(fs.Object).SetModTime(ctx, modTime); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestCDCGCDuringPut(t *testing.T) {
+	ctx := context.Background()
+	fsys, err := fs.NewFs(ctx, ":chunker,remote='"+t.TempDir()+"',chunk_mode=cdc,cdc_chunk_size=4k:")
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+	data := randomData(3, 256*1024)
+
+	// put uploads data running gc with minAge half way through
+	put := func(remote string, minAge time.Duration) (obj fs.Object, stats GCStats) {
+		in := &hookReader{in: bytes.NewReader(data), at: len(data) / 2, hook: func() {
+			var err error
+			stats, err = f.gc(ctx, minAge)
+			require.NoError(t, err)
+		}}
+		src := object.NewStaticObjectInfo(remote, mtime1, int64(len(data)), true, nil, nil)
+		obj, err := f.Put(ctx, in, src)
+		require.NoError(t, err)
+		checkObjectContents(ctx, t, obj, data)
+		return obj, stats
+	}
+
+	// Chunks an upload shares with removed files are kept
+	obj, _ := put("a", time.Hour)
+	require.NoError(t, obj.Remove(ctx))
+	setPoolModTime(ctx, t, f, time.Now().Add(-2*time.Hour))
+	_, stats := put("b", time.Hour)
+	assert.Greater(t, stats.Young, 0)
+	assert.Greater(t, stats.Removed, 0)
+
+	// Chunks an upload has made are in its temporary list
+	oldInterval := cdcListInterval
+	cdcListInterval = 0
+	defer func() { cdcListInterval = oldInterval }()
+	_, stats = put("c", 0)
+	assert.Equal(t, 2, stats.Lists)
+	assert.Greater(t, stats.Referenced, 0)
+	assert.Equal(t, 0, stats.Young)
+}
@@ -77,6 +77,11 @@ import (
 // Metadata format v1 does not define any control chunk types,
 // they are currently ignored aka reserved.
 // In future they can be used to implement resumable uploads etc.
+//
+// Metadata format v3 is used by the content-defined chunking mode.
+// Data chunks are kept in a shared pool named by their hash and
+// the list of chunks is kept in a control chunk of type "cdc"
+// next to the meta object, see cdc.go for details.
 const (
 	ctrlTypeRegStr   = `[a-z][a-z0-9]{2,6}`
 	tempSuffixFormat = `_%04s`
@@ -105,7 +110,7 @@ const maxMetadataSize = 1023
 const maxMetadataSizeWritten = 255
 
 // Current/highest supported metadata format.
-const metadataVersion = 2
+const metadataVersion = 3
 
 // optimizeFirstChunk enables the following optimization in the Put:
 // If a single chunk is expected, put the first chunk using the
@@ -143,6 +148,7 @@ func init() {
 		Name:        "chunker",
 		Description: "Transparently chunk/split large files",
 		NewFs:       NewFs,
+		CommandHelp: commandHelp,
 		Options: []fs.Option{{
 			Name:     "remote",
 			Required: true,
@@ -239,6 +245,36 @@ Falling back to SHA1 if unsupported.`,
 					Help:  "Warn user, skip incomplete file and proceed.",
 				},
 			},
+		}, {
+			Name:     "chunk_mode",
+			Advanced: true,
+			Default:  "fixed",
+			Help:     `Choose how chunker splits files in chunks.`,
+			Examples: []fs.OptionExample{
+				{
+					Value: "fixed",
+					Help:  "Split files in chunks of chunk size next to the meta object.",
+				}, {
+					Value: "cdc",
+					Help: `Split files at content-defined boundaries and keep chunks in a shared pool.
+Chunks are stored once by hash so files sharing data share chunks.
+Requires metadata. Use the "gc" backend command to remove unused chunks.`,
+				},
+			},
+		}, {
+			Name:     "cdc_chunk_size",
+			Advanced: true,
+			Default:  fs.SizeSuffix(1024 * 1024), // 1 MiB
+			Help: `Average size of chunks in the cdc chunk mode.
+
+Chunks are between a quarter and four times this size.`,
+		}, {
+			Name:     "cdc_pool",
+			Advanced: true,
+			Default:  ".rclone_chunk_pool",
+			Help: `Directory for the chunk pool in the cdc chunk mode.
+
+This is relative to the wrapped remote and is hidden from listings.`,
 		}, {
 			Name:     "transactions",
 			Advanced: true,
@@ -300,18 +336,30 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	}
 
 	f := &Fs{
-		base: baseFs,
-		name: name,
-		root: rpath,
-		opt:  *opt,
+		base:     baseFs,
+		name:     name,
+		root:     rpath,
+		opt:      *opt,
+		baseName: baseName,
+		basePath: basePath,
 	}
 	cache.PinUntilFinalized(f.base, f)
 	f.dirSort = true // processEntries requires that meta Objects prerun data chunks atm.
 
-	if err := f.configure(opt.NameFormat, opt.MetaFormat, opt.HashType, opt.Transactions); err != nil {
+	if err := f.configure(opt.NameFormat, opt.MetaFormat, opt.HashType, opt.ChunkMode, opt.Transactions); err != nil {
 		return nil, err
 	}
 
+	// Hide the chunk pool if it is inside the wrapped root
+	baseRoot := remotePath
+	if err == fs.ErrorIsFile {
+		baseRoot = path.Dir(baseRoot)
+		if baseRoot == "." {
+			baseRoot = ""
+		}
+	}
+	f.poolDir = f.poolDirIn(baseRoot)
+
 	// Handle the tricky case detected by FsMkdir/FsPutFiles/FsIsFile
 	// when `rpath` points to a composite multi-chunk file without metadata,
 	// i.e. `rpath` does not exist in the wrapped remote, but chunker
@@ -353,6 +401,9 @@ type Options struct {
 	MetaFormat   string        `config:"meta_format"`
 	HashType     string        `config:"hash_type"`
 	FailHard     bool          `config:"fail_hard"`
+	ChunkMode    string        `config:"chunk_mode"`
+	CDCChunkSize fs.SizeSuffix `config:"cdc_chunk_size"`
+	CDCPool      string        `config:"cdc_pool"`
 	Transactions string        `config:"transactions"`
 }
 
@@ -376,12 +427,19 @@ type Fs struct {
 	features     *fs.Features   // optional features
 	dirSort      bool           // reserved for future, ignored
 	useNoRename  bool           // can be set with the transactions option
+	useCDC       bool           // true if chunk mode is 'cdc'
+	baseName     string         // config name of the wrapped remote
+	basePath     string         // root of the wrapped remote in the config
+	poolDir      string         // chunk pool relative to the wrapped root or ""
+	pool         fs.Fs          // chunk pool, made on first use
+	poolMu       sync.Mutex     // protects pool
 }
 
-// configure sets up chunker for given name format, meta format and hash type.
+// configure sets up chunker for given name format, meta format, hash type,
+// chunk mode and transaction mode.
 // It also seeds the source of random transaction identifiers.
 // configure must be called only from NewFs or by unit tests.
-func (f *Fs) configure(nameFormat, metaFormat, hashType, transactionMode string) error {
+func (f *Fs) configure(nameFormat, metaFormat, hashType, chunkMode, transactionMode string) error {
 	if err := f.setChunkNameFormat(nameFormat); err != nil {
 		return fmt.Errorf("invalid name format '%s': %w", nameFormat, err)
 	}
@@ -391,6 +449,9 @@ func (f *Fs) configure(nameFormat, metaFormat, hashType, transactionMode string)
 	if err := f.setHashType(hashType); err != nil {
 		return err
 	}
+	if err := f.setChunkMode(chunkMode); err != nil {
+		return err
+	}
 	if err := f.setTransactionMode(transactionMode); err != nil {
 		return err
 	}
@@ -458,12 +519,12 @@ func (f *Fs) setTransactionMode(transactionMode string) error {
 	case "rename":
 		f.useNoRename = false
 	case "norename":
-		if !f.useMeta {
+		if !f.useMeta || f.useCDC {
 			return errors.New("incompatible transaction options")
 		}
 		f.useNoRename = true
 	case "auto":
-		f.useNoRename = !f.CanQuickRename()
+		f.useNoRename = !f.CanQuickRename() && !f.useCDC
 		if f.useNoRename && !f.useMeta {
 			f.useNoRename = false
 			return errors.New("using norename transactions requires metadata")
@@ -761,6 +822,9 @@ func (f *Fs) processEntries(ctx context.Context, origEntries fs.DirEntries, dirP
 		switch entry := dirOrObject.(type) {
 		case fs.Object:
 			remote := entry.Remote()
+			if f.isPoolPath(remote) {
+				break // skip chunk pool
+			}
 			mainRemote, chunkNo, ctrlType, xactID := f.parseChunkName(remote)
 			if mainRemote == "" {
 				// this is meta object or standalone file
@@ -802,6 +866,9 @@ func (f *Fs) processEntries(ctx context.Context, origEntries fs.DirEntries, dirP
 				// the `size` field caches metaobject size, if any
 				if f.useMeta && mainObject != nil && mainObject.size <= maxMetadataSize {
 					mainObject.unsure = true
+					if ctrlType == ctrlTypeCDC && xactID == "" {
+						mainObject.cdcList = entry
+					}
 				}
 				break
 			}
@@ -812,6 +879,9 @@ func (f *Fs) processEntries(ctx context.Context, origEntries fs.DirEntries, dirP
 				badEntry[mainRemote] = true
 			}
 		case fs.Directory:
+			if f.isPoolPath(entry.Remote()) {
+				break // skip chunk pool
+			}
 			isSubdir[entry.Remote()] = true
 			wrapDir := fs.NewDirCopy(ctx, entry)
 			wrapDir.SetRemote(entry.Remote())
@@ -844,6 +914,16 @@ func (f *Fs) processEntries(ctx context.Context, origEntries fs.DirEntries, dirP
 				fs.Debugf(f, "invalid chunks in object %q", remote)
 				continue
 			}
+			if object.cdcList != nil {
+				// size of files in the cdc mode is kept in metadata
+				if err := object.readMetadata(ctx); err != nil && err != ErrMetaUnknown {
+					if f.opt.FailHard {
+						return nil, err
+					}
+					fs.Debugf(f, "invalid metadata in object %q: %v", remote, err)
+					continue
+				}
+			}
 		}
 		newEntries = append(newEntries, entry)
 	}
@@ -957,6 +1037,9 @@ func (f *Fs) scanObject(ctx context.Context, remote string, quickScan bool) (fs.
 			if f.useMeta {
 				// temporary/control chunk calls for lazy metadata read
 				o.unsure = true
+				if ctrlType == ctrlTypeCDC && xactID == "" {
+					o.cdcList = entry
+				}
 			}
 			continue
 		}
@@ -995,6 +1078,12 @@ func (f *Fs) scanObject(ctx context.Context, remote string, quickScan bool) (fs.
 		if err := o.validate(); err != nil {
 			return nil, err
 		}
+		if o.cdcList != nil {
+			// size of files in the cdc mode is kept in metadata
+			if err := o.readMetadata(ctx); err != nil && err != ErrMetaUnknown {
+				return nil, err
+			}
+		}
 	}
 	return o, nil
 }
@@ -1026,6 +1115,7 @@ func (o *Object) readMetadata(ctx context.Context) error {
 			// this is not metadata but a foreign object
 			o.unsure = false
 			o.chunks = nil  // make isComposite return false
+			o.cdcList = nil // ditto
 			o.isFull = true // cache results
 			return nil
 		}
@@ -1051,6 +1141,7 @@ func (o *Object) readMetadata(ctx context.Context) error {
 			if !madeByChunker {
 				// this is not metadata but a foreign object
 				o.chunks = nil  // make isComposite return false
+				o.cdcList = nil // ditto
 				o.isFull = true // cache results
 				return nil
 			}
@@ -1063,8 +1154,17 @@ func (o *Object) readMetadata(ctx context.Context) error {
 		default:
 			return fmt.Errorf("invalid metadata: %w", err)
 		}
-		if o.size != metaInfo.Size() || len(o.chunks) != metaInfo.nChunks {
-			return errors.New("metadata doesn't match file size")
+		switch {
+		case metaInfo.cdc && o.cdcList == nil:
+			return errors.New("chunk list is missing")
+		case metaInfo.cdc:
+			o.size = metaInfo.Size()
+			o.cdcCount = metaInfo.nChunks
+		default:
+			o.cdcList = nil // ignore stale chunk list
+			if o.size != metaInfo.Size() || len(o.chunks) != metaInfo.nChunks {
+				return errors.New("metadata doesn't match file size")
+			}
 		}
 		o.md5 = metaInfo.md5
 		o.sha1 = metaInfo.sha1
@@ -1144,6 +1244,10 @@ func (f *Fs) put(
 		}
 	}
 
+	if f.useCDC {
+		return f.putCDC(ctx, in, src, remote, basePut)
+	}
+
 	// Prepare to upload
 	c := f.newChunkingReader(src)
 	wrapIn := c.wrapStream(ctx, in, src)
@@ -1301,7 +1405,7 @@ func (f *Fs) put(
 	switch f.opt.MetaFormat {
 	case "simplejson":
 		c.updateHashes()
-		metadata, err = marshalSimpleJSON(ctx, sizeTotal, len(c.chunks), c.md5, c.sha1, xactID)
+		metadata, err = marshalSimpleJSON(ctx, sizeTotal, len(c.chunks), c.md5, c.sha1, xactID, false)
 	}
 	if err == nil {
 		metaInfo := f.wrapInfo(src, baseRemote, int64(len(metadata)))
@@ -1489,6 +1593,11 @@ func (f *Fs) removeOldChunks(ctx context.Context, remote string) {
 				fs.Errorf(chunk, "Failed to remove old chunk: %v", err)
 			}
 		}
+		if oldObject.cdcList != nil {
+			if err := oldObject.cdcList.Remove(ctx); err != nil {
+				fs.Errorf(oldObject.cdcList, "Failed to remove old chunk list: %v", err)
+			}
+		}
 	}
 }
 
@@ -1646,7 +1755,14 @@ func (o *Object) Remove(ctx context.Context) (err error) {
 		}
 	}
 
-	// There are no known control chunks to remove atm.
+	// Remove the chunk list in the cdc mode. Its data chunks
+	// are left in the pool for the gc command.
+	if o.cdcList != nil {
+		listErr := o.cdcList.Remove(ctx)
+		if err == nil {
+			err = listErr
+		}
+	}
 	return err
 }
 
@@ -1668,6 +1784,9 @@ func (f *Fs) copyOrMove(ctx context.Context, o *Object, remote string, do copyMo
 		}
 		return f.newObject("", oResult, nil), nil
 	}
+	if o.cdcList != nil {
+		return f.copyOrMoveCDC(ctx, o, remote, do, opName)
+	}
 
 	fs.Debugf(o, "%s %d data chunks...", opName, len(o.chunks))
 	mainRemote := o.remote
@@ -1715,7 +1834,7 @@ func (f *Fs) copyOrMove(ctx context.Context, o *Object, remote string, do copyMo
 	var metadata []byte
 	switch f.opt.MetaFormat {
 	case "simplejson":
-		metadata, err = marshalSimpleJSON(ctx, newObj.size, len(newChunks), md5, sha1, o.xactID)
+		metadata, err = marshalSimpleJSON(ctx, newObj.size, len(newChunks), md5, sha1, o.xactID, false)
 		if err == nil {
 			metaInfo := f.wrapInfo(metaObject, "", int64(len(metadata)))
 			err = newObj.main.Update(ctx, bytes.NewReader(metadata), metaInfo)
@@ -1753,6 +1872,8 @@ func (f *Fs) okForServerSide(ctx context.Context, src fs.Object, opName string)
 		diff = "chunk numbering"
 	case f.opt.MetaFormat != obj.f.opt.MetaFormat:
 		diff = "meta formats"
+	case f.opt.CDCPool != obj.f.opt.CDCPool:
+		diff = "chunk pools"
 	}
 	if diff != "" {
 		fs.Debugf(src, "Can't %s - different %s", opName, diff)
@@ -1764,7 +1885,8 @@ func (f *Fs) okForServerSide(ctx context.Context, src fs.Object, opName string)
 		// ensure object is composite if need to re-read metadata
 		_ = obj.readMetadata(ctx)
 	}
-	requireMetaHash := obj.isComposite() && f.opt.MetaFormat == "simplejson"
+	// metadata of files in the cdc mode is copied as is
+	requireMetaHash := obj.isComposite() && obj.cdcList == nil && f.opt.MetaFormat == "simplejson"
 	if !requireMetaHash && !f.hashAll {
 		ok = true // hash is not required for metadata
 		return
@@ -1949,6 +2071,12 @@ func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryT
 // Shutdown the backend, closing any background tasks and any
 // cached connections.
 func (f *Fs) Shutdown(ctx context.Context) error {
+	f.poolMu.Lock()
+	if f.pool != nil {
+		cache.Unpin(f.pool)
+		f.pool = nil
+	}
+	f.poolMu.Unlock()
 	do := f.base.Features().Shutdown
 	if do == nil {
 		return nil
@@ -1966,6 +2094,9 @@ type Object struct {
 	xIDCached bool        // true if xactID has been read
 	unsure    bool        // true if need to read metadata to detect object type
 	xactID    string      // transaction ID for "norename" or empty string for "renamed" chunks
+	cdcList   fs.Object   // control chunk listing pool chunks in the cdc mode
+	cdcRefs   []cdcRef    // cached list of pool chunks in the cdc mode
+	cdcCount  int         // number of pool chunks according to metadata
 	md5       string
 	sha1      string
 	f         *Fs
@@ -2007,6 +2138,9 @@ func (o *Object) validate() error {
 		o.size = -1
 		return fmt.Errorf("%q metadata is too large", o.remote)
 	}
+	if o.cdcList != nil && o.chunks == nil {
+		return nil // total size will be read from metadata
+	}
 
 	var totalSize int64
 	for _, chunk := range o.chunks {
@@ -2053,7 +2187,7 @@ func (o *Object) mainChunk() fs.Object {
 }
 
 func (o *Object) isComposite() bool {
-	return o.chunks != nil
+	return o.chunks != nil || o.cdcList != nil
 }
 
 // Fs returns read only access to the Fs that this object is part of
@@ -2182,13 +2316,30 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 		limit = o.size - offset
 	}
 
-	return o.newLinearReader(ctx, offset, limit, openOptions)
+	var chunks []chunkOpener
+	if o.cdcList != nil {
+		if chunks, err = o.cdcChunks(ctx); err != nil {
+			return nil, fmt.Errorf("can't open: %w", err)
+		}
+	} else {
+		chunks = make([]chunkOpener, len(o.chunks))
+		for i, chunk := range o.chunks {
+			chunks[i] = chunk
+		}
+	}
+	return newLinearReader(ctx, chunks, offset, limit, openOptions)
+}
+
+// chunkOpener is the part of a data chunk used by linearReader
+type chunkOpener interface {
+	Size() int64
+	Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error)
 }
 
 // linearReader opens and reads file chunks sequentially, without read-ahead
 type linearReader struct {
 	ctx     context.Context
-	chunks  []fs.Object
+	chunks  []chunkOpener
 	options []fs.OpenOption
 	limit   int64
 	count   int64
@@ -2197,10 +2348,10 @@ type linearReader struct {
 	err     error
 }
 
-func (o *Object) newLinearReader(ctx context.Context, offset, limit int64, options []fs.OpenOption) (io.ReadCloser, error) {
+func newLinearReader(ctx context.Context, chunks []chunkOpener, offset, limit int64, options []fs.OpenOption) (io.ReadCloser, error) {
 	r := &linearReader{
 		ctx:     ctx,
-		chunks:  o.chunks,
+		chunks:  chunks,
 		options: options,
 		limit:   limit,
 	}
@@ -2295,6 +2446,7 @@ type ObjectInfo struct {
 	src     fs.ObjectInfo
 	fs      *Fs
 	nChunks int    // number of data chunks
+	cdc     bool   // true if data chunks are kept in the chunk pool
 	xactID  string // transaction ID for "norename" or empty string for "renamed" chunks
 	size    int64  // overrides source size by the total size of data chunks
 	remote  string // overrides remote name
@@ -2397,6 +2549,7 @@ type metaSimpleJSON struct {
 	MD5    string `json:"md5,omitempty"`
 	SHA1   string `json:"sha1,omitempty"`
 	XactID string `json:"txn,omitempty"` // transaction ID for norename transactions
+	CDC    string `json:"cdc,omitempty"` // hash naming data chunks in the chunk pool
 }
 
 // marshalSimpleJSON
@@ -2405,9 +2558,18 @@ type metaSimpleJSON struct {
 // - for files larger than chunk size
 // - if file contents can be mistaken as meta object
 // - if consistent hashing is On but wrapped remote can't provide given hash
-func marshalSimpleJSON(ctx context.Context, size int64, nChunks int, md5, sha1, xactID string) ([]byte, error) {
+//
+// The lowest version able to describe the file is written, so that
+// older releases can still read files they understand.
+func marshalSimpleJSON(ctx context.Context, size int64, nChunks int, md5, sha1, xactID string, cdc bool) ([]byte, error) {
 	version := metadataVersion
-	if xactID == "" && version == 2 {
+	cdcHash := ""
+	switch {
+	case cdc:
+		cdcHash = cdcHashType
+	case xactID != "":
+		version = 2
+	default:
 		version = 1
 	}
 	metadata := metaSimpleJSON{
@@ -2419,6 +2581,7 @@ func marshalSimpleJSON(ctx context.Context, size int64, nChunks int, md5, sha1,
 		MD5:    md5,
 		SHA1:   sha1,
 		XactID: xactID,
+		CDC:    cdcHash,
 	}
 	data, err := json.Marshal(&metadata)
 	if err == nil && data != nil && len(data) >= maxMetadataSizeWritten {
@@ -2489,6 +2652,9 @@ func unmarshalSimpleJSON(ctx context.Context, metaObject fs.Object, data []byte)
 	if *metadata.Version > metadataVersion {
 		return nil, true, ErrMetaUnknown // produced by incompatible version of rclone
 	}
+	if metadata.CDC != "" && metadata.CDC != cdcHashType {
+		return nil, true, ErrMetaUnknown // chunk pool named by unknown hash
+	}
 
 	var nilFs *Fs // nil object triggers appropriate type method
 	info = nilFs.wrapInfo(metaObject, "", *metadata.Size)
@@ -2496,6 +2662,7 @@ func unmarshalSimpleJSON(ctx context.Context, metaObject fs.Object, data []byte)
 	info.md5 = metadata.MD5
 	info.sha1 = metadata.SHA1
 	info.xactID = metadata.XactID
+	info.cdc = metadata.CDC != ""
 	return info, true, nil
 }
 
@@ -2549,6 +2716,7 @@ var (
 	_ fs.Wrapper         = (*Fs)(nil)
 	_ fs.ChangeNotifier  = (*Fs)(nil)
 	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
 	_ fs.ObjectInfo      = (*ObjectInfo)(nil)
 	_ fs.Object          = (*Object)(nil)
 	_ fs.ObjectUnWrapper = (*Object)(nil)
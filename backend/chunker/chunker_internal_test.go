@@ -679,7 +679,7 @@ func testMetadataInput(t *testing.T, f *Fs) {
 		}
 	}
 
-	metaData, err := marshalSimpleJSON(ctx, 3, 1, "", "", "")
+	metaData, err := marshalSimpleJSON(ctx, 3, 1, "", "", "", false)
 	require.NoError(t, err)
 	todaysMeta := string(metaData)
 	runSubtest(todaysMeta, "today")
@@ -870,6 +870,7 @@ func testMD5AllSlow(t *testing.T, f *Fs) {
 		"hash_type":    "md5all",
 		"transactions": "rename",
 		"meta_format":  "simplejson",
+		"chunk_mode":   "fixed",
 	})
 	chunkFs, ok := fsResult.(*Fs)
 	require.True(t, ok, "fs must be a chunker remote")
@@ -58,3 +58,38 @@ func TestIntegration(t *testing.T) {
 	}
 	fstests.Run(t, &opt)
 }
+
+// TestIntegrationCDC runs integration tests in the cdc chunk mode
+// over a local temporary directory.
+func TestIntegrationCDC(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	name := "TestChunkerCDC"
+	tempDir := filepath.Join(os.TempDir(), "rclone-chunker-test-cdc")
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		NilObject:  (*chunker.Object)(nil),
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "chunker"},
+			{Name: name, Key: "remote", Value: tempDir},
+			{Name: name, Key: "chunk_mode", Value: "cdc"},
+			{Name: name, Key: "cdc_chunk_size", Value: "1k"},
+		},
+		UnimplementableObjectMethods: []string{
+			"MimeType",
+			"GetTier",
+			"SetTier",
+			"Metadata",
+		},
+		UnimplementableFsMethods: []string{
+			"PublicLink",
+			"OpenWriterAt",
+			"MergeDirs",
+			"DirCacheFlush",
+			"UserInfo",
+			"Disconnect",
+		},
+		QuickTestOK: true,
+	})
+}
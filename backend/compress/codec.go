@@ -0,0 +1,320 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/buengese/sgzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/fs"
+)
+
+// Size of the uncompressed blocks written by the block based
+// compression modes
+const blockSize = 1 << 20
+
+// compressor is an io.WriteCloser which compresses the data written
+// to it and can return the metadata needed to read it back
+type compressor interface {
+	io.WriteCloser
+	MetaData() sgzip.GzipMetadata
+}
+
+// newCompressor returns a compressor for mode writing to out
+func newCompressor(out io.Writer, mode int, level int) (compressor, error) {
+	switch mode {
+	case Gzip:
+		return sgzip.NewWriterLevel(out, level)
+	case Zstd:
+		if level <= 0 {
+			level = 3 // the zstd default
+		}
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return newBlockWriter(out, func(dst, src []byte) ([]byte, error) {
+			return enc.EncodeAll(src, dst), nil
+		}, enc.Close), nil
+	case S2:
+		var buf bytes.Buffer
+		enc := s2.NewWriter(nil, s2.WriterConcurrency(1), s2.WriterBlockSize(blockSize))
+		return newBlockWriter(out, func(dst, src []byte) ([]byte, error) {
+			buf.Reset()
+			enc.Reset(&buf)
+			if _, err := enc.Write(src); err != nil {
+				return nil, err
+			}
+			if err := enc.Close(); err != nil {
+				return nil, err
+			}
+			return append(dst, buf.Bytes()...), nil
+		}, nil), nil
+	}
+	return nil, fmt.Errorf("unknown compression mode %d", mode)
+}
+
+// newDecompressor returns a reader which decompresses the data in in
+// starting at offset in the uncompressed data. If limit is >= 0 then
+// at least limit bytes will be available to read, but more may be.
+//
+// in must be positioned at the start of the data.
+func newDecompressor(ctx context.Context, in io.ReadSeeker, mode int, meta *sgzip.GzipMetadata, offset, limit int64) (io.ReadCloser, error) {
+	switch mode {
+	case Gzip:
+		if offset != 0 {
+			return sgzip.NewReaderAt(in, meta, offset)
+		}
+		return sgzip.NewReader(in)
+	case Zstd:
+		dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return newBlockReader(ctx, in, meta, offset, limit, func(dst, src []byte) ([]byte, error) {
+			return dec.DecodeAll(src, dst)
+		}, dec.Close)
+	case S2:
+		dec := s2.NewReader(nil)
+		return newBlockReader(ctx, in, meta, offset, limit, func(dst, src []byte) ([]byte, error) {
+			dec.Reset(bytes.NewReader(src))
+			out := bytes.NewBuffer(dst)
+			if _, err := io.Copy(out, dec); err != nil {
+				return nil, err
+			}
+			return out.Bytes(), nil
+		}, nil)
+	}
+	return nil, fmt.Errorf("unknown compression mode %d", mode)
+}
+
+// blockFn compresses or decompresses src appending the result to dst
+type blockFn func(dst, src []byte) ([]byte, error)
+
+// blockWriter compresses the data written to it in independently
+// decodable blocks of blockSize bytes.
+//
+// Each block is a complete frame for the compression format so the
+// output is a valid compressed file. The compressed size of each block
+// is recorded in the metadata so the blockReader can seek to any
+// block.
+type blockWriter struct {
+	out      io.Writer
+	compress blockFn
+	close    func() error
+	in       []byte // uncompressed data waiting to be compressed
+	buf      []byte // buffer for compressed data
+	meta     sgzip.GzipMetadata
+	err      error
+}
+
+// newBlockWriter makes a new blockWriter writing to out. close is
+// called when the writer is closed if it is not nil.
+func newBlockWriter(out io.Writer, compress blockFn, close func() error) *blockWriter {
+	return &blockWriter{
+		out:      out,
+		compress: compress,
+		close:    close,
+		in:       make([]byte, 0, blockSize),
+		meta: sgzip.GzipMetadata{
+			BlockSize: blockSize,
+		},
+	}
+}
+
+// flush compresses and writes the buffered data as a block
+func (w *blockWriter) flush() error {
+	if len(w.in) == 0 {
+		return nil
+	}
+	w.buf, w.err = w.compress(w.buf[:0], w.in)
+	if w.err != nil {
+		return w.err
+	}
+	_, w.err = w.out.Write(w.buf)
+	if w.err != nil {
+		return w.err
+	}
+	w.meta.BlockData = append(w.meta.BlockData, uint32(len(w.buf)))
+	w.meta.Size += int64(len(w.in))
+	w.in = w.in[:0]
+	return nil
+}
+
+// Write writes p to the compressor
+func (w *blockWriter) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	for len(p) > 0 {
+		chunk := blockSize - len(w.in)
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+		w.in = append(w.in, p[:chunk]...)
+		p = p[chunk:]
+		n += chunk
+		if len(w.in) == blockSize {
+			if err = w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes any buffered data
+func (w *blockWriter) Close() error {
+	err := w.flush()
+	if w.close != nil {
+		if closeErr := w.close(); err == nil {
+			err = closeErr
+		}
+		w.close = nil
+	}
+	return err
+}
+
+// MetaData returns the block index of the data written
+func (w *blockWriter) MetaData() sgzip.GzipMetadata {
+	return w.meta
+}
+
+// blockReader decompresses data written by a blockWriter
+type blockReader struct {
+	in         io.Reader
+	decompress blockFn
+	close      func()
+	meta       *sgzip.GzipMetadata
+	block      int    // index of the next block to read
+	end        int    // index of the block after the last one to read
+	buf        []byte // buffer for compressed data
+	out        []byte // decompressed data not yet read
+	outBuf     []byte // buffer for decompressed data
+}
+
+// errCorruptBlock is returned if a block doesn't decompress to the
+// size recorded in the metadata
+var errCorruptBlock = errors.New("compressed block has wrong size")
+
+// newBlockReader makes a new blockReader reading from in starting at
+// offset in the uncompressed data and reading at least limit bytes if
+// limit >= 0. close is called when the reader is closed if it is not
+// nil.
+//
+// Only the blocks needed are read from in. If in is an fs.RangeSeeker
+// then it is told the length of compressed data needed for limit.
+func newBlockReader(ctx context.Context, in io.ReadSeeker, meta *sgzip.GzipMetadata, offset, limit int64, decompress blockFn, close func()) (*blockReader, error) {
+	r := &blockReader{
+		in:         in,
+		decompress: decompress,
+		close:      close,
+		meta:       meta,
+		end:        len(meta.BlockData),
+	}
+	if meta.BlockSize <= 0 {
+		return nil, errors.New("missing block size in metadata")
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= meta.Size {
+		r.block = r.end
+		return r, nil
+	}
+	r.block = int(offset / int64(meta.BlockSize))
+	if limit >= 0 && offset+limit < meta.Size {
+		r.end = int((offset+limit-1)/int64(meta.BlockSize)) + 1
+		if r.end <= r.block {
+			r.end = r.block + 1
+		}
+	}
+	if r.block != 0 || r.end != len(meta.BlockData) {
+		start, length := blockRange(meta, r.block, r.end)
+		var err error
+		if do, ok := in.(fs.RangeSeeker); ok {
+			_, err = do.RangeSeek(ctx, start, io.SeekStart, length)
+		} else {
+			_, err = in.Seek(start, io.SeekStart)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if offset%int64(meta.BlockSize) != 0 {
+		if err := r.readBlock(); err != nil {
+			return nil, err
+		}
+		r.out = r.out[offset%int64(meta.BlockSize):]
+	}
+	return r, nil
+}
+
+// blockRange returns the start and length of the compressed data for
+// blocks from up to but not including end
+func blockRange(meta *sgzip.GzipMetadata, from, end int) (start, length int64) {
+	for _, size := range meta.BlockData[:from] {
+		start += int64(size)
+	}
+	for _, size := range meta.BlockData[from:end] {
+		length += int64(size)
+	}
+	return start, length
+}
+
+// readBlock reads and decompresses the next block
+func (r *blockReader) readBlock() (err error) {
+	if r.block >= r.end {
+		return io.EOF
+	}
+	size := int(r.meta.BlockData[r.block])
+	if cap(r.buf) < size {
+		r.buf = make([]byte, size)
+	}
+	r.buf = r.buf[:size]
+	if _, err = io.ReadFull(r.in, r.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	r.outBuf, err = r.decompress(r.outBuf[:0], r.buf)
+	if err != nil {
+		return err
+	}
+	want := int64(r.meta.BlockSize)
+	if r.block == len(r.meta.BlockData)-1 {
+		want = r.meta.Size - int64(r.block)*want
+	}
+	if int64(len(r.outBuf)) != want {
+		return errCorruptBlock
+	}
+	r.out = r.outBuf
+	r.block++
+	return nil
+}
+
+// Read reads decompressed data into p
+func (r *blockReader) Read(p []byte) (n int, err error) {
+	for len(r.out) == 0 {
+		if err = r.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// Close the reader
+func (r *blockReader) Close() error {
+	if r.close != nil {
+		r.close()
+		r.close = nil
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testData makes compressible data a few blocks long which doesn't end
+// on a block boundary
+func testData() (data []byte) {
+	rng := rand.New(rand.NewSource(1))
+	for len(data) < 3*blockSize+1234 {
+		data = append(data, fmt.Sprintf("line %d %d\n", len(data), rng.Intn(100))...)
+	}
+	return data
+}
+
+func TestCodecs(t *testing.T) {
+	data := testData()
+	for _, mode := range []int{Gzip, Zstd, S2} {
+		t.Run(fmt.Sprint(mode), func(t *testing.T) {
+			var buf bytes.Buffer
+			c, err := newCompressor(&buf, mode, -1)
+			require.NoError(t, err)
+			_, err = c.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, c.Close())
+			meta := c.MetaData()
+			assert.Equal(t, int64(len(data)), meta.Size)
+			assert.Less(t, buf.Len(), len(data))
+
+			for _, offset := range []int64{0, 1, blockSize - 1, blockSize, 2*blockSize + 17, int64(len(data)) - 1, int64(len(data))} {
+				d, err := newDecompressor(context.Background(), bytes.NewReader(buf.Bytes()), mode, &meta, offset, -1)
+				require.NoError(t, err)
+				got, err := io.ReadAll(d)
+				require.NoError(t, err)
+				require.NoError(t, d.Close())
+				assert.Equal(t, data[offset:], got, fmt.Sprintf("offset %d", offset))
+			}
+		})
+	}
+
+	_, err := newCompressor(io.Discard, Uncompressed, -1)
+	assert.Error(t, err)
+}
+
+// rangeReader records the range of the last RangeSeek
+type rangeReader struct {
+	*bytes.Reader
+	start, length int64
+}
+
+// RangeSeek seeks to offset and records the range asked for
+func (r *rangeReader) RangeSeek(ctx context.Context, offset int64, whence int, length int64) (int64, error) {
+	r.start, r.length = offset, length
+	return r.Seek(offset, whence)
+}
+
+func TestBlockReaderRange(t *testing.T) {
+	data := testData()
+	var buf bytes.Buffer
+	c, err := newCompressor(&buf, Zstd, -1)
+	require.NoError(t, err)
+	_, err = c.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	meta := c.MetaData()
+	require.Len(t, meta.BlockData, 4)
+	sizes := make([]int64, len(meta.BlockData))
+	for i, size := range meta.BlockData {
+		sizes[i] = int64(size)
+	}
+
+	for _, test := range []struct {
+		offset, limit int64
+		start, length int64 // expected compressed range, length 0 for no RangeSeek
+	}{
+		{0, -1, 0, 0},
+		{0, int64(len(data)), 0, 0},
+		{0, 10, 0, sizes[0]},
+		{blockSize, blockSize, sizes[0], sizes[1]},
+		{blockSize - 1, 2, 0, sizes[0] + sizes[1]},
+		{2*blockSize + 5, 0, sizes[0] + sizes[1], sizes[2]},
+		{3 * blockSize, -1, sizes[0] + sizes[1] + sizes[2], sizes[3]},
+	} {
+		what := fmt.Sprintf("offset=%d limit=%d", test.offset, test.limit)
+		in := &rangeReader{Reader: bytes.NewReader(buf.Bytes())}
+		d, err := newDecompressor(context.Background(), in, Zstd, &meta, test.offset, test.limit)
+		require.NoError(t, err, what)
+		got, err := io.ReadAll(d)
+		require.NoError(t, err, what)
+		require.NoError(t, d.Close())
+		assert.Equal(t, test.start, in.start, what)
+		assert.Equal(t, test.length, in.length, what)
+		// at least limit bytes are returned and no more than the blocks hold
+		want := data[test.offset:]
+		if test.limit >= 0 && test.limit < int64(len(want)) {
+			assert.True(t, len(got) >= int(test.limit), what)
+			want = want[:len(got)]
+		}
+		assert.Equal(t, want, got, what)
+	}
+}
@@ -43,6 +43,8 @@ const (
 	minCompressionRatio = 1.1
 
 	gzFileExt           = ".gz"
+	zstdFileExt         = ".zst"
+	s2FileExt           = ".s2"
 	metaFileExt         = ".json"
 	uncompressedFileExt = ".bin"
 )
@@ -51,8 +53,17 @@ const (
 const (
 	Uncompressed = 0
 	Gzip         = 2
+	Zstd         = 3
+	S2           = 4
 )
 
+// compressedFileExt maps compression modes onto file extensions
+var compressedFileExt = map[int]string{
+	Gzip: gzFileExt,
+	Zstd: zstdFileExt,
+	S2:   s2FileExt,
+}
+
 var nameRegexp = regexp.MustCompile(`^(.+?)\.([A-Za-z0-9-_]{11})$`)
 
 // Register with Fs
@@ -62,6 +73,12 @@ func init() {
 		{ // Default compression mode options {
 			Value: "gzip",
 			Help:  "Standard gzip compression with fastest parameters.",
+		}, {
+			Value: "zstd",
+			Help:  "Zstandard compression - better compression and faster decompression than gzip.",
+		}, {
+			Value: "s2",
+			Help:  "S2 compression - very fast but compresses less than gzip.",
 		},
 	}
 
@@ -84,7 +101,9 @@ func init() {
 			Examples: compressionModeOptions,
 		}, {
 			Name: "level",
-			Help: `GZIP compression level (-2 to 9).
+			Help: `Compression level.
+
+For gzip this is -2 to 9.
 
 Generally -1 (default, equivalent to 5) is recommended.
 Levels 1 to 9 increase compression at the cost of speed. Going past 6 
@@ -92,7 +111,14 @@ generally offers very little return.
 
 Level -2 uses Huffman encoding only. Only use if you know what you
 are doing.
-Level 0 turns off compression.`,
+Level 0 turns off compression.
+
+For zstd this is 1 to 22 as used by the zstd command. These are
+mapped onto the fastest (1), default (2 to 5), better (6 to 9) and
+best (10 and above) encoder settings. Level -1 or 0 uses the default
+of 3.
+
+The level is ignored for s2.`,
 			Default:  sgzip.DefaultCompression,
 			Advanced: true,
 		}, {
@@ -201,6 +227,10 @@ func compressionModeFromName(name string) int {
 	switch name {
 	case "gzip":
 		return Gzip
+	case "zstd":
+		return Zstd
+	case "s2":
+		return S2
 	default:
 		return Uncompressed
 	}
@@ -243,7 +273,7 @@ func processFileName(compressedFileName string) (origFileName string, extension
 	if err != nil {
 		return "", "", 0, errors.New("could not decode size")
 	}
-	return match[1], gzFileExt, size, nil
+	return match[1], extension, size, nil
 }
 
 // Generates the file name for a metadata file
@@ -258,8 +288,8 @@ func isMetadataFile(filename string) bool {
 
 // makeDataName generates the file name for a data file with specified compression mode
 func makeDataName(remote string, size int64, mode int) (newRemote string) {
-	if mode != Uncompressed {
-		newRemote = remote + "." + int64ToBase64(size) + gzFileExt
+	if ext, ok := compressedFileExt[mode]; ok {
+		newRemote = remote + "." + int64ToBase64(size) + ext
 	} else {
 		newRemote = remote + uncompressedFileExt
 	}
@@ -503,7 +533,7 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 	pipeReader, pipeWriter := io.Pipe()
 	results := make(chan compressionResult)
 	go func() {
-		gz, err := sgzip.NewWriterLevel(pipeWriter, f.opt.CompressionLevel)
+		gz, err := newCompressor(pipeWriter, f.mode, f.opt.CompressionLevel)
 		if err != nil {
 			results <- compressionResult{err: err, meta: sgzip.GzipMetadata{}}
 			return
@@ -525,7 +555,7 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 		}
 		results <- compressionResult{err: err, meta: gz.MetaData()}
 	}()
-	wrappedIn := wrap(bufio.NewReaderSize(pipeReader, bufferSize)) // Probably no longer needed as sgzip has it's own buffering
+	wrappedIn := wrap(bufio.NewReaderSize(pipeReader, bufferSize)) // Probably no longer needed as the compressors have their own buffering
 
 	// Find a hash the destination supports to compute a hash of
 	// the compressed data.
@@ -1014,11 +1044,11 @@ func (f *Fs) PublicLink(ctx context.Context, remote string, duration fs.Duration
 
 // ObjectMetadata describes the metadata for an Object.
 type ObjectMetadata struct {
-	Mode                int    // Compression mode of the file.
-	Size                int64  // Size of the object.
-	MD5                 string // MD5 hash of the file.
-	MimeType            string // Mime type of the file
-	CompressionMetadata sgzip.GzipMetadata
+	Mode                int                // Compression mode of the file.
+	Size                int64              // Size of the object.
+	MD5                 string             // MD5 hash of the file.
+	MimeType            string             // Mime type of the file
+	CompressionMetadata sgzip.GzipMetadata // Block index of the compressed data.
 }
 
 // Object with external metadata
@@ -1309,13 +1339,9 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 	// Get a chunkedreader for the wrapped object
 	chunkedReader := chunkedreader.New(ctx, o.Object, initialChunkSize, maxChunkSize)
 	// Get file handle
-	var file io.Reader
-	if offset != 0 {
-		file, err = sgzip.NewReaderAt(chunkedReader, &o.meta.CompressionMetadata, offset)
-	} else {
-		file, err = sgzip.NewReader(chunkedReader)
-	}
+	file, err := newDecompressor(ctx, chunkedReader, o.meta.Mode, &o.meta.CompressionMetadata, offset, limit)
 	if err != nil {
+		_ = chunkedReader.Close()
 		return nil, err
 	}
 
@@ -1326,7 +1352,20 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 		fileReader = file
 	}
 	// Return a ReadCloser
-	return ReadCloserWrapper{Reader: fileReader, Closer: chunkedReader}, nil
+	return ReadCloserWrapper{Reader: fileReader, Closer: &multiCloser{file, chunkedReader}}, nil
+}
+
+// multiCloser closes each of the closers in turn returning the first error
+type multiCloser []io.Closer
+
+// Close all the closers
+func (mc multiCloser) Close() (err error) {
+	for _, c := range mc {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
 // ObjectInfo describes a wrapped fs.ObjectInfo for being the source
@@ -5,7 +5,9 @@ import (
 	"context"
 	"crypto/aes"
 	gocipher "crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base32"
 	"encoding/base64"
 	"errors"
@@ -32,6 +34,8 @@ import (
 const (
 	nameCipherBlockSize = aes.BlockSize
 	fileMagic           = "RCLONE\x00\x00"
+	fileMagicBound      = "RCLONE\x00\x01" // data key bound to the file path
+	fileMagicPublic     = "RCLONE\x00\x02" // data key agreed with a public key
 	fileMagicSize       = len(fileMagic)
 	fileNonceSize       = 24
 	fileHeaderSize      = fileMagicSize + fileNonceSize
@@ -44,6 +48,7 @@ const (
 var (
 	ErrorBadDecryptUTF8          = errors.New("bad decryption - utf-8 invalid")
 	ErrorBadDecryptControlChar   = errors.New("bad decryption - contains control chars")
+	ErrorBadDecryptBothKeys      = errors.New("bad decryption - name decrypts with both the password and the previous password")
 	ErrorNotAMultipleOfBlocksize = errors.New("not a multiple of blocksize")
 	ErrorTooShortAfterDecode     = errors.New("too short after base32 decode")
 	ErrorTooLongAfterDecode      = errors.New("too long after base32 decode")
@@ -51,6 +56,10 @@ var (
 	ErrorEncryptedFileBadHeader  = errors.New("file has truncated block header")
 	ErrorEncryptedBadMagic       = errors.New("not an encrypted file - bad magic string")
 	ErrorEncryptedBadBlock       = errors.New("failed to authenticate decrypted block - bad password?")
+	ErrorEncryptedNotBound       = errors.New("file isn't bound to its name and name_binding = require")
+	ErrorEncryptedNeedsPath      = errors.New("file is bound to its name but the name isn't known")
+	ErrorEncryptedKeyMode        = errors.New("file was encrypted in a different key mode - check public_key")
+	ErrorNoPrivateKey            = errors.New("can't decrypt without the private key - set password to read in public key mode")
 	ErrorBadBase32Encoding       = errors.New("bad base32 filename encoding")
 	ErrorFileClosed              = errors.New("file already closed")
 	ErrorNotAnEncryptedFile      = errors.New("not an encrypted file - does not match suffix")
@@ -62,7 +71,9 @@ var (
 
 // Global variables
 var (
-	fileMagicBytes = []byte(fileMagic)
+	fileMagicBytes       = []byte(fileMagic)
+	fileMagicBoundBytes  = []byte(fileMagicBound)
+	fileMagicPublicBytes = []byte(fileMagicPublic)
 )
 
 // ReadSeekCloser is the interface of the read handles
@@ -117,6 +128,47 @@ func (mode NameEncryptionMode) String() (out string) {
 	return out
 }
 
+// NameBindingMode is the type of file name to content binding in use
+type NameBindingMode int
+
+// NameBindingMode levels
+const (
+	NameBindingOff NameBindingMode = iota
+	NameBindingOn
+	NameBindingRequire
+)
+
+// NewNameBindingMode turns a string into a NameBindingMode
+func NewNameBindingMode(s string) (mode NameBindingMode, err error) {
+	s = strings.ToLower(s)
+	switch s {
+	case "off", "":
+		mode = NameBindingOff
+	case "on":
+		mode = NameBindingOn
+	case "require":
+		mode = NameBindingRequire
+	default:
+		err = fmt.Errorf("unknown name binding mode %q", s)
+	}
+	return mode, err
+}
+
+// String turns mode into a human-readable string
+func (mode NameBindingMode) String() (out string) {
+	switch mode {
+	case NameBindingOff:
+		out = "off"
+	case NameBindingOn:
+		out = "on"
+	case NameBindingRequire:
+		out = "require"
+	default:
+		out = fmt.Sprintf("Unknown mode #%d", mode)
+	}
+	return out
+}
+
 // fileNameEncoding are the encoding methods dealing with encrypted file names
 type fileNameEncoding interface {
 	EncodeToString(src []byte) string
@@ -181,6 +233,9 @@ type Cipher struct {
 	dirNameEncrypt  bool
 	passBadBlocks   bool // if set passed bad blocks as zeroed blocks
 	encryptedSuffix string
+	nameBinding     NameBindingMode
+	privateKey      *[32]byte // private key derived from the password, nil if not known
+	publicKey       *[32]byte // if set, encrypt data and names to this public key
 }
 
 // newCipher initialises the cipher.  If salt is "" then it uses a built in salt val
@@ -220,6 +275,33 @@ func (c *Cipher) setPassBadBlocks(passBadBlocks bool) {
 	c.passBadBlocks = passBadBlocks
 }
 
+// Call to set the name binding mode
+func (c *Cipher) setNameBinding(mode NameBindingMode) {
+	c.nameBinding = mode
+}
+
+// fileKey returns the data key for a file bound to path.
+//
+// path is the full path of the unencrypted file so the encrypted data
+// can't be swapped with another file or moved to another name without
+// failing to decrypt.
+func (c *Cipher) fileKey(path string) *[32]byte {
+	mac := hmac.New(sha256.New, c.dataKey[:])
+	_, _ = mac.Write([]byte("rclone crypt file key\x00"))
+	_, _ = mac.Write([]byte(path))
+	var key [32]byte
+	copy(key[:], mac.Sum(nil))
+	return &key
+}
+
+// headerSize returns the size of the header of files written by c
+func (c *Cipher) headerSize() int64 {
+	if c.publicKey != nil {
+		return int64(fileHeaderSize + publicKeySize)
+	}
+	return int64(fileHeaderSize)
+}
+
 // Key creates all the internal keys from the password passed in using
 // scrypt.
 //
@@ -246,6 +328,7 @@ func (c *Cipher) Key(password, salt string) (err error) {
 	copy(c.dataKey[:], key)
 	copy(c.nameKey[:], key[len(c.dataKey):])
 	copy(c.nameTweak[:], key[len(c.dataKey)+len(c.nameKey):])
+	c.privateKey = derivePrivateKey(&c.dataKey)
 	// Key the name cipher
 	c.block, err = aes.NewCipher(c.nameKey[:])
 	return err
@@ -279,6 +362,9 @@ func (c *Cipher) encryptSegment(plaintext string) string {
 	if plaintext == "" {
 		return ""
 	}
+	if c.publicKey != nil {
+		return c.sealSegment(plaintext)
+	}
 	paddedPlaintext := pkcs7.Pad(nameCipherBlockSize, []byte(plaintext))
 	ciphertext := eme.Transform(c.block, c.nameTweak[:], paddedPlaintext, eme.DirectionEncrypt)
 	return c.fileNameEnc.EncodeToString(ciphertext)
@@ -289,6 +375,9 @@ func (c *Cipher) decryptSegment(ciphertext string) (string, error) {
 	if ciphertext == "" {
 		return "", nil
 	}
+	if c.publicKey != nil {
+		return c.openSegment(ciphertext)
+	}
 	rawCiphertext, err := c.fileNameEnc.DecodeString(ciphertext)
 	if err != nil {
 		return "", err
@@ -683,6 +772,8 @@ type encrypter struct {
 	in       io.Reader
 	c        *Cipher
 	nonce    nonce
+	key      *[32]byte // key to encrypt the data with
+	header   []byte    // the file header written
 	buf      *[blockSize]byte
 	readBuf  *[blockSize]byte
 	bufIndex int
@@ -692,9 +783,17 @@ type encrypter struct {
 
 // newEncrypter creates a new file handle encrypting on the fly
 func (c *Cipher) newEncrypter(in io.Reader, nonce *nonce) (*encrypter, error) {
+	return c.newEncrypterPath(in, nonce, "")
+}
+
+// newEncrypterPath creates a new file handle encrypting on the fly
+// with the data key bound to path. If path is "" then the data key
+// isn't bound to the path.
+func (c *Cipher) newEncrypterPath(in io.Reader, nonce *nonce, path string) (*encrypter, error) {
 	fh := &encrypter{
 		in:      in,
 		c:       c,
+		key:     &c.dataKey,
 		buf:     c.getBlock(),
 		readBuf: c.getBlock(),
 		bufSize: fileHeaderSize,
@@ -709,12 +808,51 @@ func (c *Cipher) newEncrypter(in io.Reader, nonce *nonce) (*encrypter, error) {
 		}
 	}
 	// Copy magic into buffer
-	copy((*fh.buf)[:], fileMagicBytes)
+	switch {
+	case c.publicKey != nil:
+		copy((*fh.buf)[:], fileMagicPublicBytes)
+	case path != "":
+		fh.key = c.fileKey(path)
+		copy((*fh.buf)[:], fileMagicBoundBytes)
+	default:
+		copy((*fh.buf)[:], fileMagicBytes)
+	}
 	// Copy nonce into buffer
 	copy((*fh.buf)[fileMagicSize:], fh.nonce[:])
+	// Agree a key for this file with the public key
+	if c.publicKey != nil {
+		ephemeralPublicKey, key, err := c.newEphemeralKey()
+		if err != nil {
+			return nil, err
+		}
+		fh.key = key
+		copy((*fh.buf)[fileHeaderSize:], ephemeralPublicKey[:])
+		fh.bufSize += len(ephemeralPublicKey)
+	}
+	fh.header = append([]byte(nil), (*fh.buf)[:fh.bufSize]...)
 	return fh, nil
 }
 
+// newEncrypterWithHeader creates a new file handle encrypting on the
+// fly with key and header from another encrypter or decrypter.
+//
+// This encrypts data exactly as the original was encrypted so the
+// results can be compared.
+func (c *Cipher) newEncrypterWithHeader(in io.Reader, header []byte, key *[32]byte) *encrypter {
+	fh := &encrypter{
+		in:      in,
+		c:       c,
+		key:     key,
+		header:  header,
+		buf:     c.getBlock(),
+		readBuf: c.getBlock(),
+		bufSize: len(header),
+	}
+	fh.nonce.fromBuf(header[fileMagicSize:fileHeaderSize])
+	copy((*fh.buf)[:], header)
+	return fh
+}
+
 // Read as per io.Reader
 func (fh *encrypter) Read(p []byte) (n int, err error) {
 	fh.mu.Lock()
@@ -734,7 +872,7 @@ func (fh *encrypter) Read(p []byte) (n int, err error) {
 		// possibly err != nil here, but we will process the
 		// data and the next call to ReadFill will return 0, err
 		// Encrypt the block using the nonce
-		secretbox.Seal((*fh.buf)[:0], readBuf[:n], fh.nonce.pointer(), &fh.c.dataKey)
+		secretbox.Seal((*fh.buf)[:0], readBuf[:n], fh.nonce.pointer(), fh.key)
 		fh.bufIndex = 0
 		fh.bufSize = blockHeaderSize + n
 		fh.nonce.increment()
@@ -757,10 +895,11 @@ func (fh *encrypter) finish(err error) (int, error) {
 	return 0, err
 }
 
-// Encrypt data encrypts the data stream
-func (c *Cipher) encryptData(in io.Reader) (io.Reader, *encrypter, error) {
+// Encrypt data encrypts the data stream binding it to path if it
+// isn't ""
+func (c *Cipher) encryptData(in io.Reader, path string) (io.Reader, *encrypter, error) {
 	in, wrap := accounting.UnWrap(in) // unwrap the accounting off the Reader
-	out, err := c.newEncrypter(in, nil)
+	out, err := c.newEncrypterPath(in, nil, path)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -769,7 +908,7 @@ func (c *Cipher) encryptData(in io.Reader) (io.Reader, *encrypter, error) {
 
 // EncryptData encrypts the data stream
 func (c *Cipher) EncryptData(in io.Reader) (io.Reader, error) {
-	out, _, err := c.encryptData(in)
+	out, _, err := c.encryptData(in, "")
 	return out, err
 }
 
@@ -780,6 +919,10 @@ type decrypter struct {
 	nonce        nonce
 	initialNonce nonce
 	c            *Cipher
+	key          *[32]byte // key to decrypt the data with, nil if unknown
+	keyErr       error     // error to return if key is nil
+	bound        bool      // set if the data key is bound to the path
+	header       []byte    // the file header read
 	buf          *[blockSize]byte
 	readBuf      *[blockSize]byte
 	bufIndex     int
@@ -791,6 +934,13 @@ type decrypter struct {
 
 // newDecrypter creates a new file handle decrypting on the fly
 func (c *Cipher) newDecrypter(rc io.ReadCloser) (*decrypter, error) {
+	return c.newDecrypterPath(rc, "")
+}
+
+// newDecrypterPath creates a new file handle decrypting on the fly
+// for the file at path. If path is "" then files bound to their path
+// can't be decrypted, but the header can still be read.
+func (c *Cipher) newDecrypterPath(rc io.ReadCloser, path string) (*decrypter, error) {
 	fh := &decrypter{
 		rc:      rc,
 		c:       c,
@@ -808,17 +958,51 @@ func (c *Cipher) newDecrypter(rc io.ReadCloser) (*decrypter, error) {
 		return nil, fh.finishAndClose(err)
 	}
 	// check the magic
-	if !bytes.Equal(readBuf[:fileMagicSize], fileMagicBytes) {
+	magic := readBuf[:fileMagicSize]
+	isPublic := bytes.Equal(magic, fileMagicPublicBytes)
+	isSymmetric := bytes.Equal(magic, fileMagicBytes) || bytes.Equal(magic, fileMagicBoundBytes)
+	if (isPublic && c.publicKey == nil) || (isSymmetric && c.publicKey != nil) {
+		return nil, fh.finishAndClose(ErrorEncryptedKeyMode)
+	}
+	switch {
+	case isPublic:
+		// read the ephemeral public key
+		var ephemeralPublicKey [32]byte
+		_, err = io.ReadFull(fh.rc, ephemeralPublicKey[:])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fh.finishAndClose(ErrorEncryptedFileTooShort)
+		} else if err != nil {
+			return nil, fh.finishAndClose(err)
+		}
+		readBuf = append(readBuf, ephemeralPublicKey[:]...)
+		fh.key, err = c.agreeKey(&ephemeralPublicKey)
+		if err != nil {
+			return nil, fh.finishAndClose(err)
+		}
+	case bytes.Equal(magic, fileMagicBytes):
+		if c.nameBinding == NameBindingRequire {
+			return nil, fh.finishAndClose(ErrorEncryptedNotBound)
+		}
+		fh.key = &c.dataKey
+	case bytes.Equal(magic, fileMagicBoundBytes):
+		fh.bound = true
+		if path != "" {
+			fh.key = c.fileKey(path)
+		} else {
+			fh.keyErr = ErrorEncryptedNeedsPath
+		}
+	default:
 		return nil, fh.finishAndClose(ErrorEncryptedBadMagic)
 	}
+	fh.header = append([]byte(nil), readBuf...)
 	// retrieve the nonce
-	fh.nonce.fromBuf(readBuf[fileMagicSize:])
+	fh.nonce.fromBuf(readBuf[fileMagicSize:fileHeaderSize])
 	fh.initialNonce = fh.nonce
 	return fh, nil
 }
 
 // newDecrypterSeek creates a new file handle decrypting on the fly
-func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offset, limit int64) (fh *decrypter, err error) {
+func (c *Cipher) newDecrypterSeek(ctx context.Context, path string, open OpenRangeSeek, offset, limit int64) (fh *decrypter, err error) {
 	var rc io.ReadCloser
 	doRangeSeek := false
 	setLimit := false
@@ -829,18 +1013,18 @@ func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offse
 	} else if offset == 0 {
 		// If no offset open the header + limit worth of the file
 		_, underlyingLimit, _, _ := calculateUnderlying(offset, limit)
-		rc, err = open(ctx, 0, int64(fileHeaderSize)+underlyingLimit)
+		rc, err = open(ctx, 0, c.headerSize()+underlyingLimit)
 		setLimit = true
 	} else {
 		// Otherwise just read the header to start with
-		rc, err = open(ctx, 0, int64(fileHeaderSize))
+		rc, err = open(ctx, 0, c.headerSize())
 		doRangeSeek = true
 	}
 	if err != nil {
 		return nil, err
 	}
 	// Open the stream which fills in the nonce
-	fh, err = c.newDecrypter(rc)
+	fh, err = c.newDecrypterPath(rc, path)
 	if err != nil {
 		return nil, err
 	}
@@ -876,8 +1060,11 @@ func (fh *decrypter) fillBuffer() (err error) {
 		}
 		return ErrorEncryptedFileBadHeader
 	}
+	if fh.key == nil {
+		return fh.keyErr
+	}
 	// Decrypt the block using the nonce
-	_, ok := secretbox.Open((*fh.buf)[:0], (*readBuf)[:n], fh.nonce.pointer(), &fh.c.dataKey)
+	_, ok := secretbox.Open((*fh.buf)[:0], (*readBuf)[:n], fh.nonce.pointer(), fh.key)
 	if !ok {
 		if err != nil && err != io.EOF {
 			return err // return pending error as it is likely more accurate
@@ -988,6 +1175,7 @@ func (fh *decrypter) RangeSeek(ctx context.Context, offset int64, whence int, li
 	}
 
 	underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(offset, limit)
+	underlyingOffset += int64(len(fh.header) - fileHeaderSize)
 
 	// Move the nonce on the correct number of blocks from the start
 	fh.nonce = fh.initialNonce
@@ -1110,7 +1298,13 @@ func (c *Cipher) DecryptData(rc io.ReadCloser) (io.ReadCloser, error) {
 //
 // You must use this form of DecryptData if you might want to Seek the file handle
 func (c *Cipher) DecryptDataSeek(ctx context.Context, open OpenRangeSeek, offset, limit int64) (ReadSeekCloser, error) {
-	out, err := c.newDecrypterSeek(ctx, open, offset, limit)
+	return c.decryptDataSeek(ctx, "", open, offset, limit)
+}
+
+// decryptDataSeek decrypts the data stream of the file at path from
+// offset. path is used to find the key of files bound to their path.
+func (c *Cipher) decryptDataSeek(ctx context.Context, path string, open OpenRangeSeek, offset, limit int64) (ReadSeekCloser, error) {
+	out, err := c.newDecrypterSeek(ctx, path, open, offset, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -1120,7 +1314,7 @@ func (c *Cipher) DecryptDataSeek(ctx context.Context, open OpenRangeSeek, offset
 // EncryptedSize calculates the size of the data when encrypted
 func (c *Cipher) EncryptedSize(size int64) int64 {
 	blocks, residue := size/blockDataSize, size%blockDataSize
-	encryptedSize := int64(fileHeaderSize) + blocks*(blockHeaderSize+blockDataSize)
+	encryptedSize := c.headerSize() + blocks*(blockHeaderSize+blockDataSize)
 	if residue != 0 {
 		encryptedSize += blockHeaderSize + residue
 	}
@@ -1129,7 +1323,7 @@ func (c *Cipher) EncryptedSize(size int64) int64 {
 
 // DecryptedSize calculates the size of the data when decrypted
 func (c *Cipher) DecryptedSize(size int64) (int64, error) {
-	size -= int64(fileHeaderSize)
+	size -= c.headerSize()
 	if size < 0 {
 		return 0, ErrorEncryptedFileTooShort
 	}
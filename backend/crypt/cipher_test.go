@@ -1253,6 +1253,11 @@ func TestNewDecrypter(t *testing.T) {
 	copy(file0copy, file0)
 	for i := range fileMagic {
 		file0copy[i] ^= 0x1
+		if string(file0copy[:fileMagicSize]) == fileMagicBound {
+			// this is the magic of files bound to their name
+			file0copy[i] ^= 0x1
+			continue
+		}
 		cd := newCloseDetector(bytes.NewBuffer(file0copy))
 		fh, err := c.newDecrypter(cd)
 		assert.Nil(t, fh)
@@ -1279,6 +1284,49 @@ func TestNewDecrypterErrUnexpectedEOF(t *testing.T) {
 	assert.Equal(t, int64(16), n)
 }
 
+func TestNameBound(t *testing.T) {
+	c, err := newCipher(NameEncryptionStandard, "", "", true, nil)
+	assert.NoError(t, err)
+	plaintext := bytes.Repeat([]byte("potato"), 20000)
+
+	encrypt := func(path string) []byte {
+		fh, err := c.newEncrypterPath(bytes.NewReader(plaintext), nil, path)
+		require.NoError(t, err)
+		out, err := io.ReadAll(fh)
+		require.NoError(t, err)
+		return out
+	}
+	decrypt := func(in []byte, path string) ([]byte, error) {
+		fh, err := c.newDecrypterPath(io.NopCloser(bytes.NewReader(in)), path)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(fh)
+	}
+
+	bound := encrypt("dir/file")
+	assert.Equal(t, fileMagicBound, string(bound[:fileMagicSize]))
+	out, err := decrypt(bound, "dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, out)
+
+	_, err = decrypt(bound, "dir/file2")
+	assert.Equal(t, ErrorEncryptedBadBlock, err)
+	_, err = decrypt(bound, "")
+	assert.Equal(t, ErrorEncryptedNeedsPath, err)
+
+	// Unbound files decrypt whatever the path
+	unbound := encrypt("")
+	assert.Equal(t, fileMagic, string(unbound[:fileMagicSize]))
+	out, err = decrypt(unbound, "dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, out)
+
+	c.setNameBinding(NameBindingRequire)
+	_, err = decrypt(unbound, "dir/file")
+	assert.Equal(t, ErrorEncryptedNotBound, err)
+}
+
 func TestNewDecrypterSeekLimit(t *testing.T) {
 	c, err := newCipher(NameEncryptionStandard, "", "", true, nil)
 	assert.NoError(t, err)
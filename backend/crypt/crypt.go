@@ -9,6 +9,7 @@ import (
 	"path"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
@@ -159,6 +160,79 @@ Setting suffix to "none" will result in an empty suffix. This may be useful
 when the path length is critical.`,
 			Default:  ".bin",
 			Advanced: true,
+		}, {
+			Name: "name_binding",
+			Help: `Bind the encrypted data of each file to its name.
+
+When this is on, files are written in a format where the key used to
+encrypt the data is derived from the full path of the file, so a file
+can't be renamed, moved or swapped with another file on the remote
+without failing to decrypt.
+
+Files written without name binding can still be read unless this is
+set to "require".
+
+Server-side copy and move are not available when this is on as the
+data has to be re-encrypted for its new name.
+
+Note that versions of rclone without this option can't read files
+written with it on.`,
+			Default:  "off",
+			Advanced: true,
+			Examples: []fs.OptionExample{
+				{
+					Value: "off",
+					Help:  "Don't bind the data to the file name.",
+				},
+				{
+					Value: "on",
+					Help:  "Bind the data of new files to their name, read files with or without.",
+				},
+				{
+					Value: "require",
+					Help:  "Bind the data of new files to their name, refuse to read files without.",
+				},
+			},
+		}, {
+			Name: "public_key",
+			Help: `Public key to encrypt to instead of the password.
+
+If this is set then files are encrypted so they can only be decrypted
+with the private key for it, and file names are sealed so they can
+only be listed with the private key.
+
+The private key is derived from password and password2. Get the public
+key to put here with "rclone backend publickey crypt:" on a remote
+with the password set.
+
+Remotes with password set as well as this can read and write as
+normal. Remotes without password can only write new files so a
+machine which uploads backups can't read them back.
+
+This can't be used with files encrypted without it, so use it on a new
+directory. It needs filename_encryption to be "standard" or "off".`,
+			Advanced: true,
+		}, {
+			Name: "previous_password",
+			Help: `Password or pass phrase used before the password was changed.
+
+When changing the password of a crypt remote set password and
+password2 to the new values and this and previous_password2 to the
+old values. The remote will then read files encrypted with either,
+writing new files with the new password, while the "rekey" backend
+command re-encrypts the existing files.
+
+Remove it once "rekey" has finished.
+
+This needs filename_encryption to be "standard" so that the files
+encrypted with the old password can be told apart.`,
+			IsPassword: true,
+			Advanced:   true,
+		}, {
+			Name:       "previous_password2",
+			Help:       "Password or pass phrase for salt used before the password was changed.\n\nSee previous_password.",
+			IsPassword: true,
+			Advanced:   true,
 		}},
 	})
 }
@@ -169,12 +243,15 @@ func newCipherForConfig(opt *Options) (*Cipher, error) {
 	if err != nil {
 		return nil, err
 	}
-	if opt.Password == "" {
+	if opt.Password == "" && opt.PublicKey == "" {
 		return nil, errors.New("password not set in config file")
 	}
-	password, err := obscure.Reveal(opt.Password)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt password: %w", err)
+	var password string
+	if opt.Password != "" {
+		password, err = obscure.Reveal(opt.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt password: %w", err)
+		}
 	}
 	var salt string
 	if opt.Password2 != "" {
@@ -193,6 +270,27 @@ func newCipherForConfig(opt *Options) (*Cipher, error) {
 	}
 	cipher.setEncryptedSuffix(opt.Suffix)
 	cipher.setPassBadBlocks(opt.PassBadBlocks)
+	nameBinding, err := NewNameBindingMode(opt.NameBinding)
+	if err != nil {
+		return nil, err
+	}
+	cipher.setNameBinding(nameBinding)
+	if opt.PublicKey != "" {
+		if nameBinding != NameBindingOff {
+			return nil, errors.New("name_binding can't be used with public_key")
+		}
+		if opt.PreviousPassword != "" {
+			return nil, errors.New("previous_password can't be used with public_key")
+		}
+		publicKey, err := ParsePublicKey(opt.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		err = cipher.setPublicKey(publicKey, opt.Password != "")
+		if err != nil {
+			return nil, err
+		}
+	}
 	return cipher, nil
 }
 
@@ -227,28 +325,23 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	if path.Base(rpath) == "." {
 		rpath = strings.TrimSuffix(rpath, ".")
 	}
-	// Look for a file first
-	var wrappedFs fs.Fs
-	if rpath == "" {
-		wrappedFs, err = cache.Get(ctx, remote)
-	} else {
-		remotePath := fspath.JoinRootPath(remote, cipher.EncryptFileName(rpath))
-		wrappedFs, err = cache.Get(ctx, remotePath)
-		// if that didn't produce a file, look for a directory
-		if err != fs.ErrorIsFile {
-			remotePath = fspath.JoinRootPath(remote, cipher.EncryptDirName(rpath))
-			wrappedFs, err = cache.Get(ctx, remotePath)
-		}
-	}
+	wrappedFs, err := newWrappedFs(ctx, remote, rpath, cipher)
 	if err != fs.ErrorIsFile && err != nil {
-		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", remote, err)
+		return nil, err
 	}
 	f := &Fs{
-		Fs:     wrappedFs,
-		name:   name,
-		root:   rpath,
-		opt:    *opt,
-		cipher: cipher,
+		Fs:       wrappedFs,
+		name:     name,
+		root:     rpath,
+		bindRoot: rpath,
+		opt:      *opt,
+		cipher:   cipher,
+	}
+	if err == fs.ErrorIsFile {
+		f.bindRoot = path.Dir(rpath)
+		if f.bindRoot == "." || f.bindRoot == "/" {
+			f.bindRoot = ""
+		}
 	}
 	cache.PinUntilFinalized(f.Fs, f)
 	// the features here are ones we could support, and they are
@@ -268,9 +361,77 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 		UserMetadata:            true,
 	}).Fill(ctx, f).Mask(ctx, wrappedFs).WrapsFs(f, wrappedFs)
 
+	if cipher.nameBinding != NameBindingOff && !opt.NoDataEncryption {
+		// The data needs re-encrypting for its new name
+		f.features.Disable("Copy")
+		f.features.Disable("Move")
+		f.features.Disable("DirMove")
+	}
+
+	if opt.PreviousPassword != "" {
+		prevErr := f.newPrevious(ctx)
+		if prevErr != nil {
+			return nil, prevErr
+		}
+	}
+
 	return f, err
 }
 
+// newWrappedFs makes the Fs to wrap for rpath on remote looking for a
+// file first then a directory.
+func newWrappedFs(ctx context.Context, remote, rpath string, cipher *Cipher) (wrappedFs fs.Fs, err error) {
+	if rpath == "" {
+		wrappedFs, err = cache.Get(ctx, remote)
+	} else {
+		remotePath := fspath.JoinRootPath(remote, cipher.EncryptFileName(rpath))
+		wrappedFs, err = cache.Get(ctx, remotePath)
+		// if that didn't produce a file, look for a directory
+		if err != fs.ErrorIsFile {
+			remotePath = fspath.JoinRootPath(remote, cipher.EncryptDirName(rpath))
+			wrappedFs, err = cache.Get(ctx, remotePath)
+		}
+	}
+	if err != fs.ErrorIsFile && err != nil {
+		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", remote, err)
+	}
+	return wrappedFs, err
+}
+
+// newPrevious sets up f.previous to read the files encrypted with
+// previous_password while rekeying.
+func (f *Fs) newPrevious(ctx context.Context) error {
+	if f.cipher.NameEncryptionMode() != NameEncryptionStandard {
+		return errors.New("previous_password needs filename_encryption to be \"standard\"")
+	}
+	opt := f.opt
+	opt.Password, opt.Password2 = opt.PreviousPassword, opt.PreviousPassword2
+	opt.PreviousPassword, opt.PreviousPassword2 = "", ""
+	cipher, err := newCipherForConfig(&opt)
+	if err != nil {
+		return fmt.Errorf("previous_password: %w", err)
+	}
+	wrappedFs, err := newWrappedFs(ctx, opt.Remote, f.root, cipher)
+	if err != fs.ErrorIsFile && err != nil {
+		return err
+	}
+	f.previous = &Fs{
+		Fs:       wrappedFs,
+		name:     f.name,
+		root:     f.root,
+		bindRoot: f.bindRoot,
+		opt:      opt,
+		features: f.features,
+		cipher:   cipher,
+		next:     f,
+	}
+	cache.PinUntilFinalized(f.previous.Fs, f.previous)
+	// These can't work on files encrypted with two keys
+	f.features.Disable("ListR")
+	f.features.Disable("DirMove")
+	return nil
+}
+
 // Options defines the configuration for this backend
 type Options struct {
 	Remote                  string `config:"remote"`
@@ -284,6 +445,10 @@ type Options struct {
 	PassBadBlocks           bool   `config:"pass_bad_blocks"`
 	FilenameEncoding        string `config:"filename_encoding"`
 	Suffix                  string `config:"suffix"`
+	PreviousPassword        string `config:"previous_password"`
+	PreviousPassword2       string `config:"previous_password2"`
+	NameBinding             string `config:"name_binding"`
+	PublicKey               string `config:"public_key"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -292,9 +457,12 @@ type Fs struct {
 	wrapper  fs.Fs
 	name     string
 	root     string
+	bindRoot string // the directory the paths of bound files are relative to
 	opt      Options
 	features *fs.Features // optional features
 	cipher   *Cipher
+	previous *Fs // if set, the files encrypted with previous_password
+	next     *Fs // if set, this is the previous of next
 }
 
 // Name of the remote (as passed into NewFs)
@@ -320,8 +488,14 @@ func (f *Fs) String() string {
 // Encrypt an object file name to entries.
 func (f *Fs) add(entries *fs.DirEntries, obj fs.Object) {
 	remote := obj.Remote()
-	decryptedRemote, err := f.cipher.DecryptFileName(remote)
-	if err != nil {
+	if path.Base(remote) == manifestName {
+		return
+	}
+	decryptedRemote, err := f.decryptName(remote, false)
+	if err == ErrorBadDecryptBothKeys {
+		fs.Errorf(remote, "Skipping file name: %v", err)
+		return
+	} else if err != nil {
 		fs.Debugf(remote, "Skipping undecryptable file name: %v", err)
 		return
 	}
@@ -334,8 +508,11 @@ func (f *Fs) add(entries *fs.DirEntries, obj fs.Object) {
 // Encrypt a directory file name to entries.
 func (f *Fs) addDir(ctx context.Context, entries *fs.DirEntries, dir fs.Directory) {
 	remote := dir.Remote()
-	decryptedRemote, err := f.cipher.DecryptDirName(remote)
-	if err != nil {
+	decryptedRemote, err := f.decryptName(remote, true)
+	if err == ErrorBadDecryptBothKeys {
+		fs.Errorf(remote, "Skipping dir name: %v", err)
+		return
+	} else if err != nil {
 		fs.Debugf(remote, "Skipping undecryptable dir name: %v", err)
 		return
 	}
@@ -345,6 +522,71 @@ func (f *Fs) addDir(ctx context.Context, entries *fs.DirEntries, dir fs.Director
 	*entries = append(*entries, f.newDir(ctx, dir))
 }
 
+// decryptName decrypts a file or directory name from the wrapped
+// remote.
+//
+// When rekeying, a name encrypted with one key will occasionally
+// decrypt without error with the other key. So the name is only
+// taken to be encrypted with this Fs's key if it decrypts with it and
+// doesn't decrypt with the other key, otherwise the key it belongs to
+// isn't known and it returns ErrorBadDecryptBothKeys.
+func (f *Fs) decryptName(remote string, isDir bool) (string, error) {
+	name, err := f.decryptWithKey(remote, isDir)
+	other := f.previous
+	if other == nil {
+		other = f.next
+	}
+	if err != nil || other == nil || (isDir && !f.cipher.dirNameEncrypt) {
+		return name, err
+	}
+	_, err = other.decryptWithKey(remote, isDir)
+	if err == nil {
+		return "", ErrorBadDecryptBothKeys
+	}
+	return name, nil
+}
+
+// decryptWithKey decrypts a file or directory name with this Fs's key
+// only.
+//
+// When rekeying names which aren't valid UTF-8 or contain control
+// characters are rejected so names encrypted with the other key
+// fail to decrypt.
+func (f *Fs) decryptWithKey(remote string, isDir bool) (name string, err error) {
+	if isDir {
+		name, err = f.cipher.DecryptDirName(remote)
+	} else {
+		name, err = f.cipher.DecryptFileName(remote)
+	}
+	if err != nil || (f.previous == nil && f.next == nil) {
+		return name, err
+	}
+	if !utf8.ValidString(name) {
+		return "", ErrorBadDecryptUTF8
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f {
+			return "", ErrorBadDecryptControlChar
+		}
+	}
+	return name, nil
+}
+
+// fullPath returns the path of remote from the root of the crypt
+// remote which is what the data of bound files is bound to.
+func (f *Fs) fullPath(remote string) string {
+	return path.Join(f.bindRoot, remote)
+}
+
+// bindPath returns the path to bind the data of a new file at remote
+// to, or "" if it shouldn't be bound.
+func (f *Fs) bindPath(remote string) string {
+	if f.cipher.nameBinding == NameBindingOff {
+		return ""
+	}
+	return f.fullPath(remote)
+}
+
 // Encrypt some directory entries.  This alters entries returning it as newEntries.
 func (f *Fs) encryptEntries(ctx context.Context, entries fs.DirEntries) (newEntries fs.DirEntries, err error) {
 	newEntries = entries[:0] // in place filter
@@ -371,6 +613,35 @@ func (f *Fs) encryptEntries(ctx context.Context, entries fs.DirEntries) (newEntr
 // This should return ErrDirNotFound if the directory isn't
 // found.
 func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	entries, err = f.list(ctx, dir)
+	if f.previous == nil {
+		return entries, err
+	}
+	prevEntries, prevErr := f.previous.list(ctx, dir)
+	if err == fs.ErrorDirNotFound && prevErr == nil {
+		return prevEntries, nil
+	}
+	if err != nil || prevErr == fs.ErrorDirNotFound {
+		return entries, err
+	}
+	if prevErr != nil {
+		return nil, prevErr
+	}
+	// Merge the entries preferring those with the current key
+	seen := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		seen[entry.Remote()] = struct{}{}
+	}
+	for _, entry := range prevEntries {
+		if _, found := seen[entry.Remote()]; !found {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// list the directory using this Fs's key only
+func (f *Fs) list(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
 	entries, err = f.Fs.List(ctx, f.cipher.EncryptDirName(dir))
 	if err != nil {
 		return nil, err
@@ -406,6 +677,15 @@ func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (
 
 // NewObject finds the Object at remote.
 func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o, err := f.newObjectWithKey(ctx, remote)
+	if err == fs.ErrorObjectNotFound && f.previous != nil {
+		return f.previous.newObjectWithKey(ctx, remote)
+	}
+	return o, err
+}
+
+// newObjectWithKey finds the Object at remote using this Fs's key only
+func (f *Fs) newObjectWithKey(ctx context.Context, remote string) (fs.Object, error) {
 	o, err := f.Fs.NewObject(ctx, f.cipher.EncryptFileName(remote))
 	if err != nil {
 		return nil, err
@@ -420,7 +700,7 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	ci := fs.GetConfig(ctx)
 
 	if f.opt.NoDataEncryption {
-		o, err := put(ctx, in, f.newObjectInfo(src, nonce{}), options...)
+		o, err := put(ctx, in, f.newObjectInfo(src, nil, nil), options...)
 		if err == nil && o != nil {
 			o = f.newObject(o)
 		}
@@ -428,7 +708,8 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	}
 
 	// Encrypt the data into wrappedIn
-	wrappedIn, encrypter, err := f.cipher.encryptData(in)
+	bindPath := f.bindPath(src.Remote())
+	wrappedIn, encrypter, err := f.cipher.encryptData(in, bindPath)
 	if err != nil {
 		return nil, err
 	}
@@ -455,7 +736,7 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	}
 
 	// Transfer the data
-	o, err := put(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce), options...)
+	o, err := put(ctx, wrappedIn, f.newObjectInfo(src, encrypter.header, encrypter.key), options...)
 	if err != nil {
 		return nil, err
 	}
@@ -514,7 +795,24 @@ func (f *Fs) Mkdir(ctx context.Context, dir string) error {
 //
 // Return an error if it doesn't exist or isn't empty
 func (f *Fs) Rmdir(ctx context.Context, dir string) error {
-	return f.Fs.Rmdir(ctx, f.cipher.EncryptDirName(dir))
+	err := f.rmdir(ctx, dir)
+	if f.previous == nil || !f.previous.separateDir(dir) {
+		return err
+	}
+	prevErr := f.previous.Rmdir(ctx, dir)
+	if err == fs.ErrorDirNotFound {
+		return prevErr
+	}
+	if err == nil && prevErr != fs.ErrorDirNotFound {
+		return prevErr
+	}
+	return err
+}
+
+// separateDir returns true if dir is in a different directory of the
+// wrapped remote for this Fs and the Fs it is the previous of
+func (f *Fs) separateDir(dir string) bool {
+	return f.Fs != f.next.Fs || f.cipher.EncryptDirName(dir) != f.next.cipher.EncryptDirName(dir)
 }
 
 // Purge all files in the directory specified
@@ -528,7 +826,18 @@ func (f *Fs) Purge(ctx context.Context, dir string) error {
 	if do == nil {
 		return fs.ErrorCantPurge
 	}
-	return do(ctx, f.cipher.EncryptDirName(dir))
+	err := do(ctx, f.cipher.EncryptDirName(dir))
+	if f.previous == nil || !f.previous.separateDir(dir) {
+		return err
+	}
+	prevErr := f.previous.Purge(ctx, dir)
+	if err == fs.ErrorDirNotFound {
+		return prevErr
+	}
+	if err == nil && prevErr != fs.ErrorDirNotFound {
+		return prevErr
+	}
+	return err
 }
 
 // Copy src to this remote using server-side copy operations.
@@ -549,6 +858,10 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	if !ok {
 		return nil, fs.ErrorCantCopy
 	}
+	if o.f != f && !f.opt.NoDataEncryption {
+		// data encrypted with the previous key needs re-encrypting
+		return nil, fs.ErrorCantCopy
+	}
 	oResult, err := do(ctx, o.Object, f.cipher.EncryptFileName(remote))
 	if err != nil {
 		return nil, err
@@ -574,6 +887,10 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	if !ok {
 		return nil, fs.ErrorCantMove
 	}
+	if o.f != f && !f.opt.NoDataEncryption {
+		// data encrypted with the previous key needs re-encrypting
+		return nil, fs.ErrorCantMove
+	}
 	oResult, err := do(ctx, o.Object, f.cipher.EncryptFileName(remote))
 	if err != nil {
 		return nil, err
@@ -611,11 +928,12 @@ func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo,
 	if do == nil {
 		return nil, errors.New("can't PutUnchecked")
 	}
-	wrappedIn, encrypter, err := f.cipher.encryptData(in)
+	bindPath := f.bindPath(src.Remote())
+	wrappedIn, encrypter, err := f.cipher.encryptData(in, bindPath)
 	if err != nil {
 		return nil, err
 	}
-	o, err := do(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce))
+	o, err := do(ctx, wrappedIn, f.newObjectInfo(src, encrypter.header, encrypter.key))
 	if err != nil {
 		return nil, err
 	}
@@ -668,11 +986,12 @@ func (f *Fs) DecryptFileName(encryptedFileName string) (string, error) {
 	return f.cipher.DecryptFileName(encryptedFileName)
 }
 
-// computeHashWithNonce takes the nonce and encrypts the contents of
-// src with it, and calculates the hash given by HashType on the fly
+// computeHashWithHeader takes the header and key of an encrypted file
+// and encrypts the contents of src with them, and calculates the hash
+// given by HashType on the fly
 //
 // Note that we break lots of encapsulation in this function.
-func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Object, hashType hash.Type) (hashStr string, err error) {
+func (f *Fs) computeHashWithHeader(ctx context.Context, header []byte, key *[32]byte, src fs.Object, hashType hash.Type) (hashStr string, err error) {
 	// Open the src for input
 	in, err := src.Open(ctx)
 	if err != nil {
@@ -680,11 +999,8 @@ func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Objec
 	}
 	defer fs.CheckClose(in, &err)
 
-	// Now encrypt the src with the nonce
-	out, err := f.cipher.newEncrypter(in, &nonce)
-	if err != nil {
-		return "", fmt.Errorf("failed to make encrypter: %w", err)
-	}
+	// Now encrypt the src with the header
+	out := f.cipher.newEncrypterWithHeader(in, header, key)
 
 	// pipe into hash
 	m, err := hash.NewMultiHasherTypes(hash.NewHashSet(hashType))
@@ -710,16 +1026,21 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 
 	// Read the nonce - opening the file is sufficient to read the nonce in
 	// use a limited read so we only read the header
-	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(fileHeaderSize) - 1})
+	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: o.f.cipher.headerSize() - 1})
 	if err != nil {
 		return "", fmt.Errorf("failed to open object to read nonce: %w", err)
 	}
-	d, err := f.cipher.newDecrypter(in)
+	d, err := o.f.cipher.newDecrypterPath(in, o.f.fullPath(o.Remote()))
 	if err != nil {
 		_ = in.Close()
 		return "", fmt.Errorf("failed to open object to read nonce: %w", err)
 	}
 	nonce := d.nonce
+	header, key := d.header, d.key
+	if key == nil {
+		_ = d.Close()
+		return "", d.keyErr
+	}
 	// fs.Debugf(o, "Read nonce % 2x", nonce)
 
 	// Check nonce isn't all zeros
@@ -739,7 +1060,7 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 		return "", fmt.Errorf("failed to close nonce read: %w", err)
 	}
 
-	return f.computeHashWithNonce(ctx, nonce, src, hashType)
+	return o.f.computeHashWithHeader(ctx, header, key, src, hashType)
 }
 
 // MergeDirs merges the contents of all the directories passed
@@ -795,9 +1116,15 @@ func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryT
 		)
 		switch entryType {
 		case fs.EntryDirectory:
-			decrypted, err = f.cipher.DecryptDirName(path)
+			decrypted, err = f.decryptName(path, true)
+			if err != nil && err != ErrorBadDecryptBothKeys && f.previous != nil {
+				decrypted, err = f.previous.decryptName(path, true)
+			}
 		case fs.EntryObject:
-			decrypted, err = f.cipher.DecryptFileName(path)
+			decrypted, err = f.decryptName(path, false)
+			if err != nil && err != ErrorBadDecryptBothKeys && f.previous != nil {
+				decrypted, err = f.previous.decryptName(path, false)
+			}
 		default:
 			fs.Errorf(path, "crypt ChangeNotify: ignoring unknown EntryType %d", entryType)
 			return
@@ -835,6 +1162,76 @@ Usage Example:
 
     rclone backend decode crypt: encryptedfile1 [encryptedfile2...]
     rclone rc backend/command command=decode fs=crypt: encryptedfile1 [encryptedfile2...]
+`,
+	},
+	{
+		Name:  "rekey",
+		Short: "Re-encrypt the files with the current password",
+		Long: `This re-encrypts the names and data of all the files encrypted with
+previous_password to use password, for use after changing the
+password.
+
+To change the password, set password and password2 to the new values
+and previous_password and previous_password2 to the old ones, then run:
+
+    rclone backend rekey crypt:
+    rclone rc backend/command command=rekey fs=crypt:
+
+While this is running the remote can be used as normal, reading files
+encrypted with either password. If only the names are encrypted
+(no_data_encryption) the files are renamed server-side, otherwise the
+data is streamed through rclone to re-encrypt it.
+
+Progress is recorded in a journal in the cache directory and the
+command can be interrupted and run again to carry on. It returns the
+number of files rekeyed, stale files deleted and directories moved.
+
+When it has finished without errors previous_password and
+previous_password2 can be removed from the config.
+`,
+	},
+	{
+		Name:  "verify",
+		Short: "Verify the integrity of all the files",
+		Long: `This reads all the files, checking every block of their data
+authenticates with the key for their name, without writing the
+decrypted data anywhere.
+
+    rclone backend verify crypt:
+    rclone backend verify crypt: -o update
+    rclone rc backend/command command=verify fs=crypt:
+
+If a directory has a manifest it is checked to see whether any files
+or directories have been added, removed or replaced since it was
+written. With the "update" option the manifests are written (or
+rewritten) after checking, so run it with "update" to record a known
+good state.
+
+The manifests are stored in each directory on the underlying remote
+and are authenticated with a key derived from the password. They only
+contain the encrypted names, with the path of the directory included
+in the authentication so a manifest can't be moved to another
+directory.
+
+It returns the number of files checked, how many of them have their
+data bound to their name (see name_binding) and the number of errors.
+`,
+		Opts: map[string]string{
+			"update": "Write the manifests after checking them",
+		},
+	},
+	{
+		Name:  "publickey",
+		Short: "Show the public key for the password",
+		Long: `This shows the public key for the password and password2 of the
+remote, to put in the public_key option.
+
+    rclone backend publickey crypt:
+    rclone rc backend/command command=publickey fs=crypt:
+
+Set public_key to this on the remotes which should only be able to
+write files, leaving out the password. Set it on remotes with the
+password to write files in public key mode too.
 `,
 	},
 }
@@ -867,6 +1264,13 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 			out = append(out, encryptedFileName)
 		}
 		return out, nil
+	case "rekey":
+		return f.rekey(ctx)
+	case "verify":
+		_, update := opt["update"]
+		return f.verify(ctx, update)
+	case "publickey":
+		return f.cipher.PublicKey()
 	default:
 		return nil, fs.ErrorCommandNotFound
 	}
@@ -889,6 +1293,9 @@ func (f *Fs) newObject(o fs.Object) *Object {
 
 // Fs returns read only access to the Fs that this object is part of
 func (o *Object) Fs() fs.Info {
+	if o.f.next != nil {
+		return o.f.next
+	}
 	return o.f
 }
 
@@ -954,7 +1361,7 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 			openOptions = append(openOptions, option)
 		}
 	}
-	rc, err = o.f.cipher.DecryptDataSeek(ctx, func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
+	rc, err = o.f.cipher.decryptDataSeek(ctx, o.f.fullPath(o.Remote()), func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
 		if underlyingOffset == 0 && underlyingLimit < 0 {
 			// Open with no seek
 			return o.Object.Open(ctx, openOptions...)
@@ -978,6 +1385,24 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 
 // Update in to the object with the modTime given of the given size
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	if f := o.f.next; f != nil {
+		// Encrypted with the previous key so write a new object
+		// with the current key and remove this one
+		newObj, err := f.put(ctx, in, fs.NewOverrideRemote(src, o.Remote()), options, f.Fs.Put)
+		if err != nil {
+			return err
+		}
+		if err = o.Object.Remove(ctx); err != nil {
+			return fmt.Errorf("failed to remove object encrypted with previous key: %w", err)
+		}
+		o.Object = newObj.(*Object).Object
+		o.f = f
+		return nil
+	}
+	if o.f.cipher.nameBinding != NameBindingOff && src.Remote() != o.Remote() {
+		// Bind the data to the name of this object
+		src = fs.NewOverrideRemote(src, o.Remote())
+	}
 	update := func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 		return o.Object, o.Object.Update(ctx, in, src, options...)
 	}
@@ -1031,15 +1456,17 @@ func (f *Fs) Shutdown(ctx context.Context) error {
 // This encrypts the remote name and adjusts the size
 type ObjectInfo struct {
 	fs.ObjectInfo
-	f     *Fs
-	nonce nonce
+	f      *Fs
+	header []byte    // header of the encrypted data, nil if not encrypted
+	key    *[32]byte // key the data is encrypted with
 }
 
-func (f *Fs) newObjectInfo(src fs.ObjectInfo, nonce nonce) *ObjectInfo {
+func (f *Fs) newObjectInfo(src fs.ObjectInfo, header []byte, key *[32]byte) *ObjectInfo {
 	return &ObjectInfo{
 		ObjectInfo: src,
 		f:          f,
-		nonce:      nonce,
+		header:     header,
+		key:        key,
 	}
 }
 
@@ -1080,11 +1507,15 @@ func (o *ObjectInfo) Hash(ctx context.Context, hash hash.Type) (string, error) {
 		// Otherwise don't unwrap any further
 		return "", nil
 	}
+	// if the data isn't encrypted the hash is the hash of the source
+	if o.header == nil {
+		return srcObj.Hash(ctx, hash)
+	}
 	// if this is wrapping a local object then we work out the hash
 	if srcObj.Fs().Features().IsLocal {
 		// Read the data and encrypt it to calculate the hash
 		fs.Debugf(o, "Computing %v hash of encrypted source", hash)
-		return o.f.computeHashWithNonce(ctx, o.nonce, srcObj, hash)
+		return o.f.computeHashWithHeader(ctx, o.header, o.key, srcObj, hash)
 	}
 	return "", nil
 }
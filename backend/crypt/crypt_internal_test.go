@@ -61,7 +61,6 @@ func testObjectInfo(t *testing.T, f *Fs, wrap bool) {
 	var outBuf bytes.Buffer
 	enc, err := f.cipher.newEncrypter(inBuf, nil)
 	require.NoError(t, err)
-	nonce := enc.nonce // read the nonce at the start
 	_, err = io.Copy(&outBuf, enc)
 	require.NoError(t, err)
 
@@ -71,9 +70,9 @@ func testObjectInfo(t *testing.T, f *Fs, wrap bool) {
 		oi = fs.NewOverrideRemote(oi, "new_remote")
 	}
 
-	// wrap the object in a crypt for upload using the nonce we
-	// saved from the encrypter
-	src := f.newObjectInfo(oi, nonce)
+	// wrap the object in a crypt for upload using the header and
+	// key from the encrypter
+	src := f.newObjectInfo(oi, enc.header, enc.key)
 
 	// Test ObjectInfo methods
 	if !f.opt.NoDataEncryption {
@@ -0,0 +1,198 @@
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Public key mode
+//
+// In public key mode the data of each file is encrypted with a key
+// agreed between a new ephemeral X25519 key pair and the public key.
+// The ephemeral public key is stored in the file header after the
+// nonce and the ephemeral private key is thrown away, so only the
+// holder of the private key can decrypt the file.
+//
+// File names are sealed to the public key in the same way, except the
+// ephemeral key is derived from the name so the same name always
+// encrypts to the same thing.
+//
+// The private key is derived from the password so the remote can read
+// everything when the password is set, and can only write when it
+// isn't.
+
+// Constants
+const (
+	publicKeySize    = 32
+	publicKeyPrefix  = "rclone-crypt-pk-"
+	maxSealedNameLen = 2048
+)
+
+// Errors returned in public key mode
+var (
+	ErrorPublicKeyMismatch  = errors.New("public_key doesn't match the private key from password and password2")
+	ErrorSealedNameTooShort = errors.New("sealed name too short")
+	ErrorSealedNameBad      = errors.New("failed to authenticate sealed name - wrong password?")
+)
+
+// derivePrivateKey derives the private key for public key mode from the data key
+func derivePrivateKey(dataKey *[32]byte) *[32]byte {
+	mac := hmac.New(sha256.New, dataKey[:])
+	_, _ = mac.Write([]byte("rclone crypt private key"))
+	var privateKey [32]byte
+	copy(privateKey[:], mac.Sum(nil))
+	return &privateKey
+}
+
+// publicKeyOf returns the public key for privateKey
+func publicKeyOf(privateKey *[32]byte) (*[32]byte, error) {
+	publicKey, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	var out [32]byte
+	copy(out[:], publicKey)
+	return &out, nil
+}
+
+// PublicKey returns the public key for the password of c in the form
+// used in the public_key config option
+func (c *Cipher) PublicKey() (string, error) {
+	if c.privateKey == nil {
+		return "", ErrorNoPrivateKey
+	}
+	publicKey, err := publicKeyOf(c.privateKey)
+	if err != nil {
+		return "", err
+	}
+	return publicKeyPrefix + base64.RawURLEncoding.EncodeToString(publicKey[:]), nil
+}
+
+// ParsePublicKey parses a public key in the form used in the
+// public_key config option
+func ParsePublicKey(s string) (*[32]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, publicKeyPrefix) {
+		return nil, fmt.Errorf("public key should start with %q", publicKeyPrefix)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s[len(publicKeyPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("bad public key: %w", err)
+	}
+	if len(data) != publicKeySize {
+		return nil, fmt.Errorf("bad public key: wrong length %d", len(data))
+	}
+	var publicKey [32]byte
+	copy(publicKey[:], data)
+	return &publicKey, nil
+}
+
+// setPublicKey switches c to public key mode encrypting to
+// publicKey.
+//
+// If havePassword is set then the private key derived from the
+// password must match publicKey, otherwise c can only encrypt.
+func (c *Cipher) setPublicKey(publicKey *[32]byte, havePassword bool) error {
+	if c.mode == NameEncryptionObfuscated {
+		return errors.New("public_key can't be used with filename_encryption obfuscate")
+	}
+	if havePassword {
+		ourPublicKey, err := publicKeyOf(c.privateKey)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal(ourPublicKey[:], publicKey[:]) {
+			return ErrorPublicKeyMismatch
+		}
+	} else {
+		c.privateKey = nil
+	}
+	c.publicKey = publicKey
+	return nil
+}
+
+// newEphemeralKey makes a new ephemeral key pair returning its public
+// key and the key agreed with the public key of c.
+func (c *Cipher) newEphemeralKey() (ephemeralPublicKey, key *[32]byte, err error) {
+	ephemeralPublicKey, ephemeralPrivateKey, err := box.GenerateKey(c.cryptoRand)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to make ephemeral key: %w", err)
+	}
+	key = new([32]byte)
+	box.Precompute(key, c.publicKey, ephemeralPrivateKey)
+	return ephemeralPublicKey, key, nil
+}
+
+// agreeKey returns the key agreed between the private key of c and
+// ephemeralPublicKey.
+func (c *Cipher) agreeKey(ephemeralPublicKey *[32]byte) (key *[32]byte, err error) {
+	if c.privateKey == nil {
+		return nil, ErrorNoPrivateKey
+	}
+	key = new([32]byte)
+	box.Precompute(key, ephemeralPublicKey, c.privateKey)
+	return key, nil
+}
+
+// nameNonce returns the nonce used to seal a name with ephemeralPublicKey
+func (c *Cipher) nameNonce(ephemeralPublicKey []byte) *[24]byte {
+	h := sha256.New()
+	_, _ = h.Write(ephemeralPublicKey)
+	_, _ = h.Write(c.publicKey[:])
+	var nonce [24]byte
+	copy(nonce[:], h.Sum(nil))
+	return &nonce
+}
+
+// sealSegment encrypts a path segment to the public key
+//
+// The ephemeral key is derived from the public key and the segment so
+// this is deterministic. This means that anyone with the public key
+// can check whether a name is a guess, but only the holder of the
+// private key can decrypt it.
+func (c *Cipher) sealSegment(plaintext string) string {
+	h := sha256.New()
+	_, _ = h.Write([]byte("rclone crypt name key\x00"))
+	_, _ = h.Write(c.publicKey[:])
+	_, _ = h.Write([]byte(plaintext))
+	var ephemeralPrivateKey [32]byte
+	copy(ephemeralPrivateKey[:], h.Sum(nil))
+	ephemeralPublicKey, err := publicKeyOf(&ephemeralPrivateKey)
+	if err != nil {
+		// can't happen as the base point isn't low order
+		panic(fmt.Sprintf("crypt: failed to seal name: %v", err))
+	}
+	sealed := box.Seal(ephemeralPublicKey[:], []byte(plaintext), c.nameNonce(ephemeralPublicKey[:]), c.publicKey, &ephemeralPrivateKey)
+	return c.fileNameEnc.EncodeToString(sealed)
+}
+
+// openSegment decrypts a path segment sealed with sealSegment
+func (c *Cipher) openSegment(ciphertext string) (string, error) {
+	sealed, err := c.fileNameEnc.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < publicKeySize+box.Overhead {
+		return "", ErrorSealedNameTooShort
+	}
+	if len(sealed) > maxSealedNameLen {
+		return "", ErrorTooLongAfterDecode
+	}
+	if c.privateKey == nil {
+		return "", ErrorNoPrivateKey
+	}
+	var ephemeralPublicKey [32]byte
+	copy(ephemeralPublicKey[:], sealed)
+	plaintext, ok := box.Open(nil, sealed[publicKeySize:], c.nameNonce(ephemeralPublicKey[:]), &ephemeralPublicKey, c.privateKey)
+	if !ok {
+		return "", ErrorSealedNameBad
+	}
+	return string(plaintext), nil
+}
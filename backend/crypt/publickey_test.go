@@ -0,0 +1,134 @@
+package crypt
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPublicKeyFs makes a crypt Fs on dir with the password and public key given
+func newPublicKeyFs(t *testing.T, dir, password, publicKey string) (*Fs, error) {
+	m := configmap.Simple{
+		"remote":                    dir,
+		"filename_encryption":       "standard",
+		"directory_name_encryption": "true",
+		"filename_encoding":         "base32",
+		"suffix":                    ".bin",
+		"public_key":                publicKey,
+	}
+	if password != "" {
+		m["password"] = obscure.MustObscure(password)
+	}
+	f, err := NewFs(context.Background(), "publickey", "", m)
+	if err != nil {
+		return nil, err
+	}
+	return f.(*Fs), nil
+}
+
+// publicKeyFor returns the public key for password
+func publicKeyFor(t *testing.T, password string) string {
+	c, err := newCipher(NameEncryptionStandard, password, "", true, nil)
+	require.NoError(t, err)
+	publicKey, err := c.PublicKey()
+	require.NoError(t, err)
+	return publicKey
+}
+
+func TestPublicKeyWriteOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	publicKey := publicKeyFor(t, "potato")
+	assert.True(t, strings.HasPrefix(publicKey, publicKeyPrefix))
+
+	reader, err := newPublicKeyFs(t, dir, "potato", publicKey)
+	require.NoError(t, err)
+	out, err := reader.Command(ctx, "publickey", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, out)
+
+	// Without the password files can be written but not read or listed
+	writer, err := newPublicKeyFs(t, dir, "", publicKey)
+	require.NoError(t, err)
+	contents := strings.Repeat("potato", 30000)
+	putFile(t, writer, "dir/file", contents)
+	assert.Equal(t, []string(nil), listAll(t, writer))
+	o, err := writer.NewObject(ctx, "dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), o.Size())
+	_, err = o.Open(ctx)
+	assert.Equal(t, ErrorNoPrivateKey, err)
+	_, err = writer.Command(ctx, "publickey", nil, nil)
+	assert.Equal(t, ErrorNoPrivateKey, err)
+	_, err = writer.Command(ctx, "verify", nil, nil)
+	assert.Equal(t, ErrorNoPrivateKey, err)
+
+	// With the password they can be read and listed
+	assert.Equal(t, []string{"dir/file"}, listAll(t, reader))
+	assert.Equal(t, contents, readFile(t, reader, "dir/file"))
+	o, err = reader.NewObject(ctx, "dir/file")
+	require.NoError(t, err)
+	in, err := o.Open(ctx, &fs.RangeOption{Start: 70000, End: 140000})
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, contents[70000:140001], string(data))
+
+	// The hash of the encrypted data can be computed from the source
+	srcDir := t.TempDir()
+	src, err := newPublicKeyFs(t, srcDir, "potato", publicKey)
+	require.NoError(t, err)
+	putFile(t, src.Fs, "file", contents)
+	srcObj, err := src.Fs.NewObject(ctx, "file")
+	require.NoError(t, err)
+	gotHash, err := reader.ComputeHash(ctx, o.(*Object), srcObj, hash.MD5)
+	require.NoError(t, err)
+	wantHash, err := o.(*Object).Object.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	assert.Equal(t, wantHash, gotHash)
+
+	// The files can't be read by a remote without the public key
+	symmetric := newRekeyFs(t, dir, "potato", "", false)
+	assert.Equal(t, []string(nil), listAll(t, symmetric))
+}
+
+func TestPublicKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+	publicKey := publicKeyFor(t, "potato")
+
+	_, err := newPublicKeyFs(t, dir, "carrot", publicKey)
+	assert.Equal(t, ErrorPublicKeyMismatch, err)
+
+	_, err = newPublicKeyFs(t, dir, "", "potato")
+	assert.ErrorContains(t, err, "public key should start with")
+
+	_, err = newPublicKeyFs(t, dir, "", publicKeyPrefix+"AAAA")
+	assert.ErrorContains(t, err, "wrong length")
+
+	_, err = NewFs(context.Background(), "publickey", "", configmap.Simple{
+		"remote":              dir,
+		"filename_encryption": "obfuscate",
+		"filename_encoding":   "base32",
+		"public_key":          publicKey,
+	})
+	assert.ErrorContains(t, err, "obfuscate")
+
+	_, err = NewFs(context.Background(), "publickey", "", configmap.Simple{
+		"remote":              dir,
+		"filename_encryption": "standard",
+		"filename_encoding":   "base32",
+		"name_binding":        "on",
+		"public_key":          publicKey,
+	})
+	assert.ErrorContains(t, err, "name_binding")
+}
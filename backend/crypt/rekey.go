@@ -0,0 +1,296 @@
+package crypt
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+// RekeyStats is returned by the rekey command
+type RekeyStats struct {
+	Rekeyed int `json:"rekeyed"` // files re-encrypted with the current key
+	Deleted int `json:"deleted"` // stale files with the previous key removed
+	Dirs    int `json:"dirs"`    // directories moved to the current key
+	Errors  int `json:"errors"`  // files which couldn't be rekeyed
+	Resumed int `json:"resumed"` // files done by earlier runs of rekey
+}
+
+// Actions recorded in the rekey journal
+const (
+	rekeyActionRekeyed = "rekeyed"
+	rekeyActionDeleted = "deleted"
+	rekeyActionFailed  = "failed"
+)
+
+// rekeyEntry is a line in the rekey journal
+type rekeyEntry struct {
+	Remote string `json:"remote"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// rekeyJournal records the progress of a rekey, one JSON line per
+// file, so a rekey which is interrupted can be resumed.
+type rekeyJournal struct {
+	mu   sync.Mutex
+	path string
+	fh   *os.File
+	enc  *json.Encoder
+}
+
+// openRekeyJournal opens the journal for f returning the number of
+// files done by earlier runs.
+func openRekeyJournal(f *Fs) (j *rekeyJournal, done int, err error) {
+	hash := md5.Sum([]byte(fs.ConfigString(f)))
+	dir := filepath.Join(config.GetCacheDir(), "crypt-rekey")
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to make rekey journal directory: %w", err)
+	}
+	j = &rekeyJournal{
+		path: filepath.Join(dir, hex.EncodeToString(hash[:])+".jsonl"),
+	}
+	fh, err := os.Open(j.path)
+	if err == nil {
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			var entry rekeyEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Action != rekeyActionFailed {
+				done++
+			}
+		}
+		_ = fh.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, 0, fmt.Errorf("failed to read rekey journal: %w", err)
+	}
+	j.fh, err = os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open rekey journal: %w", err)
+	}
+	j.enc = json.NewEncoder(j.fh)
+	return j, done, nil
+}
+
+// record an action in the journal
+func (j *rekeyJournal) record(remote, action string, err error) {
+	entry := rekeyEntry{
+		Remote: remote,
+		Action: action,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.enc.Encode(&entry); err != nil {
+		fs.Errorf(nil, "Failed to write rekey journal: %v", err)
+	}
+}
+
+// close the journal, removing it if remove is set
+func (j *rekeyJournal) close(remove bool) {
+	if err := j.fh.Close(); err != nil {
+		fs.Errorf(nil, "Failed to close rekey journal: %v", err)
+	}
+	if remove {
+		if err := os.Remove(j.path); err != nil {
+			fs.Errorf(nil, "Failed to remove rekey journal: %v", err)
+		}
+	}
+}
+
+// rekeyObject moves o which is encrypted with the previous key to the
+// current key returning the action taken.
+func (f *Fs) rekeyObject(ctx context.Context, o *Object) (action string, err error) {
+	remote := o.Remote()
+	_, err = f.newObjectWithKey(ctx, remote)
+	if err == nil {
+		// Already written with the current key so o is stale
+		action = rekeyActionDeleted
+	} else if err != fs.ErrorObjectNotFound {
+		return "", err
+	} else {
+		action = rekeyActionRekeyed
+	}
+	if fs.GetConfig(ctx).DryRun {
+		fs.Logf(o, "Not %s as --dry-run is set", action)
+		return action, nil
+	}
+	if action == rekeyActionDeleted {
+		return action, o.Object.Remove(ctx)
+	}
+
+	// Rename server-side if only the names are encrypted
+	if f.opt.NoDataEncryption {
+		_, err = f.Move(ctx, o, remote)
+		if err == nil {
+			fs.Infof(o, "Renamed with current key")
+			return action, nil
+		} else if err != fs.ErrorCantMove {
+			return action, err
+		}
+	}
+
+	// Otherwise copy the data re-encrypting it and remove the original
+	tr := accounting.Stats(ctx).NewTransfer(o)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	rc, err := o.Open(ctx)
+	if err != nil {
+		return action, err
+	}
+	in := tr.Account(ctx, rc).WithBuffer()
+	newObj, err := f.Put(ctx, in, o)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && newObj.Size() != o.Size() {
+		err = fmt.Errorf("corrupted on transfer: sizes differ %d vs %d", o.Size(), newObj.Size())
+	}
+	if err != nil {
+		if newObj != nil {
+			if removeErr := newObj.Remove(ctx); removeErr != nil {
+				fs.Errorf(newObj, "Failed to remove partially rekeyed file: %v", removeErr)
+			}
+		}
+		return action, err
+	}
+	fs.Infof(o, "Re-encrypted with current key")
+	return action, o.Object.Remove(ctx)
+}
+
+// rekeyDirs moves the directories encrypted with the previous key to
+// the current key, deepest first.
+func (f *Fs) rekeyDirs(ctx context.Context, dirs []string) (n int) {
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], "/") > strings.Count(dirs[j], "/")
+	})
+	for _, dir := range dirs {
+		if !f.previous.separateDir(dir) {
+			continue
+		}
+		if err := f.Mkdir(ctx, dir); err != nil {
+			fs.Errorf(dir, "Failed to make directory with current key: %v", err)
+			continue
+		}
+		// This fails if the directory still has files with the previous key in
+		if err := f.previous.Fs.Rmdir(ctx, f.previous.cipher.EncryptDirName(dir)); err != nil {
+			fs.Debugf(dir, "Not removing directory with previous key: %v", err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// rekey re-encrypts everything encrypted with previous_password to
+// the current password.
+func (f *Fs) rekey(ctx context.Context) (stats *RekeyStats, err error) {
+	if f.previous == nil {
+		return nil, errors.New("rekey needs previous_password set to the old password and password to the new one")
+	}
+	ci := fs.GetConfig(ctx)
+	stats = new(RekeyStats)
+
+	var journal *rekeyJournal
+	if !ci.DryRun {
+		journal, stats.Resumed, err = openRekeyJournal(f)
+		if err != nil {
+			return nil, err
+		}
+		if stats.Resumed > 0 {
+			fs.Infof(f, "Resuming rekey - %d files were done by earlier runs", stats.Resumed)
+		}
+	}
+
+	// Find everything encrypted with the previous key
+	var (
+		objects []*Object
+		dirs    []string
+	)
+	err = walk.Walk(ctx, f.previous, "", true, -1, func(dir string, entries fs.DirEntries, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			switch x := entry.(type) {
+			case *Object:
+				objects = append(objects, x)
+			case fs.Directory:
+				dirs = append(dirs, x.Remote())
+			}
+		}
+		return nil
+	})
+	if err == fs.ErrorDirNotFound {
+		err = nil
+	}
+	if err != nil {
+		if journal != nil {
+			journal.close(false)
+		}
+		return nil, fmt.Errorf("failed to list files with previous key: %w", err)
+	}
+	fs.Infof(f, "Rekeying %d files and %d directories", len(objects), len(dirs))
+
+	var mu sync.Mutex
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(ci.Transfers)
+	for _, o := range objects {
+		o := o
+		g.Go(func() error {
+			action, err := f.rekeyObject(gCtx, o)
+			if err != nil {
+				fs.Errorf(o, "Failed to rekey: %v", err)
+				action = rekeyActionFailed
+			}
+			if journal != nil {
+				journal.record(o.Remote(), action, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch action {
+			case rekeyActionRekeyed:
+				stats.Rekeyed++
+			case rekeyActionDeleted:
+				stats.Deleted++
+			default:
+				stats.Errors++
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	if !ci.DryRun {
+		stats.Dirs = f.rekeyDirs(ctx, append(dirs, ""))
+	}
+
+	if journal != nil {
+		journal.close(stats.Errors == 0)
+	}
+	if stats.Errors > 0 {
+		return stats, fmt.Errorf("failed to rekey %d files - run rekey again to retry", stats.Errors)
+	}
+	if !ci.DryRun {
+		fs.Logf(f, "Rekey complete - previous_password and previous_password2 can be removed from the config")
+	}
+	return stats, nil
+}
@@ -0,0 +1,181 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRekeyFs makes a crypt Fs on dir with the passwords given
+func newRekeyFs(t *testing.T, dir string, password, previousPassword string, noDataEncryption bool) *Fs {
+	m := configmap.Simple{
+		"remote":                    dir,
+		"password":                  obscure.MustObscure(password),
+		"filename_encryption":       "standard",
+		"directory_name_encryption": "true",
+		"filename_encoding":         "base32",
+		"suffix":                    ".bin",
+	}
+	if previousPassword != "" {
+		m["previous_password"] = obscure.MustObscure(previousPassword)
+	}
+	if noDataEncryption {
+		m["no_data_encryption"] = "true"
+	}
+	f, err := NewFs(context.Background(), "rekey", "", m)
+	require.NoError(t, err)
+	return f.(*Fs)
+}
+
+// putFile uploads contents to remote on f
+func putFile(t *testing.T, f fs.Fs, remote, contents string) {
+	src := object.NewStaticObjectInfo(remote, time.Now(), int64(len(contents)), true, nil, nil)
+	_, err := f.Put(context.Background(), bytes.NewBufferString(contents), src)
+	require.NoError(t, err)
+}
+
+// readFile reads the contents of remote on f
+func readFile(t *testing.T, f fs.Fs, remote string) string {
+	o, err := f.NewObject(context.Background(), remote)
+	require.NoError(t, err)
+	in, err := o.Open(context.Background())
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+// listAll lists all the file names on f
+func listAll(t *testing.T, f fs.Fs) (names []string) {
+	err := walk.Walk(context.Background(), f, "", true, -1, func(dir string, entries fs.DirEntries, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if _, ok := entry.(fs.Object); ok {
+				names = append(names, entry.Remote())
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(names)
+	return names
+}
+
+func testRekey(t *testing.T, noDataEncryption bool) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	old := newRekeyFs(t, dir, "old", "", noDataEncryption)
+	putFile(t, old, "file1", "one")
+	putFile(t, old, "dir/file2", "two")
+	putFile(t, old, "dir/sub/file3", "three")
+	putFile(t, old, "file4", "four")
+
+	// Both keys are read during the transition
+	f := newRekeyFs(t, dir, "new", "old", noDataEncryption)
+	putFile(t, f, "dir/file5", "five")
+	assert.Equal(t, []string{"dir/file2", "dir/file5", "dir/sub/file3", "file1", "file4"}, listAll(t, f))
+	assert.Equal(t, "two", readFile(t, f, "dir/file2"))
+	assert.Equal(t, []string{"dir/file5"}, listAll(t, newRekeyFs(t, dir, "new", "", noDataEncryption)))
+
+	// Updating a file with the previous key moves it to the current key
+	o, err := f.NewObject(ctx, "file1")
+	require.NoError(t, err)
+	assert.Equal(t, f, o.Fs())
+	src := object.NewStaticObjectInfo("file1", time.Now(), 7, true, nil, nil)
+	require.NoError(t, o.Update(ctx, bytes.NewBufferString("updated"), src))
+	assert.Equal(t, "updated", readFile(t, f, "file1"))
+
+	// A stale copy with the previous key is ignored then removed
+	putFile(t, old, "file4", "four again")
+	putFile(t, f, "file4", "four new")
+	assert.Equal(t, "four new", readFile(t, f, "file4"))
+
+	out, err := f.Command(ctx, "rekey", nil, nil)
+	require.NoError(t, err)
+	stats := out.(*RekeyStats)
+	assert.Equal(t, 2, stats.Rekeyed)
+	assert.Equal(t, 1, stats.Deleted)
+	assert.Equal(t, 0, stats.Errors)
+	assert.Equal(t, 2, stats.Dirs)
+
+	// Everything is now on the current key only
+	current := newRekeyFs(t, dir, "new", "", noDataEncryption)
+	assert.Equal(t, []string{"dir/file2", "dir/file5", "dir/sub/file3", "file1", "file4"}, listAll(t, current))
+	assert.Equal(t, "three", readFile(t, current, "dir/sub/file3"))
+	assert.Equal(t, "four new", readFile(t, current, "file4"))
+	assert.Equal(t, []string(nil), listAll(t, newRekeyFs(t, dir, "old", "", noDataEncryption)))
+
+	// Running again does nothing
+	out, err = f.Command(ctx, "rekey", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &RekeyStats{}, out)
+}
+
+func TestRekey(t *testing.T) {
+	t.Run("Data", func(t *testing.T) { testRekey(t, false) })
+	t.Run("NoData", func(t *testing.T) { testRekey(t, true) })
+}
+
+func TestRekeyErrors(t *testing.T) {
+	ctx := context.Background()
+	f := newRekeyFs(t, t.TempDir(), "new", "", false)
+	_, err := f.Command(ctx, "rekey", nil, nil)
+	assert.ErrorContains(t, err, "previous_password")
+
+	_, err = NewFs(ctx, "rekey", "", configmap.Simple{
+		"remote":              t.TempDir(),
+		"password":            obscure.MustObscure("new"),
+		"previous_password":   obscure.MustObscure("old"),
+		"filename_encryption": "off",
+		"filename_encoding":   "base32",
+	})
+	assert.ErrorContains(t, err, "standard")
+}
+
+func TestRekeyNameKey(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	putFile(t, newRekeyFs(t, dir, "old", "", false), "dir/file1", "one")
+	f := newRekeyFs(t, dir, "new", "old", false)
+	putFile(t, f, "file2", "two")
+
+	// Each name decrypts with exactly one of the keys
+	newFile := f.cipher.EncryptFileName("file2")
+	name, err := f.decryptName(newFile, false)
+	require.NoError(t, err)
+	assert.Equal(t, "file2", name)
+	_, err = f.previous.decryptName(newFile, false)
+	assert.Error(t, err)
+	oldDir := f.previous.cipher.EncryptDirName("dir")
+	name, err = f.previous.decryptName(oldDir, true)
+	require.NoError(t, err)
+	assert.Equal(t, "dir", name)
+	_, err = f.decryptName(oldDir, true)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"dir/file1", "file2"}, listAll(t, f))
+
+	// If a name decrypts with both keys it isn't known which it
+	// belongs to so it isn't listed
+	f.previous.cipher = f.cipher
+	_, err = f.decryptName(newFile, false)
+	assert.Equal(t, ErrorBadDecryptBothKeys, err)
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
@@ -0,0 +1,382 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+// Name of the directory manifest in each directory of the wrapped
+// remote. This never decrypts as a file name so isn't listed.
+const manifestName = ".rclone-crypt-manifest"
+
+// Version of the manifest format
+const manifestVersion = 1
+
+// VerifyStats is returned by the verify command
+type VerifyStats struct {
+	Files          int `json:"files"`           // files checked
+	Bound          int `json:"bound"`           // files with their data bound to their name
+	Unbound        int `json:"unbound"`         // files without their data bound to their name
+	Errors         int `json:"errors"`          // files which failed to verify
+	Dirs           int `json:"dirs"`            // directories checked
+	Manifests      int `json:"manifests"`       // manifests checked
+	ManifestErrors int `json:"manifest_errors"` // manifests which didn't match
+	Written        int `json:"written"`         // manifests written
+}
+
+// manifestFile describes a file in a manifest
+type manifestFile struct {
+	Size  int64  `json:"size"`            // size on the wrapped remote
+	Nonce string `json:"nonce,omitempty"` // initial nonce of the data in hex
+	Bound bool   `json:"bound,omitempty"` // set if the data is bound to the name
+}
+
+// manifest lists the contents of a directory on the wrapped remote
+// authenticated with a MAC so that files being added, removed,
+// replaced or rolled back can be detected.
+type manifest struct {
+	Version int                     `json:"version"`
+	Dir     string                  `json:"-"`     // full unencrypted path of the directory, only in the MAC
+	Files   map[string]manifestFile `json:"files"` // keyed on the encrypted leaf name
+	Dirs    []string                `json:"dirs"`  // encrypted leaf names
+	MAC     string                  `json:"mac,omitempty"`
+}
+
+// manifestKey returns the key used to authenticate manifests
+func (c *Cipher) manifestKey() []byte {
+	mac := hmac.New(sha256.New, c.dataKey[:])
+	_, _ = mac.Write([]byte("rclone crypt manifest key"))
+	return mac.Sum(nil)
+}
+
+// sum returns the MAC of m in hex.
+//
+// The directory isn't stored in the manifest as it would leak the
+// unencrypted path, but it is part of the MAC so a manifest moved to
+// another directory fails to authenticate.
+func (m *manifest) sum(c *Cipher) (string, error) {
+	unsigned := *m
+	unsigned.MAC = ""
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, c.manifestKey())
+	_, _ = mac.Write([]byte(m.Dir))
+	_, _ = mac.Write([]byte{0})
+	_, _ = mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// diff returns the differences between the manifest old and m
+func (m *manifest) diff(old *manifest) (changes []string) {
+	for name, was := range old.Files {
+		is, ok := m.Files[name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("file %q is missing", name))
+		} else if is != was {
+			changes = append(changes, fmt.Sprintf("file %q has changed", name))
+		}
+	}
+	for name := range m.Files {
+		if _, ok := old.Files[name]; !ok {
+			changes = append(changes, fmt.Sprintf("file %q is not in the manifest", name))
+		}
+	}
+	oldDirs := make(map[string]struct{}, len(old.Dirs))
+	for _, name := range old.Dirs {
+		oldDirs[name] = struct{}{}
+	}
+	for _, name := range m.Dirs {
+		if _, ok := oldDirs[name]; !ok {
+			changes = append(changes, fmt.Sprintf("directory %q is not in the manifest", name))
+		}
+		delete(oldDirs, name)
+	}
+	for name := range oldDirs {
+		changes = append(changes, fmt.Sprintf("directory %q is missing", name))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// encryptedDir returns the path of dir on the wrapped remote
+func (f *Fs) encryptedDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	return f.cipher.EncryptDirName(dir)
+}
+
+// readManifest reads the manifest for dir returning nil if there
+// isn't one.
+func (f *Fs) readManifest(ctx context.Context, dir string) (m *manifest, err error) {
+	o, err := f.Fs.NewObject(ctx, path.Join(f.encryptedDir(dir), manifestName))
+	if err == fs.ErrorObjectNotFound || err == fs.ErrorIsDir {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	m = new(manifest)
+	err = json.NewDecoder(in).Decode(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return m, nil
+}
+
+// writeManifest signs m and writes it as the manifest for dir
+func (f *Fs) writeManifest(ctx context.Context, dir string, m *manifest) (err error) {
+	m.MAC, err = m.sum(f.cipher)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	src := object.NewStaticObjectInfo(path.Join(f.encryptedDir(dir), manifestName), time.Now(), int64(len(data)), true, nil, f.Fs)
+	_, err = f.Fs.Put(ctx, bytes.NewReader(data), src)
+	return err
+}
+
+// Errors returned by checkManifest
+var (
+	errManifestBadMAC = errors.New("manifest failed to authenticate - tampered with, moved from another directory or wrong password")
+)
+
+// checkManifest compares m with the manifest stored for dir,
+// returning the changes found and whether there was a manifest.
+//
+// An error is returned if the manifest can't be read or trusted.
+func (f *Fs) checkManifest(ctx context.Context, dir string, m *manifest) (changes []string, found bool, err error) {
+	old, err := f.readManifest(ctx, dir)
+	if err != nil || old == nil {
+		return nil, false, err
+	}
+	old.Dir = m.Dir
+	sum, err := old.sum(f.cipher)
+	if err != nil {
+		return nil, true, err
+	}
+	if !hmac.Equal([]byte(sum), []byte(old.MAC)) {
+		return nil, true, errManifestBadMAC
+	}
+	if old.Version != manifestVersion {
+		return nil, true, fmt.Errorf("unknown manifest version %d", old.Version)
+	}
+	return m.diff(old), true, nil
+}
+
+// rmdir removes dir from the wrapped remote, removing its manifest
+// first if that is all that is left in it.
+func (f *Fs) rmdir(ctx context.Context, dir string) error {
+	encryptedDir := f.cipher.EncryptDirName(dir)
+	err := f.Fs.Rmdir(ctx, encryptedDir)
+	if err == nil || err == fs.ErrorDirNotFound {
+		return err
+	}
+	entries, listErr := f.Fs.List(ctx, encryptedDir)
+	if listErr != nil || len(entries) != 1 || path.Base(entries[0].Remote()) != manifestName {
+		return err
+	}
+	o, ok := entries[0].(fs.Object)
+	if !ok {
+		return err
+	}
+	if err = o.Remove(ctx); err != nil {
+		return fmt.Errorf("failed to remove manifest: %w", err)
+	}
+	return f.Fs.Rmdir(ctx, encryptedDir)
+}
+
+// verifyObject reads all the data of o authenticating every block
+// without keeping the decrypted data, returning its manifest entry.
+func (f *Fs) verifyObject(ctx context.Context, o *Object) (entry manifestFile, err error) {
+	entry.Size = o.Object.Size()
+	if f.opt.NoDataEncryption {
+		return entry, nil
+	}
+	tr := accounting.Stats(ctx).NewCheckingTransfer(o, "verifying")
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	rc, err := o.Object.Open(ctx)
+	if err != nil {
+		return entry, err
+	}
+	d, err := f.cipher.newDecrypterPath(rc, f.fullPath(o.Remote()))
+	if err != nil {
+		return entry, err
+	}
+	entry.Nonce = hex.EncodeToString(d.initialNonce[:])
+	entry.Bound = d.bound
+	in := tr.Account(ctx, d)
+	n, err := io.Copy(io.Discard, in)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return entry, err
+	}
+	if n != o.Size() {
+		return entry, fmt.Errorf("decrypted size %d doesn't match expected size %d", n, o.Size())
+	}
+	return entry, nil
+}
+
+// verify checks the data of every file authenticates with its name
+// and checks the directory manifests.
+//
+// If update is set the manifests are rewritten after checking and
+// changes since they were last written are logged rather than
+// treated as errors.
+func (f *Fs) verify(ctx context.Context, update bool) (stats *VerifyStats, err error) {
+	if f.previous != nil {
+		return nil, errors.New("can't verify while previous_password is set - finish the rekey first")
+	}
+	if f.cipher.publicKey != nil && f.cipher.privateKey == nil {
+		return nil, ErrorNoPrivateKey
+	}
+	ci := fs.GetConfig(ctx)
+	stats = new(VerifyStats)
+
+	// Find all the directories and their contents
+	type dirEntries struct {
+		dir     string
+		objects []*Object
+		dirs    []string
+	}
+	var dirs []*dirEntries
+	err = walk.Walk(ctx, f, "", true, -1, func(dir string, entries fs.DirEntries, err error) error {
+		if err != nil {
+			return err
+		}
+		d := &dirEntries{dir: dir}
+		for _, entry := range entries {
+			switch x := entry.(type) {
+			case *Object:
+				d.objects = append(d.objects, x)
+			case fs.Directory:
+				d.dirs = append(d.dirs, x.Remote())
+			}
+		}
+		dirs = append(dirs, d)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	// Check the data of all the files
+	var (
+		mu      sync.Mutex
+		entries = make(map[*Object]manifestFile)
+	)
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(ci.Checkers)
+	for _, d := range dirs {
+		for _, o := range d.objects {
+			o := o
+			g.Go(func() error {
+				entry, err := f.verifyObject(gCtx, o)
+				mu.Lock()
+				defer mu.Unlock()
+				stats.Files++
+				if err != nil {
+					fs.Errorf(o, "Failed to verify: %v", err)
+					stats.Errors++
+					return nil
+				}
+				if entry.Bound {
+					stats.Bound++
+				} else if !f.opt.NoDataEncryption {
+					stats.Unbound++
+				}
+				entries[o] = entry
+				return nil
+			})
+		}
+	}
+	_ = g.Wait()
+
+	// Check the manifests
+	for _, d := range dirs {
+		stats.Dirs++
+		m := &manifest{
+			Version: manifestVersion,
+			Dir:     f.fullPath(d.dir),
+			Files:   make(map[string]manifestFile, len(d.objects)),
+			Dirs:    make([]string, 0, len(d.dirs)),
+		}
+		for _, o := range d.objects {
+			entry, ok := entries[o]
+			if !ok {
+				// failed to verify so record it as unreadable
+				entry = manifestFile{Size: o.Object.Size()}
+			}
+			m.Files[path.Base(o.Object.Remote())] = entry
+		}
+		for _, dir := range d.dirs {
+			m.Dirs = append(m.Dirs, path.Base(f.cipher.EncryptDirName(dir)))
+		}
+		sort.Strings(m.Dirs)
+		changes, found, err := f.checkManifest(ctx, d.dir, m)
+		if found {
+			stats.Manifests++
+		}
+		if err != nil {
+			fs.Errorf(d.dir, "Failed to check manifest: %v", err)
+			stats.ManifestErrors++
+		} else if update {
+			for _, change := range changes {
+				fs.Logf(d.dir, "Manifest updated: %s", change)
+			}
+		} else if len(changes) > 0 {
+			for _, change := range changes {
+				fs.Errorf(d.dir, "Manifest: %s", change)
+			}
+			stats.ManifestErrors++
+		}
+		if update {
+			if ci.DryRun {
+				fs.Logf(d.dir, "Not writing manifest as --dry-run is set")
+				continue
+			}
+			if err := f.writeManifest(ctx, d.dir, m); err != nil {
+				fs.Errorf(d.dir, "Failed to write manifest: %v", err)
+				stats.ManifestErrors++
+				continue
+			}
+			stats.Written++
+		}
+	}
+
+	if stats.Errors > 0 || stats.ManifestErrors > 0 {
+		return stats, fmt.Errorf("verify failed: %d files and %d manifests had errors", stats.Errors, stats.ManifestErrors)
+	}
+	return stats, nil
+}
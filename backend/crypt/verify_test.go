@@ -0,0 +1,194 @@
+package crypt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBindingFs makes a crypt Fs on dir/root with the name binding mode given
+func newBindingFs(t *testing.T, dir, root, nameBinding string) *Fs {
+	f, err := NewFs(context.Background(), "binding", root, configmap.Simple{
+		"remote":                    dir,
+		"password":                  obscure.MustObscure("potato"),
+		"filename_encryption":       "standard",
+		"directory_name_encryption": "true",
+		"filename_encoding":         "base32",
+		"suffix":                    ".bin",
+		"name_binding":              nameBinding,
+	})
+	if err != fs.ErrorIsFile {
+		require.NoError(t, err)
+	}
+	return f.(*Fs)
+}
+
+// encryptedPath returns the local path of the encrypted remote
+func encryptedPath(dir string, f *Fs, remote string) string {
+	return filepath.Join(dir, filepath.FromSlash(f.cipher.EncryptFileName(remote)))
+}
+
+func TestNameBindingSwap(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := newBindingFs(t, dir, "", "on")
+	assert.Nil(t, f.Features().Copy)
+	assert.Nil(t, f.Features().Move)
+	putFile(t, f, "dir/file1", "one")
+	putFile(t, f, "file2", "two")
+	assert.Equal(t, "one", readFile(t, f, "dir/file1"))
+
+	// The path is relative to the root of the remote
+	sub := newBindingFs(t, dir, "dir", "on")
+	assert.Equal(t, "one", readFile(t, sub, "file1"))
+	file := newBindingFs(t, dir, "dir/file1", "on")
+	assert.Equal(t, "one", readFile(t, file, "file1"))
+
+	// Swapping the data of the files makes them fail to decrypt
+	data, err := os.ReadFile(encryptedPath(dir, f, "dir/file1"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(encryptedPath(dir, f, "file2"), data, 0600))
+	o, err := f.NewObject(ctx, "file2")
+	require.NoError(t, err)
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	_, err = in.Read(make([]byte, 16))
+	assert.Equal(t, ErrorEncryptedBadBlock, err)
+	require.NoError(t, in.Close())
+}
+
+func TestNameBindingModes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	putFile(t, newBindingFs(t, dir, "", "off"), "unbound", "old format")
+
+	f := newBindingFs(t, dir, "", "on")
+	putFile(t, f, "bound", "new format")
+	assert.Equal(t, "old format", readFile(t, f, "unbound"))
+	assert.Equal(t, "new format", readFile(t, f, "bound"))
+
+	// Updating an unbound file binds it
+	putFile(t, f, "unbound", "now bound")
+	data, err := os.ReadFile(encryptedPath(dir, f, "unbound"))
+	require.NoError(t, err)
+	assert.Equal(t, fileMagicBound, string(data[:fileMagicSize]))
+
+	putFile(t, newBindingFs(t, dir, "", "off"), "unbound2", "old format")
+	strict := newBindingFs(t, dir, "", "require")
+	assert.Equal(t, "new format", readFile(t, strict, "bound"))
+	o, err := strict.NewObject(ctx, "unbound2")
+	require.NoError(t, err)
+	_, err = o.Open(ctx)
+	assert.Equal(t, ErrorEncryptedNotBound, err)
+
+	_, err = NewFs(ctx, "binding", "", configmap.Simple{
+		"remote":              dir,
+		"password":            obscure.MustObscure("potato"),
+		"filename_encryption": "standard",
+		"filename_encoding":   "base32",
+		"name_binding":        "potato",
+	})
+	assert.ErrorContains(t, err, "unknown name binding mode")
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	putFile(t, newBindingFs(t, dir, "", "off"), "unbound", "old format")
+	f := newBindingFs(t, dir, "", "on")
+	putFile(t, f, "dir/file1", "one")
+	putFile(t, f, "file2", "two")
+
+	out, err := f.Command(ctx, "verify", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &VerifyStats{Files: 3, Bound: 2, Unbound: 1, Dirs: 2}, out)
+
+	out, err = f.Command(ctx, "verify", nil, map[string]string{"update": ""})
+	require.NoError(t, err)
+	assert.Equal(t, &VerifyStats{Files: 3, Bound: 2, Unbound: 1, Dirs: 2, Written: 2}, out)
+
+	// The manifests aren't listed
+	assert.Equal(t, []string{"dir/file1", "file2", "unbound"}, listAll(t, f))
+
+	out, err = f.Command(ctx, "verify", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &VerifyStats{Files: 3, Bound: 2, Unbound: 1, Dirs: 2, Manifests: 2}, out)
+
+	// Replacing a file with an older version is detected
+	old, err := os.ReadFile(encryptedPath(dir, f, "file2"))
+	require.NoError(t, err)
+	putFile(t, f, "file2", "two again")
+	_, err = f.Command(ctx, "verify", nil, map[string]string{"update": ""})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(encryptedPath(dir, f, "file2"), old, 0600))
+	out, err = f.Command(ctx, "verify", nil, nil)
+	assert.ErrorContains(t, err, "verify failed")
+	assert.Equal(t, 1, out.(*VerifyStats).ManifestErrors)
+	assert.Equal(t, 0, out.(*VerifyStats).Errors)
+
+	// Corrupted data is detected
+	data, err := os.ReadFile(encryptedPath(dir, f, "dir/file1"))
+	require.NoError(t, err)
+	data[len(data)-1] ^= 1
+	require.NoError(t, os.WriteFile(encryptedPath(dir, f, "dir/file1"), data, 0600))
+	out, err = f.Command(ctx, "verify", nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 1, out.(*VerifyStats).Errors)
+
+	// A tampered manifest is detected
+	manifestPath := filepath.Join(dir, f.cipher.EncryptDirName("dir"), manifestName)
+	data, err = os.ReadFile(manifestPath)
+	require.NoError(t, err)
+	data[len(data)-3] ^= 1
+	require.NoError(t, os.WriteFile(manifestPath, data, 0600))
+	m, err := f.readManifest(ctx, "dir")
+	require.NoError(t, err)
+	_, found, err := f.checkManifest(ctx, "dir", m)
+	assert.True(t, found)
+	assert.Equal(t, errManifestBadMAC, err)
+
+	// A directory with only a manifest in can be removed
+	o, err := f.NewObject(ctx, "dir/file1")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	require.NoError(t, f.Rmdir(ctx, "dir"))
+	_, err = os.Stat(filepath.Join(dir, f.cipher.EncryptDirName("dir")))
+	assert.True(t, os.IsNotExist(err))
+	assert.Error(t, f.Rmdir(ctx, "dir"))
+}
+
+func TestVerifyManifestPrivate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := newBindingFs(t, dir, "", "on")
+	putFile(t, f, "secret/hidden/file", "one")
+	putFile(t, f, "secret/file", "two")
+	_, err := f.Command(ctx, "verify", nil, map[string]string{"update": ""})
+	require.NoError(t, err)
+
+	// The manifests don't contain the unencrypted directory names
+	secretManifest := filepath.Join(dir, f.cipher.EncryptDirName("secret"), manifestName)
+	hiddenManifest := filepath.Join(dir, f.cipher.EncryptDirName("secret/hidden"), manifestName)
+	for _, manifestPath := range []string{secretManifest, hiddenManifest} {
+		data, err := os.ReadFile(manifestPath)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "secret")
+		assert.NotContains(t, string(data), "hidden")
+	}
+
+	// but are bound to their directory by the MAC
+	data, err := os.ReadFile(secretManifest)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(hiddenManifest, data, 0600))
+	m := &manifest{Version: manifestVersion, Dir: f.fullPath("secret/hidden")}
+	_, found, err := f.checkManifest(ctx, "secret/hidden", m)
+	assert.True(t, found)
+	assert.Equal(t, errManifestBadMAC, err)
+}
@@ -0,0 +1,926 @@
+// Package erasure implements a backend which stripes files across
+// several remotes with Reed-Solomon erasure coding
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+const maxBlockSize = fs.Gibi
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "erasure",
+		Description: "Stripe files across several remotes with erasure coding",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name: "upstreams",
+			Help: `List of space separated upstreams.
+
+Each file is split into data and parity shards which are stored under
+the same path on the upstreams, one shard per upstream. The number of
+upstreams must equal data_shards plus parity_shards.
+
+Can be 'remotea:dir remoteb:dir remotec:', '"remotea:space dir" remoteb:', etc.
+
+The order of the upstreams must never change once files are written.`,
+			Required: true,
+		}, {
+			Name:    "data_shards",
+			Help:    "Number of data shards each file is split into.",
+			Default: 2,
+		}, {
+			Name: "parity_shards",
+			Help: `Number of parity shards for each file.
+
+Files can be read as long as no more than this many upstreams are
+unavailable or have lost their shard.`,
+			Default: 1,
+		}, {
+			Name: "block_size",
+			Help: `Size of the blocks each shard is split into.
+
+Files are encoded a stripe of data_shards blocks at a time. Bigger
+blocks use more memory while smaller ones make ranged reads cheaper.
+
+This must not be changed once files are written.`,
+			Default:  fs.SizeSuffix(fs.Mebi),
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Upstreams    fs.SpaceSepList `config:"upstreams"`
+	DataShards   int             `config:"data_shards"`
+	ParityShards int             `config:"parity_shards"`
+	BlockSize    fs.SizeSuffix   `config:"block_size"`
+}
+
+// Fs represents a set of upstreams striped with erasure coding
+type Fs struct {
+	name      string       // name of this remote
+	root      string       // the path we are working on
+	opt       Options      // parsed options
+	features  *fs.Features // optional features
+	upstreams []fs.Fs      // upstreams in shard order, nil if unavailable
+	failed    []error      // why each unavailable upstream couldn't be created
+	layout    layout       // how files are split into shards
+	enc       reedsolomon.Encoder
+}
+
+// NewFs constructs an Fs from the path.
+//
+// The returned Fs is the actual Fs, referenced by remote in the config
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.DataShards < 1 {
+		return nil, errors.New("data_shards must be at least 1")
+	}
+	if opt.ParityShards < 1 {
+		return nil, errors.New("parity_shards must be at least 1")
+	}
+	if opt.BlockSize < 1 || opt.BlockSize > maxBlockSize {
+		return nil, fmt.Errorf("block_size must be between 1 and %v", maxBlockSize)
+	}
+	shards := opt.DataShards + opt.ParityShards
+	if len(opt.Upstreams) != shards {
+		return nil, fmt.Errorf("need %d upstreams for %d data and %d parity shards but have %d - check the value of the upstreams setting",
+			shards, opt.DataShards, opt.ParityShards, len(opt.Upstreams))
+	}
+	for _, u := range opt.Upstreams {
+		if strings.HasPrefix(u, name+":") {
+			return nil, errors.New("can't point erasure remote at itself - check the value of the upstreams setting")
+		}
+	}
+	enc, err := reedsolomon.New(opt.DataShards, opt.ParityShards)
+	if err != nil {
+		return nil, fmt.Errorf("bad shard configuration: %w", err)
+	}
+
+	root = strings.Trim(root, "/")
+	f := &Fs{
+		name:      name,
+		root:      root,
+		opt:       *opt,
+		upstreams: make([]fs.Fs, shards),
+		failed:    make([]error, shards),
+		layout: layout{
+			k:  opt.DataShards,
+			m:  opt.ParityShards,
+			bs: int64(opt.BlockSize),
+		},
+		enc: enc,
+	}
+	errs := make(shardErrors, shards)
+	multithread(shards, func(i int) {
+		f.upstreams[i], errs[i] = cache.Get(ctx, fspath.JoinRootPath(opt.Upstreams[i], root))
+	})
+	isFile := false
+	for i, err := range errs {
+		if err == fs.ErrorIsFile {
+			isFile = true
+			errs[i] = nil
+		} else if err != nil {
+			f.upstreams[i] = nil
+			f.failed[i] = err
+		}
+	}
+	// Files can still be read with up to parity_shards upstreams
+	// unavailable, but nothing can be written
+	if errs.count() > f.layout.m {
+		return nil, fmt.Errorf("failed to create upstreams: %w", errs.err(f))
+	}
+	for i, err := range errs {
+		if err != nil {
+			fs.Errorf(f, "Upstream %q is unavailable, only reading is possible: %v", opt.Upstreams[i], err)
+		}
+	}
+	if isFile {
+		// Upstreams which lost the file are left pointing at it, so
+		// they simply won't find it
+		f.root = path.Dir(root)
+		if f.root == "." {
+			f.root = ""
+		}
+	}
+
+	f.features = (&fs.Features{
+		CaseInsensitive:         true,
+		DuplicateFiles:          false,
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f)
+	for _, u := range f.upstreams {
+		if u != nil {
+			f.features = f.features.Mask(ctx, u) // PutStream needs all the upstreams to stream
+		}
+	}
+	f.features.Overlay = true
+
+	if isFile {
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("erasure root '%s'", f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Precision is the greatest precision of all upstreams
+func (f *Fs) Precision() time.Duration {
+	var greatest time.Duration
+	for _, u := range f.upstreams {
+		if u == nil {
+			continue
+		}
+		if p := u.Precision(); p > greatest {
+			greatest = p
+		}
+	}
+	return greatest
+}
+
+// Hashes returns the supported hash types
+//
+// The MD5 of each file is recorded in its parity shards when it is
+// written.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.MD5)
+}
+
+// shardErrors collects errors from the upstreams
+type shardErrors []error
+
+// count returns the number of errors which aren't nil
+func (errs shardErrors) count() (n int) {
+	for _, err := range errs {
+		if err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// err returns the first error found prefixed with the upstream, or nil
+func (errs shardErrors) err(f *Fs) error {
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.opt.Upstreams[i], err)
+		}
+	}
+	return nil
+}
+
+// errUnavailable returns the error for upstream i if it couldn't be
+// created, or nil
+func (f *Fs) errUnavailable(i int) error {
+	if f.upstreams[i] != nil {
+		return nil
+	}
+	return fmt.Errorf("upstream unavailable: %w", f.failed[i])
+}
+
+// forAll runs fn on all the upstreams in parallel
+//
+// Unavailable upstreams return an error without calling fn.
+func (f *Fs) forAll(fn func(i int, u fs.Fs) error) shardErrors {
+	errs := make(shardErrors, len(f.upstreams))
+	multithread(len(f.upstreams), func(i int) {
+		if errs[i] = f.errUnavailable(i); errs[i] == nil {
+			errs[i] = fn(i, f.upstreams[i])
+		}
+	})
+	return errs
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	lists := make([]fs.DirEntries, len(f.upstreams))
+	errs := f.forAll(func(i int, u fs.Fs) (err error) {
+		lists[i], err = u.List(ctx, dir)
+		return err
+	})
+	notFound := 0
+	for i, err := range errs {
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			errs[i] = nil
+			notFound++
+		}
+	}
+	if notFound == len(f.upstreams) {
+		return nil, fs.ErrorDirNotFound
+	}
+	if errs.count() > f.layout.m {
+		return nil, errs.err(f)
+	}
+	for i, err := range errs {
+		if err != nil {
+			fs.Errorf(f, "Listing %q without %s: %v", dir, f.opt.Upstreams[i], err)
+		}
+	}
+
+	dirs := map[string]fs.Directory{}
+	shards := map[string][]fs.Object{}
+	var order []string
+	for i, list := range lists {
+		for _, entry := range list {
+			remote := entry.Remote()
+			switch x := entry.(type) {
+			case fs.Object:
+				if shards[remote] == nil {
+					shards[remote] = make([]fs.Object, len(f.upstreams))
+					order = append(order, remote)
+				}
+				shards[remote][i] = x
+			case fs.Directory:
+				if dirs[remote] == nil {
+					dirs[remote] = fs.NewDirCopy(ctx, x)
+					order = append(order, remote)
+				}
+			}
+		}
+	}
+	for _, remote := range order {
+		if d, ok := dirs[remote]; ok {
+			if shards[remote] == nil {
+				entries = append(entries, d)
+			}
+			continue
+		}
+		entries = append(entries, f.newObject(ctx, remote, shards[remote]))
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	shards := make([]fs.Object, len(f.upstreams))
+	errs := f.forAll(func(i int, u fs.Fs) (err error) {
+		shards[i], err = u.NewObject(ctx, remote)
+		if err == fs.ErrorObjectNotFound || err == fs.ErrorIsDir || err == fs.ErrorNotAFile {
+			err = nil
+		}
+		return err
+	})
+	if errs.count() > f.layout.m {
+		return nil, errs.err(f)
+	}
+	found := false
+	for _, shard := range shards {
+		found = found || shard != nil
+	}
+	if !found {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return f.newObject(ctx, remote, shards), nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := &Object{
+		f:      f,
+		remote: src.Remote(),
+		shards: make([]fs.Object, len(f.upstreams)),
+		bad:    make([]bool, len(f.upstreams)),
+	}
+	return o, o.Update(ctx, in, src, options...)
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// Mkdir makes the directory on all the upstreams
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return f.forAll(func(i int, u fs.Fs) error {
+		return u.Mkdir(ctx, dir)
+	}).err(f)
+}
+
+// Rmdir removes the directory from all the upstreams
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	errs := f.forAll(func(i int, u fs.Fs) error {
+		return u.Rmdir(ctx, dir)
+	})
+	notFound := 0
+	for i, err := range errs {
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			errs[i] = nil
+			notFound++
+		}
+	}
+	if notFound == len(f.upstreams) {
+		return fs.ErrorDirNotFound
+	}
+	return errs.err(f)
+}
+
+// Purge all files in the directory specified
+//
+// Implement this if you have a way of deleting all the files
+// quicker than just running Remove() on the result of List()
+//
+// Return an error if it doesn't exist
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	errs := f.forAll(func(i int, u fs.Fs) error {
+		return u.Features().Purge(ctx, dir)
+	})
+	notFound := 0
+	for i, err := range errs {
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			errs[i] = nil
+			notFound++
+		}
+	}
+	if notFound == len(f.upstreams) {
+		return fs.ErrorDirNotFound
+	}
+	return errs.err(f)
+}
+
+// sameLayout returns the source object if it can be copied or moved
+// shard by shard to f, or nil if not
+func (f *Fs) sameLayout(ctx context.Context, src fs.Object) *Object {
+	srcObj, ok := src.(*Object)
+	if !ok {
+		fs.Debugf(src, "Can't copy - not same remote type")
+		return nil
+	}
+	if srcObj.f.layout != f.layout {
+		fs.Debugf(src, "Can't copy - different shard layout")
+		return nil
+	}
+	srcObj.readTrailers(ctx)
+	for i, shard := range srcObj.shards {
+		if shard == nil || srcObj.bad[i] {
+			fs.Debugf(src, "Can't copy - shards missing or damaged")
+			return nil
+		}
+	}
+	return srcObj
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// Each shard is copied on its upstream. This is only possible if no
+// shards are missing, otherwise the file is copied the slow way
+// which rebuilds the missing shards.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj := f.sameLayout(ctx, src)
+	if srcObj == nil {
+		return nil, fs.ErrorCantCopy
+	}
+	shards := make([]fs.Object, len(f.upstreams))
+	errs := f.forAll(func(i int, u fs.Fs) (err error) {
+		shards[i], err = u.Features().Copy(ctx, srcObj.shards[i], remote)
+		return err
+	})
+	if err := errs.err(f); err != nil {
+		// Don't leave a partial copy behind
+		for _, shard := range shards {
+			if shard != nil {
+				_ = shard.Remove(ctx)
+			}
+		}
+		return nil, err
+	}
+	return f.newObject(ctx, remote, shards), nil
+}
+
+// Move src to this remote using server-side move operations.
+//
+// Each shard is moved on its upstream. This is only possible if no
+// shards are missing, otherwise the file is moved the slow way.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj := f.sameLayout(ctx, src)
+	if srcObj == nil {
+		return nil, fs.ErrorCantMove
+	}
+	shards := make([]fs.Object, len(f.upstreams))
+	errs := f.forAll(func(i int, u fs.Fs) (err error) {
+		shards[i], err = u.Features().Move(ctx, srcObj.shards[i], remote)
+		if err == nil {
+			srcObj.shards[i] = nil
+		}
+		return err
+	})
+	if err := errs.err(f); err != nil {
+		return nil, err
+	}
+	return f.newObject(ctx, remote, shards), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations on each upstream.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || srcFs.layout != f.layout {
+		fs.Debugf(src, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	errs := f.forAll(func(i int, u fs.Fs) error {
+		return u.Features().DirMove(ctx, srcFs.upstreams[i], srcRemote, dstRemote)
+	})
+	for _, err := range errs {
+		if errors.Is(err, fs.ErrorDirExists) {
+			return fs.ErrorDirExists
+		}
+	}
+	return errs.err(f)
+}
+
+// DirCacheFlush resets the directory cache - used in testing
+// as an optional interface
+func (f *Fs) DirCacheFlush() {
+	multithread(len(f.upstreams), func(i int) {
+		if f.upstreams[i] == nil {
+			return
+		}
+		if do := f.upstreams[i].Features().DirCacheFlush; do != nil {
+			do()
+		}
+	})
+}
+
+// About gets quota information from the Fs
+//
+// Each upstream holds about 1/data_shards of every file, so the
+// space usable for files is the smallest free space of the upstreams
+// times the number of data shards.
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	usages := make([]*fs.Usage, len(f.upstreams))
+	errs := f.forAll(func(i int, u fs.Fs) (err error) {
+		usages[i], err = u.Features().About(ctx)
+		return err
+	})
+	if err := errs.err(f); err != nil {
+		return nil, err
+	}
+	k := int64(f.layout.k)
+	scale := func(get func(u *fs.Usage) *int64, min bool) *int64 {
+		var total int64
+		for i, usage := range usages {
+			value := get(usage)
+			if value == nil {
+				return nil
+			}
+			if !min {
+				total += *value
+			} else if i == 0 || *value < total {
+				total = *value
+			}
+		}
+		if min {
+			total *= k
+		} else {
+			total = total * k / int64(len(f.upstreams))
+		}
+		return &total
+	}
+	return &fs.Usage{
+		Total: scale(func(u *fs.Usage) *int64 { return u.Total }, true),
+		Used:  scale(func(u *fs.Usage) *int64 { return u.Used }, false),
+		Free:  scale(func(u *fs.Usage) *int64 { return u.Free }, true),
+	}, nil
+}
+
+// CleanUp the trash in the Fs
+//
+// Implement this if you have a way of emptying the trash or
+// otherwise cleaning up old versions of files.
+func (f *Fs) CleanUp(ctx context.Context) error {
+	return f.forAll(func(i int, u fs.Fs) error {
+		if do := u.Features().CleanUp; do != nil {
+			return do(ctx)
+		}
+		return nil
+	}).err(f)
+}
+
+// Shutdown the backend, closing any background tasks and any
+// cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	errs := f.forAll(func(i int, u fs.Fs) error {
+		if do := u.Features().Shutdown; do != nil {
+			return do(ctx)
+		}
+		return nil
+	})
+	for i := range errs {
+		if f.upstreams[i] == nil {
+			// nothing to shut down
+			errs[i] = nil
+		}
+	}
+	return errs.err(f)
+}
+
+func multithread(num int, fn func(int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < num; i++ {
+		wg.Add(1)
+		i := i
+		go func() {
+			defer wg.Done()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// Object describes a file striped across the upstreams
+type Object struct {
+	f       *Fs
+	remote  string
+	size    int64
+	modTime time.Time
+	shards  []fs.Object // shards in upstream order, nil if missing
+
+	mu          sync.Mutex // protects the fields below while reading the trailers
+	trailerRead bool       // set once the trailers have been read
+	bad         []bool     // shards which are damaged or from another write
+	trailer     *trailer   // trailer of the parity shards, nil if none found
+}
+
+// newObject makes an Object from the shards found on the upstreams
+func (f *Fs) newObject(ctx context.Context, remote string, shards []fs.Object) *Object {
+	o := &Object{
+		f:      f,
+		remote: remote,
+		shards: shards,
+	}
+	o.init(ctx)
+	return o
+}
+
+// init reads the size and modification time from the shards.
+//
+// If all the data shards are present and their sizes agree with the
+// other shards, the size is the sum of their sizes and the trailers
+// aren't read until they are needed, so listing doesn't read every
+// parity shard. Otherwise the trailers are read straight away.
+func (o *Object) init(ctx context.Context) {
+	l := o.f.layout
+	o.size = -1
+	o.bad = make([]bool, len(o.shards))
+	for _, shard := range o.shards {
+		if shard != nil {
+			o.modTime = shard.ModTime(ctx)
+			break
+		}
+	}
+	var size int64
+	for _, shard := range o.shards[:l.k] {
+		if shard == nil {
+			size = -1
+			break
+		}
+		size += shard.Size()
+	}
+	for i, shard := range o.shards {
+		if size >= 0 && shard != nil && shard.Size() != l.shardSize(size, i) {
+			size = -1
+		}
+	}
+	if size >= 0 {
+		o.size = size
+		return
+	}
+	o.readTrailers(ctx)
+}
+
+// readTrailers reads the trailers of the parity shards if that hasn't
+// been done already, setting the size of the file from them.
+//
+// The trailers record the write each shard belongs to. Shards which
+// don't belong to the write found on the most parity shards, or which
+// have the wrong size for it, are marked bad so they are
+// reconstructed when read and rewritten by repair.
+func (o *Object) readTrailers(ctx context.Context) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.trailerRead {
+		return
+	}
+	o.trailerRead = true
+	l := o.f.layout
+	trailers := make([]*trailer, len(o.shards))
+	multithread(l.m, func(j int) {
+		i := l.k + j
+		if o.shards[i] == nil {
+			return
+		}
+		t, err := readTrailer(ctx, l, o.shards[i])
+		if err == nil {
+			err = t.check(l, i)
+		}
+		if err != nil {
+			fs.Errorf(o, "Bad parity shard on %s: %v", o.f.upstreams[i], err)
+			o.bad[i] = true
+			return
+		}
+		trailers[i] = &t
+	})
+	// Pick the write found on the most parity shards, the latest if tied
+	count := map[string]int{}
+	for _, t := range trailers {
+		if t != nil {
+			count[string(t.id)]++
+		}
+	}
+	for _, t := range trailers {
+		if t == nil {
+			continue
+		}
+		if o.trailer == nil {
+			o.trailer = t
+			continue
+		}
+		n, best := count[string(t.id)], count[string(o.trailer.id)]
+		if n > best || (n == best && bytes.Compare(t.id, o.trailer.id) > 0) {
+			o.trailer = t
+		}
+	}
+	var size int64
+	if o.trailer != nil {
+		size = o.trailer.size
+		for i, t := range trailers {
+			if t != nil && !bytes.Equal(t.id, o.trailer.id) {
+				fs.Errorf(o, "Parity shard on %s is from a different write", o.f.upstreams[i])
+				o.bad[i] = true
+			}
+		}
+	} else {
+		// Without parity the data shards add up to the size of the file
+		for _, shard := range o.shards[:l.k] {
+			if shard == nil {
+				fs.Errorf(o, "Can't find the size, too many shards missing")
+				return
+			}
+			size += shard.Size()
+		}
+	}
+	for i, shard := range o.shards {
+		if shard != nil && !o.bad[i] && shard.Size() != l.shardSize(size, i) {
+			fs.Errorf(o, "Shard on %s has wrong size %d", o.f.upstreams[i], shard.Size())
+			o.bad[i] = true
+		}
+	}
+	o.size = size
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// Storable returns whether object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Hash returns the MD5 of the file recorded in the parity trailers
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if ht != hash.MD5 {
+		return "", hash.ErrUnsupported
+	}
+	o.readTrailers(ctx)
+	if o.trailer == nil {
+		return "", nil
+	}
+	return hex.EncodeToString(o.trailer.md5), nil
+}
+
+// SetModTime sets the modification time of all the shards
+func (o *Object) SetModTime(ctx context.Context, t time.Time) error {
+	errs := o.f.forAll(func(i int, u fs.Fs) error {
+		if o.shards[i] == nil {
+			return nil
+		}
+		return o.shards[i].SetModTime(ctx, t)
+	})
+	if err := errs.err(o.f); err != nil {
+		return err
+	}
+	o.modTime = t
+	return nil
+}
+
+// Remove all the shards of the object
+func (o *Object) Remove(ctx context.Context) error {
+	return o.f.forAll(func(i int, u fs.Fs) error {
+		if o.shards[i] == nil {
+			return nil
+		}
+		err := o.shards[i].Remove(ctx)
+		if err == nil {
+			o.shards[i] = nil
+		}
+		return err
+	}).err(o.f)
+}
+
+// Open the file for reading, reconstructing it from the parity
+// shards if some data shards are unavailable
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	o.readTrailers(ctx)
+	if o.size < 0 {
+		return nil, errors.New("too many shards missing to read the file")
+	}
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	if offset > o.size {
+		offset = o.size
+	}
+	if limit < 0 || offset+limit > o.size {
+		limit = o.size - offset
+	}
+	return o.newReader(ctx, offset, limit)
+}
+
+// Update in to the object with the modTime given of the given size
+//
+// The shards are written in parallel as the input is encoded.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	t, err := o.f.writeShards(ctx, in, src, o.remote, o.shards, nil, nil, options...)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.size = t.size
+	o.modTime = src.ModTime(ctx)
+	o.trailerRead = true
+	o.bad = make([]bool, len(o.shards))
+	o.trailer = &t
+	o.mu.Unlock()
+	return nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.PutStreamer     = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+)
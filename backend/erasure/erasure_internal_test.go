@@ -0,0 +1,247 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MakeTestDirs makes directories in /tmp for testing
+func MakeTestDirs(t *testing.T, n int) (dirs []string) {
+	for i := 1; i <= n; i++ {
+		dir := t.TempDir()
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func TestLayout(t *testing.T) {
+	l := layout{k: 3, m: 2, bs: 10}
+	for _, size := range []int64{0, 1, 2, 3, 29, 30, 31, 59, 60, 61, 100} {
+		var data int64
+		for i := 0; i < l.k; i++ {
+			data += l.shardSize(size, i)
+		}
+		assert.Equal(t, size, data, "size %d", size)
+		var stripes int64
+		for s := int64(0); s < l.stripes(size); s++ {
+			for j := 0; j < l.k; j++ {
+				stripes += l.dataLen(size, s, j)
+			}
+		}
+		assert.Equal(t, size, stripes, "size %d", size)
+		for i := l.k; i < l.k+l.m; i++ {
+			assert.Equal(t, l.shardSize(size, 0)+l.trailerSize(), l.shardSize(size, i), "size %d", size)
+		}
+	}
+}
+
+func readAll(ctx context.Context, t *testing.T, o fs.Object, options ...fs.OpenOption) string {
+	in, err := o.Open(ctx, options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestDegraded(t *testing.T) {
+	ctx := context.Background()
+	dirs := MakeTestDirs(t, 5)
+	var upstreams string
+	for _, dir := range dirs {
+		upstreams += " " + dir
+	}
+	fsys, err := fs.NewFs(ctx, fmt.Sprintf(":erasure,upstreams='%s',data_shards=3,parity_shards=2,block_size=100:", upstreams))
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+
+	contents := random.String(1234)
+	src := object.NewStaticObjectInfo("dir/file", time.Now(), int64(len(contents)), true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewBufferString(contents), src)
+	require.NoError(t, err)
+
+	// Lose two shards, one data and one parity
+	for _, i := range []int{1, 4} {
+		shard, err := f.upstreams[i].NewObject(ctx, "dir/file")
+		require.NoError(t, err)
+		require.NoError(t, shard.Remove(ctx))
+	}
+	o, err := f.NewObject(ctx, "dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), o.Size())
+	assert.Equal(t, contents, readAll(ctx, t, o))
+	assert.Equal(t, contents[250:900], readAll(ctx, t, o, &fs.RangeOption{Start: 250, End: 899}))
+
+	// Repair rebuilds the lost shards
+	stats, err := f.repair(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, RepairStats{Checked: 1, Repaired: 1, Shards: 2}, stats)
+	checkShards := func() {
+		for i := range f.upstreams {
+			shard, err := f.upstreams[i].NewObject(ctx, "dir/file")
+			require.NoError(t, err)
+			assert.Equal(t, f.layout.shardSize(int64(len(contents)), i), shard.Size())
+		}
+	}
+	checkShards()
+	stats, err = f.repair(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, RepairStats{Checked: 1}, stats)
+
+	// A shard of the wrong size is ignored and rebuilt
+	shard, err := f.upstreams[0].NewObject(ctx, "dir/file")
+	require.NoError(t, err)
+	require.NoError(t, shard.Update(ctx, bytes.NewBufferString("damaged"), object.NewStaticObjectInfo("dir/file", time.Now(), 7, true, nil, nil)))
+	o, err = f.NewObject(ctx, "dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), o.Size())
+	assert.Equal(t, contents, readAll(ctx, t, o))
+	stats, err = f.repair(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, RepairStats{Checked: 1, Repaired: 1, Shards: 1}, stats)
+	checkShards()
+
+	// The repaired file reads from the data shards alone
+	for i := f.layout.k; i < len(f.upstreams); i++ {
+		shard, err := f.upstreams[i].NewObject(ctx, "dir/file")
+		require.NoError(t, err)
+		require.NoError(t, shard.Remove(ctx))
+	}
+	o, err = f.NewObject(ctx, "dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, contents, readAll(ctx, t, o))
+}
+
+func TestStaleShards(t *testing.T) {
+	ctx := context.Background()
+	dirs := MakeTestDirs(t, 5)
+	fsys, err := fs.NewFs(ctx, fmt.Sprintf(":erasure,upstreams='%s',data_shards=3,parity_shards=2,block_size=100:", strings.Join(dirs, " ")))
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+
+	put := func(contents string) {
+		src := object.NewStaticObjectInfo("file", time.Now(), int64(len(contents)), true, nil, nil)
+		o, err := f.Put(ctx, bytes.NewBufferString(contents), src)
+		require.NoError(t, err)
+		sum := md5.Sum([]byte(contents))
+		got, err := o.Hash(ctx, hash.MD5)
+		require.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(sum[:]), got)
+	}
+	readShard := func(i int) string {
+		shard, err := f.upstreams[i].NewObject(ctx, "file")
+		require.NoError(t, err)
+		return readAll(ctx, t, shard)
+	}
+	writeShard := func(i int, contents string) {
+		shard, err := f.upstreams[i].NewObject(ctx, "file")
+		require.NoError(t, err)
+		src := object.NewStaticObjectInfo("file", time.Now(), int64(len(contents)), true, nil, nil)
+		require.NoError(t, shard.Update(ctx, bytes.NewBufferString(contents), src))
+	}
+
+	// Overwrite a file with one of the same size and put back a
+	// data shard and a parity shard from the first write
+	old := random.String(1234)
+	put(old)
+	oldData, oldParity := readShard(1), readShard(3)
+	contents := random.String(1234)
+	put(contents)
+	writeShard(1, oldData)
+	writeShard(3, oldParity)
+
+	// The trailers aren't read while the shard sizes agree
+	o, err := f.NewObject(ctx, "file")
+	require.NoError(t, err)
+	obj := o.(*Object)
+	assert.False(t, obj.trailerRead)
+	assert.Equal(t, int64(len(contents)), o.Size())
+
+	// but when the hash is needed, which finds the stale parity shard
+	sum := md5.Sum([]byte(contents))
+	got, err := o.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:]), got)
+	assert.True(t, obj.trailerRead)
+	assert.Equal(t, []bool{false, false, false, true, false}, obj.bad)
+
+	// and the stale data shard from its hash when read
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	_, err = io.ReadAll(in)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't match the hash")
+	require.NoError(t, in.Close())
+
+	// Repair finds and rebuilds both of them
+	stats, err := f.repair(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, RepairStats{Checked: 1, Repaired: 1, Shards: 2}, stats)
+	o, err = f.NewObject(ctx, "file")
+	require.NoError(t, err)
+	o.(*Object).readTrailers(ctx)
+	assert.Equal(t, make([]bool, 5), o.(*Object).bad)
+	assert.Equal(t, contents, readAll(ctx, t, o))
+	stats, err = f.repair(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, RepairStats{Checked: 1}, stats)
+
+	// A corrupt data shard is found and rebuilt too
+	data := []byte(readShard(0))
+	data[10] ^= 0xff
+	writeShard(0, string(data))
+	stats, err = f.repair(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, RepairStats{Checked: 1, Repaired: 1, Shards: 1}, stats)
+	o, err = f.NewObject(ctx, "file")
+	require.NoError(t, err)
+	assert.Equal(t, contents, readAll(ctx, t, o))
+}
+
+func TestUnavailableUpstreams(t *testing.T) {
+	ctx := context.Background()
+	dirs := MakeTestDirs(t, 3)
+	fsys, err := fs.NewFs(ctx, fmt.Sprintf(":erasure,upstreams='%s':", strings.Join(dirs, " ")))
+	require.NoError(t, err)
+	contents := random.String(1234)
+	src := object.NewStaticObjectInfo("file", time.Now(), int64(len(contents)), true, nil, nil)
+	_, err = fsys.Put(ctx, bytes.NewBufferString(contents), src)
+	require.NoError(t, err)
+
+	// With one upstream which can't be created files can be read
+	missing := ":bad-backend-type:" + dirs[1]
+	fsys, err = fs.NewFs(ctx, fmt.Sprintf(":erasure,upstreams='%s %s %s':", dirs[0], missing, dirs[2]))
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+	assert.Nil(t, f.upstreams[1])
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	o, err := f.NewObject(ctx, "file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), o.Size())
+	assert.Equal(t, contents, readAll(ctx, t, o))
+
+	// but not written
+	_, err = f.Put(ctx, bytes.NewBufferString(contents), object.NewStaticObjectInfo("file2", time.Now(), int64(len(contents)), true, nil, nil))
+	assert.ErrorContains(t, err, "upstream unavailable")
+	require.NoError(t, f.Shutdown(ctx))
+
+	// and more than parity_shards unavailable is an error
+	_, err = fs.NewFs(ctx, fmt.Sprintf(":erasure,upstreams='%s %s %s':", dirs[0], missing, missing+"2"))
+	assert.ErrorContains(t, err, "failed to create upstreams")
+}
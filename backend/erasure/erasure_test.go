@@ -0,0 +1,74 @@
+// Test Erasure filesystem interface
+package erasure_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/backend/erasure"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+var (
+	unimplementableFsMethods     = []string{"UnWrap", "WrapFs", "SetWrapper", "UserInfo", "Disconnect", "PublicLink", "PutUnchecked", "MergeDirs", "OpenWriterAt", "ListR", "ChangeNotify"}
+	unimplementableObjectMethods = []string{"MimeType", "GetTier", "SetTier", "Metadata", "ID", "UnWrap"}
+)
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	if *fstest.RemoteName == "" {
+		t.Skip("Skipping as -remote not set")
+	}
+	fstests.Run(t, &fstests.Opt{
+		RemoteName:                   *fstest.RemoteName,
+		NilObject:                    (*erasure.Object)(nil),
+		UnimplementableFsMethods:     unimplementableFsMethods,
+		UnimplementableObjectMethods: unimplementableObjectMethods,
+	})
+}
+
+func TestLocal(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	dirs := erasure.MakeTestDirs(t, 3)
+	upstreams := dirs[0] + " " + dirs[1] + " " + dirs[2]
+	name := "TestErasureLocal"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "erasure"},
+			{Name: name, Key: "upstreams", Value: upstreams},
+			{Name: name, Key: "block_size", Value: "64"},
+		},
+		NilObject:                    (*erasure.Object)(nil),
+		UnimplementableFsMethods:     unimplementableFsMethods,
+		UnimplementableObjectMethods: unimplementableObjectMethods,
+		QuickTestOK:                  true,
+	})
+}
+
+func TestParity(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	dirs := erasure.MakeTestDirs(t, 5)
+	upstreams := strings.Join(dirs, " ")
+	name := "TestErasureParity"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "erasure"},
+			{Name: name, Key: "upstreams", Value: upstreams},
+			{Name: name, Key: "data_shards", Value: "3"},
+			{Name: name, Key: "parity_shards", Value: "2"},
+			{Name: name, Key: "block_size", Value: "100"},
+		},
+		NilObject:                    (*erasure.Object)(nil),
+		UnimplementableFsMethods:     unimplementableFsMethods,
+		UnimplementableObjectMethods: unimplementableObjectMethods,
+		QuickTestOK:                  true,
+	})
+}
@@ -0,0 +1,203 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// RepairStats reports the results of the repair command
+type RepairStats struct {
+	Checked  int `json:"checked"`  // files checked
+	Repaired int `json:"repaired"` // files which had shards rewritten
+	Shards   int `json:"shards"`   // shards rewritten
+	Errors   int `json:"errors"`   // files which could not be repaired
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "repair",
+	Short: "Rebuild missing or damaged shards.",
+	Long: `This command checks the shards of every file under the path and
+rebuilds the ones which are missing or damaged from the remaining
+shards, for example after replacing a failed upstream.
+
+Every shard is read to check it against the hashes recorded in the
+parity shards when the file was written, so shards which are corrupt
+or left over from another write are found as well as ones which are
+missing or have the wrong size.
+
+Usage Example:
+
+    rclone backend repair erasure:path/to/dir
+
+It returns a summary of the files checked and repaired.
+`,
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "repair":
+		return f.repair(ctx, "")
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// repair rebuilds the damaged shards of all the files under dir
+func (f *Fs) repair(ctx context.Context, dir string) (stats RepairStats, err error) {
+	var mu sync.Mutex
+	err = walk.ListR(ctx, f, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(*Object)
+			if !ok {
+				continue
+			}
+			n, err := o.repair(ctx)
+			mu.Lock()
+			stats.Checked++
+			if err != nil {
+				fs.Errorf(o, "Failed to repair: %v", err)
+				stats.Errors++
+			}
+			if n > 0 {
+				stats.Repaired++
+				stats.Shards += n
+			}
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	if stats.Errors > 0 {
+		return stats, fmt.Errorf("failed to repair %d files", stats.Errors)
+	}
+	return stats, nil
+}
+
+// verify reads the shards which aren't already known to be bad and
+// marks those which don't match the hashes in the parity trailers
+func (o *Object) verify(ctx context.Context) {
+	if o.trailer == nil {
+		return
+	}
+	l := o.f.layout
+	o.f.forAll(func(i int, u fs.Fs) (err error) {
+		shard := o.shards[i]
+		if shard == nil || o.bad[i] {
+			return nil
+		}
+		size := l.shardSize(o.size, i)
+		if i >= l.k {
+			size -= l.trailerSize()
+		}
+		defer func() {
+			if err != nil {
+				fs.Errorf(o, "Shard on %s is damaged: %v", u, err)
+				o.bad[i] = true
+			}
+		}()
+		in, err := shard.Open(ctx)
+		if err != nil {
+			return err
+		}
+		defer fs.CheckClose(in, &err)
+		h := md5.New()
+		if _, err = io.CopyN(h, in, size); err != nil {
+			return err
+		}
+		if !bytes.Equal(h.Sum(nil), o.trailer.hashes[i]) {
+			return fmt.Errorf("doesn't match the hash in the parity trailers")
+		}
+		return nil
+	})
+}
+
+// damaged returns the shards which are missing or bad
+func (o *Object) damaged() (bad []bool, n int) {
+	bad = make([]bool, len(o.shards))
+	for i, shard := range o.shards {
+		if shard == nil || o.bad[i] {
+			bad[i] = true
+			n++
+		}
+	}
+	return bad, n
+}
+
+// repair rebuilds the damaged shards of o from the others.
+// It returns the number of shards rewritten.
+func (o *Object) repair(ctx context.Context) (int, error) {
+	o.readTrailers(ctx)
+	if o.size < 0 {
+		return 0, fmt.Errorf("too many shards missing to repair the file")
+	}
+	o.verify(ctx)
+	bad, n := o.damaged()
+	if n == 0 {
+		return 0, nil
+	}
+	if n > o.f.layout.m {
+		return 0, fmt.Errorf("%d shards damaged but only %d can be rebuilt", n, o.f.layout.m)
+	}
+	d, err := o.newDecoder(ctx, 0, bad)
+	if err != nil {
+		return 0, err
+	}
+	defer fs.CheckClose(d, &err)
+
+	// Re-encode the file from the surviving shards. This recomputes
+	// the parity too so it is checked against the data read.
+	pr, pw := io.Pipe()
+	decoded := make(chan struct{})
+	go func() {
+		defer close(decoded)
+		var (
+			buf bytes.Buffer
+			err error
+		)
+		for err == nil {
+			if err = d.next(false); err == nil {
+				buf.Reset()
+				d.data(&buf)
+				_, err = pw.Write(buf.Bytes())
+			}
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	src := object.NewStaticObjectInfo(o.remote, o.modTime, o.size, true, nil, o.f)
+	// Damaged shards are rewritten in place with the ID of the write
+	// they belong to, so their trailers match those of the others
+	var id []byte
+	if o.trailer != nil {
+		id = o.trailer.id
+	}
+	_, err = o.f.writeShards(ctx, pr, src, o.remote, o.shards, bad, id)
+	_ = pr.CloseWithError(err)
+	<-decoded
+	if err != nil {
+		return 0, err
+	}
+	fs.Infof(o, "Rebuilt %d shards", n)
+	return n, nil
+}
@@ -0,0 +1,610 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+)
+
+// layout describes how files are split into shards.
+//
+// A file is encoded a stripe at a time. Each full stripe holds k
+// blocks of bs bytes, block j going to data shard j, and m blocks of
+// parity computed from them. A shorter last stripe is split into k
+// blocks of blockLen bytes, the last ones padded with zeroes for the
+// parity computation only, so the data shards add up to the file.
+//
+// Parity shards end with a trailer recording the size of the file, an
+// ID for the write and the MD5 of the file and of each shard.
+type layout struct {
+	k  int   // number of data shards
+	m  int   // number of parity shards
+	bs int64 // block size
+}
+
+const (
+	trailerMagic      = "RCEC"
+	trailerVersion    = 2
+	trailerHeaderSize = 20
+	writeIDSize       = 16
+)
+
+// stripes returns the number of stripes for a file of size
+func (l layout) stripes(size int64) int64 {
+	stripe := int64(l.k) * l.bs
+	return (size + stripe - 1) / stripe
+}
+
+// blockLen returns the length of the blocks in stripe
+func (l layout) blockLen(size, stripe int64) int64 {
+	full := size / (int64(l.k) * l.bs)
+	if stripe < full {
+		return l.bs
+	}
+	rem := size - full*int64(l.k)*l.bs
+	return (rem + int64(l.k) - 1) / int64(l.k)
+}
+
+// dataLen returns the length of the data in block j of stripe
+func (l layout) dataLen(size, stripe int64, j int) int64 {
+	full := size / (int64(l.k) * l.bs)
+	if stripe < full {
+		return l.bs
+	}
+	rem := size - full*int64(l.k)*l.bs
+	n := rem - int64(j)*l.blockLen(size, stripe)
+	if n < 0 {
+		return 0
+	}
+	if max := l.blockLen(size, stripe); n > max {
+		return max
+	}
+	return n
+}
+
+// shardSize returns the size of shard i for a file of size
+func (l layout) shardSize(size int64, i int) int64 {
+	full := size / (int64(l.k) * l.bs)
+	if i < l.k {
+		return full*l.bs + l.dataLen(size, full, i)
+	}
+	return full*l.bs + l.blockLen(size, full) + l.trailerSize()
+}
+
+// trailerSize returns the size of the trailer of the parity shards
+func (l layout) trailerSize() int64 {
+	return writeIDSize + int64(1+l.k+l.m)*md5.Size + trailerHeaderSize
+}
+
+// newWriteID makes an ID for a write. IDs of later writes sort
+// after earlier ones.
+func newWriteID() ([]byte, error) {
+	id := make([]byte, writeIDSize)
+	binary.BigEndian.PutUint64(id, uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(id[8:]); err != nil {
+		return nil, fmt.Errorf("failed to make write ID: %w", err)
+	}
+	return id, nil
+}
+
+// trailer is stored at the end of the parity shards.
+//
+// The fixed size header comes last so the layout can be read before
+// the rest of the trailer.
+type trailer struct {
+	k, m      int
+	index     int
+	blockSize int64
+	size      int64
+	id        []byte   // ID of the write, the same on all its shards
+	md5       []byte   // MD5 of the file
+	hashes    [][]byte // MD5 of each shard, not counting the trailer
+}
+
+func (t *trailer) marshal() []byte {
+	l := layout{k: t.k, m: t.m}
+	buf := make([]byte, 0, l.trailerSize())
+	buf = append(buf, t.id...)
+	buf = append(buf, t.md5...)
+	for _, h := range t.hashes {
+		buf = append(buf, h...)
+	}
+	header := make([]byte, trailerHeaderSize)
+	copy(header, trailerMagic)
+	header[4] = trailerVersion
+	header[5] = byte(t.k)
+	header[6] = byte(t.m)
+	header[7] = byte(t.index)
+	binary.BigEndian.PutUint32(header[8:], uint32(t.blockSize))
+	binary.BigEndian.PutUint64(header[12:], uint64(t.size))
+	return append(buf, header...)
+}
+
+func (t *trailer) unmarshal(buf []byte) error {
+	if len(buf) < trailerHeaderSize {
+		return errors.New("no parity trailer")
+	}
+	header := buf[len(buf)-trailerHeaderSize:]
+	if string(header[:4]) != trailerMagic {
+		return errors.New("no parity trailer")
+	}
+	if header[4] != trailerVersion {
+		return fmt.Errorf("unsupported parity trailer version %d", header[4])
+	}
+	t.k, t.m, t.index = int(header[5]), int(header[6]), int(header[7])
+	t.blockSize = int64(binary.BigEndian.Uint32(header[8:]))
+	t.size = int64(binary.BigEndian.Uint64(header[12:]))
+	l := layout{k: t.k, m: t.m}
+	if int64(len(buf)) != l.trailerSize() {
+		return fmt.Errorf("parity trailer written with %d data shards and %d parity shards", t.k, t.m)
+	}
+	t.id, buf = buf[:writeIDSize], buf[writeIDSize:]
+	t.md5, buf = buf[:md5.Size], buf[md5.Size:]
+	t.hashes = make([][]byte, t.k+t.m)
+	for i := range t.hashes {
+		t.hashes[i], buf = buf[:md5.Size], buf[md5.Size:]
+	}
+	return nil
+}
+
+// check the trailer matches the layout and shard index
+func (t *trailer) check(l layout, index int) error {
+	if t.k != l.k || t.m != l.m || t.blockSize != l.bs {
+		return fmt.Errorf("written with %d data shards, %d parity shards and block size %d", t.k, t.m, t.blockSize)
+	}
+	if t.index != index {
+		return fmt.Errorf("shard %d found in place of shard %d", t.index, index)
+	}
+	return nil
+}
+
+// readTrailer reads the trailer of a parity shard written with l
+func readTrailer(ctx context.Context, l layout, shard fs.Object) (t trailer, err error) {
+	size := l.trailerSize()
+	if shard.Size() < size {
+		return t, errors.New("parity shard too short")
+	}
+	in, err := shard.Open(ctx, &fs.RangeOption{Start: shard.Size() - size, End: -1})
+	if err != nil {
+		return t, err
+	}
+	defer fs.CheckClose(in, &err)
+	buf := make([]byte, size)
+	if _, err = io.ReadFull(in, buf); err != nil {
+		return t, err
+	}
+	return t, t.unmarshal(buf)
+}
+
+// writeShards encodes in and uploads the shards for remote.
+//
+// Shards are uploaded to all the upstreams unless only is set, when
+// only the shards marked in it are uploaded. This is used by repair,
+// in which case the data comes from reading the other shards.
+//
+// The trailers are written with id, which should be that of the
+// existing shards when repairing. If it is nil a new one is made.
+//
+// Existing shards are updated in place and new ones stored in shards.
+// It returns the trailer of the file written.
+func (f *Fs) writeShards(ctx context.Context, in io.Reader, src fs.ObjectInfo, remote string, shards []fs.Object, only []bool, id []byte, options ...fs.OpenOption) (t trailer, err error) {
+	l := f.layout
+	n := l.k + l.m
+	srcSize := src.Size()
+	modTime := src.ModTime(ctx)
+	for i := 0; i < n; i++ {
+		if only == nil || only[i] {
+			if err := f.errUnavailable(i); err != nil {
+				return t, fmt.Errorf("%s: %w", f.opt.Upstreams[i], err)
+			}
+		}
+	}
+	if id == nil {
+		id, err = newWriteID()
+		if err != nil {
+			return t, err
+		}
+	}
+
+	writers := make([]*io.PipeWriter, n)
+	results := make([]fs.Object, n)
+	errs := make(shardErrors, n)
+	done := make(chan struct{})
+	running := 0
+	for i := 0; i < n; i++ {
+		if only != nil && !only[i] {
+			continue
+		}
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		shardSize := int64(-1)
+		if srcSize >= 0 {
+			shardSize = l.shardSize(srcSize, i)
+		}
+		info := object.NewStaticObjectInfo(remote, modTime, shardSize, true, nil, f.upstreams[i])
+		running++
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			var err error
+			u := f.upstreams[i]
+			switch {
+			case shards[i] != nil:
+				err = shards[i].Update(ctx, pr, info, options...)
+				results[i] = shards[i]
+			case shardSize < 0:
+				results[i], err = u.Features().PutStream(ctx, pr, info, options...)
+			default:
+				results[i], err = u.Put(ctx, pr, info, options...)
+			}
+			errs[i] = err
+			// Stop the encoder if this upload failed
+			_ = pr.CloseWithError(err)
+		}(i)
+	}
+
+	t, err = f.encode(in, writers, id)
+	for _, pw := range writers {
+		if pw != nil {
+			_ = pw.CloseWithError(err)
+		}
+	}
+	for ; running > 0; running-- {
+		<-done
+	}
+	if err == nil {
+		err = errs.err(f)
+	}
+	if err == nil && srcSize >= 0 && t.size != srcSize {
+		err = fmt.Errorf("wrote %d bytes but expected %d", t.size, srcSize)
+	}
+	if err != nil {
+		// Remove new shards so no half written file is left behind
+		for i, o := range results {
+			if o != nil && shards[i] == nil {
+				if rmErr := o.Remove(ctx); rmErr != nil {
+					fs.Errorf(o, "Failed to remove shard after failed upload: %v", rmErr)
+				}
+			}
+		}
+		return t, err
+	}
+	for i, o := range results {
+		if o != nil {
+			shards[i] = o
+		}
+	}
+	return t, nil
+}
+
+// encode reads in a stripe at a time and writes the shards to out,
+// finishing the parity shards with trailers recording id.
+// Shards with a nil writer are encoded but not written.
+//
+// It returns the trailer written.
+func (f *Fs) encode(in io.Reader, out []*io.PipeWriter, id []byte) (t trailer, err error) {
+	l := f.layout
+	n := l.k + l.m
+	t = trailer{k: l.k, m: l.m, blockSize: l.bs, id: id}
+	buf := make([]byte, int64(l.k)*l.bs)
+	shards := make([][]byte, n)
+	hashers := make([]hash.Hash, n)
+	for i := range shards {
+		shards[i] = make([]byte, l.bs)
+		hashers[i] = md5.New()
+	}
+	fileHasher := md5.New()
+	write := func(i int, data []byte) error {
+		_, _ = hashers[i].Write(data)
+		if out[i] == nil {
+			return nil
+		}
+		_, err := out[i].Write(data)
+		return err
+	}
+	for {
+		got, err := io.ReadFull(in, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return t, err
+		}
+		t.size += int64(got)
+		_, _ = fileHasher.Write(buf[:got])
+		blen := (got + l.k - 1) / l.k
+		stripe := make([][]byte, n)
+		for i := range stripe {
+			stripe[i] = shards[i][:blen]
+		}
+		for j := 0; j < l.k; j++ {
+			start, end := j*blen, (j+1)*blen
+			if end > got {
+				end = got
+			}
+			if start > end {
+				start = end
+			}
+			data := stripe[j][:copy(stripe[j], buf[start:end])]
+			for x := len(data); x < blen; x++ {
+				stripe[j][x] = 0
+			}
+			if err := write(j, data); err != nil {
+				return t, err
+			}
+		}
+		if err := f.enc.Encode(stripe); err != nil {
+			return t, err
+		}
+		for i := l.k; i < n; i++ {
+			if err := write(i, stripe[i]); err != nil {
+				return t, err
+			}
+		}
+		if got < len(buf) {
+			break
+		}
+	}
+	t.md5 = fileHasher.Sum(nil)
+	t.hashes = make([][]byte, n)
+	for i, h := range hashers {
+		t.hashes[i] = h.Sum(nil)
+	}
+	for i := l.k; i < n; i++ {
+		t.index = i
+		if out[i] == nil {
+			continue
+		}
+		if _, err := out[i].Write(t.marshal()); err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// decoder reads the shards of an object a stripe at a time,
+// reconstructing the missing ones
+//
+// Shards read from the start are checked against the hashes in the
+// parity trailers once their last block has been read.
+type decoder struct {
+	ctx     context.Context
+	o       *Object
+	l       layout
+	size    int64
+	stripe  int64           // next stripe to read
+	skip    []bool          // shards which mustn't be used
+	in      []io.ReadCloser // open shards
+	hashers []hash.Hash     // hashes of the shards read from the start
+	shards  [][]byte        // the current stripe
+}
+
+// newDecoder makes a decoder for o starting at stripe which won't
+// read the shards marked in skip or found bad by init
+func (o *Object) newDecoder(ctx context.Context, stripe int64, skip []bool) (*decoder, error) {
+	l := o.f.layout
+	n := l.k + l.m
+	d := &decoder{
+		ctx:     ctx,
+		o:       o,
+		l:       l,
+		size:    o.size,
+		stripe:  stripe,
+		skip:    make([]bool, n),
+		in:      make([]io.ReadCloser, n),
+		hashers: make([]hash.Hash, n),
+		shards:  make([][]byte, n),
+	}
+	for i := range d.skip {
+		d.skip[i] = o.bad[i] || (skip != nil && skip[i])
+	}
+	for i := range d.shards {
+		d.shards[i] = make([]byte, 0, l.bs)
+	}
+	for i := 0; i < l.k; i++ {
+		if err := d.openAny(); err != nil {
+			_ = d.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// openAny opens another shard at the current stripe, preferring
+// data shards as these don't need decoding
+func (d *decoder) openAny() (err error) {
+	for i, shard := range d.o.shards {
+		if shard == nil || d.skip[i] || d.in[i] != nil {
+			continue
+		}
+		var options []fs.OpenOption
+		if offset := d.stripe * d.l.bs; offset > 0 {
+			options = append(options, &fs.SeekOption{Offset: offset})
+		}
+		in, err := shard.Open(d.ctx, options...)
+		if err != nil {
+			fs.Errorf(d.o, "Failed to open shard on %s: %v", d.o.f.upstreams[i], err)
+			d.skip[i] = true
+			continue
+		}
+		d.in[i] = in
+		if d.stripe == 0 && d.o.trailer != nil {
+			d.hashers[i] = md5.New()
+		}
+		return nil
+	}
+	return errors.New("too many shards missing to read the file")
+}
+
+// next reads the next stripe and reconstructs the data shards, or
+// all the shards if all is set.
+//
+// If a shard fails to read another one is opened in its place.
+//
+// It returns io.EOF after the last stripe.
+func (d *decoder) next(all bool) error {
+	if d.stripe >= d.l.stripes(d.size) {
+		return io.EOF
+	}
+	blen := d.l.blockLen(d.size, d.stripe)
+	for i := range d.shards {
+		d.shards[i] = d.shards[i][:0]
+	}
+	for failed := true; failed; {
+		failed = false
+		for i := range d.shards {
+			if d.in[i] == nil || len(d.shards[i]) > 0 {
+				continue
+			}
+			if err := d.readBlock(i, blen); err != nil {
+				fs.Errorf(d.o, "Failed to read shard on %s: %v", d.o.f.upstreams[i], err)
+				_ = d.in[i].Close()
+				d.in[i] = nil
+				d.hashers[i] = nil
+				d.skip[i] = true
+				if err := d.openAny(); err != nil {
+					return err
+				}
+				failed = true
+			}
+		}
+	}
+	if d.stripe == d.l.stripes(d.size)-1 {
+		if err := d.checkHashes(); err != nil {
+			return err
+		}
+	}
+	for i := range d.shards {
+		if len(d.shards[i]) > 0 {
+			continue
+		}
+		var err error
+		if all {
+			err = d.o.f.enc.Reconstruct(d.shards)
+		} else {
+			err = d.o.f.enc.ReconstructData(d.shards)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to reconstruct stripe %d: %w", d.stripe, err)
+		}
+		break
+	}
+	d.stripe++
+	return nil
+}
+
+// readBlock reads the block of shard i for the current stripe,
+// padding the data shards to blen
+func (d *decoder) readBlock(i int, blen int64) error {
+	want := blen
+	if i < d.l.k {
+		want = d.l.dataLen(d.size, d.stripe, i)
+	}
+	block := d.shards[i][:blen]
+	if _, err := io.ReadFull(d.in[i], block[:want]); err != nil {
+		return err
+	}
+	if h := d.hashers[i]; h != nil {
+		_, _ = h.Write(block[:want])
+	}
+	for x := want; x < blen; x++ {
+		block[x] = 0
+	}
+	d.shards[i] = block
+	return nil
+}
+
+// checkHashes checks the shards read from the start against the
+// hashes in the parity trailers
+func (d *decoder) checkHashes() error {
+	for i, h := range d.hashers {
+		if h == nil {
+			continue
+		}
+		if !bytes.Equal(h.Sum(nil), d.o.trailer.hashes[i]) {
+			return fmt.Errorf("shard on %s doesn't match the hash in the parity trailers - run the repair command", d.o.f.upstreams[i])
+		}
+	}
+	return nil
+}
+
+// data returns the file data in the current stripe
+func (d *decoder) data(buf *bytes.Buffer) {
+	stripe := d.stripe - 1
+	for j := 0; j < d.l.k; j++ {
+		buf.Write(d.shards[j][:d.l.dataLen(d.size, stripe, j)])
+	}
+}
+
+// Close the open shards
+func (d *decoder) Close() (err error) {
+	for i, in := range d.in {
+		if in != nil {
+			if closeErr := in.Close(); closeErr != nil {
+				err = closeErr
+			}
+			d.in[i] = nil
+		}
+	}
+	return err
+}
+
+// reader reads a range of an object
+type reader struct {
+	d     *decoder
+	buf   bytes.Buffer
+	skip  int64 // bytes to skip at the start
+	limit int64 // bytes left to return
+}
+
+// newReader returns a reader for limit bytes at offset
+func (o *Object) newReader(ctx context.Context, offset, limit int64) (io.ReadCloser, error) {
+	stripeLen := int64(o.f.layout.k) * o.f.layout.bs
+	stripe := offset / stripeLen
+	d, err := o.newDecoder(ctx, stripe, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{
+		d:     d,
+		skip:  offset - stripe*stripeLen,
+		limit: limit,
+	}, nil
+}
+
+// Read data from the object
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.limit <= 0 {
+		return 0, io.EOF
+	}
+	for r.buf.Len() == 0 {
+		if err = r.d.next(false); err != nil {
+			return 0, err
+		}
+		r.d.data(&r.buf)
+		if r.skip > 0 {
+			r.buf.Next(int(r.skip))
+			r.skip = 0
+		}
+	}
+	if int64(len(p)) > r.limit {
+		p = p[:r.limit]
+	}
+	n, _ = r.buf.Read(p)
+	r.limit -= int64(n)
+	return n, nil
+}
+
+// Close the reader
+func (r *reader) Close() error {
+	return r.d.Close()
+}
@@ -36,6 +36,8 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 			return nil, errors.New("please provide checksum type and path to sum file")
 		}
 		return nil, f.dbImport(ctx, arg[0], arg[1], sticky)
+	case "sync":
+		return f.syncShared(ctx)
 	default:
 		return nil, fs.ErrorCommandNotFound
 	}
@@ -70,6 +72,14 @@ Usage Example:
 Usage Example:
     rclone backend stickyimport hasher:subdir md5 remote:path/to/sum.md5
 `,
+}, {
+	Name:  "sync",
+	Short: "Sync the shared hash database",
+	Long: `Merge the local checksum cache with the database set by shared_db
+and upload the result if it changed.
+Usage Example:
+    rclone backend sync hasher:
+`,
 }}
 
 func (f *Fs) dbDump(ctx context.Context, full bool, root string) error {
@@ -50,16 +50,34 @@ func init() {
 			Advanced: true,
 			Default:  fs.SizeSuffix(0),
 			Help:     "Auto-update checksum for files smaller than this size (disabled by default).",
+		}, {
+			Name:     "shared_db",
+			Advanced: true,
+			Default:  "",
+			Help: `Path of a hash database shared on the wrapped remote.
+
+If set, checksums cached locally are merged with a database file kept
+at this path relative to the root of the wrapped remote, so hashes
+computed by one machine are reused by every hasher sharing the file.
+
+The file is hidden from listings. Requires max_age to be non-zero.`,
+		}, {
+			Name:     "shared_db_sync",
+			Advanced: true,
+			Default:  fs.Duration(5 * time.Minute),
+			Help:     "Interval between syncs of the shared hash database (0 to only sync at start and exit).",
 		}},
 	})
 }
 
 // Options defines the configuration for this backend
 type Options struct {
-	Remote   string          `config:"remote"`
-	Hashes   fs.CommaSepList `config:"hashes"`
-	AutoSize fs.SizeSuffix   `config:"auto_size"`
-	MaxAge   fs.Duration     `config:"max_age"`
+	Remote       string          `config:"remote"`
+	Hashes       fs.CommaSepList `config:"hashes"`
+	AutoSize     fs.SizeSuffix   `config:"auto_size"`
+	MaxAge       fs.Duration     `config:"max_age"`
+	SharedDB     string          `config:"shared_db"`
+	SharedDBSync fs.Duration     `config:"shared_db_sync"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -71,6 +89,7 @@ type Fs struct {
 	features *fs.Features
 	opt      *Options
 	db       *kv.DB
+	shared   *sharedState // shared hash database or nil
 	// fingerprinting
 	fpTime bool      // true if using time in fingerprints
 	fpHash hash.Type // hash type to use in fingerprints or None
@@ -169,6 +188,12 @@ func NewFs(ctx context.Context, fsname, rpath string, cmap configmap.Mapper) (fs
 	}
 	f.features = stubFeatures.Fill(ctx, f).Mask(ctx, f.Fs).WrapsFs(f, f.Fs)
 
+	if f.opt.SharedDB != "" {
+		if err := f.startShared(ctx); err != nil {
+			return nil, err
+		}
+	}
+
 	cache.PinUntilFinalized(f.Fs, f)
 	return f, err
 }
@@ -210,6 +235,9 @@ func (f *Fs) wrapEntries(baseEntries fs.DirEntries) (hashEntries fs.DirEntries,
 	for _, entry := range baseEntries {
 		switch x := entry.(type) {
 		case fs.Object:
+			if f.shared != nil && x.Remote() == f.shared.hidden {
+				continue
+			}
 			obj, err := f.wrapObject(x, nil)
 			if err != nil {
 				return nil, err
@@ -247,8 +275,10 @@ func (f *Fs) Purge(ctx context.Context, dir string) error {
 		if err := do(ctx, dir); err != nil {
 			return err
 		}
+		key := path.Join(f.Fs.Root(), dir)
+		f.tombstone(key, true)
 		err := f.db.Do(true, &kvPurge{
-			dir: path.Join(f.Fs.Root(), dir),
+			dir: key,
 		})
 		if err != nil {
 			fs.Errorf(f, "Failed to purge some hashes: %v", err)
@@ -280,8 +310,10 @@ func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo,
 
 // pruneHash deletes hash for a path
 func (f *Fs) pruneHash(remote string) error {
+	key := path.Join(f.Fs.Root(), remote)
+	f.tombstone(key, false)
 	return f.db.Do(true, &kvPrune{
-		key: path.Join(f.Fs.Root(), remote),
+		key: key,
 	})
 }
 
@@ -377,6 +409,7 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	if err != nil {
 		return nil, err
 	}
+	f.tombstone(path.Join(f.Fs.Root(), src.Remote()), false)
 	_ = f.db.Do(true, &kvMove{
 		src: path.Join(f.Fs.Root(), src.Remote()),
 		dst: path.Join(f.Fs.Root(), remote),
@@ -398,6 +431,7 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 	}
 	err := do(ctx, srcFs.Fs, srcRemote, dstRemote)
 	if err == nil {
+		f.tombstone(path.Join(srcFs.Fs.Root(), srcRemote), true)
 		_ = f.db.Do(true, &kvMove{
 			src: path.Join(srcFs.Fs.Root(), srcRemote),
 			dst: path.Join(f.Fs.Root(), dstRemote),
@@ -410,7 +444,10 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 
 // Shutdown the backend, closing any background tasks and any cached connections.
 func (f *Fs) Shutdown(ctx context.Context) (err error) {
-	err = f.db.Stop(false)
+	err = f.stopShared(ctx)
+	if err2 := f.db.Stop(false); err2 != nil {
+		err = err2
+	}
 	if do := f.Fs.Features().Shutdown; do != nil {
 		if err2 := do(ctx); err2 != nil {
 			err = err2
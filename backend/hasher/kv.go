@@ -23,9 +23,9 @@ const (
 type hashMap map[hash.Type]string
 
 type hashRecord struct {
-	Fp      string // fingerprint
-	Hashes  operations.HashSums
-	Created time.Time
+	Fp      string              `json:"fp"` // fingerprint
+	Hashes  operations.HashSums `json:"hashes"`
+	Created time.Time           `json:"created"`
 }
 
 func (r *hashRecord) encode(key string) ([]byte, error) {
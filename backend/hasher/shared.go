@@ -0,0 +1,457 @@
+package hasher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+const (
+	sharedVersion     = 1
+	maxSharedAttempts = 5
+)
+
+// sharedDB is the contents of the hash database shared on the remote.
+// Records are keyed by path relative to the root of the wrapped remote,
+// so all hashers sharing a database agree on the keys whatever their
+// local cache looks like.
+type sharedDB struct {
+	Version int                    `json:"version"`
+	Records map[string]*hashRecord `json:"records"`
+}
+
+// sharedState keeps track of the shared hash database
+type sharedState struct {
+	f      fs.Fs  // root of the wrapped remote holding the database
+	root   string // root of the wrapped remote as cache keys start
+	hidden string // database path relative to the hasher root or ""
+	syncMu sync.Mutex
+	// paths pruned locally since the last sync, a trailing slash
+	// marks a whole directory
+	tombMu sync.Mutex
+	tombs  map[string]time.Time
+	stop   chan struct{}
+	done   sync.WaitGroup
+}
+
+// SyncStats reports the results of a shared database sync
+type SyncStats struct {
+	Records int  `json:"records"` // records in the shared database
+	Pulled  int  `json:"pulled"`  // records updated in the local cache
+	Pushed  bool `json:"pushed"`  // true if the shared database was uploaded
+}
+
+// startShared sets up the shared hash database and runs the first sync
+func (f *Fs) startShared(ctx context.Context) error {
+	if f.db == nil {
+		return errors.New("shared_db needs the local cache enabled by max_age")
+	}
+	dbPath := strings.Trim(f.opt.SharedDB, "/")
+	if dbPath == "" || path.Clean(dbPath) != dbPath || strings.HasPrefix(dbPath, "../") {
+		return fmt.Errorf("invalid shared_db path %q", f.opt.SharedDB)
+	}
+	f.opt.SharedDB = dbPath
+	remoteFs, err := cache.Get(ctx, f.opt.Remote)
+	if err != nil {
+		return fmt.Errorf("failed to open shared database remote: %w", err)
+	}
+	cache.Pin(remoteFs)
+	s := &sharedState{
+		f:     remoteFs,
+		root:  remoteFs.Root(),
+		tombs: map[string]time.Time{},
+		stop:  make(chan struct{}),
+	}
+	if f.root == "" {
+		s.hidden = dbPath
+	} else if strings.HasPrefix(dbPath, f.root+"/") {
+		s.hidden = dbPath[len(f.root)+1:]
+	}
+	f.shared = s
+
+	if _, err := f.syncShared(ctx); err != nil {
+		fs.Errorf(f, "Failed to sync shared hash database: %v", err)
+	}
+	if interval := time.Duration(f.opt.SharedDBSync); interval > 0 {
+		s.done.Add(1)
+		go f.sharedLoop(interval)
+	}
+	return nil
+}
+
+// sharedLoop syncs the shared database periodically until stopped
+func (f *Fs) sharedLoop(interval time.Duration) {
+	s := f.shared
+	defer s.done.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if _, err := f.syncShared(context.Background()); err != nil {
+				fs.Errorf(f, "Failed to sync shared hash database: %v", err)
+			}
+		}
+	}
+}
+
+// stopShared stops the periodic sync and pushes the final changes
+func (f *Fs) stopShared(ctx context.Context) error {
+	s := f.shared
+	if s == nil {
+		return nil
+	}
+	close(s.stop)
+	s.done.Wait()
+	_, err := f.syncShared(ctx)
+	cache.Unpin(s.f)
+	f.shared = nil
+	return err
+}
+
+// sharedKey converts a cache key to a key in the shared database
+func (s *sharedState) sharedKey(key string) (string, bool) {
+	if s.root == "" {
+		return key, key != ""
+	}
+	if !strings.HasPrefix(key, s.root+"/") {
+		return "", false
+	}
+	return key[len(s.root)+1:], true
+}
+
+// cacheKey converts a key in the shared database to a cache key
+func (s *sharedState) cacheKey(key string) string {
+	return path.Join(s.root, key)
+}
+
+// tombstone remembers that hashes for a cache key or a directory
+// were deleted so the next sync will not bring them back
+func (f *Fs) tombstone(key string, dir bool) {
+	s := f.shared
+	if s == nil {
+		return
+	}
+	key, ok := s.sharedKey(key)
+	if !ok {
+		return
+	}
+	if dir {
+		key += "/"
+	}
+	s.tombMu.Lock()
+	s.tombs[key] = time.Now()
+	s.tombMu.Unlock()
+}
+
+// isBuried returns true if a record created at the given time
+// was deleted by one of the tombstones
+func isBuried(tombs map[string]time.Time, key string, created time.Time) bool {
+	for tomb, deleted := range tombs {
+		if (tomb == key || (strings.HasSuffix(tomb, "/") && strings.HasPrefix(key, tomb))) && !created.After(deleted) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeRecord combines two records for the same path.
+// Records with the same fingerprint pool their hashes with the newer
+// values winning, otherwise the newer record replaces the older one.
+func mergeRecord(a, b *hashRecord) *hashRecord {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if b.Created.Before(a.Created) {
+		a, b = b, a
+	}
+	if a.Fp != b.Fp {
+		return b
+	}
+	r := &hashRecord{
+		Fp:      b.Fp,
+		Hashes:  make(map[string]string, len(a.Hashes)+len(b.Hashes)),
+		Created: b.Created,
+	}
+	for hashType, hashVal := range a.Hashes {
+		r.Hashes[hashType] = hashVal
+	}
+	for hashType, hashVal := range b.Hashes {
+		r.Hashes[hashType] = hashVal
+	}
+	return r
+}
+
+// sameRecord returns true if records carry identical data
+func sameRecord(a, b *hashRecord) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Fp != b.Fp || !a.Created.Equal(b.Created) || len(a.Hashes) != len(b.Hashes) {
+		return false
+	}
+	for hashType, hashVal := range a.Hashes {
+		if b.Hashes[hashType] != hashVal {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeShared merges the shared and local records.
+// It returns the merged records, the records the local cache lacks
+// and whether the shared database must be updated.
+func mergeShared(remote, local map[string]*hashRecord, tombs map[string]time.Time, maxAge time.Duration) (merged, pull map[string]*hashRecord, push bool) {
+	merged = map[string]*hashRecord{}
+	pull = map[string]*hashRecord{}
+	keys := map[string]struct{}{}
+	for key := range remote {
+		keys[key] = struct{}{}
+	}
+	for key := range local {
+		keys[key] = struct{}{}
+	}
+	for key := range keys {
+		r, l := remote[key], local[key]
+		if r != nil && isBuried(tombs, key, r.Created) {
+			r = nil
+			push = true
+		}
+		m := mergeRecord(r, l)
+		if m == nil || time.Since(m.Created) > maxAge {
+			push = push || r != nil
+			continue
+		}
+		merged[key] = m
+		if !sameRecord(m, l) {
+			pull[key] = m
+		}
+		if !sameRecord(m, r) {
+			push = true
+		}
+	}
+	return merged, pull, push
+}
+
+// syncShared merges the local cache with the shared database.
+// Concurrent writers are detected by checking the database object
+// did not change while merging, in which case the merge is retried.
+func (f *Fs) syncShared(ctx context.Context) (stats SyncStats, err error) {
+	s := f.shared
+	if s == nil {
+		return stats, errors.New("shared hash database is not configured")
+	}
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	s.tombMu.Lock()
+	tombs := s.tombs
+	s.tombs = map[string]time.Time{}
+	s.tombMu.Unlock()
+	defer func() {
+		if err != nil {
+			// keep tombstones for the next attempt
+			s.tombMu.Lock()
+			for key, deleted := range tombs {
+				if prev, ok := s.tombs[key]; !ok || prev.Before(deleted) {
+					s.tombs[key] = deleted
+				}
+			}
+			s.tombMu.Unlock()
+		}
+	}()
+
+	maxAge := time.Duration(f.opt.MaxAge)
+	for attempt := 1; attempt <= maxSharedAttempts; attempt++ {
+		remoteObj, remote, err := f.readShared(ctx)
+		if err != nil {
+			return stats, err
+		}
+		export := &kvExport{root: s.root, shared: s}
+		if err = f.db.Do(false, export); err != nil && err != kv.ErrEmpty {
+			return stats, fmt.Errorf("failed to read local cache: %w", err)
+		}
+		merged, pull, push := mergeShared(remote.Records, export.records, tombs, maxAge)
+
+		if len(pull) > 0 {
+			if err = f.db.Do(true, &kvImport{records: pull, shared: s}); err != nil {
+				return stats, fmt.Errorf("failed to update local cache: %w", err)
+			}
+		}
+		stats = SyncStats{Records: len(merged), Pulled: len(pull)}
+		if !push {
+			fs.Debugf(f, "Shared hash database: %d records, %d pulled", stats.Records, stats.Pulled)
+			return stats, nil
+		}
+
+		changed, err := f.sharedChanged(ctx, remoteObj)
+		if err != nil {
+			return stats, err
+		}
+		if changed {
+			fs.Debugf(f, "Shared hash database changed while merging, retrying (%d/%d)", attempt, maxSharedAttempts)
+			continue
+		}
+		if err = f.writeShared(ctx, remoteObj, merged); err != nil {
+			return stats, err
+		}
+		stats.Pushed = true
+		fs.Debugf(f, "Shared hash database: %d records, %d pulled, pushed", stats.Records, stats.Pulled)
+		return stats, nil
+	}
+	return stats, errors.New("shared hash database keeps changing, giving up")
+}
+
+// readShared downloads the shared database.
+// It returns a nil object and an empty database if there is none yet.
+func (f *Fs) readShared(ctx context.Context) (fs.Object, *sharedDB, error) {
+	db := &sharedDB{Records: map[string]*hashRecord{}}
+	obj, err := f.shared.f.NewObject(ctx, f.opt.SharedDB)
+	if err == fs.ErrorObjectNotFound {
+		return nil, db, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find shared hash database: %w", err)
+	}
+	in, err := obj.Open(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open shared hash database: %w", err)
+	}
+	defer fs.CheckClose(in, &err)
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid shared hash database: %w", err)
+	}
+	if err = json.NewDecoder(gz).Decode(db); err != nil {
+		return nil, nil, fmt.Errorf("invalid shared hash database: %w", err)
+	}
+	if db.Version != sharedVersion {
+		return nil, nil, fmt.Errorf("unsupported shared hash database version %d", db.Version)
+	}
+	if db.Records == nil {
+		db.Records = map[string]*hashRecord{}
+	}
+	return obj, db, nil
+}
+
+// sharedChanged returns true if the shared database was updated
+// by another writer since it was read as obj
+func (f *Fs) sharedChanged(ctx context.Context, obj fs.Object) (bool, error) {
+	cur, err := f.shared.f.NewObject(ctx, f.opt.SharedDB)
+	if err == fs.ErrorObjectNotFound {
+		return obj != nil, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to find shared hash database: %w", err)
+	}
+	if obj == nil {
+		return true, nil
+	}
+	return cur.Size() != obj.Size() || !cur.ModTime(ctx).Equal(obj.ModTime(ctx)), nil
+}
+
+// writeShared uploads the merged records replacing obj if not nil
+func (f *Fs) writeShared(ctx context.Context, obj fs.Object, records map[string]*hashRecord) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	err := json.NewEncoder(gz).Encode(&sharedDB{
+		Version: sharedVersion,
+		Records: records,
+	})
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode shared hash database: %w", err)
+	}
+	info := object.NewStaticObjectInfo(f.opt.SharedDB, time.Now(), int64(buf.Len()), true, nil, nil)
+	if obj != nil {
+		err = obj.Update(ctx, &buf, info)
+	} else {
+		_, err = f.shared.f.Put(ctx, &buf, info)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload shared hash database: %w", err)
+	}
+	return nil
+}
+
+// kvExport: read records under the shared root
+type kvExport struct {
+	root    string
+	shared  *sharedState
+	records map[string]*hashRecord
+}
+
+func (op *kvExport) Do(ctx context.Context, b kv.Bucket) error {
+	op.records = map[string]*hashRecord{}
+	cur := b.Cursor()
+	var bkey, data []byte
+	if op.root != "" {
+		bkey, data = cur.Seek([]byte(op.root + "/"))
+	} else {
+		bkey, data = cur.First()
+	}
+	for ; bkey != nil; bkey, data = cur.Next() {
+		key, ok := op.shared.sharedKey(string(bkey))
+		if !ok {
+			break
+		}
+		r := &hashRecord{}
+		if err := r.decode(string(bkey), data); err != nil {
+			continue
+		}
+		op.records[key] = r
+	}
+	return nil
+}
+
+// kvImport: merge shared records into the cache
+type kvImport struct {
+	records map[string]*hashRecord
+	shared  *sharedState
+}
+
+func (op *kvImport) Do(ctx context.Context, b kv.Bucket) error {
+	s := op.shared
+	s.tombMu.Lock()
+	defer s.tombMu.Unlock()
+	for key, r := range op.records {
+		// skip records deleted while the sync was running
+		if isBuried(s.tombs, key, r.Created) {
+			continue
+		}
+		cacheKey := s.cacheKey(key)
+		if data := b.Get([]byte(cacheKey)); len(data) > 0 {
+			var cur hashRecord
+			if err := cur.decode(cacheKey, data); err == nil {
+				r = mergeRecord(&cur, r)
+			}
+		}
+		data, err := r.encode(cacheKey)
+		if err != nil {
+			return err
+		}
+		if err = b.Put([]byte(cacheKey), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package hasher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeShared(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	rec := func(fp string, created time.Time, hashes ...string) *hashRecord {
+		r := &hashRecord{Fp: fp, Created: created, Hashes: operations.HashSums{}}
+		for i := 0; i < len(hashes); i += 2 {
+			r.Hashes[hashes[i]] = hashes[i+1]
+		}
+		return r
+	}
+	remote := map[string]*hashRecord{
+		"same":    rec("fp1", old, "md5", "m1"),
+		"union":   rec("fp1", old, "md5", "m1"),
+		"changed": rec("fp1", old, "md5", "m1"),
+		"remote":  rec("fp1", old, "md5", "m1"),
+		"deleted": rec("fp1", old, "md5", "m1"),
+		"dir/sub": rec("fp1", old, "md5", "m1"),
+		"expired": rec("fp1", now.Add(-48*time.Hour), "md5", "m1"),
+	}
+	local := map[string]*hashRecord{
+		"same":    rec("fp1", old, "md5", "m1"),
+		"union":   rec("fp1", now, "sha1", "s1"),
+		"changed": rec("fp2", now, "md5", "m2"),
+		"local":   rec("fp1", now, "md5", "m1"),
+	}
+	tombs := map[string]time.Time{
+		"deleted": now,
+		"dir/":    now,
+	}
+
+	merged, pull, push := mergeShared(remote, local, tombs, 24*time.Hour)
+	assert.True(t, push)
+	assert.Equal(t, []string{"changed", "local", "remote", "same", "union"}, sortedKeys(merged))
+	assert.Equal(t, operations.HashSums{"md5": "m1", "sha1": "s1"}, merged["union"].Hashes)
+	assert.Equal(t, "fp2", merged["changed"].Fp)
+	assert.Equal(t, []string{"remote", "union"}, sortedKeys(pull))
+
+	// nothing to do when both sides agree
+	_, pull, push = mergeShared(merged, merged, nil, 24*time.Hour)
+	assert.False(t, push)
+	assert.Empty(t, pull)
+
+	// a record recreated after the tombstone survives
+	recreated := map[string]*hashRecord{"deleted": rec("fp1", now.Add(time.Second))}
+	merged, pull, push = mergeShared(recreated, nil, tombs, 24*time.Hour)
+	assert.False(t, push)
+	assert.Contains(t, merged, "deleted")
+	assert.Contains(t, pull, "deleted")
+}
+
+func sortedKeys(m map[string]*hashRecord) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestSharedDB(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("hasher is not supported on this OS")
+	}
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	const dbName = ".rclone_hashes.json.gz"
+	fsys, err := fs.NewFs(ctx, ":hasher,remote='"+tempDir+"',shared_db="+dbName+",shared_db_sync=0:")
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+	defer func() {
+		assert.NoError(t, f.Shutdown(ctx))
+	}()
+	require.NotNil(t, f.shared)
+
+	// a hash computed here is pushed to the shared database
+	obj := putFile(ctx, t, f, "dir/file", "shared hashes")
+	sum, err := obj.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	require.NotEmpty(t, sum)
+	stats, err := f.syncShared(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, SyncStats{Records: 1, Pushed: true}, stats)
+	_, err = os.Stat(filepath.Join(tempDir, dbName))
+	require.NoError(t, err)
+
+	// the database is hidden from listings
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// a lost local cache is refilled from the shared database
+	require.NoError(t, f.db.Do(true, &kvPurge{dir: f.Fs.Root()}))
+	_, err = f.getRawHash(ctx, hash.MD5, "dir/file", anyFingerprint, time.Hour)
+	require.Error(t, err)
+	stats, err = f.syncShared(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, SyncStats{Records: 1, Pulled: 1}, stats)
+	got, err := f.getRawHash(ctx, hash.MD5, "dir/file", anyFingerprint, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, sum, got)
+
+	// records written by another machine are merged in
+	remoteObj, db, err := f.readShared(ctx)
+	require.NoError(t, err)
+	db.Records["other"] = &hashRecord{Fp: anyFingerprint, Created: time.Now(), Hashes: operations.HashSums{"md5": "0123"}}
+	require.NoError(t, f.writeShared(ctx, remoteObj, db.Records))
+	stats, err = f.syncShared(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, SyncStats{Records: 2, Pulled: 1}, stats)
+	got, err = f.getRawHash(ctx, hash.MD5, "other", anyFingerprint, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "0123", got)
+
+	// removed files are removed from the shared database
+	require.NoError(t, obj.Remove(ctx))
+	stats, err = f.syncShared(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, SyncStats{Records: 1, Pushed: true}, stats)
+	_, db, err = f.readShared(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, db.Records, "dir/file")
+}
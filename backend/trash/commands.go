@@ -0,0 +1,338 @@
+package trash
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/hiddendir"
+	"github.com/rclone/rclone/lib/version"
+)
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "list":
+		return f.listCommand(ctx, arg, opt)
+	case "restore":
+		return f.restoreCommand(ctx, arg, opt)
+	case "empty":
+		return f.emptyCommand(ctx, opt)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "list",
+	Short: "List the files in the trash.",
+	Long: `This command lists the deleted files under the paths given, or all
+the deleted files if no paths are given. The paths are the ones the
+files had before they were deleted and can be files or directories.
+
+Usage Example:
+
+    rclone backend list remote: [path...]
+
+Files are listed newest deletion first with the day they were deleted.
+`,
+	Opts: map[string]string{
+		"date": "Only list files deleted on this day, as YYYY-MM-DD",
+	},
+}, {
+	Name:  "restore",
+	Short: "Restore files from the trash.",
+	Long: `This command moves deleted files under the paths given back to
+where they were. If a file was deleted more than once the most recent
+deletion is restored unless the date option is given.
+
+Usage Examples:
+
+    rclone backend restore remote: path/to/file
+    rclone backend restore remote: path/to/dir -o date=2023-06-01
+
+Files which exist again are not overwritten but left in the trash.
+Use --dry-run to see what would be restored.
+`,
+	Opts: map[string]string{
+		"date": "Restore files deleted on this day, as YYYY-MM-DD",
+	},
+}, {
+	Name:  "empty",
+	Short: "Empty the trash.",
+	Long: `This command removes all the files from the trash, or only the ones
+deleted longer ago than max-age if given.
+
+Usage Example:
+
+    rclone backend empty remote: -o max-age=7d
+
+rclone cleanup does the same with the retention from the config. Use
+--dry-run to see what would be removed.
+`,
+	Opts: map[string]string{
+		"max-age": "Only remove files deleted longer ago than this",
+	},
+}}
+
+// trashedFile describes a deleted file in the trash
+type trashedFile struct {
+	obj  fs.Object // the object in the trash
+	date string    // the day it was deleted
+	when time.Time // when it was deleted if it isn't the first that day
+}
+
+// dates returns the days which have files in the trash, newest first
+func (f *Fs) dates(ctx context.Context) (dates []string, err error) {
+	entries, err := f.trash.Fs.List(ctx, "")
+	if err == fs.ErrorDirNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if _, ok := entry.(fs.Directory); !ok {
+			continue
+		}
+		if _, err := time.Parse(dateFormat, entry.Remote()); err == nil {
+			dates = append(dates, entry.Remote())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+	return dates, nil
+}
+
+// findTrash finds the deleted files under dir, or the file dir if
+// it isn't a directory, keyed by their original path and newest
+// first.
+//
+// If date is set only files deleted then are found.
+func (f *Fs) findTrash(ctx context.Context, dir, date string) (map[string][]trashedFile, error) {
+	dates, err := f.dates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string][]trashedFile{}
+	for _, d := range dates {
+		if date != "" && d != date {
+			continue
+		}
+		root := path.Join(d, f.trash.Prefix)
+		add := func(entries fs.DirEntries) error {
+			for _, entry := range entries {
+				o, ok := entry.(fs.Object)
+				if !ok {
+					continue
+				}
+				t, remote := unversion(o.Remote()[len(root)+1:])
+				found[remote] = append(found[remote], trashedFile{obj: o, date: d, when: t})
+			}
+			return nil
+		}
+		isDir := dir == ""
+		if !isDir {
+			// dir may be a file, possibly deleted more than once,
+			// so look for it in the parent first
+			parent := path.Dir(dir)
+			if parent == "." {
+				parent = ""
+			}
+			entries, err := f.trash.Fs.List(ctx, f.trashPath(d, parent))
+			if err == fs.ErrorDirNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			var matched fs.DirEntries
+			for _, entry := range entries {
+				if _, ok := entry.(fs.Directory); ok {
+					isDir = isDir || entry.Remote() == f.trashPath(d, dir)
+				} else if _, remote := unversion(entry.Remote()); remote == f.trashPath(d, dir) {
+					matched = append(matched, entry)
+				}
+			}
+			_ = add(matched)
+		}
+		var err error
+		if isDir {
+			err = walk.ListR(ctx, f.trash.Fs, f.trashPath(d, dir), true, -1, walk.ListObjects, add)
+		}
+		if err != nil && err != fs.ErrorDirNotFound {
+			return nil, err
+		}
+	}
+	for _, files := range found {
+		sort.Slice(files, func(i, j int) bool {
+			if files[i].date != files[j].date {
+				return files[i].date > files[j].date
+			}
+			return files[i].when.After(files[j].when)
+		})
+	}
+	return found, nil
+}
+
+// unversion removes the time added to the name of a file deleted
+// more than once on the same day
+func unversion(p string) (time.Time, string) {
+	dir, leaf := path.Split(p)
+	t, leaf := version.Remove(leaf)
+	return t, dir + leaf
+}
+
+// checkDate checks the date option if set
+func checkDate(opt map[string]string) (string, error) {
+	date := opt["date"]
+	if date != "" {
+		if _, err := time.Parse(dateFormat, date); err != nil {
+			return "", fmt.Errorf("bad date option %q - use YYYY-MM-DD", date)
+		}
+	}
+	return date, nil
+}
+
+// TrashItem describes a file in the trash for the list command
+type TrashItem struct {
+	Path    string    `json:"path"` // path of the file before it was deleted
+	Date    string    `json:"date"` // day it was deleted
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// listCommand lists the deleted files under the paths in arg
+func (f *Fs) listCommand(ctx context.Context, arg []string, opt map[string]string) (out []TrashItem, err error) {
+	date, err := checkDate(opt)
+	if err != nil {
+		return nil, err
+	}
+	if len(arg) == 0 {
+		arg = []string{""}
+	}
+	out = []TrashItem{}
+	for _, dir := range arg {
+		found, err := f.findTrash(ctx, hiddendir.CleanPath(dir), date)
+		if err != nil {
+			return nil, err
+		}
+		remotes := make([]string, 0, len(found))
+		for remote := range found {
+			remotes = append(remotes, remote)
+		}
+		sort.Strings(remotes)
+		for _, remote := range remotes {
+			for _, file := range found[remote] {
+				out = append(out, TrashItem{
+					Path:    remote,
+					Date:    file.date,
+					Size:    file.obj.Size(),
+					ModTime: file.obj.ModTime(ctx),
+				})
+			}
+		}
+	}
+	return out, nil
+}
+
+// RestoreStats reports the results of the restore command
+type RestoreStats struct {
+	Restored int `json:"restored"` // files moved back
+	Exists   int `json:"exists"`   // files left in the trash as they exist again
+	Errors   int `json:"errors"`   // files which could not be restored
+}
+
+// restoreCommand restores the deleted files under the paths in arg
+func (f *Fs) restoreCommand(ctx context.Context, arg []string, opt map[string]string) (stats RestoreStats, err error) {
+	date, err := checkDate(opt)
+	if err != nil {
+		return stats, err
+	}
+	if len(arg) == 0 {
+		arg = []string{""}
+	}
+	for _, p := range arg {
+		p = hiddendir.CleanPath(p)
+		found, err := f.findTrash(ctx, p, date)
+		if err != nil {
+			return stats, err
+		}
+		if len(found) == 0 {
+			return stats, fmt.Errorf("nothing found in the trash for %q", p)
+		}
+		for remote, files := range found {
+			if _, err := f.Fs.NewObject(ctx, remote); err == nil {
+				fs.Logf(remote, "Not restoring as it exists")
+				stats.Exists++
+				continue
+			}
+			_, err = operations.Move(ctx, f.Fs, nil, remote, files[0].obj)
+			if err != nil {
+				fs.Errorf(remote, "Failed to restore: %v", err)
+				stats.Errors++
+				continue
+			}
+			fs.Infof(remote, "Restored from the trash of %s", files[0].date)
+			stats.Restored++
+		}
+	}
+	if stats.Errors > 0 {
+		return stats, fmt.Errorf("failed to restore %d files", stats.Errors)
+	}
+	return stats, nil
+}
+
+// EmptyStats reports the results of emptying the trash
+type EmptyStats struct {
+	Kept    []string `json:"kept"`    // days kept in the trash
+	Removed []string `json:"removed"` // days removed from the trash
+}
+
+// emptyCommand empties the trash
+func (f *Fs) emptyCommand(ctx context.Context, opt map[string]string) (stats EmptyStats, err error) {
+	var maxAge time.Duration
+	if s, ok := opt["max-age"]; ok {
+		maxAge, err = fs.ParseDuration(s)
+		if err != nil {
+			return stats, fmt.Errorf("bad max-age option: %w", err)
+		}
+	}
+	return f.empty(ctx, maxAge)
+}
+
+// empty removes the days from the trash where all the files were
+// deleted longer ago than maxAge, or all of them if maxAge is 0
+func (f *Fs) empty(ctx context.Context, maxAge time.Duration) (stats EmptyStats, err error) {
+	dates, err := f.dates(ctx)
+	if err != nil {
+		return stats, err
+	}
+	stats.Kept, stats.Removed = []string{}, []string{}
+	now := time.Now()
+	for _, date := range dates {
+		day, _ := time.Parse(dateFormat, date)
+		if maxAge > 0 && now.Sub(day.AddDate(0, 0, 1)) < maxAge {
+			stats.Kept = append(stats.Kept, date)
+			continue
+		}
+		err = operations.Purge(ctx, f.trash.Fs, date)
+		if err != nil {
+			return stats, err
+		}
+		stats.Removed = append(stats.Removed, date)
+	}
+	return stats, nil
+}
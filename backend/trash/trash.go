@@ -0,0 +1,513 @@
+// Package trash implements an overlay backend which moves deleted
+// files to a trash directory
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/hiddendir"
+	"github.com/rclone/rclone/lib/version"
+)
+
+// dateFormat is the name of the dated directories in the trash
+const dateFormat = "2006-01-02"
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "trash",
+		Description: "Move deleted files to a trash directory on any remote",
+		NewFs:       NewFs,
+		MetadataInfo: &fs.MetadataInfo{
+			Help: `Any metadata supported by the underlying remote is read and written.`,
+		},
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name:     "remote",
+			Required: true,
+			Help: `Remote to keep a trash on (e.g. myRemote:path).
+
+Deleted files are moved to the directory set by trash_dir at the root
+of this remote.`,
+		}, {
+			Name:    "retention",
+			Default: fs.Duration(30 * 24 * time.Hour),
+			Help: `How long to keep deleted files in the trash (off = forever).
+
+Files are only removed from the trash by rclone cleanup and the empty
+command.`,
+		}, {
+			Name:     "trash_dir",
+			Default:  ".trash",
+			Advanced: true,
+			Help: `Name of the directory deleted files are moved to.
+
+This is relative to the root of the remote and hidden from listings.`,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote    string      `config:"remote"`
+	Retention fs.Duration `config:"retention"`
+	TrashDir  string      `config:"trash_dir"`
+}
+
+// Fs represents a wrapped fs.Fs
+type Fs struct {
+	fs.Fs
+	name     string
+	root     string
+	wrapper  fs.Fs
+	features *fs.Features
+	opt      *Options
+	trash    *hiddendir.Dir // the trash directory at the root of the remote
+}
+
+// NewFs constructs an Fs from the remote:path string
+func NewFs(ctx context.Context, fsname, rpath string, cmap configmap.Mapper) (fs.Fs, error) {
+	opt := &Options{}
+	err := configstruct.Set(cmap, opt)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(opt.Remote, fsname+":") {
+		return nil, errors.New("can't point remote at itself")
+	}
+	opt.TrashDir = strings.Trim(opt.TrashDir, "/")
+	if opt.TrashDir == "" {
+		return nil, errors.New("trash_dir can't be empty")
+	}
+
+	baseFs, trash, err := hiddendir.New(ctx, opt.Remote, rpath, opt.TrashDir)
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, err
+	}
+
+	f := &Fs{
+		Fs:    baseFs,
+		name:  fsname,
+		root:  rpath,
+		opt:   opt,
+		trash: trash,
+	}
+	f.features = hiddendir.Features(ctx, f, f.Fs)
+	// Purge moves the directory to the trash so needs DirMove
+	f.features.Purge = nil
+	if f.trash.Fs.Features().DirMove != nil {
+		f.features.Purge = f.Purge
+	}
+	// Emptying the trash doesn't need the remote to support cleanup
+	f.features.CleanUp = f.CleanUp
+
+	cache.PinUntilFinalized(f.Fs, f)
+	return f, err
+}
+
+//
+// Filesystem
+//
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string { return f.name }
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string { return f.root }
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features { return f.features }
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("trash root '%s'", f.root)
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs { return f.Fs }
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs { return f.wrapper }
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) { f.wrapper = wrapper }
+
+// trashPath returns the path in the trash of remote deleted on date
+func (f *Fs) trashPath(date, remote string) string {
+	return path.Join(date, f.trash.Prefix, remote)
+}
+
+// newTrashPath returns a free path in the trash for remote deleted
+// at time now.
+//
+// If remote was deleted before on the same day the time is added to
+// the name.
+func (f *Fs) newTrashPath(ctx context.Context, remote string, now time.Time) (string, error) {
+	now = now.UTC()
+	dir, leaf := path.Split(f.trashPath(now.Format(dateFormat), remote))
+	name := dir + leaf
+	for t := now; ; t = t.Add(time.Millisecond) {
+		_, err := f.trash.Fs.NewObject(ctx, name)
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			return name, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to find a name in the trash for %q: %w", remote, err)
+		}
+		name = dir + version.Add(leaf, t)
+	}
+}
+
+// Wrap base entries into trash entries, dropping the trash directory.
+func (f *Fs) wrapEntries(baseEntries fs.DirEntries) fs.DirEntries {
+	return f.trash.Filter(baseEntries, func(o fs.Object) fs.Object { return f.newObject(o) })
+}
+
+// List the objects and directories in dir into entries.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	if entries, err = f.Fs.List(ctx, dir); err != nil {
+		return nil, err
+	}
+	return f.wrapEntries(entries), nil
+}
+
+// ListR lists the objects and directories recursively into out.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (err error) {
+	return f.Fs.Features().ListR(ctx, dir, func(baseEntries fs.DirEntries) error {
+		return callback(f.wrapEntries(baseEntries))
+	})
+}
+
+// NewObject finds the Object at remote.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	if f.trash.IsHidden(remote) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	o, err := f.Fs.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o, err := f.Fs.Put(ctx, in, src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// PutStream uploads to the remote path with undeterminate size.
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if do := f.Fs.Features().PutStream; do != nil {
+		o, err := do(ctx, in, src, options...)
+		if err != nil {
+			return nil, err
+		}
+		return f.newObject(o), nil
+	}
+	return nil, errors.New("PutStream not supported")
+}
+
+// PutUnchecked uploads the object, allowing duplicates.
+func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if do := f.Fs.Features().PutUnchecked; do != nil {
+		o, err := do(ctx, in, src, options...)
+		if err != nil {
+			return nil, err
+		}
+		return f.newObject(o), nil
+	}
+	return nil, errors.New("PutUnchecked not supported")
+}
+
+// Rmdir removes the directory, recording it in the trash
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	if err := f.Fs.Rmdir(ctx, dir); err != nil {
+		return err
+	}
+	if f.trash.Path(dir) == "" {
+		return nil
+	}
+	// Keep the empty directory so it can be restored
+	return f.trash.Fs.Mkdir(ctx, f.trashPath(time.Now().UTC().Format(dateFormat), dir))
+}
+
+// Purge moves the directory to the trash
+//
+// If the directory can't be moved in one go this returns
+// fs.ErrorCantPurge so the files are moved one at a time.
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	if f.trash.Path(dir) == "" {
+		// the trash can't be moved into itself
+		return fs.ErrorCantPurge
+	}
+	if _, err := f.Fs.List(ctx, dir); err != nil {
+		return err
+	}
+	dst := f.trashPath(time.Now().UTC().Format(dateFormat), dir)
+	err := f.trash.Fs.Features().DirMove(ctx, f.Fs, dir, dst)
+	if err == fs.ErrorDirExists || err == fs.ErrorCantDirMove {
+		return fs.ErrorCantPurge
+	}
+	return err
+}
+
+// CleanUp removes files from the trash which are older than
+// retention then cleans up the remote if it supports it.
+func (f *Fs) CleanUp(ctx context.Context) error {
+	if f.opt.Retention != fs.DurationOff {
+		if _, err := f.empty(ctx, time.Duration(f.opt.Retention)); err != nil {
+			return err
+		}
+	}
+	if do := f.Fs.Features().CleanUp; do != nil {
+		return do(ctx)
+	}
+	return nil
+}
+
+// About gets quota information from the Fs
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	if do := f.Fs.Features().About; do != nil {
+		return do(ctx)
+	}
+	return nil, errors.New("not supported by underlying remote")
+}
+
+// ChangeNotify calls the passed function with a path that has had changes.
+func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType), pollIntervalChan <-chan time.Duration) {
+	if do := f.Fs.Features().ChangeNotify; do != nil {
+		do(ctx, func(remote string, entryType fs.EntryType) {
+			if !f.trash.IsHidden(remote) {
+				notifyFunc(remote, entryType)
+			}
+		}, pollIntervalChan)
+	}
+}
+
+// UserInfo returns info about the connected user
+func (f *Fs) UserInfo(ctx context.Context) (map[string]string, error) {
+	if do := f.Fs.Features().UserInfo; do != nil {
+		return do(ctx)
+	}
+	return nil, fs.ErrorNotImplemented
+}
+
+// Disconnect the current user
+func (f *Fs) Disconnect(ctx context.Context) error {
+	if do := f.Fs.Features().Disconnect; do != nil {
+		return do(ctx)
+	}
+	return fs.ErrorNotImplemented
+}
+
+// MergeDirs merges the contents of all the directories passed
+// in into the first one and rmdirs the other directories.
+func (f *Fs) MergeDirs(ctx context.Context, dirs []fs.Directory) error {
+	if do := f.Fs.Features().MergeDirs; do != nil {
+		return do(ctx, dirs)
+	}
+	return errors.New("MergeDirs not supported")
+}
+
+// DirCacheFlush resets the directory cache - used in testing
+// as an optional interface
+func (f *Fs) DirCacheFlush() {
+	if do := f.Fs.Features().DirCacheFlush; do != nil {
+		do()
+	}
+	if do := f.trash.Fs.Features().DirCacheFlush; do != nil {
+		do()
+	}
+}
+
+// PublicLink generates a public link to the remote path (usually readable by anyone)
+func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration, unlink bool) (string, error) {
+	if do := f.Fs.Features().PublicLink; do != nil {
+		return do(ctx, remote, expire, unlink)
+	}
+	return "", errors.New("PublicLink not supported")
+}
+
+// Copy src to this remote using server-side copy operations.
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Copy
+	if do == nil {
+		return nil, fs.ErrorCantCopy
+	}
+	o, ok := src.(*Object)
+	if !ok {
+		return nil, fs.ErrorCantCopy
+	}
+	oResult, err := do(ctx, o.Object, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(oResult), nil
+}
+
+// Move src to this remote using server-side move operations.
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Move
+	if do == nil {
+		return nil, fs.ErrorCantMove
+	}
+	o, ok := src.(*Object)
+	if !ok {
+		return nil, fs.ErrorCantMove
+	}
+	oResult, err := do(ctx, o.Object, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(oResult), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote using server-side move operations.
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	do := f.Fs.Features().DirMove
+	if do == nil {
+		return fs.ErrorCantDirMove
+	}
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		return fs.ErrorCantDirMove
+	}
+	return do(ctx, srcFs.Fs, srcRemote, dstRemote)
+}
+
+// Shutdown the backend, closing any background tasks and any cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	f.trash.Close()
+	if do := f.Fs.Features().Shutdown; do != nil {
+		return do(ctx)
+	}
+	return nil
+}
+
+//
+// Object
+//
+
+// Object represents a file on the wrapped remote
+type Object struct {
+	fs.Object
+	f *Fs
+}
+
+// newObject wraps an object from the base remote
+func (f *Fs) newObject(o fs.Object) *Object {
+	return &Object{Object: o, f: f}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info { return o.f }
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object { return o.Object }
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.Object.String()
+}
+
+// Remove an object by moving it to the trash
+func (o *Object) Remove(ctx context.Context) error {
+	name, err := o.f.newTrashPath(ctx, o.Remote(), time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = operations.Move(ctx, o.f.trash.Fs, nil, name, o.Object)
+	if err != nil {
+		return fmt.Errorf("failed to move to trash: %w", err)
+	}
+	fs.Debugf(o, "Moved to trash as %q", name)
+	return nil
+}
+
+// ID returns the ID of the Object if possible
+func (o *Object) ID() string {
+	if doer, ok := o.Object.(fs.IDer); ok {
+		return doer.ID()
+	}
+	return ""
+}
+
+// GetTier returns the Tier of the Object if possible
+func (o *Object) GetTier() string {
+	if doer, ok := o.Object.(fs.GetTierer); ok {
+		return doer.GetTier()
+	}
+	return ""
+}
+
+// SetTier set the Tier of the Object if possible
+func (o *Object) SetTier(tier string) error {
+	if doer, ok := o.Object.(fs.SetTierer); ok {
+		return doer.SetTier(tier)
+	}
+	return errors.New("SetTier not supported")
+}
+
+// MimeType of an Object if known, "" otherwise
+func (o *Object) MimeType(ctx context.Context) string {
+	if doer, ok := o.Object.(fs.MimeTyper); ok {
+		return doer.MimeType(ctx)
+	}
+	return ""
+}
+
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	do, ok := o.Object.(fs.Metadataer)
+	if !ok {
+		return nil, nil
+	}
+	return do.Metadata(ctx)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.PutUncheckeder  = (*Fs)(nil)
+	_ fs.PutStreamer     = (*Fs)(nil)
+	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.UnWrapper       = (*Fs)(nil)
+	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.Wrapper         = (*Fs)(nil)
+	_ fs.MergeDirser     = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ fs.ChangeNotifier  = (*Fs)(nil)
+	_ fs.PublicLinker    = (*Fs)(nil)
+	_ fs.UserInfoer      = (*Fs)(nil)
+	_ fs.Disconnecter    = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.FullObject      = (*Object)(nil)
+)
@@ -0,0 +1,134 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/rclone/rclone/lib/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	fsys, err := fs.NewFs(ctx, ":trash,remote='"+tempDir+"':")
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+	defer func() {
+		assert.NoError(t, f.Shutdown(ctx))
+	}()
+	mtime := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := fstest.NewItem("file.txt", "one", mtime)
+	file2 := fstest.NewItem("file.txt", "two", mtime)
+	fileA := fstest.NewItem("dir/a", "a", mtime)
+	fileB := fstest.NewItem("dir/sub/b", "b", mtime)
+	today := time.Now().UTC().Format(dateFormat)
+
+	// Deleting moves files to the trash, which is hidden
+	require.NoError(t, fstests.PutTestContents(ctx, t, f, &file1, "one", true).Remove(ctx))
+	require.NoError(t, fstests.PutTestContents(ctx, t, f, &file2, "two", true).Remove(ctx))
+	fstests.PutTestContents(ctx, t, f, &fileA, "a", true)
+	fstests.PutTestContents(ctx, t, f, &fileB, "b", true)
+	require.NoError(t, operations.Purge(ctx, f, "dir"))
+	require.NoError(t, f.Mkdir(ctx, "empty"))
+	require.NoError(t, f.Rmdir(ctx, "empty"))
+	fstest.CheckListingWithPrecision(t, f, nil, nil, fs.ModTimeNotSupported)
+
+	items, err := f.listCommand(ctx, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, items, 4)
+	assert.Equal(t, TrashItem{Path: "dir/a", Date: today, Size: 1, ModTime: items[0].ModTime}, items[0])
+	assert.Equal(t, "dir/sub/b", items[1].Path)
+	assert.Equal(t, "file.txt", items[2].Path)
+	assert.Equal(t, int64(3), items[2].Size)
+	assert.Equal(t, "file.txt", items[3].Path)
+	items, err = f.listCommand(ctx, []string{"dir/sub"}, map[string]string{"date": today})
+	require.NoError(t, err)
+	assert.Len(t, items, 1)
+	_, err = f.listCommand(ctx, nil, map[string]string{"date": "yesterday"})
+	assert.Error(t, err)
+	// the trash keeps the files and directories under the day they
+	// were deleted
+	var trashed []string
+	err = walk.ListR(ctx, f.trash.Fs, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		entries.ForObject(func(o fs.Object) {
+			_, leaf := version.Remove(path.Base(o.Remote()))
+			trashed = append(trashed, path.Join(path.Dir(o.Remote()), leaf))
+		})
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(trashed)
+	assert.Equal(t, []string{today + "/dir/a", today + "/dir/sub/b", today + "/file.txt", today + "/file.txt"}, trashed)
+	_, err = f.trash.Fs.List(ctx, today+"/empty")
+	assert.NoError(t, err)
+
+	// Restore puts back the last deletion of a file
+	stats, err := f.restoreCommand(ctx, []string{"file.txt", "dir"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, RestoreStats{Restored: 3}, stats)
+	fstest.CheckListingWithPrecision(t, f, []fstest.Item{fileA, fileB, file2}, []string{"dir", "dir/sub"}, fs.ModTimeNotSupported)
+
+	// but doesn't overwrite files which exist
+	stats, err = f.restoreCommand(ctx, []string{"file.txt"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, RestoreStats{Exists: 1}, stats)
+	_, err = f.restoreCommand(ctx, []string{"missing"}, nil)
+	assert.Error(t, err)
+
+	// Cleanup only removes files older than the retention
+	require.NoError(t, f.CleanUp(ctx))
+	items, err = f.listCommand(ctx, nil, nil)
+	require.NoError(t, err)
+	assert.Len(t, items, 1)
+	emptied, err := f.emptyCommand(ctx, map[string]string{"max-age": "1d"})
+	require.NoError(t, err)
+	assert.Equal(t, EmptyStats{Kept: []string{today}, Removed: []string{}}, emptied)
+	emptied, err = f.emptyCommand(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, EmptyStats{Kept: []string{}, Removed: []string{today}}, emptied)
+	items, err = f.listCommand(ctx, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+// flakyFs is an fs.Fs whose NewObject fails with a transient error
+type flakyFs struct {
+	fs.Fs
+}
+
+// NewObject fails as if the network was down
+func (f flakyFs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	return nil, errors.New("transient error")
+}
+
+func TestRemoveError(t *testing.T) {
+	ctx := context.Background()
+	fsys, err := fs.NewFs(ctx, ":trash,remote='"+t.TempDir()+"':")
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+	defer func() {
+		assert.NoError(t, f.Shutdown(ctx))
+	}()
+	item := fstest.NewItem("file.txt", "one", fstest.Time("2001-02-03T04:05:06.499999999Z"))
+	o := fstests.PutTestContents(ctx, t, f, &item, "one", true)
+
+	// the file is kept if a free name in the trash can't be found
+	tfs := f.trash.Fs
+	f.trash.Fs = flakyFs{Fs: tfs}
+	assert.ErrorContains(t, o.Remove(ctx), "transient error")
+	f.trash.Fs = tfs
+	fstest.CheckListingWithPrecision(t, f, []fstest.Item{item}, nil, fs.ModTimeNotSupported)
+	fstest.CheckListingWithPrecision(t, f.trash.Fs, nil, nil, fs.ModTimeNotSupported)
+}
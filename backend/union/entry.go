@@ -17,8 +17,10 @@ import (
 // This is a wrapped object which returns the Union Fs as its parent
 type Object struct {
 	*upstream.Object
-	fs *Fs // what this object is part of
-	co []upstream.Entry
+	fs       *Fs        // what this object is part of
+	healOnce sync.Once  // heal_on_read is only done once per object
+	mu       sync.Mutex // protects co
+	co       []upstream.Entry
 }
 
 // Directory describes a union Directory
@@ -45,9 +47,18 @@ func (o *Object) Fs() fs.Info {
 }
 
 func (o *Object) candidates() []upstream.Entry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	return o.co
 }
 
+// addCandidate adds e to the candidates of the object
+func (o *Object) addCandidate(e upstream.Entry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.co = append(o.co[:len(o.co):len(o.co)], e)
+}
+
 func (d *Directory) candidates() []upstream.Entry {
 	return d.cd
 }
@@ -67,7 +78,10 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 			return err
 		}
 		// Update current object
-		*o = *newO.(*Object)
+		newObj := newO.(*Object)
+		o.mu.Lock()
+		o.Object, o.co = newObj.Object, newObj.co
+		o.mu.Unlock()
 		return nil
 	} else if err != nil {
 		return err
@@ -97,6 +111,52 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	return errs.Err()
 }
 
+// Open the object for reading
+//
+// If the chosen candidate can't be opened, other candidates holding a
+// copy of the same file are tried in turn.
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	if o.fs.opt.HealOnRead {
+		o.healOnce.Do(func() {
+			o.fs.healInBackground(ctx, o)
+		})
+	}
+	in, err := o.Object.Open(ctx, options...)
+	if err == nil || ctx.Err() != nil {
+		return in, err
+	}
+	for _, c := range o.replicas(ctx) {
+		in, cErr := c.Open(ctx, options...)
+		if cErr == nil {
+			fs.Infof(o, "Reading from %s after: %v", c.UpstreamFs().Name(), err)
+			return in, nil
+		}
+		fs.Debugf(o, "Failed to open copy on %s: %v", c.UpstreamFs().Name(), cErr)
+	}
+	return nil, err
+}
+
+// replicas returns the other candidates which look like copies of
+// the chosen object, having the same size and modification time
+func (o *Object) replicas(ctx context.Context) (replicas []*upstream.Object) {
+	modTime := o.ModTime(ctx)
+	precision := o.fs.Precision()
+	for _, e := range o.candidates() {
+		c, ok := e.(*upstream.Object)
+		if !ok || c == o.Object || c.Size() != o.Size() {
+			continue
+		}
+		dt := c.ModTime(ctx).Sub(modTime)
+		if dt < 0 {
+			dt = -dt
+		}
+		if dt <= precision {
+			replicas = append(replicas, c)
+		}
+	}
+	return replicas
+}
+
 // Remove candidate objects selected by ACTION policy
 func (o *Object) Remove(ctx context.Context) error {
 	entries, err := o.fs.actionEntries(o.candidates()...)
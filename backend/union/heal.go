@@ -0,0 +1,175 @@
+package union
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rclone/rclone/backend/union/upstream"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// errNoUpstreamsLeft is returned when a file can't be healed as all
+// the upstreams it could be copied to have a copy already
+var errNoUpstreamsLeft = errors.New("no upstreams left to copy to")
+
+// HealStats reports the results of the heal command
+type HealStats struct {
+	Checked int `json:"checked"` // files checked
+	Healed  int `json:"healed"`  // files which had copies restored
+	Copies  int `json:"copies"`  // copies made
+	Errors  int `json:"errors"`  // files which could not be healed
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "heal",
+	Short: "Restore missing copies of files.",
+	Long: `This command checks that every file under the path has as many
+copies as set by the copies option and copies files with missing
+copies to the upstreams with the most free space.
+
+Usage Example:
+
+    rclone backend heal union:path/to/dir
+
+Only missing copies are restored. Copies with a different size or
+modification time are reported but left alone.
+
+It returns a summary of the files checked and healed. Use --dry-run
+to see what would be copied.
+`,
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "heal":
+		return f.heal(ctx, "")
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// heal restores missing copies of all the files under dir
+func (f *Fs) heal(ctx context.Context, dir string) (stats HealStats, err error) {
+	var mu sync.Mutex
+	err = walk.ListR(ctx, f, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(*Object)
+			if !ok {
+				continue
+			}
+			n, err := f.healObject(ctx, o)
+			mu.Lock()
+			stats.Checked++
+			if err != nil {
+				fs.Errorf(o, "Failed to heal: %v", err)
+				stats.Errors++
+			}
+			if n > 0 {
+				stats.Healed++
+				stats.Copies += n
+			}
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	if stats.Errors > 0 {
+		return stats, fmt.Errorf("failed to heal %d files", stats.Errors)
+	}
+	return stats, nil
+}
+
+// healObject copies o to more upstreams until it has as many copies
+// as set by the copies option. It returns the number of copies made.
+func (f *Fs) healObject(ctx context.Context, o *Object) (n int, err error) {
+	have := map[*upstream.Fs]bool{}
+	for _, e := range o.candidates() {
+		have[e.UpstreamFs()] = true
+	}
+	replicas := o.replicas(ctx)
+	if len(have) > len(replicas)+1 {
+		fs.Logf(o, "Some copies differ in size or modification time")
+	}
+	need := f.opt.Copies - len(have)
+	if need <= 0 {
+		return 0, nil
+	}
+	var targets []*upstream.Fs
+	for _, u := range f.upstreams {
+		if u.IsCreatable() && !have[u] {
+			targets = append(targets, u)
+		}
+	}
+	if len(targets) == 0 {
+		return 0, errNoUpstreamsLeft
+	}
+	space := make(map[*upstream.Fs]int64, len(targets))
+	for _, u := range targets {
+		space[u], _ = u.GetFreeSpace()
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		return space[targets[i]] > space[targets[j]]
+	})
+	if need < len(targets) {
+		targets = targets[:need]
+	}
+	src := o.UnWrapUpstream().UnWrap()
+	for _, u := range targets {
+		dst, err := operations.Copy(ctx, u, nil, o.Remote(), src)
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", u.Name(), err)
+		}
+		if dst == nil {
+			continue // dry run
+		}
+		fs.Infof(o, "Restored copy on %s", u.Name())
+		o.addCandidate(u.WrapObject(dst))
+		n++
+	}
+	return n, nil
+}
+
+// healInBackground heals o for heal_on_read without holding up the
+// read. Only one heal of each file is run at once.
+func (f *Fs) healInBackground(ctx context.Context, o *Object) {
+	remote := o.Remote()
+	f.healMu.Lock()
+	defer f.healMu.Unlock()
+	if f.healing[remote] {
+		return
+	}
+	f.healing[remote] = true
+	f.healWG.Add(1)
+	go func() {
+		defer f.healWG.Done()
+		// The read may finish before the heal so don't cancel it
+		// with the context of the read
+		_, err := f.healObject(fs.CopyConfig(context.Background(), ctx), o)
+		if err == errNoUpstreamsLeft {
+			f.noUpstreamsOnce.Do(func() {
+				fs.Logf(f, "Can't heal files on read as there are not enough upstreams to hold %d copies", f.opt.Copies)
+			})
+		} else if err != nil {
+			fs.Errorf(o, "Failed to heal: %v", err)
+		}
+		f.healMu.Lock()
+		delete(f.healing, remote)
+		f.healMu.Unlock()
+	}()
+}
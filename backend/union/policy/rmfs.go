@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"context"
+	"sort"
+
+	"github.com/rclone/rclone/backend/union/upstream"
+	"github.com/rclone/rclone/fs"
+)
+
+func init() {
+	registerPolicy("rmfs", &RMfs{})
+}
+
+// RMfs stands for replicated, most free space
+// Search category: same as epff.
+// Action category: same as epall.
+// Create category: Pick the number of upstreams set by copies with the most free space.
+type RMfs struct {
+	EpAll
+}
+
+// copies returns the number of copies to create
+func copies(u *upstream.Fs) int {
+	if u.Opt.Copies < 1 {
+		return 1
+	}
+	return u.Opt.Copies
+}
+
+// freeSpace returns the free space of upstreams treating unknown as infinite
+func freeSpace(upstreams []*upstream.Fs) []int64 {
+	space := make([]int64, len(upstreams))
+	for i, u := range upstreams {
+		var err error
+		space[i], err = u.GetFreeSpace()
+		if err != nil {
+			fs.LogPrintf(fs.LogLevelNotice, nil,
+				"Free Space is not supported for upstream %s, treating as infinite", u.Name())
+		}
+	}
+	return space
+}
+
+// rmfs sorts the upstreams by free space, most first, and returns
+// as many as there should be copies
+func (p *RMfs) rmfs(upstreams []*upstream.Fs) []*upstream.Fs {
+	space := freeSpace(upstreams)
+	index := make([]int, len(upstreams))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(i, j int) bool {
+		return space[index[i]] > space[index[j]]
+	})
+	n := copies(upstreams[0])
+	if n > len(upstreams) {
+		n = len(upstreams)
+	}
+	chosen := make([]*upstream.Fs, n)
+	for i := range chosen {
+		chosen[i] = upstreams[index[i]]
+	}
+	return chosen
+}
+
+// Create category policy, governing the creation of files and directories
+func (p *RMfs) Create(ctx context.Context, upstreams []*upstream.Fs, path string) ([]*upstream.Fs, error) {
+	if len(upstreams) == 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	upstreams = filterNC(upstreams)
+	if len(upstreams) == 0 {
+		return nil, fs.ErrorPermissionDenied
+	}
+	return p.rmfs(upstreams), nil
+}
+
+// CreateEntries is CREATE category policy but receiving a set of candidate entries
+func (p *RMfs) CreateEntries(entries ...upstream.Entry) ([]upstream.Entry, error) {
+	if len(entries) == 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	entries = filterNCEntries(entries)
+	if len(entries) == 0 {
+		return nil, fs.ErrorPermissionDenied
+	}
+	upstreams := make([]*upstream.Fs, len(entries))
+	for i, e := range entries {
+		upstreams[i] = e.UpstreamFs()
+	}
+	var chosen []upstream.Entry
+	for _, u := range p.rmfs(upstreams) {
+		for _, e := range entries {
+			if e.UpstreamFs() == u {
+				chosen = append(chosen, e)
+				break
+			}
+		}
+	}
+	return chosen, nil
+}
@@ -0,0 +1,57 @@
+package policy
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/rclone/rclone/backend/union/upstream"
+	"github.com/rclone/rclone/fs"
+)
+
+func init() {
+	registerPolicy("rr", &RR{})
+}
+
+// RR stands for round robin
+// Action category: same as all.
+// Create category: same as all.
+// Search category: Calls epall and then takes turns between the candidates. Returns one candidate.
+//
+// Only searches take turns so that actions and creates still apply to
+// every copy of a replicated file.
+type RR struct {
+	All
+	next uint32
+}
+
+func (p *RR) turn(n int) int {
+	return int((atomic.AddUint32(&p.next, 1) - 1) % uint32(n))
+}
+
+func (p *RR) rr(upstreams []*upstream.Fs) *upstream.Fs {
+	return upstreams[p.turn(len(upstreams))]
+}
+
+func (p *RR) rrEntries(entries []upstream.Entry) upstream.Entry {
+	return entries[p.turn(len(entries))]
+}
+
+// Search category policy, governing the access to files and directories
+func (p *RR) Search(ctx context.Context, upstreams []*upstream.Fs, path string) (*upstream.Fs, error) {
+	if len(upstreams) == 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	upstreams, err := p.epall(ctx, upstreams, path)
+	if err != nil {
+		return nil, err
+	}
+	return p.rr(upstreams), nil
+}
+
+// SearchEntries is SEARCH category policy but receiving a set of candidate entries
+func (p *RR) SearchEntries(entries ...upstream.Entry) (upstream.Entry, error) {
+	if len(entries) == 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return p.rrEntries(entries), nil
+}
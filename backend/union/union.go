@@ -61,7 +61,26 @@ If a remote has less than this much free space then it won't be
 considered for use in lfs or eplfs policies.`,
 			Advanced: true,
 			Default:  fs.Gibi,
+		}, {
+			Name: "copies",
+			Help: `Number of copies of each file to keep.
+
+Used by the rmfs create policy to choose how many upstreams a new
+file is written to, and by the heal command and heal_on_read to
+restore missing copies.`,
+			Advanced: true,
+			Default:  2,
+		}, {
+			Name: "heal_on_read",
+			Help: `Restore missing copies of files when they are opened.
+
+If a file opened for reading has fewer copies than set by copies,
+it is copied in the background to the upstreams with the most free
+space first.`,
+			Advanced: true,
+			Default:  false,
 		}},
+		CommandHelp: commandHelp,
 	}
 	fs.Register(fsi)
 }
@@ -77,6 +96,11 @@ type Fs struct {
 	actionPolicy policy.Policy  // policy for ACTION
 	createPolicy policy.Policy  // policy for CREATE
 	searchPolicy policy.Policy  // policy for SEARCH
+
+	healMu          sync.Mutex      // protects healing
+	healing         map[string]bool // files being healed by heal_on_read
+	healWG          sync.WaitGroup  // wait for heal_on_read to finish
+	noUpstreamsOnce sync.Once       // only log that heal_on_read can't work once
 }
 
 // Wrap candidate objects in to a union Object
@@ -788,6 +812,7 @@ func (f *Fs) mergeDirEntries(entriesList [][]upstream.Entry) (fs.DirEntries, err
 // Shutdown the backend, closing any background tasks and any
 // cached connections.
 func (f *Fs) Shutdown(ctx context.Context) error {
+	f.healWG.Wait()
 	errs := Errors(make([]error, len(f.upstreams)))
 	multithread(len(f.upstreams), func(i int) {
 		u := f.upstreams[i]
@@ -842,6 +867,9 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	if len(opt.Upstreams) == 1 {
 		return nil, errors.New("union can't point to a single upstream - check the value of the upstreams setting")
 	}
+	if opt.Copies < 1 {
+		return nil, errors.New("union must keep at least one copy - check the value of the copies setting")
+	}
 	for _, u := range opt.Upstreams {
 		if strings.HasPrefix(u, name+":") {
 			return nil, errors.New("can't point union remote at itself - check the value of the upstreams setting")
@@ -876,6 +904,7 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 		root:      root,
 		opt:       *opt,
 		upstreams: usedUpstreams,
+		healing:   make(map[string]bool),
 	}
 	f.actionPolicy, err = policy.Get(opt.ActionPolicy)
 	if err != nil {
@@ -982,4 +1011,5 @@ var (
 	_ fs.ListRer         = (*Fs)(nil)
 	_ fs.Shutdowner      = (*Fs)(nil)
 	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
 )
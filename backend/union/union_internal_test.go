@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"testing"
 	"time"
 
@@ -156,3 +157,114 @@ func TestMoveCopy(t *testing.T) {
 		})
 	})
 }
+
+// countCopies returns the upstreams holding remote
+func countCopies(ctx context.Context, t *testing.T, f *Fs, remote string) (n int) {
+	for _, u := range f.upstreams {
+		_, err := u.NewObject(ctx, remote)
+		if err == nil {
+			n++
+		} else {
+			require.ErrorIs(t, err, fs.ErrorObjectNotFound)
+		}
+	}
+	return n
+}
+
+func readObject(ctx context.Context, t *testing.T, o fs.Object) string {
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+// This tests the rmfs create policy keeps the configured number of
+// copies which are restored by heal and read with failover
+func TestReplication(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	ctx := context.Background()
+	dirs := MakeTestDirs(t, 3)
+	upstreams := dirs[0] + " " + dirs[1] + " " + dirs[2]
+	fsString := fmt.Sprintf(":union,upstreams='%s',create_policy=rmfs,search_policy=rr,copies=2:", upstreams)
+	fsys, err := fs.NewFs(ctx, fsString)
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+
+	contents := random.String(100)
+	item := fstest.NewItem("dir/file.txt", contents, time.Now())
+	_ = fstests.PutTestContents(ctx, t, f, &item, contents, true)
+	assert.Equal(t, 2, countCopies(ctx, t, f, item.Path))
+
+	// Reads fail over to the other copy
+	o, err := f.NewObject(ctx, item.Path)
+	require.NoError(t, err)
+	require.NoError(t, o.(*Object).UnWrapUpstream().UnWrap().Remove(ctx))
+	assert.Equal(t, contents, readObject(ctx, t, o))
+	assert.Equal(t, 1, countCopies(ctx, t, f, item.Path))
+
+	// Heal restores the missing copy
+	stats, err := f.heal(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, HealStats{Checked: 1, Healed: 1, Copies: 1}, stats)
+	assert.Equal(t, 2, countCopies(ctx, t, f, item.Path))
+	stats, err = f.heal(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, HealStats{Checked: 1}, stats)
+
+	// Opening the file heals it if heal_on_read is set
+	o, err = f.NewObject(ctx, item.Path)
+	require.NoError(t, err)
+	require.NoError(t, o.(*Object).UnWrapUpstream().UnWrap().Remove(ctx))
+	fsys, err = fs.NewFs(ctx, fsString[:len(fsString)-1]+",heal_on_read=true:")
+	require.NoError(t, err)
+	f = fsys.(*Fs)
+	o, err = f.NewObject(ctx, item.Path)
+	require.NoError(t, err)
+	assert.Equal(t, contents, readObject(ctx, t, o))
+	f.healWG.Wait()
+	assert.Equal(t, 2, countCopies(ctx, t, f, item.Path))
+	assert.Len(t, o.(*Object).candidates(), 2)
+
+	// Each object is only healed once
+	require.NoError(t, o.(*Object).UnWrapUpstream().UnWrap().Remove(ctx))
+	assert.Equal(t, contents, readObject(ctx, t, o))
+	f.healWG.Wait()
+	assert.Equal(t, 1, countCopies(ctx, t, f, item.Path))
+	o, err = f.NewObject(ctx, item.Path)
+	require.NoError(t, err)
+	assert.Equal(t, contents, readObject(ctx, t, o))
+	f.healWG.Wait()
+	assert.Equal(t, 2, countCopies(ctx, t, f, item.Path))
+
+	// Removing the file removes all the copies
+	require.NoError(t, o.Remove(ctx))
+	assert.Equal(t, 0, countCopies(ctx, t, f, item.Path))
+}
+
+func TestRoundRobinSearchOnly(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	ctx := context.Background()
+	dirs := MakeTestDirs(t, 3)
+	upstreams := dirs[0] + " " + dirs[1] + " " + dirs[2]
+	fsys, err := fs.NewFs(ctx, fmt.Sprintf(":union,upstreams='%s',action_policy=rr,create_policy=rr,search_policy=rr:", upstreams))
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+
+	// rr only takes turns for searches so creates write every copy
+	contents := random.String(100)
+	item := fstest.NewItem("file.txt", contents, time.Now())
+	_ = fstests.PutTestContents(ctx, t, f, &item, contents, true)
+	assert.Equal(t, 3, countCopies(ctx, t, f, item.Path))
+
+	// and actions change all of them
+	o, err := f.NewObject(ctx, item.Path)
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	assert.Equal(t, 0, countCopies(ctx, t, f, item.Path))
+}
@@ -0,0 +1,344 @@
+package versioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/hiddendir"
+	"github.com/rclone/rclone/lib/version"
+)
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "list-versions":
+		return f.listVersionsCommand(ctx, arg)
+	case "restore":
+		return f.restoreCommand(ctx, arg, opt)
+	case "prune":
+		return f.pruneCommand(ctx, opt)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "list-versions",
+	Short: "List the old versions of files.",
+	Long: `This command lists the old versions of the files under the paths
+given, or of all the files if no paths are given. The paths can be
+files or directories.
+
+Usage Example:
+
+    rclone backend list-versions remote: [path...]
+
+Old versions are listed newest first with the time they were replaced
+or deleted.
+`,
+}, {
+	Name:  "restore",
+	Short: "Restore old versions of files.",
+	Long: `This command restores old versions of the files under the paths
+given, or of all the files if no paths are given.
+
+By default the newest old version of each file is restored, undoing
+the last change to it. Use the "at" option to restore the files as
+they were at a point in time. A path can also name an old version as
+shown by list-versions, to restore exactly that version.
+
+Usage Examples:
+
+    rclone backend restore remote: path/to/file
+    rclone backend restore remote: path/to/file-v2023-06-01-120000-000
+    rclone backend restore remote: path/to/dir -o at=2023-06-01T12:00:00Z
+    rclone backend restore remote: -o at=1d
+
+The current contents of restored files are kept as an old version, so
+a restore can be undone too. Files created since the time given are
+left alone. Use --dry-run to see what would be restored.
+`,
+	Opts: map[string]string{
+		"at": "Restore the files as they were at this time, either a date or an age like 1d",
+	},
+}, {
+	Name:  "prune",
+	Short: "Remove old versions.",
+	Long: `This command removes old versions older than max_age and beyond
+the newest max_versions of each file. The limits can be given as
+options, otherwise the ones set in the config are used.
+
+Usage Example:
+
+    rclone backend prune remote: -o max-age=30d -o max-versions=10
+
+rclone cleanup does the same with the limits from the config. Use
+--dry-run to see what would be removed.
+`,
+	Opts: map[string]string{
+		"max-age":      "Remove old versions older than this",
+		"max-versions": "Number of old versions of each file to keep",
+	},
+}}
+
+// oldVersion describes an old version of a file
+type oldVersion struct {
+	obj     fs.Object // the object in the versions directory
+	version time.Time // when it was replaced or deleted
+}
+
+// findVersions finds the old versions of the files under dir, or of
+// the file dir if it isn't a directory, returning them newest first.
+func (f *Fs) findVersions(ctx context.Context, dir string) (map[string][]oldVersion, error) {
+	found := map[string][]oldVersion{}
+	add := func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			t, remote := version.Remove(f.fromVersionsPath(o.Remote()))
+			if !t.IsZero() {
+				found[remote] = append(found[remote], oldVersion{obj: o, version: t})
+			}
+		}
+		return nil
+	}
+	err := walk.ListR(ctx, f.versions.Fs, f.versionsPath(dir), true, -1, walk.ListObjects, add)
+	if err == fs.ErrorDirNotFound && dir != "" {
+		// dir may be a file so look for its versions in the parent
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		err = walk.ListR(ctx, f.versions.Fs, f.versionsPath(parent), true, 1, walk.ListObjects, add)
+		for remote := range found {
+			if remote != dir {
+				delete(found, remote)
+			}
+		}
+	}
+	if err == fs.ErrorDirNotFound {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, versions := range found {
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i].version.After(versions[j].version)
+		})
+	}
+	return found, nil
+}
+
+// VersionInfo describes an old version for the list-versions command
+type VersionInfo struct {
+	Path    string    `json:"path"`    // path of the file
+	Version string    `json:"version"` // path of the old version
+	Time    time.Time `json:"time"`    // when it was replaced or deleted
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// listVersionsCommand lists the old versions under the paths in arg
+func (f *Fs) listVersionsCommand(ctx context.Context, arg []string) (out []VersionInfo, err error) {
+	if len(arg) == 0 {
+		arg = []string{""}
+	}
+	out = []VersionInfo{}
+	for _, dir := range arg {
+		found, err := f.findVersions(ctx, hiddendir.CleanPath(dir))
+		if err != nil {
+			return nil, err
+		}
+		remotes := make([]string, 0, len(found))
+		for remote := range found {
+			remotes = append(remotes, remote)
+		}
+		sort.Strings(remotes)
+		for _, remote := range remotes {
+			for _, v := range found[remote] {
+				out = append(out, VersionInfo{
+					Path:    remote,
+					Version: f.fromVersionsPath(v.obj.Remote()),
+					Time:    v.version,
+					Size:    v.obj.Size(),
+					ModTime: v.obj.ModTime(ctx),
+				})
+			}
+		}
+	}
+	return out, nil
+}
+
+// RestoreStats reports the results of the restore command
+type RestoreStats struct {
+	Restored int `json:"restored"` // files restored
+	Errors   int `json:"errors"`   // files which could not be restored
+}
+
+// restoreCommand restores old versions under the paths in arg
+func (f *Fs) restoreCommand(ctx context.Context, arg []string, opt map[string]string) (stats RestoreStats, err error) {
+	if err := f.writable(); err != nil {
+		return stats, err
+	}
+	var at time.Time
+	if s, ok := opt["at"]; ok {
+		at, err = fs.ParseTime(s)
+		if err != nil {
+			return stats, fmt.Errorf("bad at option: %w", err)
+		}
+	}
+	if len(arg) == 0 {
+		arg = []string{""}
+	}
+	for _, p := range arg {
+		p = hiddendir.CleanPath(p)
+		// A path naming an old version restores exactly that
+		if t, remote := version.Remove(path.Base(p)); !t.IsZero() {
+			remote = path.Join(path.Dir(p), remote)
+			o, err := f.versions.Fs.NewObject(ctx, f.versionsPath(p))
+			if err == nil {
+				err = f.restore(ctx, remote, o)
+			}
+			if err != nil {
+				return stats, err
+			}
+			stats.Restored++
+			continue
+		}
+		found, err := f.findVersions(ctx, p)
+		if err != nil {
+			return stats, err
+		}
+		if len(found) == 0 {
+			return stats, fmt.Errorf("no old versions found for %q", p)
+		}
+		for remote, versions := range found {
+			v := pickVersion(versions, at)
+			if v == nil {
+				continue
+			}
+			err = f.restore(ctx, remote, v.obj)
+			if err != nil {
+				fs.Errorf(remote, "Failed to restore: %v", err)
+				stats.Errors++
+				continue
+			}
+			stats.Restored++
+		}
+	}
+	if stats.Errors > 0 {
+		return stats, fmt.Errorf("failed to restore %d files", stats.Errors)
+	}
+	return stats, nil
+}
+
+// pickVersion returns the old version which was current at time at,
+// or the newest if at is zero.
+//
+// It returns nil if the current file was already current then.
+func pickVersion(versions []oldVersion, at time.Time) *oldVersion {
+	if at.IsZero() {
+		return &versions[0]
+	}
+	// versions are newest first so the last one replaced after at
+	// was current at that time
+	var picked *oldVersion
+	for i := range versions {
+		if !versions[i].version.After(at) {
+			break
+		}
+		picked = &versions[i]
+	}
+	return picked
+}
+
+// restore copies the old version o back to remote, keeping the
+// current contents as an old version.
+func (f *Fs) restore(ctx context.Context, remote string, o fs.Object) error {
+	if err := f.keepExisting(ctx, remote); err != nil {
+		return err
+	}
+	_, err := operations.Copy(ctx, f.Fs, nil, remote, o)
+	if err == nil {
+		fs.Infof(remote, "Restored old version %q", f.fromVersionsPath(o.Remote()))
+	}
+	return err
+}
+
+// PruneStats reports the results of pruning old versions
+type PruneStats struct {
+	Kept    int `json:"kept"`    // old versions kept
+	Removed int `json:"removed"` // old versions removed
+}
+
+// pruneCommand removes old versions with the limits in opt or the config
+func (f *Fs) pruneCommand(ctx context.Context, opt map[string]string) (stats PruneStats, err error) {
+	maxAge, maxVersions := f.maxAge(), f.opt.MaxVersions
+	if s, ok := opt["max-age"]; ok {
+		maxAge, err = fs.ParseDuration(s)
+		if err != nil {
+			return stats, fmt.Errorf("bad max-age option: %w", err)
+		}
+	}
+	if s, ok := opt["max-versions"]; ok {
+		maxVersions, err = strconv.Atoi(s)
+		if err != nil || maxVersions < 0 {
+			return stats, fmt.Errorf("bad max-versions option %q", s)
+		}
+	}
+	if maxAge <= 0 && maxVersions <= 0 {
+		return stats, errors.New("nothing to prune - set max_age or max_versions")
+	}
+	return f.prune(ctx, "", maxAge, maxVersions)
+}
+
+// maxAge returns the max_age from the config or 0 if off
+func (f *Fs) maxAge() time.Duration {
+	if f.opt.MaxAge == fs.DurationOff {
+		return 0
+	}
+	return time.Duration(f.opt.MaxAge)
+}
+
+// prune removes the old versions under dir older than maxAge or
+// beyond the newest maxVersions of each file. Zero limits are off.
+func (f *Fs) prune(ctx context.Context, dir string, maxAge time.Duration, maxVersions int) (stats PruneStats, err error) {
+	found, err := f.findVersions(ctx, dir)
+	if err != nil {
+		return stats, err
+	}
+	now := time.Now()
+	for _, versions := range found {
+		for i, v := range versions {
+			if (maxVersions > 0 && i >= maxVersions) || (maxAge > 0 && now.Sub(v.version) > maxAge) {
+				err = operations.DeleteFile(ctx, v.obj)
+				if err != nil {
+					return stats, err
+				}
+				stats.Removed++
+			} else {
+				stats.Kept++
+			}
+		}
+	}
+	return stats, nil
+}
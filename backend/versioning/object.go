@@ -0,0 +1,129 @@
+package versioning
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Object represents a file or an old version of a file
+type Object struct {
+	fs.Object
+	f       *Fs
+	remote  string    // remote of an old version
+	version time.Time // when an old version was replaced, zero if current
+}
+
+// newObject wraps a current file from the base remote
+func (f *Fs) newObject(o fs.Object) *Object {
+	return &Object{Object: o, f: f}
+}
+
+// newVersion wraps an old version from the versions directory
+func (f *Fs) newVersion(o fs.Object, remote string, t time.Time) *Object {
+	return &Object{Object: o, f: f, remote: remote, version: t}
+}
+
+// isVersion returns true if this is an old version
+func (o *Object) isVersion() bool {
+	return !o.version.IsZero()
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info { return o.f }
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object { return o.Object }
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.Remote()
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	if o.isVersion() {
+		return o.remote
+	}
+	return o.Object.Remote()
+}
+
+// Update in to the object with the modTime given of the given size
+//
+// The current contents are kept as an old version first.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	if err := o.f.writable(); err != nil {
+		return err
+	}
+	if err := o.f.keep(ctx, o.Object, false); err != nil {
+		return err
+	}
+	return o.Object.Update(ctx, in, src, options...)
+}
+
+// Remove an object
+//
+// The object is moved to the versions directory.
+func (o *Object) Remove(ctx context.Context) error {
+	if err := o.f.writable(); err != nil {
+		return err
+	}
+	return o.f.keep(ctx, o.Object, true)
+}
+
+// SetModTime sets the modification time of the file
+func (o *Object) SetModTime(ctx context.Context, t time.Time) error {
+	if err := o.f.writable(); err != nil {
+		return err
+	}
+	return o.Object.SetModTime(ctx, t)
+}
+
+// ID returns the ID of the Object if possible
+func (o *Object) ID() string {
+	if doer, ok := o.Object.(fs.IDer); ok {
+		return doer.ID()
+	}
+	return ""
+}
+
+// GetTier returns the Tier of the Object if possible
+func (o *Object) GetTier() string {
+	if doer, ok := o.Object.(fs.GetTierer); ok {
+		return doer.GetTier()
+	}
+	return ""
+}
+
+// SetTier set the Tier of the Object if possible
+func (o *Object) SetTier(tier string) error {
+	if doer, ok := o.Object.(fs.SetTierer); ok {
+		return doer.SetTier(tier)
+	}
+	return errors.New("SetTier not supported")
+}
+
+// MimeType of an Object if known, "" otherwise
+func (o *Object) MimeType(ctx context.Context) string {
+	if doer, ok := o.Object.(fs.MimeTyper); ok {
+		return doer.MimeType(ctx)
+	}
+	return ""
+}
+
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	do, ok := o.Object.(fs.Metadataer)
+	if !ok {
+		return nil, nil
+	}
+	return do.Metadata(ctx)
+}
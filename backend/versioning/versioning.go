@@ -0,0 +1,559 @@
+// Package versioning implements an overlay backend which keeps old
+// versions of files
+package versioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/hiddendir"
+	"github.com/rclone/rclone/lib/version"
+)
+
+var errNotWithVersions = errors.New("can't modify or delete files in --versioning-versions mode")
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "versioning",
+		Description: "Keep old versions of files on any remote",
+		NewFs:       NewFs,
+		MetadataInfo: &fs.MetadataInfo{
+			Help: `Any metadata supported by the underlying remote is read and written.`,
+		},
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name:     "remote",
+			Required: true,
+			Help: `Remote to keep versions on (e.g. myRemote:path).
+
+Old versions are kept in the directory set by versions_dir at the
+root of this remote.`,
+		}, {
+			Name:    "max_age",
+			Default: fs.DurationOff,
+			Help: `Remove old versions older than this (off = keep forever).
+
+Old versions are only removed by rclone cleanup and the prune
+command.`,
+		}, {
+			Name:    "max_versions",
+			Default: 0,
+			Help: `Number of old versions of each file to keep (0 = keep all).
+
+Old versions are only removed by rclone cleanup and the prune
+command.`,
+		}, {
+			Name:     "versions",
+			Default:  false,
+			Advanced: true,
+			Help: `Include old versions in directory listings.
+
+Old versions are shown next to the current files with the time they
+were replaced or deleted in their names.
+
+Note that when using this no file write operations are permitted,
+so you can't upload files or delete them.`,
+		}, {
+			Name:     "versions_dir",
+			Default:  ".versions",
+			Advanced: true,
+			Help: `Name of the directory old versions are kept in.
+
+This is relative to the root of the remote and hidden from listings.`,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote      string      `config:"remote"`
+	MaxAge      fs.Duration `config:"max_age"`
+	MaxVersions int         `config:"max_versions"`
+	Versions    bool        `config:"versions"`
+	VersionsDir string      `config:"versions_dir"`
+}
+
+// Fs represents a wrapped fs.Fs
+type Fs struct {
+	fs.Fs
+	name     string
+	root     string
+	wrapper  fs.Fs
+	features *fs.Features
+	opt      *Options
+	versions *hiddendir.Dir // the versions directory at the root of the remote
+}
+
+// NewFs constructs an Fs from the remote:path string
+func NewFs(ctx context.Context, fsname, rpath string, cmap configmap.Mapper) (fs.Fs, error) {
+	opt := &Options{}
+	err := configstruct.Set(cmap, opt)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(opt.Remote, fsname+":") {
+		return nil, errors.New("can't point remote at itself")
+	}
+	opt.VersionsDir = strings.Trim(opt.VersionsDir, "/")
+	if opt.VersionsDir == "" {
+		return nil, errors.New("versions_dir can't be empty")
+	}
+	if opt.MaxVersions < 0 {
+		return nil, errors.New("max_versions can't be negative")
+	}
+
+	baseFs, versions, err := hiddendir.New(ctx, opt.Remote, rpath, opt.VersionsDir)
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, err
+	}
+
+	f := &Fs{
+		Fs:       baseFs,
+		name:     fsname,
+		root:     rpath,
+		opt:      opt,
+		versions: versions,
+	}
+	f.features = hiddendir.Features(ctx, f, f.Fs)
+	// Pruning old versions doesn't need the remote to support cleanup
+	f.features.CleanUp = f.CleanUp
+
+	cache.PinUntilFinalized(f.Fs, f)
+	return f, err
+}
+
+//
+// Filesystem
+//
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string { return f.name }
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string { return f.root }
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features { return f.features }
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("versioning root '%s'", f.root)
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs { return f.Fs }
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs { return f.wrapper }
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) { f.wrapper = wrapper }
+
+// versionsPath returns the path in the versions directory of the
+// old versions of remote
+func (f *Fs) versionsPath(remote string) string {
+	return f.versions.Path(remote)
+}
+
+// fromVersionsPath is the inverse of versionsPath
+func (f *Fs) fromVersionsPath(vpath string) string {
+	if f.versions.Prefix == "" {
+		return vpath
+	}
+	return strings.TrimPrefix(vpath, f.versions.Prefix+"/")
+}
+
+// writable returns an error if files can't be modified
+func (f *Fs) writable() error {
+	if f.opt.Versions {
+		return errNotWithVersions
+	}
+	return nil
+}
+
+// Wrap base entries into versioning entries, dropping the versions
+// directory.
+func (f *Fs) wrapEntries(baseEntries fs.DirEntries) fs.DirEntries {
+	return f.versions.Filter(baseEntries, func(o fs.Object) fs.Object { return f.newObject(o) })
+}
+
+// Wrap entries from the versions directory into versioning entries.
+//
+// Objects which don't have a version in their name are skipped.
+// Directories are only added if they aren't in seen.
+func (f *Fs) wrapVersionEntries(vEntries fs.DirEntries, seen map[string]bool) (entries fs.DirEntries) {
+	for _, entry := range vEntries {
+		remote := f.fromVersionsPath(entry.Remote())
+		switch x := entry.(type) {
+		case fs.Object:
+			if t, _ := version.Remove(path.Base(remote)); !t.IsZero() {
+				entries = append(entries, f.newVersion(x, remote, t))
+			}
+		case fs.Directory:
+			if !seen[remote] {
+				d := fs.NewDirCopy(context.Background(), x)
+				d.SetRemote(remote)
+				entries = append(entries, d)
+			}
+		}
+	}
+	return entries
+}
+
+// List the objects and directories in dir into entries.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	entries, err = f.Fs.List(ctx, dir)
+	if !f.opt.Versions {
+		if err != nil {
+			return nil, err
+		}
+		return f.wrapEntries(entries), nil
+	}
+	if err != nil && err != fs.ErrorDirNotFound {
+		return nil, err
+	}
+	notFound := err == fs.ErrorDirNotFound
+	entries = f.wrapEntries(entries)
+	vEntries, err := f.versions.Fs.List(ctx, f.versionsPath(dir))
+	if err == fs.ErrorDirNotFound {
+		if notFound {
+			return nil, fs.ErrorDirNotFound
+		}
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, f.wrapVersionEntries(vEntries, dirSet(entries))...), nil
+}
+
+// dirSet returns the set of directories in entries
+func dirSet(entries fs.DirEntries) map[string]bool {
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if _, ok := entry.(fs.Directory); ok {
+			seen[entry.Remote()] = true
+		}
+	}
+	return seen
+}
+
+// ListR lists the objects and directories recursively into out.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (err error) {
+	seen := map[string]bool{}
+	err = f.Fs.Features().ListR(ctx, dir, func(baseEntries fs.DirEntries) error {
+		entries := f.wrapEntries(baseEntries)
+		if f.opt.Versions {
+			for remote := range dirSet(entries) {
+				seen[remote] = true
+			}
+		}
+		return callback(entries)
+	})
+	if !f.opt.Versions || (err != nil && err != fs.ErrorDirNotFound) {
+		return err
+	}
+	notFound := err == fs.ErrorDirNotFound
+	err = walk.ListR(ctx, f.versions.Fs, f.versionsPath(dir), true, -1, walk.ListAll, func(vEntries fs.DirEntries) error {
+		return callback(f.wrapVersionEntries(vEntries, seen))
+	})
+	if err == fs.ErrorDirNotFound && !notFound {
+		err = nil
+	}
+	return err
+}
+
+// NewObject finds the Object at remote.
+//
+// In versions mode this finds old versions too.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	if f.versions.IsHidden(remote) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	o, err := f.Fs.NewObject(ctx, remote)
+	if err == fs.ErrorObjectNotFound && f.opt.Versions {
+		if t, _ := version.Remove(path.Base(remote)); !t.IsZero() {
+			vo, vErr := f.versions.Fs.NewObject(ctx, f.versionsPath(remote))
+			if vErr == nil {
+				return f.newVersion(vo, remote, t), nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// keep saves the current contents of o in the versions directory
+// before it is overwritten or deleted.
+//
+// If move is set the object is moved there, otherwise it is copied.
+func (f *Fs) keep(ctx context.Context, o fs.Object, move bool) error {
+	remote := f.versionsPath(o.Remote())
+	dir, leaf := path.Split(remote)
+	t := time.Now().UTC()
+	var name string
+	for {
+		name = dir + version.Add(leaf, t)
+		_, err := f.versions.Fs.NewObject(ctx, name)
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find a name for the old version of %q: %w", o.Remote(), err)
+		}
+		// two versions in the same millisecond
+		t = t.Add(time.Millisecond)
+	}
+	var err error
+	if move {
+		_, err = operations.Move(ctx, f.versions.Fs, nil, name, o)
+	} else {
+		_, err = operations.Copy(ctx, f.versions.Fs, nil, name, o)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to keep old version of %q: %w", o.Remote(), err)
+	}
+	fs.Debugf(o, "Kept old version as %q", name)
+	return nil
+}
+
+// keepExisting keeps the current contents of remote if it exists
+//
+// Errors other than the file not existing are returned so the file
+// isn't overwritten without keeping its old version.
+func (f *Fs) keepExisting(ctx context.Context, remote string) error {
+	o, err := f.Fs.NewObject(ctx, remote)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check for an old version of %q: %w", remote, err)
+	}
+	return f.keep(ctx, o, false)
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// If the file exists its current contents are kept as an old version
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if err := f.writable(); err != nil {
+		return nil, err
+	}
+	if err := f.keepExisting(ctx, src.Remote()); err != nil {
+		return nil, err
+	}
+	o, err := f.Fs.Put(ctx, in, src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// PutStream uploads to the remote path with undeterminate size.
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	do := f.Fs.Features().PutStream
+	if do == nil {
+		return nil, errors.New("PutStream not supported")
+	}
+	if err := f.writable(); err != nil {
+		return nil, err
+	}
+	if err := f.keepExisting(ctx, src.Remote()); err != nil {
+		return nil, err
+	}
+	o, err := do(ctx, in, src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// Mkdir makes the directory
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	if err := f.writable(); err != nil {
+		return err
+	}
+	return f.Fs.Mkdir(ctx, dir)
+}
+
+// Rmdir removes the directory
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	if err := f.writable(); err != nil {
+		return err
+	}
+	return f.Fs.Rmdir(ctx, dir)
+}
+
+// CleanUp removes old versions beyond max_age and max_versions then
+// cleans up the remote if it supports it.
+func (f *Fs) CleanUp(ctx context.Context) error {
+	if f.maxAge() > 0 || f.opt.MaxVersions > 0 {
+		if _, err := f.prune(ctx, "", f.maxAge(), f.opt.MaxVersions); err != nil {
+			return err
+		}
+	}
+	if do := f.Fs.Features().CleanUp; do != nil {
+		return do(ctx)
+	}
+	return nil
+}
+
+// About gets quota information from the Fs
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	if do := f.Fs.Features().About; do != nil {
+		return do(ctx)
+	}
+	return nil, errors.New("not supported by underlying remote")
+}
+
+// ChangeNotify calls the passed function with a path that has had changes.
+func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType), pollIntervalChan <-chan time.Duration) {
+	if do := f.Fs.Features().ChangeNotify; do != nil {
+		do(ctx, func(remote string, entryType fs.EntryType) {
+			if !f.versions.IsHidden(remote) {
+				notifyFunc(remote, entryType)
+			}
+		}, pollIntervalChan)
+	}
+}
+
+// UserInfo returns info about the connected user
+func (f *Fs) UserInfo(ctx context.Context) (map[string]string, error) {
+	if do := f.Fs.Features().UserInfo; do != nil {
+		return do(ctx)
+	}
+	return nil, fs.ErrorNotImplemented
+}
+
+// Disconnect the current user
+func (f *Fs) Disconnect(ctx context.Context) error {
+	if do := f.Fs.Features().Disconnect; do != nil {
+		return do(ctx)
+	}
+	return fs.ErrorNotImplemented
+}
+
+// DirCacheFlush resets the directory cache - used in testing
+// as an optional interface
+func (f *Fs) DirCacheFlush() {
+	if do := f.Fs.Features().DirCacheFlush; do != nil {
+		do()
+	}
+	if do := f.versions.Fs.Features().DirCacheFlush; do != nil {
+		do()
+	}
+}
+
+// PublicLink generates a public link to the remote path (usually readable by anyone)
+func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration, unlink bool) (string, error) {
+	if do := f.Fs.Features().PublicLink; do != nil {
+		return do(ctx, remote, expire, unlink)
+	}
+	return "", errors.New("PublicLink not supported")
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// If the destination exists its current contents are kept as an old
+// version.
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Copy
+	if do == nil {
+		return nil, fs.ErrorCantCopy
+	}
+	o, ok := src.(*Object)
+	if !ok || f.writable() != nil {
+		return nil, fs.ErrorCantCopy
+	}
+	if err := f.keepExisting(ctx, remote); err != nil {
+		return nil, err
+	}
+	oResult, err := do(ctx, o.Object, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(oResult), nil
+}
+
+// Move src to this remote using server-side move operations.
+//
+// If the destination exists its current contents are kept as an old
+// version.
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Move
+	if do == nil {
+		return nil, fs.ErrorCantMove
+	}
+	o, ok := src.(*Object)
+	if !ok || f.writable() != nil || o.isVersion() {
+		return nil, fs.ErrorCantMove
+	}
+	if err := f.keepExisting(ctx, remote); err != nil {
+		return nil, err
+	}
+	oResult, err := do(ctx, o.Object, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(oResult), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote using server-side move operations.
+//
+// Old versions of the files stay where they were.
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	do := f.Fs.Features().DirMove
+	if do == nil {
+		return fs.ErrorCantDirMove
+	}
+	srcFs, ok := src.(*Fs)
+	if !ok || f.writable() != nil {
+		return fs.ErrorCantDirMove
+	}
+	return do(ctx, srcFs.Fs, srcRemote, dstRemote)
+}
+
+// Shutdown the backend, closing any background tasks and any cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	f.versions.Close()
+	if do := f.Fs.Features().Shutdown; do != nil {
+		return do(ctx)
+	}
+	return nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.PutStreamer     = (*Fs)(nil)
+	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.UnWrapper       = (*Fs)(nil)
+	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.Wrapper         = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ fs.ChangeNotifier  = (*Fs)(nil)
+	_ fs.PublicLinker    = (*Fs)(nil)
+	_ fs.UserInfoer      = (*Fs)(nil)
+	_ fs.Disconnecter    = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.FullObject      = (*Object)(nil)
+)
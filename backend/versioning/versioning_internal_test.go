@@ -0,0 +1,151 @@
+package versioning
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/rclone/rclone/lib/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	fsys, err := fs.NewFs(ctx, ":versioning,remote='"+tempDir+"':")
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+	defer func() {
+		assert.NoError(t, f.Shutdown(ctx))
+	}()
+	mtime := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := fstest.NewItem("dir/file.txt", "one", mtime)
+	file2 := fstest.NewItem("dir/file.txt", "two", mtime)
+	file3 := fstest.NewItem("dir/file.txt", "three", mtime)
+
+	// Overwriting and deleting keeps the old versions
+	fstests.PutTestContents(ctx, t, f, &file1, "one", true)
+	before := time.Now()
+	time.Sleep(2 * time.Millisecond) // versions have millisecond precision
+	o := fstests.PutTestContents(ctx, t, f, &file2, "two", true)
+	fstests.PutTestContents(ctx, t, f, &file3, "three", true)
+	require.NoError(t, o.Remove(ctx))
+	_, err = f.NewObject(ctx, "dir/file.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	// The versions directory is hidden
+	fstest.CheckListingWithPrecision(t, f, nil, []string{"dir"}, fs.ModTimeNotSupported)
+
+	versions, err := f.listVersionsCommand(ctx, []string{"dir/file.txt"})
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for i, want := range []int64{5, 3, 3} {
+		assert.Equal(t, "dir/file.txt", versions[i].Path)
+		assert.Equal(t, want, versions[i].Size)
+	}
+	assert.True(t, versions[0].Time.After(versions[1].Time))
+
+	// Restore undoes the delete
+	stats, err := f.restoreCommand(ctx, []string{"dir"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, RestoreStats{Restored: 1}, stats)
+	fstest.CheckListingWithPrecision(t, f, []fstest.Item{file3}, []string{"dir"}, fs.ModTimeNotSupported)
+
+	// Restore a point in time, keeping the current contents
+	stats, err = f.restoreCommand(ctx, nil, map[string]string{"at": before.Format(time.RFC3339Nano)})
+	require.NoError(t, err)
+	assert.Equal(t, RestoreStats{Restored: 1}, stats)
+	fstest.CheckListingWithPrecision(t, f, []fstest.Item{file1}, []string{"dir"}, fs.ModTimeNotSupported)
+
+	// Restore an exact version
+	versions, err = f.listVersionsCommand(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, versions, 4)
+	assert.Equal(t, "dir/file.txt", versions[0].Path)
+	t0, remote := version.Remove(path.Base(versions[0].Version))
+	assert.Equal(t, "file.txt", remote)
+	assert.Equal(t, versions[0].Time, t0)
+	_, err = f.restoreCommand(ctx, []string{versions[0].Version}, nil)
+	require.NoError(t, err)
+	fstest.CheckListingWithPrecision(t, f, []fstest.Item{file3}, []string{"dir"}, fs.ModTimeNotSupported)
+
+	// Versions mode shows the old versions and is read only
+	vfsys, err := fs.NewFs(ctx, ":versioning,versions,remote='"+tempDir+"':dir")
+	require.NoError(t, err)
+	entries, err := vfsys.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, entries, 6)
+	vo, err := vfsys.NewObject(ctx, versions[0].Version[len("dir/"):])
+	require.NoError(t, err)
+	assert.Equal(t, "three", fstests.ReadObject(ctx, t, vo, -1))
+	src := object.NewStaticObjectInfo("new", mtime, 1, true, nil, nil)
+	_, err = vfsys.Put(ctx, strings.NewReader("x"), src)
+	assert.Equal(t, errNotWithVersions, err)
+
+	// Prune keeps the newest versions
+	_, err = f.pruneCommand(ctx, nil)
+	assert.Error(t, err)
+	pruned, err := f.pruneCommand(ctx, map[string]string{"max-versions": "2"})
+	require.NoError(t, err)
+	assert.Equal(t, PruneStats{Kept: 2, Removed: 3}, pruned)
+	pruned, err = f.pruneCommand(ctx, map[string]string{"max-age": "1h"})
+	require.NoError(t, err)
+	assert.Equal(t, PruneStats{Kept: 2}, pruned)
+	f.opt.MaxAge = fs.Duration(time.Nanosecond)
+	time.Sleep(5 * time.Millisecond) // versions stamped in the same millisecond are moved on
+	require.NoError(t, f.CleanUp(ctx))
+	versions, err = f.listVersionsCommand(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+	fstest.CheckListingWithPrecision(t, f, []fstest.Item{file3}, []string{"dir"}, fs.ModTimeNotSupported)
+}
+
+// flakyFs is an fs.Fs whose NewObject fails with a transient error
+type flakyFs struct {
+	fs.Fs
+}
+
+// NewObject fails as if the network was down
+func (f flakyFs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	return nil, errors.New("transient error")
+}
+
+func TestKeepExistingError(t *testing.T) {
+	ctx := context.Background()
+	fsys, err := fs.NewFs(ctx, ":versioning,remote='"+t.TempDir()+"':")
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+	defer func() {
+		assert.NoError(t, f.Shutdown(ctx))
+	}()
+	mtime := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	item := fstest.NewItem("file.txt", "one", mtime)
+	fstests.PutTestContents(ctx, t, f, &item, "one", true)
+	src := object.NewStaticObjectInfo("file.txt", mtime, 3, true, nil, nil)
+
+	// the file isn't overwritten if it can't be checked for
+	base := f.Fs
+	f.Fs = flakyFs{Fs: base}
+	_, err = f.Put(ctx, strings.NewReader("two"), src)
+	assert.ErrorContains(t, err, "transient error")
+	f.Fs = base
+	fstest.CheckListingWithPrecision(t, f, []fstest.Item{item}, nil, fs.ModTimeNotSupported)
+
+	// nor if a name for the old version can't be found
+	vfs := f.versions.Fs
+	f.versions.Fs = flakyFs{Fs: vfs}
+	_, err = f.Put(ctx, strings.NewReader("two"), src)
+	assert.ErrorContains(t, err, "transient error")
+	f.versions.Fs = vfs
+	fstest.CheckListingWithPrecision(t, f, []fstest.Item{item}, nil, fs.ModTimeNotSupported)
+	fstest.CheckListingWithPrecision(t, f.versions.Fs, nil, nil, fs.ModTimeNotSupported)
+}
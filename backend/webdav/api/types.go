@@ -2,6 +2,7 @@
 package api
 
 import (
+	"encoding/json"
 	"encoding/xml"
 	"regexp"
 	"strconv"
@@ -76,6 +77,7 @@ type Prop struct {
 	Modified     Time      `xml:"DAV: prop>getlastmodified,omitempty"`
 	Checksums    []string  `xml:"prop>checksums>checksum,omitempty"`
 	MESha1Hex    *string   `xml:"ME: prop>sha1hex,omitempty"` // Fastmail-specific sha1 checksum
+	Permissions  string    `xml:"prop>permissions,omitempty"` // owncloud style permissions, e.g. RDNVW
 }
 
 // Parse a status of the form "HTTP/1.1 200 OK" or "HTTP/1.1 200"
@@ -241,3 +243,128 @@ type Quota struct {
 	Available string `xml:"DAV: response>propstat>prop>quota-available-bytes"`
 	Used      string `xml:"DAV: response>propstat>prop>quota-used-bytes"`
 }
+
+// DriveList is the response from the ownCloud Infinite Scale Graph
+// drives API listing the spaces of the user
+type DriveList struct {
+	Value []Drive `json:"value"`
+}
+
+// Drive describes a space in ownCloud Infinite Scale
+//
+//	{
+//	  "driveAlias": "project/marketing",
+//	  "driveType": "project",
+//	  "id": "1284d238-aa92-42ce-bdc4-0b0000009157$4c510ada-c86b-4815-8820-42cdf82c3d51",
+//	  "lastModifiedDateTime": "2023-06-01T12:00:00.123456789Z",
+//	  "name": "Marketing",
+//	  "quota": {"remaining": 999999000, "state": "normal", "total": 1000000000, "used": 1000},
+//	  "root": {"webDavUrl": "https://ocis.example.com/dav/spaces/1284d238-aa92-42ce-bdc4-0b0000009157$4c510ada-c86b-4815-8820-42cdf82c3d51"}
+//	}
+type Drive struct {
+	ID           string      `json:"id"`
+	Name         string      `json:"name"`
+	DriveType    string      `json:"driveType"` // personal, project, virtual or mountpoint
+	DriveAlias   string      `json:"driveAlias"`
+	LastModified time.Time   `json:"lastModifiedDateTime"`
+	Quota        *DriveQuota `json:"quota,omitempty"`
+	Root         DriveRoot   `json:"root"`
+}
+
+// DriveQuota is the quota of a space - Total is 0 if unlimited
+type DriveQuota struct {
+	Remaining int64  `json:"remaining"`
+	Total     int64  `json:"total"`
+	Used      int64  `json:"used"`
+	State     string `json:"state"`
+}
+
+// DriveRoot is the root item of a space
+type DriveRoot struct {
+	WebDavURL string        `json:"webDavUrl"`
+	Deleted   *DriveDeleted `json:"deleted,omitempty"`
+}
+
+// DriveDeleted is set on the root of a disabled space
+type DriveDeleted struct {
+	State string `json:"state"` // "trashed" if disabled
+}
+
+// OCSResponse is the envelope of an OCS API response
+//
+//	{"ocs":{"meta":{"status":"ok","statuscode":200,"message":"OK"},"data":{...}}}
+type OCSResponse struct {
+	OCS struct {
+		Meta OCSMeta         `json:"meta"`
+		Data json.RawMessage `json:"data"`
+	} `json:"ocs"`
+}
+
+// OCSMeta is the status of an OCS API response
+type OCSMeta struct {
+	Status     string `json:"status"`
+	StatusCode int    `json:"statuscode"` // 100 for v1 and 200 for v2 if OK
+	Message    string `json:"message"`
+}
+
+// OK returns true if the OCS API call succeeded
+func (m *OCSMeta) OK() bool {
+	return m.StatusCode == 100 || m.StatusCode == 200
+}
+
+// Share types of the OCS Share API
+const (
+	ShareTypeUser      = 0
+	ShareTypeGroup     = 1
+	ShareTypePublic    = 3
+	ShareTypeEmail     = 4
+	ShareTypeFederated = 6
+)
+
+// Share permissions of the OCS Share API
+const (
+	SharePermissionRead   = 1
+	SharePermissionUpdate = 2
+	SharePermissionCreate = 4
+	SharePermissionDelete = 8
+	SharePermissionShare  = 16
+	SharePermissionAll    = 31
+)
+
+// ShareID is the ID of a share which some servers send as a number
+// and others as a string
+type ShareID string
+
+// UnmarshalJSON turns a JSON number or string into a ShareID
+func (id *ShareID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = ShareID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*id = ShareID(n)
+	return nil
+}
+
+// Share describes a share as returned by the OCS Share API
+type Share struct {
+	ID                   ShareID `json:"id"`
+	ShareType            int     `json:"share_type"`
+	Owner                string  `json:"uid_owner"`
+	Permissions          int     `json:"permissions"`
+	Time                 int64   `json:"stime"`      // creation time in seconds since the epoch
+	Expiration           string  `json:"expiration"` // e.g. "2023-06-01 00:00:00" or empty
+	Token                string  `json:"token"`
+	Path                 string  `json:"path"`
+	ItemType             string  `json:"item_type"` // file or folder
+	ShareWith            string  `json:"share_with"`
+	ShareWithDisplayName string  `json:"share_with_displayname"`
+	Password             string  `json:"password"` // hashed, Nextcloud only
+	Name                 string  `json:"name"`
+	Label                string  `json:"label"` // Nextcloud's name of a public link
+	URL                  string  `json:"url"`
+}
@@ -0,0 +1,231 @@
+package webdav
+
+/*
+   ownCloud Infinite Scale spaces
+
+   Each user has a personal space plus any project spaces and shares
+   they are a member of. The spaces are listed with the Graph drives
+   API and addressed by ID under /dav/spaces/ - see
+   https://owncloud.dev/apis/http/graph/spaces/
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/rclone/rclone/backend/webdav/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// ocisBaseURL returns the URL of the server from the configured URL
+// which may point into its WebDAV endpoints
+func ocisBaseURL(u string) string {
+	for _, dav := range []string{"/dav/", "/remote.php/"} {
+		if i := strings.Index(u, dav); i >= 0 {
+			return u[:i+1]
+		}
+	}
+	return addSlash(u)
+}
+
+// listSpaces lists the spaces the user can access
+//
+// Disabled spaces and the mount points of shares, which are reached
+// through the Shares space, are left out.
+func (f *Fs) listSpaces(ctx context.Context) (spaces []api.Drive, err error) {
+	opts := rest.Opts{
+		Method:  "GET",
+		RootURL: f.ocisURL,
+		Path:    "graph/v1.0/me/drives",
+	}
+	var result api.DriveList
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
+		return f.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list spaces: %w", err)
+	}
+	for _, space := range result.Value {
+		if space.DriveType == "mountpoint" || (space.Root.Deleted != nil && space.Root.Deleted.State == "trashed") {
+			continue
+		}
+		spaces = append(spaces, space)
+	}
+	// personal first, then by name
+	sort.SliceStable(spaces, func(i, j int) bool {
+		iPersonal, jPersonal := spaces[i].DriveType == "personal", spaces[j].DriveType == "personal"
+		if iPersonal != jPersonal {
+			return iPersonal
+		}
+		return spaces[i].Name < spaces[j].Name
+	})
+	return spaces, nil
+}
+
+// findSpace finds the space with the name, alias or ID given
+func findSpace(spaces []api.Drive, name string) (space api.Drive, err error) {
+	var found []api.Drive
+	for _, space := range spaces {
+		if space.ID == name || space.DriveAlias == name {
+			return space, nil
+		}
+		if strings.EqualFold(space.Name, name) {
+			found = append(found, space)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return space, fmt.Errorf("space %q not found", name)
+	case 1:
+		return found[0], nil
+	}
+	return space, fmt.Errorf("%d spaces called %q found - use the alias or ID of the space instead", len(found), name)
+}
+
+// spaceNames returns the directory name of each space. Spaces which
+// can't be shown by their name use their ID.
+func spaceNames(spaces []api.Drive) (names []string, ids map[string]string) {
+	ids = make(map[string]string, len(spaces))
+	for _, space := range spaces {
+		name := space.Name
+		if _, found := ids[name]; found || name == "" || strings.Contains(name, "/") {
+			name = space.ID
+		}
+		names = append(names, name)
+		ids[name] = space.ID
+	}
+	return names, ids
+}
+
+// setSpace points the Fs at the configured space, or at all the
+// spaces if none is configured
+func (f *Fs) setSpace(ctx context.Context) error {
+	f.ocisURL = ocisBaseURL(f.endpointURL)
+	spaces, err := f.listSpaces(ctx)
+	if err != nil {
+		return err
+	}
+	endpointURL := f.ocisURL + "dav/spaces/"
+	if f.opt.Space != "" {
+		space, err := findSpace(spaces, f.opt.Space)
+		if err != nil {
+			return err
+		}
+		f.spaceID = space.ID
+		endpointURL += rest.URLPathEscape(space.ID) + "/"
+		fs.Debugf(f, "Using space %q with ID %q", space.Name, space.ID)
+	} else {
+		f.allSpaces = true
+		_, f.spaces = spaceNames(spaces)
+	}
+	f.endpoint, err = url.Parse(endpointURL)
+	if err != nil {
+		return err
+	}
+	f.endpointURL = f.endpoint.String()
+	f.srv.SetRoot(f.endpointURL)
+	return nil
+}
+
+// lookupSpace returns the ID of the space shown as the directory name
+func (f *Fs) lookupSpace(name string) (id string, ok bool) {
+	f.spacesMu.Lock()
+	defer f.spacesMu.Unlock()
+	if id, ok = f.spaces[name]; ok {
+		return id, true
+	}
+	for _, id := range f.spaces {
+		if id == name {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// splitSpace splits the path p into the ID of its space and the path
+// inside the space
+//
+// Unknown spaces are returned as is so the server reports them.
+func (f *Fs) splitSpace(p string) (space, subPath string) {
+	space, subPath, _ = strings.Cut(p, "/")
+	if id, ok := f.lookupSpace(space); ok {
+		space = id
+	}
+	return space, subPath
+}
+
+// checkSpace checks remote is inside a space if the spaces are shown
+// as directories as nothing else can be created at the top level
+func (f *Fs) checkSpace(remote string) error {
+	if !f.allSpaces {
+		return nil
+	}
+	p := path.Join(f.root, remote)
+	if p == "" {
+		return nil
+	}
+	name, _, _ := strings.Cut(p, "/")
+	if _, ok := f.lookupSpace(name); !ok {
+		return fmt.Errorf("space %q not found - spaces can only be created in the web interface", name)
+	}
+	return nil
+}
+
+// listSpacesAll calls fn on each space as a directory, refreshing the
+// spaces known
+func (f *Fs) listSpacesAll(ctx context.Context, fn listAllFn) (found bool, err error) {
+	spaces, err := f.listSpaces(ctx)
+	if err != nil {
+		return false, err
+	}
+	names, ids := spaceNames(spaces)
+	f.spacesMu.Lock()
+	f.spaces = ids
+	f.spacesMu.Unlock()
+	for i, space := range spaces {
+		if fn(names[i], true, &api.Prop{Modified: api.Time(space.LastModified)}) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// aboutSpace returns the quota of the configured space, or of the
+// personal space if the spaces are shown as directories
+func (f *Fs) aboutSpace(ctx context.Context) (*fs.Usage, error) {
+	spaces, err := f.listSpaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, space := range spaces {
+		if f.spaceID == space.ID || (f.spaceID == "" && space.DriveType == "personal") {
+			usage := &fs.Usage{}
+			if q := space.Quota; q != nil {
+				usage.Used = fs.NewUsageValue(q.Used)
+				if q.Total > 0 {
+					usage.Total = fs.NewUsageValue(q.Total)
+					usage.Free = fs.NewUsageValue(q.Remaining)
+				}
+			}
+			return usage, nil
+		}
+	}
+	return nil, fmt.Errorf("space %q not found", f.spaceID)
+}
+
+// checkPermission checks the owncloud style permissions of the object
+// contain flag before an operation, if the permissions are known
+func (o *Object) checkPermission(flag, operation string) error {
+	if !o.fs.hasOCPerms || o.permissions == "" || strings.Contains(o.permissions, flag) {
+		return nil
+	}
+	return fmt.Errorf("%s not allowed by permissions %q: %w", operation, o.permissions, fs.ErrorPermissionDenied)
+}
@@ -0,0 +1,545 @@
+package webdav
+
+/*
+   Shares using the OCS Share API of ownCloud, Nextcloud and ownCloud
+   Infinite Scale - see
+   https://doc.owncloud.com/server/next/developer_manual/core/apis/ocs-share-api.html
+   https://docs.nextcloud.com/server/latest/developer_manual/client_apis/OCS/ocs-share-api.html
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/backend/webdav/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+const (
+	sharesPath      = "ocs/v2.php/apps/files_sharing/api/v1/shares"
+	shareDateFormat = "2006-01-02"
+)
+
+// errNoShares is returned for vendors without the OCS Share API
+var errNoShares = errors.New("shares are only supported with the owncloud, nextcloud and owncloud-infinite-scale vendors")
+
+// matches the WebDAV URL of the files of a user, capturing the root
+// of the server and the directory inside the files of the user
+var ocsFilesURL = regexp.MustCompile(`^(.*/)remote\.php/(?:webdav|dav/files/[^/]+)(/.*)?$`)
+
+// setOCS finds the OCS API from the URL of the files of the user
+func (f *Fs) setOCS() {
+	match := ocsFilesURL.FindStringSubmatch(f.endpoint.Path)
+	if match == nil {
+		fs.Debugf(f, "Shares not available as %q isn't the WebDAV URL of the files of a user", f.endpointURL)
+		return
+	}
+	u := *f.endpoint
+	u.Path, u.RawPath, u.RawQuery = match[1], "", ""
+	f.ocsURL = u.String()
+	f.ocsPrefix = strings.Trim(match[2], "/")
+}
+
+// ocsCall calls the OCS Share API, decoding the data into out if set
+func (f *Fs) ocsCall(ctx context.Context, method, id string, params url.Values, out interface{}) error {
+	opts := rest.Opts{
+		Method:     method,
+		RootURL:    f.ocsURL,
+		Path:       sharesPath,
+		Parameters: url.Values{"format": {"json"}},
+		ExtraHeaders: map[string]string{
+			"OCS-APIRequest": "true",
+		},
+	}
+	if id != "" {
+		opts.Path += "/" + url.PathEscape(id)
+	}
+	if method == "POST" {
+		opts.Body = strings.NewReader(params.Encode())
+		opts.ContentType = "application/x-www-form-urlencoded"
+	} else {
+		for k, v := range params {
+			opts.Parameters[k] = v
+		}
+	}
+	var result api.OCSResponse
+	var resp *http.Response
+	var err error
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
+		return f.shouldRetry(ctx, resp, err)
+	})
+	if apiErr, ok := err.(*api.Error); ok && apiErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", apiErr.Message, fs.ErrorObjectNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if !result.OCS.Meta.OK() {
+		return &api.Error{
+			Message:    result.OCS.Meta.Message,
+			Status:     result.OCS.Meta.Status,
+			StatusCode: result.OCS.Meta.StatusCode,
+		}
+	}
+	if out != nil && len(result.OCS.Data) > 0 {
+		err = json.Unmarshal(result.OCS.Data, out)
+		if err != nil {
+			return fmt.Errorf("couldn't decode shares: %w", err)
+		}
+	}
+	return nil
+}
+
+// shareParams returns the parameters addressing remote in the OCS API
+func (f *Fs) shareParams(remote string) (url.Values, error) {
+	p := path.Join(f.root, remote)
+	switch {
+	case f.allSpaces:
+		if p == "" {
+			return nil, errors.New("can't share the list of spaces")
+		}
+		space, subPath := f.splitSpace(p)
+		return url.Values{"space_ref": {space + "/" + subPath}}, nil
+	case f.spaceID != "":
+		return url.Values{"space_ref": {f.spaceID + "/" + p}}, nil
+	}
+	return url.Values{"path": {"/" + path.Join(f.ocsPrefix, p)}}, nil
+}
+
+// shareRemote returns the remote of a path returned by the OCS API,
+// or the path as is if it isn't inside the Fs
+func (f *Fs) shareRemote(p string) string {
+	if f.ocisURL != "" {
+		return p
+	}
+	root := "/" + path.Join(f.ocsPrefix, f.root)
+	if root == "/" {
+		return strings.TrimPrefix(p, "/")
+	}
+	if p == root {
+		return ""
+	}
+	if strings.HasPrefix(p, root+"/") {
+		return p[len(root)+1:]
+	}
+	return p
+}
+
+// listShares lists the shares of remote, or of the files in it if
+// subfiles is set
+func (f *Fs) listShares(ctx context.Context, remote string, subfiles bool) (shares []api.Share, err error) {
+	params, err := f.shareParams(remote)
+	if err != nil {
+		return nil, err
+	}
+	if subfiles {
+		params.Set("subfiles", "true")
+	}
+	err = f.ocsCall(ctx, "GET", "", params, &shares)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list shares: %w", err)
+	}
+	return shares, nil
+}
+
+// createShare shares remote with the parameters given
+func (f *Fs) createShare(ctx context.Context, remote string, params url.Values) (share *api.Share, err error) {
+	ref, err := f.shareParams(remote)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range ref {
+		params[k] = v
+	}
+	err = f.ocsCall(ctx, "POST", "", params, &share)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create share: %w", err)
+	}
+	return share, nil
+}
+
+// deleteShare deletes the share with the ID given
+func (f *Fs) deleteShare(ctx context.Context, id string) error {
+	err := f.ocsCall(ctx, "DELETE", id, nil, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't delete share %q: %w", id, err)
+	}
+	return nil
+}
+
+// expireDate returns the day a share lasting expire should expire
+//
+// Shares expire at the start of the day so this is rounded up.
+func expireDate(expire time.Duration) string {
+	return time.Now().Add(expire).AddDate(0, 0, 1).Format(shareDateFormat)
+}
+
+// isPlainLink returns true if share is a public link without a
+// password or a name
+func isPlainLink(share *api.Share) bool {
+	if share.ShareType != api.ShareTypePublic || share.URL == "" {
+		return false
+	}
+	if share.ShareWith != "" || share.Password != "" {
+		// has a password
+		return false
+	}
+	if share.Name != "" || share.Label != "" {
+		// made by the user for something else
+		return false
+	}
+	return true
+}
+
+// expiresOn returns true if share expires on date, or never if date
+// is empty
+func expiresOn(share *api.Share, date string) bool {
+	return strings.Split(share.Expiration, " ")[0] == date
+}
+
+// PublicLink generates a public link to the remote path (usually readable by anyone)
+//
+// An existing public link without a password or a name is returned if
+// it expires on the same day, or never if expire isn't set.
+//
+// Unlinking removes all the public links without a password or a name
+// whatever their expiry, returning fs.ErrorObjectNotFound if there
+// weren't any. Links made with the create-share command with a
+// password or a name are left alone.
+func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration, unlink bool) (string, error) {
+	shares, err := f.listShares(ctx, remote, false)
+	if err != nil {
+		return "", err
+	}
+	if unlink {
+		return "", f.unlink(ctx, shares)
+	}
+	var date string
+	if expire != fs.DurationOff {
+		date = expireDate(time.Duration(expire))
+	}
+	for i := range shares {
+		share := &shares[i]
+		if isPlainLink(share) && expiresOn(share, date) {
+			return share.URL, nil
+		}
+	}
+	params := url.Values{
+		"shareType":   {strconv.Itoa(api.ShareTypePublic)},
+		"permissions": {strconv.Itoa(api.SharePermissionRead)},
+	}
+	if date != "" {
+		params.Set("expireDate", date)
+	}
+	share, err := f.createShare(ctx, remote, params)
+	if err != nil {
+		return "", err
+	}
+	return share.URL, nil
+}
+
+// unlink deletes the plain public links in shares
+func (f *Fs) unlink(ctx context.Context, shares []api.Share) error {
+	found := false
+	for i := range shares {
+		share := &shares[i]
+		if !isPlainLink(share) {
+			continue
+		}
+		if err := f.deleteShare(ctx, string(share.ID)); err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return fs.ErrorObjectNotFound
+	}
+	return nil
+}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "list-shares":
+		return f.listSharesCommand(ctx, arg, opt)
+	case "create-share":
+		return f.createShareCommand(ctx, arg, opt)
+	case "delete-share":
+		return nil, f.deleteShareCommand(ctx, arg)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "list-shares",
+	Short: "List the shares of files and directories.",
+	Long: `This command lists the shares of the paths given, or of the files
+in the directories given with the subfiles option. It lists all the
+shares made by the user if no paths are given.
+
+Usage Examples:
+
+    rclone backend list-shares remote:
+    rclone backend list-shares remote: path/to/file
+    rclone backend list-shares remote: path/to/dir -o subfiles
+
+This needs the owncloud, nextcloud or owncloud-infinite-scale vendor.
+`,
+	Opts: map[string]string{
+		"subfiles": "List the shares of the files in the directories given",
+	},
+}, {
+	Name:  "create-share",
+	Short: "Share files and directories.",
+	Long: `This command shares the paths given with a user, a group or anyone
+with the link, returning the shares created.
+
+Usage Examples:
+
+    rclone backend create-share remote: path/to/dir -o type=user -o with=alice -o permissions=read,update
+    rclone backend create-share remote: path/to/file -o password=secret -o expire=7d
+
+The permissions are a comma separated list of read, update, create,
+delete and share, or all, or the number used by the OCS Share API.
+Public links are read only by default and other shares have all the
+permissions allowed.
+`,
+	Opts: map[string]string{
+		"type":        "Type of share: user, group or public (default public)",
+		"with":        "User or group to share with",
+		"permissions": "Permissions of the share",
+		"password":    "Password of a public link",
+		"expire":      "Expiry as a date YYYY-MM-DD or a duration like 7d",
+		"name":        "Name of a public link",
+	},
+}, {
+	Name:  "delete-share",
+	Short: "Delete shares.",
+	Long: `This command deletes the shares with the IDs given, as shown by
+list-shares.
+
+Usage Example:
+
+    rclone backend delete-share remote: 42 43
+`,
+}}
+
+// ShareInfo describes a share for the share commands
+type ShareInfo struct {
+	ID          string `json:"id"`
+	Path        string `json:"path"` // path of the share, relative to the remote if inside it
+	Type        string `json:"type"` // user, group, public or other types as a number
+	With        string `json:"with,omitempty"`
+	Permissions int    `json:"permissions"`
+	Name        string `json:"name,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Expires     string `json:"expires,omitempty"` // day the share expires as YYYY-MM-DD
+	Owner       string `json:"owner"`
+}
+
+// shareTypeNames are the names of the share types
+var shareTypeNames = map[int]string{
+	api.ShareTypeUser:      "user",
+	api.ShareTypeGroup:     "group",
+	api.ShareTypePublic:    "public",
+	api.ShareTypeEmail:     "email",
+	api.ShareTypeFederated: "federated",
+}
+
+// sharePermissionNames are the names of the share permissions
+var sharePermissionNames = map[string]int{
+	"read":   api.SharePermissionRead,
+	"update": api.SharePermissionUpdate,
+	"create": api.SharePermissionCreate,
+	"delete": api.SharePermissionDelete,
+	"share":  api.SharePermissionShare,
+	"all":    api.SharePermissionAll,
+}
+
+// shareInfo converts a share from the OCS API
+func (f *Fs) shareInfo(share *api.Share) ShareInfo {
+	info := ShareInfo{
+		ID:          string(share.ID),
+		Path:        f.shareRemote(share.Path),
+		Type:        shareTypeNames[share.ShareType],
+		With:        share.ShareWith,
+		Permissions: share.Permissions,
+		Name:        share.Name,
+		URL:         share.URL,
+		Expires:     strings.Split(share.Expiration, " ")[0],
+		Owner:       share.Owner,
+	}
+	if info.Type == "" {
+		info.Type = strconv.Itoa(share.ShareType)
+	}
+	if share.Label != "" {
+		info.Name = share.Label
+	}
+	if share.ShareType == api.ShareTypePublic {
+		// share_with is the hashed password
+		info.With = ""
+	}
+	return info
+}
+
+// listSharesCommand lists the shares of the paths in arg
+func (f *Fs) listSharesCommand(ctx context.Context, arg []string, opt map[string]string) (out []ShareInfo, err error) {
+	if f.ocsURL == "" {
+		return nil, errNoShares
+	}
+	out = []ShareInfo{}
+	var shares []api.Share
+	if len(arg) == 0 && !f.allSpaces && f.spaceID == "" {
+		err = f.ocsCall(ctx, "GET", "", nil, &shares)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list shares: %w", err)
+		}
+	} else {
+		if len(arg) == 0 {
+			arg = []string{""}
+		}
+		_, subfiles := opt["subfiles"]
+		for _, remote := range arg {
+			found, err := f.listShares(ctx, cleanPath(remote), subfiles)
+			if err != nil {
+				return nil, err
+			}
+			shares = append(shares, found...)
+		}
+	}
+	for i := range shares {
+		out = append(out, f.shareInfo(&shares[i]))
+	}
+	return out, nil
+}
+
+// parseSharePermissions parses names or the number of permissions
+func parseSharePermissions(s string) (permissions int, err error) {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= api.SharePermissionAll {
+		return n, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		permission, ok := sharePermissionNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("bad permission %q - use read, update, create, delete, share or all", name)
+		}
+		permissions |= permission
+	}
+	return permissions, nil
+}
+
+// parseExpire parses a date or a duration into the day to expire
+func parseExpire(s string) (string, error) {
+	if _, err := time.Parse(shareDateFormat, s); err == nil {
+		return s, nil
+	}
+	d, err := fs.ParseDuration(s)
+	if err != nil {
+		return "", fmt.Errorf("bad expire option %q - use YYYY-MM-DD or a duration", s)
+	}
+	return expireDate(d), nil
+}
+
+// createShareCommand shares the paths in arg
+func (f *Fs) createShareCommand(ctx context.Context, arg []string, opt map[string]string) (out []ShareInfo, err error) {
+	if f.ocsURL == "" {
+		return nil, errNoShares
+	}
+	if len(arg) == 0 {
+		return nil, errors.New("need at least one path to share")
+	}
+	params := url.Values{}
+	shareType, permissions := api.ShareTypePublic, api.SharePermissionRead
+	switch t := opt["type"]; t {
+	case "", "public":
+	case "user", "group":
+		shareType, permissions = api.ShareTypeUser, api.SharePermissionAll
+		if t == "group" {
+			shareType = api.ShareTypeGroup
+		}
+		if opt["with"] == "" {
+			return nil, fmt.Errorf("need the with option to share with a %s", t)
+		}
+		params.Set("shareWith", opt["with"])
+	default:
+		return nil, fmt.Errorf("bad type option %q - use user, group or public", t)
+	}
+	params.Set("shareType", strconv.Itoa(shareType))
+	if s, ok := opt["permissions"]; ok {
+		permissions, err = parseSharePermissions(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	params.Set("permissions", strconv.Itoa(permissions))
+	if s, ok := opt["expire"]; ok {
+		date, err := parseExpire(s)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("expireDate", date)
+	}
+	if s, ok := opt["password"]; ok {
+		if shareType != api.ShareTypePublic {
+			return nil, errors.New("only public links can have a password")
+		}
+		params.Set("password", s)
+	}
+	if s, ok := opt["name"]; ok {
+		if f.opt.Vendor == "nextcloud" {
+			params.Set("label", s)
+		} else {
+			params.Set("name", s)
+		}
+	}
+	out = []ShareInfo{}
+	for _, remote := range arg {
+		share, err := f.createShare(ctx, cleanPath(remote), params)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, f.shareInfo(share))
+	}
+	return out, nil
+}
+
+// deleteShareCommand deletes the shares with the IDs in arg
+func (f *Fs) deleteShareCommand(ctx context.Context, arg []string) error {
+	if f.ocsURL == "" {
+		return errNoShares
+	}
+	if len(arg) == 0 {
+		return errors.New("need at least one share ID")
+	}
+	for _, id := range arg {
+		if err := f.deleteShare(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanPath makes a path given to a command relative to the root
+func cleanPath(p string) string {
+	return path.Clean("/" + p)[1:]
+}
@@ -11,6 +11,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -69,6 +70,8 @@ func init() {
 		Name:        "webdav",
 		Description: "WebDAV",
 		NewFs:       NewFs,
+		Config:      Config,
+		CommandHelp: commandHelp,
 		Options: []fs.Option{{
 			Name:     "url",
 			Help:     "URL of http host to connect to.\n\nE.g. https://example.com.",
@@ -85,6 +88,9 @@ func init() {
 			}, {
 				Value: "owncloud",
 				Help:  "Owncloud",
+			}, {
+				Value: "owncloud-infinite-scale",
+				Help:  "ownCloud Infinite Scale",
 			}, {
 				Value: "sharepoint",
 				Help:  "Sharepoint Online, authenticated by Microsoft account",
@@ -144,10 +150,69 @@ Set to 0 to disable chunked uploading.
 `,
 			Advanced: true,
 			Default:  10 * fs.Mebi, // Default NextCloud `max_chunk_size` is `10 MiB`. See https://github.com/nextcloud/server/blob/0447b53bda9fe95ea0cbed765aa332584605d652/apps/files/lib/App.php#L57
+		}, {
+			Name: "space",
+			Help: `ownCloud Infinite Scale space to use.
+
+This can be the name, the alias (e.g. project/marketing) or the ID of
+the space. Leave blank to show all the spaces as top level
+directories.
+
+Only used with the owncloud-infinite-scale vendor.
+`,
+			Advanced: true,
 		}},
 	})
 }
 
+// Config offers a choice of the spaces for ownCloud Infinite Scale
+func Config(ctx context.Context, name string, m configmap.Mapper, config fs.ConfigIn) (*fs.ConfigOut, error) {
+	if vendor, _ := m.Get("vendor"); vendor != "owncloud-infinite-scale" {
+		return nil, nil
+	}
+	switch config.State {
+	case "":
+		// list the spaces without changing the one configured
+		current, _ := m.Get("space")
+		noSpace := configmap.New().
+			AddGetter(configmap.Simple{"space": ""}, configmap.PriorityNormal).
+			AddGetter(m, configmap.PriorityConfig)
+		f, err := NewFs(ctx, name, "", noSpace)
+		if err != nil {
+			fs.Logf(nil, "Couldn't list the spaces - set the space option later if needed: %v", err)
+			return nil, nil
+		}
+		spaces, err := f.(*Fs).listSpaces(ctx)
+		if err != nil {
+			fs.Logf(nil, "Couldn't list the spaces - set the space option later if needed: %v", err)
+			return nil, nil
+		}
+		items := []fs.OptionExample{{
+			Value: "",
+			Help:  "Show all the spaces as top level directories",
+		}}
+		for _, space := range spaces {
+			items = append(items, fs.OptionExample{
+				Value: space.ID,
+				Help:  fmt.Sprintf("%s (%s)", space.Name, space.DriveType),
+			})
+		}
+		return &fs.ConfigOut{
+			State: "space_end",
+			Option: &fs.Option{
+				Name:     "config_space",
+				Help:     "Space to use",
+				Default:  current,
+				Examples: items,
+			},
+		}, nil
+	case "space_end":
+		m.Set("space", config.Result)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown state %q", config.State)
+}
+
 // Options defines the configuration for this backend
 type Options struct {
 	URL                string               `config:"url"`
@@ -160,6 +225,7 @@ type Options struct {
 	Headers            fs.CommaSepList      `config:"headers"`
 	PacerMinSleep      fs.Duration          `config:"pacer_min_sleep"`
 	ChunkSize          fs.SizeSuffix        `config:"nextcloud_chunk_size"`
+	Space              string               `config:"space"`
 }
 
 // Fs represents a remote webdav
@@ -184,6 +250,16 @@ type Fs struct {
 	ntlmAuthMu         sync.Mutex    // mutex to serialize NTLM auth roundtrips
 	chunksUploadURL    string        // upload URL for nextcloud chunked
 	canChunk           bool          // set if nextcloud and nextcloud_chunk_size is set
+	hasOCPerms         bool          // set if can use owncloud style permissions
+	ocsURL             string        // URL of the OCS API if shares are supported
+	ocsPrefix          string        // directory of the URL inside the files of the user
+
+	// ownCloud Infinite Scale spaces
+	ocisURL   string            // URL of the ownCloud Infinite Scale server
+	spaceID   string            // ID of the ownCloud Infinite Scale space in use
+	allSpaces bool              // set if the spaces are shown as top level directories
+	spacesMu  sync.Mutex        // mutex to protect spaces
+	spaces    map[string]string // space directory names to IDs if allSpaces
 }
 
 // Object describes a webdav object
@@ -197,6 +273,7 @@ type Object struct {
 	modTime     time.Time // modification time of the object
 	sha1        string    // SHA-1 of the object content if known
 	md5         string    // MD5 of the object content if known
+	permissions string    // owncloud style permissions if known
 }
 
 // ------------------------------------------------------------
@@ -355,7 +432,13 @@ func errorHandler(resp *http.Response) error {
 	err = xml.Unmarshal(body, &errResponse)
 	if err != nil {
 		// set the Message to be the body if can't parse the XML
-		errResponse.Message = strings.TrimSpace(string(body))
+		// unless it is an error from the OCS API
+		var ocs api.OCSResponse
+		if json.Unmarshal(body, &ocs) == nil && ocs.OCS.Meta.Message != "" {
+			errResponse.Message = ocs.OCS.Meta.Message
+		} else {
+			errResponse.Message = strings.TrimSpace(string(body))
+		}
 	}
 	errResponse.Status = resp.Status
 	errResponse.StatusCode = resp.StatusCode
@@ -373,9 +456,16 @@ func addSlash(s string) string {
 // filePath returns a file path (f.root, file)
 func (f *Fs) filePath(file string) string {
 	subPath := path.Join(f.root, file)
+	var space string
+	if f.allSpaces && subPath != "" {
+		space, subPath = f.splitSpace(subPath)
+	}
 	if f.opt.Enc != encoder.EncodeZero {
 		subPath = f.opt.Enc.FromStandardPath(subPath)
 	}
+	if space != "" {
+		subPath = path.Join(space, subPath)
+	}
 	return rest.URLPathEscape(subPath)
 }
 
@@ -586,17 +676,30 @@ func (f *Fs) setQuirks(ctx context.Context, vendor string) error {
 		f.propsetMtime = true
 		f.hasOCMD5 = true
 		f.hasOCSHA1 = true
+		f.setOCS()
 	case "nextcloud":
 		f.precision = time.Second
 		f.useOCMtime = true
 		f.propsetMtime = true
 		f.hasOCSHA1 = true
 		f.canChunk = true
+		f.setOCS()
 		if err := f.verifyChunkConfig(); err != nil {
 			return err
 		}
 		f.chunksUploadURL = f.getChunksUploadURL()
 		fs.Logf(nil, "Chunks temporary upload directory: %s", f.chunksUploadURL)
+	case "owncloud-infinite-scale":
+		f.precision = time.Second
+		f.useOCMtime = true
+		f.propsetMtime = true
+		f.hasOCMD5 = true
+		f.hasOCSHA1 = true
+		f.hasOCPerms = true
+		if err := f.setSpace(ctx); err != nil {
+			return err
+		}
+		f.ocsURL = f.ocisURL
 	case "sharepoint":
 		// To mount sharepoint, two Cookies are required
 		// They have to be set instead of BasicAuth
@@ -643,6 +746,11 @@ func (f *Fs) setQuirks(ctx context.Context, vendor string) error {
 	if !f.canStream {
 		f.features.PutStream = nil
 	}
+
+	// Remove PublicLink if there is no OCS API for shares
+	if f.ocsURL == "" {
+		f.features.PublicLink = nil
+	}
 	return nil
 }
 
@@ -685,6 +793,7 @@ var owncloudProps = []byte(`<?xml version="1.0"?>
   <d:resourcetype />
   <d:getcontenttype />
   <oc:checksums />
+  <oc:permissions />
  </d:prop>
 </d:propfind>
 `)
@@ -701,6 +810,12 @@ type listAllFn func(string, bool, *api.Prop) bool
 //
 // If the user fn ever returns true then it early exits with found = true
 func (f *Fs) listAll(ctx context.Context, dir string, directoriesOnly bool, filesOnly bool, depth string, fn listAllFn) (found bool, err error) {
+	if f.allSpaces && path.Join(f.root, dir) == "" {
+		if filesOnly {
+			return false, nil
+		}
+		return f.listSpacesAll(ctx, fn)
+	}
 	opts := rest.Opts{
 		Method: "PROPFIND",
 		Path:   f.dirPath(dir), // FIXME Should not start with /
@@ -945,6 +1060,9 @@ func (f *Fs) mkdir(ctx context.Context, dirPath string) (err error) {
 
 // Mkdir creates the directory if it doesn't exist
 func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	if err := f.checkSpace(dir); err != nil {
+		return err
+	}
 	dirPath := f.dirPath(dir)
 	return f.mkdir(ctx, dirPath)
 }
@@ -1034,6 +1152,9 @@ func (f *Fs) copyOrMove(ctx context.Context, src fs.Object, remote string, metho
 		return nil, fs.ErrorCantMove
 	}
 	srcFs := srcObj.fs
+	if err := f.checkSpace(remote); err != nil {
+		return nil, err
+	}
 	dstPath := f.filePath(remote)
 	err := f.mkParentDir(ctx, dstPath)
 	if err != nil {
@@ -1090,6 +1211,10 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 // deleting all the files quicker than just running Remove() on the
 // result of List()
 func (f *Fs) Purge(ctx context.Context, dir string) error {
+	if f.allSpaces && path.Join(f.root, dir) == "" {
+		// the spaces themselves can't be deleted
+		return fs.ErrorCantPurge
+	}
 	return f.purgeCheck(ctx, dir, false)
 }
 
@@ -1120,6 +1245,9 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 		fs.Debugf(srcFs, "Can't move directory - not same remote type")
 		return fs.ErrorCantDirMove
 	}
+	if err := f.checkSpace(dstRemote); err != nil {
+		return err
+	}
 	srcPath := srcFs.filePath(srcRemote)
 	dstPath := f.filePath(dstRemote)
 
@@ -1178,6 +1306,9 @@ func (f *Fs) Hashes() hash.Set {
 
 // About gets quota information
 func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	if f.ocisURL != "" {
+		return f.aboutSpace(ctx)
+	}
 	opts := rest.Opts{
 		Method: "PROPFIND",
 		Path:   "",
@@ -1272,6 +1403,9 @@ func (o *Object) setMetaData(info *api.Prop) (err error) {
 			o.md5 = hashes[hash.MD5]
 		}
 	}
+	if o.fs.hasOCPerms {
+		o.permissions = info.Permissions
+	}
 	return nil
 }
 
@@ -1317,6 +1451,9 @@ var owncloudPropset = `<?xml version="1.0" encoding="utf-8" ?>
 
 // SetModTime sets the modification time of the local fs object
 func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	if err := o.checkPermission("W", "set modification time"); err != nil {
+		return err
+	}
 	if o.fs.propsetMtime {
 		opts := rest.Opts{
 			Method:     "PROPPATCH",
@@ -1385,6 +1522,12 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.Read
 //
 // The new object may have been created if an error is returned
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	if err = o.checkPermission("W", "update"); err != nil {
+		return err
+	}
+	if err = o.fs.checkSpace(o.remote); err != nil {
+		return err
+	}
 	err = o.fs.mkParentDir(ctx, o.filePath())
 	if err != nil {
 		return fmt.Errorf("Update mkParentDir failed: %w", err)
@@ -1477,6 +1620,9 @@ func (o *Object) updateSimple(ctx context.Context, body io.Reader, getBody func(
 
 // Remove an object
 func (o *Object) Remove(ctx context.Context) error {
+	if err := o.checkPermission("D", "delete"); err != nil {
+		return err
+	}
 	opts := rest.Opts{
 		Method:     "DELETE",
 		Path:       o.filePath(),
@@ -1490,12 +1636,14 @@ func (o *Object) Remove(ctx context.Context) error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs          = (*Fs)(nil)
-	_ fs.Purger      = (*Fs)(nil)
-	_ fs.PutStreamer = (*Fs)(nil)
-	_ fs.Copier      = (*Fs)(nil)
-	_ fs.Mover       = (*Fs)(nil)
-	_ fs.DirMover    = (*Fs)(nil)
-	_ fs.Abouter     = (*Fs)(nil)
-	_ fs.Object      = (*Object)(nil)
+	_ fs.Fs           = (*Fs)(nil)
+	_ fs.Purger       = (*Fs)(nil)
+	_ fs.PutStreamer  = (*Fs)(nil)
+	_ fs.Copier       = (*Fs)(nil)
+	_ fs.Mover        = (*Fs)(nil)
+	_ fs.DirMover     = (*Fs)(nil)
+	_ fs.Abouter      = (*Fs)(nil)
+	_ fs.PublicLinker = (*Fs)(nil)
+	_ fs.Commander    = (*Fs)(nil)
+	_ fs.Object       = (*Object)(nil)
 )
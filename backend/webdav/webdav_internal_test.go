@@ -2,16 +2,21 @@ package webdav_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/rclone/rclone/backend/webdav"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/configfile"
 	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/hash"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -80,3 +85,341 @@ func TestHeaders(t *testing.T) {
 	_, err := f.Features().About(context.Background())
 	require.NoError(t, err)
 }
+
+// prepareOCIS makes a test server with the spaces of an ownCloud
+// Infinite Scale server and returns a function to tidy it up afterwards
+func prepareOCIS(t *testing.T) (configmap.Simple, func()) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /graph/v1.0/me/drives":
+			fmt.Fprint(w, `{"value":[
+{"id":"s$2","name":"Marketing","driveType":"project","driveAlias":"project/marketing","lastModifiedDateTime":"2023-06-01T12:00:00Z"},
+{"id":"s$3","name":"Old","driveType":"project","driveAlias":"project/old","root":{"deleted":{"state":"trashed"}}},
+{"id":"s$4","name":"Shared","driveType":"mountpoint","driveAlias":"mountpoint/shared"},
+{"id":"p$1","name":"Personal","driveType":"personal","driveAlias":"personal/einstein","quota":{"remaining":900,"total":1000,"used":100}}
+]}`)
+		case "PROPFIND /dav/spaces/s$2/":
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+<d:response>
+ <d:href>/dav/spaces/s%242/</d:href>
+ <d:propstat>
+  <d:prop>
+   <d:resourcetype><d:collection/></d:resourcetype>
+   <oc:permissions>RDNVCK</oc:permissions>
+  </d:prop>
+  <d:status>HTTP/1.1 200 OK</d:status>
+ </d:propstat>
+</d:response>
+<d:response>
+ <d:href>/dav/spaces/s%242/file.txt</d:href>
+ <d:propstat>
+  <d:prop>
+   <d:getlastmodified>Thu, 01 Jun 2023 12:00:00 GMT</d:getlastmodified>
+   <d:getcontentlength>6</d:getcontentlength>
+   <d:resourcetype/>
+   <oc:checksums><oc:checksum>SHA1:f572d396fae9206628714fb2ce00f72e94f2258f MD5:b1946ac92492d2347c6235b4d2611184 ADLER32:084b021f</oc:checksum></oc:checksums>
+   <oc:permissions>RNVW</oc:permissions>
+  </d:prop>
+  <d:status>HTTP/1.1 200 OK</d:status>
+ </d:propstat>
+</d:response>
+</d:multistatus>`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	ts := httptest.NewServer(handler)
+	configfile.Install()
+	m := configmap.Simple{
+		"type":   "webdav",
+		"url":    ts.URL + "/remote.php/webdav/",
+		"vendor": "owncloud-infinite-scale",
+	}
+	return m, ts.Close
+}
+
+// TestOwncloudInfiniteScale tests the spaces are found and addressed
+func TestOwncloudInfiniteScale(t *testing.T) {
+	ctx := context.Background()
+	m, tidy := prepareOCIS(t)
+	defer tidy()
+
+	t.Run("AllSpaces", func(t *testing.T) {
+		f, err := webdav.NewFs(ctx, remoteName, "", m)
+		require.NoError(t, err)
+
+		entries, err := f.List(ctx, "")
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			_, isDir := entry.(fs.Directory)
+			assert.True(t, isDir)
+			names = append(names, entry.Remote())
+		}
+		assert.Equal(t, []string{"Personal", "Marketing"}, names)
+
+		entries, err = f.List(ctx, "Marketing")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "Marketing/file.txt", entries[0].Remote())
+
+		assert.Error(t, f.Mkdir(ctx, "Unknown"))
+
+		usage, err := f.Features().About(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), *usage.Used)
+		assert.Equal(t, int64(900), *usage.Free)
+		assert.Equal(t, int64(1000), *usage.Total)
+	})
+
+	t.Run("Space", func(t *testing.T) {
+		m.Set("space", "project/marketing")
+		defer m.Set("space", "")
+		f, err := webdav.NewFs(ctx, remoteName, "", m)
+		require.NoError(t, err)
+
+		entries, err := f.List(ctx, "")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		o, ok := entries[0].(fs.Object)
+		require.True(t, ok)
+		assert.Equal(t, "file.txt", o.Remote())
+		sha1, err := o.Hash(ctx, hash.SHA1)
+		require.NoError(t, err)
+		assert.Equal(t, "f572d396fae9206628714fb2ce00f72e94f2258f", sha1)
+
+		// the permissions don't allow deleting the file
+		err = o.Remove(ctx)
+		assert.ErrorIs(t, err, fs.ErrorPermissionDenied)
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		m.Set("space", "project/marketing")
+		defer m.Set("space", "")
+		out, err := webdav.Config(ctx, remoteName, m, fs.ConfigIn{})
+		require.NoError(t, err)
+		require.NotNil(t, out)
+		assert.Equal(t, "space_end", out.State)
+		assert.Equal(t, "project/marketing", out.Option.Default)
+		var values []string
+		for _, item := range out.Option.Examples {
+			values = append(values, item.Value)
+		}
+		assert.Equal(t, []string{"", "p$1", "s$2"}, values)
+
+		// listing the spaces doesn't change the one configured
+		space, _ := m.Get("space")
+		assert.Equal(t, "project/marketing", space)
+
+		out, err = webdav.Config(ctx, remoteName, m, fs.ConfigIn{State: "space_end", Result: "s$2"})
+		require.NoError(t, err)
+		assert.Nil(t, out)
+		space, _ = m.Get("space")
+		assert.Equal(t, "s$2", space)
+	})
+
+	t.Run("SpaceNotFound", func(t *testing.T) {
+		m.Set("space", "Missing")
+		defer m.Set("space", "")
+		_, err := webdav.NewFs(ctx, remoteName, "", m)
+		assert.ErrorContains(t, err, `space "Missing" not found`)
+	})
+}
+
+// prepareShares makes a test server with the OCS Share API of a
+// Nextcloud server keeping the shares in memory and returns a
+// function to tidy it up afterwards
+func prepareShares(t *testing.T) (configmap.Simple, func()) {
+	var (
+		mu     sync.Mutex
+		shares []map[string]interface{}
+		nextID = 1
+	)
+	reply := func(w http.ResponseWriter, status int, message string, data interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"ocs": map[string]interface{}{
+				"meta": map[string]interface{}{"status": "ok", "statuscode": status, "message": message},
+				"data": data,
+			},
+		})
+		assert.NoError(t, err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "true", r.Header.Get("OCS-APIRequest"))
+		assert.Equal(t, "json", r.URL.Query().Get("format"))
+		const sharesPath = "/ocs/v2.php/apps/files_sharing/api/v1/shares"
+		switch {
+		case r.Method == "GET" && r.URL.Path == sharesPath:
+			p := r.URL.Query().Get("path")
+			if p == "/dir/missing" {
+				reply(w, http.StatusNotFound, "Wrong path, file/folder doesn't exist", []string{})
+				return
+			}
+			found := []map[string]interface{}{}
+			for _, share := range shares {
+				if p == "" || share["path"] == p {
+					found = append(found, share)
+				}
+			}
+			reply(w, http.StatusOK, "OK", found)
+		case r.Method == "POST" && r.URL.Path == sharesPath:
+			require.NoError(t, r.ParseForm())
+			shareType, _ := strconv.Atoi(r.PostForm.Get("shareType"))
+			permissions, _ := strconv.Atoi(r.PostForm.Get("permissions"))
+			share := map[string]interface{}{
+				"id":          strconv.Itoa(nextID),
+				"share_type":  shareType,
+				"uid_owner":   "alice",
+				"permissions": permissions,
+				"path":        r.PostForm.Get("path"),
+				"share_with":  r.PostForm.Get("shareWith"),
+				"expiration":  nil,
+			}
+			if password := r.PostForm.Get("password"); password != "" {
+				share["password"] = "hashed-" + password
+			}
+			share["label"] = r.PostForm.Get("label")
+			share["name"] = r.PostForm.Get("name")
+			if date := r.PostForm.Get("expireDate"); date != "" {
+				share["expiration"] = date + " 00:00:00"
+			}
+			if shareType == 3 {
+				share["url"] = fmt.Sprintf("https://example.com/s/token%d", nextID)
+			}
+			nextID++
+			shares = append(shares, share)
+			reply(w, http.StatusOK, "OK", share)
+		case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, sharesPath+"/"):
+			id := r.URL.Path[len(sharesPath)+1:]
+			for i, share := range shares {
+				if share["id"] == id {
+					shares = append(shares[:i], shares[i+1:]...)
+					reply(w, http.StatusOK, "OK", []string{})
+					return
+				}
+			}
+			reply(w, http.StatusNotFound, "Wrong share ID, share doesn't exist", []string{})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	ts := httptest.NewServer(handler)
+	configfile.Install()
+	m := configmap.Simple{
+		"type":                 "webdav",
+		"url":                  ts.URL + "/remote.php/dav/files/alice/dir/",
+		"vendor":               "nextcloud",
+		"nextcloud_chunk_size": "0",
+	}
+	return m, ts.Close
+}
+
+// TestShares tests public links and the share commands
+func TestShares(t *testing.T) {
+	ctx := context.Background()
+	m, tidy := prepareShares(t)
+	defer tidy()
+	f, err := webdav.NewFs(ctx, remoteName, "", m)
+	require.NoError(t, err)
+	publicLink := f.Features().PublicLink
+	require.NotNil(t, publicLink)
+	command := f.Features().Command
+	require.NotNil(t, command)
+
+	listShares := func() []webdav.ShareInfo {
+		out, err := command(ctx, "list-shares", nil, nil)
+		require.NoError(t, err)
+		return out.([]webdav.ShareInfo)
+	}
+
+	_, err = publicLink(ctx, "missing", fs.DurationOff, false)
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+
+	// the link is made once and reused
+	link, err := publicLink(ctx, "file.txt", fs.DurationOff, false)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/s/token1", link)
+	link, err = publicLink(ctx, "file.txt", fs.DurationOff, false)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/s/token1", link)
+
+	// a link with an expiry is a new link
+	link, err = publicLink(ctx, "file.txt", fs.Duration(24*time.Hour), false)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/s/token2", link)
+	shares := listShares()
+	require.Len(t, shares, 2)
+	assert.Equal(t, time.Now().AddDate(0, 0, 2).Format("2006-01-02"), shares[1].Expires)
+
+	out, err := command(ctx, "create-share", []string{"sub/file.txt"}, map[string]string{
+		"type":        "user",
+		"with":        "bob",
+		"permissions": "read,update",
+	})
+	require.NoError(t, err)
+	created := out.([]webdav.ShareInfo)
+	require.Len(t, created, 1)
+	assert.Equal(t, webdav.ShareInfo{
+		ID:          "3",
+		Path:        "sub/file.txt",
+		Type:        "user",
+		With:        "bob",
+		Permissions: 3,
+		Owner:       "alice",
+	}, created[0])
+
+	_, err = command(ctx, "create-share", []string{"file.txt"}, map[string]string{"type": "user"})
+	assert.Error(t, err)
+	_, err = command(ctx, "create-share", []string{"file.txt"}, map[string]string{"permissions": "potato"})
+	assert.Error(t, err)
+
+	// links with a password or a name aren't reused
+	for _, opt := range []map[string]string{{"password": "secret"}, {"name": "for bob"}} {
+		opt["type"] = "public"
+		_, err = command(ctx, "create-share", []string{"file.txt"}, opt)
+		require.NoError(t, err)
+	}
+	link, err = publicLink(ctx, "file.txt", fs.DurationOff, false)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/s/token1", link)
+
+	listIDs := func() (ids []string) {
+		for _, share := range listShares() {
+			ids = append(ids, share.ID)
+		}
+		return ids
+	}
+
+	// unlink without an expiry removes a link with one
+	link, err = publicLink(ctx, "sub/file.txt", fs.Duration(24*time.Hour), false)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/s/token6", link)
+	_, err = publicLink(ctx, "sub/file.txt", fs.DurationOff, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, listIDs())
+
+	// unlink with a different expiry removes all the plain links but
+	// not those with a password or a name
+	_, err = publicLink(ctx, "file.txt", fs.Duration(48*time.Hour), true)
+	require.NoError(t, err)
+	ids := listIDs()
+	assert.Equal(t, []string{"3", "4", "5"}, ids)
+	_, err = publicLink(ctx, "file.txt", fs.DurationOff, true)
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+	assert.Len(t, listShares(), 3)
+
+	for _, id := range ids {
+		_, err = command(ctx, "delete-share", []string{id}, nil)
+		require.NoError(t, err)
+	}
+	assert.Len(t, listShares(), 0)
+	_, err = command(ctx, "delete-share", []string{"3"}, nil)
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+}
@@ -41,6 +41,7 @@ docs = [
     "combine.md",
     "dropbox.md",
     "filefabric.md",
+    "erasure.md",
     "ftp.md",
     "googlecloudstorage.md",
     "drive.md",
@@ -72,9 +73,11 @@ docs = [
     "smb.md",
     "storj.md",
     "sugarsync.md",
+    "trash.md",
     "tardigrade.md",            # stub only to redirect to storj.md
     "uptobox.md",
     "union.md",
+    "versioning.md",
     "webdav.md",
     "yandex.md",
     "zoho.md",
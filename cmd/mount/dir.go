@@ -129,6 +129,8 @@ func (d *Dir) ReadDirAll(ctx context.Context) (dirents []fuse.Dirent, err error)
 		}
 		if node.IsDir() {
 			dirent.Type = fuse.DT_Dir
+		} else if node.Mode()&os.ModeSymlink != 0 {
+			dirent.Type = fuse.DT_Link
 		}
 		dirents = append(dirents, dirent)
 	}
@@ -168,6 +170,20 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (node fusefs.No
 	return node, nil
 }
 
+var _ fusefs.NodeSymlinker = (*Dir)(nil)
+
+// Symlink creates a new symlink in the receiver
+func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (node fusefs.Node, err error) {
+	defer log.Trace(d, "newName=%q, target=%q", req.NewName, req.Target)("node=%v, err=%v", &node, &err)
+	file, err := d.Dir.Symlink(req.Target, req.NewName)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	node = &File{file, d.fsys}
+	file.SetSys(node) // cache the FUSE node for later
+	return node, nil
+}
+
 var _ fusefs.NodeRemover = (*Dir)(nil)
 
 // Remove removes the entry with the given name from
@@ -269,3 +285,41 @@ func (d *Dir) Mknod(ctx context.Context, req *fuse.MknodRequest) (node fusefs.No
 	}
 	return node, nil
 }
+
+// Getxattr gets an extended attribute by the given name from the
+// node.
+//
+// If there is no xattr by that name, returns fuse.ErrNoXattr.
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) (err error) {
+	defer log.Trace(d, "name=%q", req.Name)("err=%v", &err)
+	return getxattr(d.Dir, req.Name, resp)
+}
+
+var _ fusefs.NodeGetxattrer = (*Dir)(nil)
+
+// Listxattr lists the extended attributes recorded for the node.
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) (err error) {
+	defer log.Trace(d, "")("err=%v", &err)
+	return listxattr(d.Dir, resp)
+}
+
+var _ fusefs.NodeListxattrer = (*Dir)(nil)
+
+// Setxattr sets an extended attribute with the given name and
+// value for the node.
+func (d *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) (err error) {
+	defer log.Trace(d, "name=%q", req.Name)("err=%v", &err)
+	return setxattr(d.Dir, req.Name, req.Xattr)
+}
+
+var _ fusefs.NodeSetxattrer = (*Dir)(nil)
+
+// Removexattr removes an extended attribute for the name.
+//
+// If there is no xattr by that name, returns fuse.ErrNoXattr.
+func (d *Dir) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) (err error) {
+	defer log.Trace(d, "name=%q", req.Name)("err=%v", &err)
+	return removexattr(d.Dir, req.Name)
+}
+
+var _ fusefs.NodeRemovexattrer = (*Dir)(nil)
@@ -5,7 +5,7 @@ package mount
 
 import (
 	"context"
-	"syscall"
+	"os"
 	"time"
 
 	"bazil.org/fuse"
@@ -30,9 +30,8 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 	modTime := f.File.ModTime()
 	Size := uint64(f.File.Size())
 	Blocks := (Size + 511) / 512
-	a.Gid = f.VFS().Opt.GID
-	a.Uid = f.VFS().Opt.UID
-	a.Mode = f.VFS().Opt.FilePerms
+	a.Uid, a.Gid = f.File.Owner()
+	a.Mode = f.File.Mode() & (os.ModePerm | os.ModeSymlink)
 	a.Size = Size
 	a.Atime = modTime
 	a.Mtime = modTime
@@ -44,7 +43,8 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 // Check interface satisfied
 var _ fusefs.NodeSetattrer = (*File)(nil)
 
-// Setattr handles attribute changes from FUSE. Currently supports ModTime and Size only
+// Setattr handles attribute changes from FUSE. Currently supports ModTime,
+// Size, Mode and ownership only
 func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) (err error) {
 	defer log.Trace(f, "a=%+v", req)("err=%v", &err)
 	if !f.VFS().Opt.NoModTime {
@@ -57,6 +57,19 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse
 	if req.Valid.Size() {
 		err = f.File.Truncate(int64(req.Size))
 	}
+	if err == nil && req.Valid.Mode() {
+		err = f.File.Chmod(req.Mode)
+	}
+	if err == nil && (req.Valid.Uid() || req.Valid.Gid()) {
+		uid, gid := ^uint32(0), ^uint32(0)
+		if req.Valid.Uid() {
+			uid = req.Uid
+		}
+		if req.Valid.Gid() {
+			gid = req.Gid
+		}
+		err = f.File.Chown(uid, gid)
+	}
 	return translateError(err)
 }
 
@@ -82,6 +95,16 @@ func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenR
 	return &FileHandle{handle}, nil
 }
 
+// Check interface satisfied
+var _ fusefs.NodeReadlinker = (*File)(nil)
+
+// Readlink reads the target of a symlink
+func (f *File) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (target string, err error) {
+	defer log.Trace(f, "")("target=%q, err=%v", &target, &err)
+	target, err = f.File.Readlink()
+	return target, translateError(err)
+}
+
 // Check interface satisfied
 var _ fusefs.NodeFsyncer = (*File)(nil)
 
@@ -97,23 +120,26 @@ func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) (err error) {
 // node.
 //
 // If there is no xattr by that name, returns fuse.ErrNoXattr.
-func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
-	return syscall.ENOSYS // we never implement this
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) (err error) {
+	defer log.Trace(f, "name=%q", req.Name)("err=%v", &err)
+	return getxattr(f.File, req.Name, resp)
 }
 
 var _ fusefs.NodeGetxattrer = (*File)(nil)
 
 // Listxattr lists the extended attributes recorded for the node.
-func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
-	return syscall.ENOSYS // we never implement this
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) (err error) {
+	defer log.Trace(f, "")("err=%v", &err)
+	return listxattr(f.File, resp)
 }
 
 var _ fusefs.NodeListxattrer = (*File)(nil)
 
 // Setxattr sets an extended attribute with the given name and
 // value for the node.
-func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
-	return syscall.ENOSYS // we never implement this
+func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) (err error) {
+	defer log.Trace(f, "name=%q", req.Name)("err=%v", &err)
+	return setxattr(f.File, req.Name, req.Xattr)
 }
 
 var _ fusefs.NodeSetxattrer = (*File)(nil)
@@ -121,8 +147,9 @@ var _ fusefs.NodeSetxattrer = (*File)(nil)
 // Removexattr removes an extended attribute for the name.
 //
 // If there is no xattr by that name, returns fuse.ErrNoXattr.
-func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
-	return syscall.ENOSYS // we never implement this
+func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) (err error) {
+	defer log.Trace(f, "name=%q", req.Name)("err=%v", &err)
+	return removexattr(f.File, req.Name)
 }
 
 var _ fusefs.NodeRemovexattrer = (*File)(nil)
@@ -99,6 +99,16 @@ func translateError(err error) error {
 		return fuse.Errno(syscall.EROFS)
 	case vfs.ENOSYS, fs.ErrorNotImplemented:
 		return syscall.ENOSYS
+	case vfs.ENOATTR:
+		return fuse.ErrNoXattr
+	case vfs.ENOTSUP:
+		return fuse.Errno(syscall.ENOTSUP)
+	case vfs.EAGAIN:
+		return fuse.Errno(syscall.EAGAIN)
+	case vfs.EINTR:
+		return fuse.Errno(syscall.EINTR)
+	case vfs.EBUSY:
+		return fuse.Errno(syscall.EBUSY)
 	case vfs.EINVAL:
 		return fuse.Errno(syscall.EINVAL)
 	}
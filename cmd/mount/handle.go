@@ -68,6 +68,8 @@ var _ fusefs.HandleFlusher = (*FileHandle)(nil)
 // some writes, or that if will be called at all.
 func (fh *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) (err error) {
 	defer log.Trace(fh, "")("err=%v", &err)
+	// POSIX locks are released on every close
+	fh.Node().VFS().ReleaseLocks(fh.Node(), uint64(req.LockOwner), false)
 	return translateError(fh.Handle.Flush())
 }
 
@@ -79,5 +81,8 @@ var _ fusefs.HandleReleaser = (*FileHandle)(nil)
 // the kernel
 func (fh *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
 	defer log.Trace(fh, "")("err=%v", &err)
+	if req.ReleaseFlags&fuse.ReleaseFlockUnlock != 0 {
+		fh.Node().VFS().ReleaseLocks(fh.Node(), uint64(req.LockOwner), true)
+	}
 	return translateError(fh.Handle.Release())
 }
@@ -0,0 +1,135 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+package mount
+
+import (
+	"context"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/vfs"
+)
+
+// vfsLock converts a fuse lock request into a vfs.Lock
+func vfsLock(req *fuse.LockRequest) vfs.Lock {
+	lk := vfs.Lock{
+		Start: req.Lock.Start,
+		End:   req.Lock.End,
+		Owner: uint64(req.LockOwner),
+		PID:   uint32(req.Lock.PID),
+		Flock: req.LockFlags&fuse.LockFlock != 0,
+	}
+	switch req.Lock.Type {
+	case fuse.LockRead:
+		lk.Type = vfs.LockRead
+	case fuse.LockWrite:
+		lk.Type = vfs.LockWrite
+	default:
+		lk.Type = vfs.LockUnlock
+	}
+	return lk
+}
+
+// setLock takes or releases the lock in req on node
+func setLock(node vfs.Node, req *fuse.LockRequest) error {
+	return translateError(node.VFS().SetLock(node, vfsLock(req)))
+}
+
+// setLockWait takes the lock in req on node waiting until it is free
+func setLockWait(ctx context.Context, node vfs.Node, req *fuse.LockRequest) error {
+	return translateError(node.VFS().SetLockWait(ctx, node, vfsLock(req)))
+}
+
+// queryLock reads the first lock on node conflicting with req into resp
+func queryLock(node vfs.Node, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) error {
+	lk := vfsLock(&fuse.LockRequest{
+		LockOwner: req.LockOwner,
+		Lock:      req.Lock,
+		LockFlags: req.LockFlags,
+	})
+	conflict := node.VFS().GetLock(node, lk)
+	if conflict == nil {
+		return nil
+	}
+	resp.Lock = fuse.FileLock{
+		Start: conflict.Start,
+		End:   conflict.End,
+		Type:  fuse.LockRead,
+		PID:   int32(conflict.PID),
+	}
+	if conflict.Type == vfs.LockWrite {
+		resp.Lock.Type = fuse.LockWrite
+	}
+	return nil
+}
+
+// Check interface satisfied
+var _ fusefs.HandlePOSIXLocker = (*FileHandle)(nil)
+var _ fusefs.HandleFlockLocker = (*FileHandle)(nil)
+
+// Lock tries to take a lock on a byte range of the file returning
+// EAGAIN if a conflicting lock is held
+func (fh *FileHandle) Lock(ctx context.Context, req *fuse.LockRequest) (err error) {
+	defer log.Trace(fh, "req=%v", req)("err=%v", &err)
+	return setLock(fh.Node(), req)
+}
+
+// LockWait takes a lock on a byte range of the file waiting until it
+// can be taken
+func (fh *FileHandle) LockWait(ctx context.Context, req *fuse.LockWaitRequest) (err error) {
+	defer log.Trace(fh, "req=%v", req)("err=%v", &err)
+	return setLockWait(ctx, fh.Node(), (*fuse.LockRequest)(req))
+}
+
+// Unlock releases a lock on a byte range of the file
+func (fh *FileHandle) Unlock(ctx context.Context, req *fuse.UnlockRequest) (err error) {
+	defer log.Trace(fh, "req=%v", req)("err=%v", &err)
+	return setLock(fh.Node(), (*fuse.LockRequest)(req))
+}
+
+// QueryLock returns a lock which would conflict with the one in req
+func (fh *FileHandle) QueryLock(ctx context.Context, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) (err error) {
+	defer log.Trace(fh, "req=%v", req)("resp=%v, err=%v", resp, &err)
+	return queryLock(fh.Node(), req, resp)
+}
+
+// Check interface satisfied
+var _ fusefs.HandleFlockLocker = (*Dir)(nil)
+
+// Lock tries to take a lock on the directory returning EAGAIN if a
+// conflicting lock is held
+func (d *Dir) Lock(ctx context.Context, req *fuse.LockRequest) (err error) {
+	defer log.Trace(d, "req=%v", req)("err=%v", &err)
+	return setLock(d.Dir, req)
+}
+
+// LockWait takes a lock on the directory waiting until it can be taken
+func (d *Dir) LockWait(ctx context.Context, req *fuse.LockWaitRequest) (err error) {
+	defer log.Trace(d, "req=%v", req)("err=%v", &err)
+	return setLockWait(ctx, d.Dir, (*fuse.LockRequest)(req))
+}
+
+// Unlock releases a lock on the directory
+func (d *Dir) Unlock(ctx context.Context, req *fuse.UnlockRequest) (err error) {
+	defer log.Trace(d, "req=%v", req)("err=%v", &err)
+	return setLock(d.Dir, (*fuse.LockRequest)(req))
+}
+
+// QueryLock returns a lock which would conflict with the one in req
+func (d *Dir) QueryLock(ctx context.Context, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) (err error) {
+	defer log.Trace(d, "req=%v", req)("resp=%v, err=%v", resp, &err)
+	return queryLock(d.Dir, req, resp)
+}
+
+// Release is called when the directory handle is closed
+//
+// This releases any flock locks taken through it
+func (d *Dir) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
+	defer log.Trace(d, "")("err=%v", &err)
+	if req.ReleaseFlags&fuse.ReleaseFlockUnlock != 0 {
+		d.VFS().ReleaseLocks(d.Dir, uint64(req.LockOwner), true)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+package mount
+
+import (
+	"bazil.org/fuse"
+	"github.com/rclone/rclone/vfs"
+)
+
+// getxattr reads the extended attribute name from node
+func getxattr(node vfs.Node, name string, resp *fuse.GetxattrResponse) error {
+	value, err := vfs.Getxattr(node, name)
+	if err != nil {
+		return translateError(err)
+	}
+	resp.Xattr = value
+	return nil
+}
+
+// listxattr lists the extended attributes set on node
+func listxattr(node vfs.Node, resp *fuse.ListxattrResponse) error {
+	names, err := vfs.Listxattr(node)
+	if err != nil {
+		return translateError(err)
+	}
+	resp.Append(names...)
+	return nil
+}
+
+// setxattr sets the extended attribute name on node to value
+func setxattr(node vfs.Node, name string, value []byte) error {
+	return translateError(vfs.Setxattr(node, name, value))
+}
+
+// removexattr removes the extended attribute name from node
+func removexattr(node vfs.Node, name string) error {
+	return translateError(vfs.Removexattr(node, name))
+}
@@ -54,6 +54,8 @@ func getMode(node os.FileInfo) uint32 {
 	Mode := node.Mode().Perm()
 	if node.IsDir() {
 		Mode |= fuse.S_IFDIR
+	} else if node.Mode()&os.ModeSymlink != 0 {
+		Mode |= fuse.S_IFLNK
 	} else {
 		Mode |= fuse.S_IFREG
 	}
@@ -67,9 +69,7 @@ func setAttr(node vfs.Node, attr *fuse.Attr) {
 	Blocks := (Size + BlockSize - 1) / BlockSize
 	modTime := node.ModTime()
 	// set attributes
-	vfs := node.VFS()
-	attr.Owner.Gid = vfs.Opt.GID
-	attr.Owner.Uid = vfs.Opt.UID
+	attr.Owner.Uid, attr.Owner.Gid = node.Owner()
 	attr.Mode = getMode(node)
 	attr.Size = Size
 	attr.Nlink = 1
@@ -126,6 +126,16 @@ func translateError(err error) syscall.Errno {
 		return syscall.EROFS
 	case vfs.ENOSYS, fs.ErrorNotImplemented:
 		return syscall.ENOSYS
+	case vfs.ENOATTR:
+		return syscall.ENODATA
+	case vfs.ENOTSUP:
+		return syscall.ENOTSUP
+	case vfs.EAGAIN:
+		return syscall.EAGAIN
+	case vfs.EINTR:
+		return syscall.EINTR
+	case vfs.EBUSY:
+		return syscall.EBUSY
 	case vfs.EINVAL:
 		return syscall.EINVAL
 	}
@@ -0,0 +1,115 @@
+//go:build linux || (darwin && amd64)
+// +build linux darwin,amd64
+
+package mount2
+
+import (
+	"context"
+	"syscall"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/vfs"
+)
+
+// vfsLock converts a fuse lock into a vfs.Lock
+func vfsLock(owner uint64, lk *fuse.FileLock, flags uint32) vfs.Lock {
+	lock := vfs.Lock{
+		Start: lk.Start,
+		End:   lk.End,
+		Owner: owner,
+		PID:   lk.Pid,
+		Flock: flags&fuse.FUSE_LK_FLOCK != 0,
+	}
+	switch lk.Typ {
+	case syscall.F_RDLCK:
+		lock.Type = vfs.LockRead
+	case syscall.F_WRLCK:
+		lock.Type = vfs.LockWrite
+	default:
+		lock.Type = vfs.LockUnlock
+	}
+	return lock
+}
+
+// addLockOwner records that owner has taken locks through this handle
+func (f *FileHandle) addLockOwner(lk vfs.Lock) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lockOwners == nil {
+		f.lockOwners = make(map[uint64]bool)
+	}
+	f.lockOwners[lk.Owner] = lk.Flock
+}
+
+// releaseLocks releases the POSIX locks taken through this handle,
+// and the flock locks too if flock is set.
+//
+// go-fuse doesn't pass the lock owner to Flush and Release so this
+// releases the locks of all the owners which used the handle.
+func (f *FileHandle) releaseLocks(flock bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	node := f.h.Node()
+	for owner, isFlock := range f.lockOwners {
+		if isFlock && !flock {
+			continue
+		}
+		node.VFS().ReleaseLocks(node, owner, isFlock)
+		delete(f.lockOwners, owner)
+	}
+}
+
+// Getlk returns locks that would conflict with the given input
+// lock. If no locks conflict, the output has type L_UNLCK.
+func (f *FileHandle) Getlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=%d, lk=%v, flags=%d", owner, lk, flags)("out=%v, errno=%v", out, &errno)
+	node := f.h.Node()
+	conflict := node.VFS().GetLock(node, vfsLock(owner, lk, flags))
+	if conflict == nil {
+		*out = fuse.FileLock{Typ: syscall.F_UNLCK}
+		return 0
+	}
+	*out = fuse.FileLock{
+		Start: conflict.Start,
+		End:   conflict.End,
+		Typ:   syscall.F_RDLCK,
+		Pid:   conflict.PID,
+	}
+	if conflict.Type == vfs.LockWrite {
+		out.Typ = syscall.F_WRLCK
+	}
+	return 0
+}
+
+var _ fusefs.FileGetlker = (*FileHandle)(nil)
+
+// Setlk obtains a lock on a file, or fail if the lock could not
+// obtained.
+func (f *FileHandle) Setlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=%d, lk=%v, flags=%d", owner, lk, flags)("errno=%v", &errno)
+	node := f.h.Node()
+	lock := vfsLock(owner, lk, flags)
+	err := node.VFS().SetLock(node, lock)
+	if err == nil && lock.Type != vfs.LockUnlock {
+		f.addLockOwner(lock)
+	}
+	return translateError(err)
+}
+
+var _ fusefs.FileSetlker = (*FileHandle)(nil)
+
+// Setlkw obtains a lock on a file, waiting if necessary.
+func (f *FileHandle) Setlkw(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=%d, lk=%v, flags=%d", owner, lk, flags)("errno=%v", &errno)
+	node := f.h.Node()
+	lock := vfsLock(owner, lk, flags)
+	err := node.VFS().SetLockWait(ctx, node, lock)
+	if err == nil && lock.Type != vfs.LockUnlock {
+		f.addLockOwner(lock)
+	}
+	return translateError(err)
+}
+
+var _ fusefs.FileSetlkwer = (*FileHandle)(nil)
@@ -139,6 +139,30 @@ func (n *Node) Setattr(ctx context.Context, f fusefs.FileHandle, in *fuse.SetAtt
 		out.Attr.Mtime = uint64(mtime.Unix())
 		out.Attr.Mtimensec = uint32(mtime.Nanosecond())
 	}
+	mode, ok := in.GetMode()
+	if ok {
+		err = n.node.Chmod(os.FileMode(mode).Perm())
+		if err != nil {
+			return translateError(err)
+		}
+	}
+	uid, uidOK := in.GetUID()
+	gid, gidOK := in.GetGID()
+	if uidOK || gidOK {
+		if !uidOK {
+			uid = ^uint32(0)
+		}
+		if !gidOK {
+			gid = ^uint32(0)
+		}
+		err = n.node.Chown(uid, gid)
+		if err != nil {
+			return translateError(err)
+		}
+	}
+	if ok || uidOK || gidOK {
+		n.fsys.setAttrOut(n.node, out)
+	}
 	return 0
 }
 
@@ -313,6 +337,42 @@ func (n *Node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.En
 
 var _ = (fusefs.NodeMkdirer)((*Node)(nil))
 
+// Symlink is similar to Lookup, but must create a symlink entry and
+// Inode.
+func (n *Node) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (inode *fusefs.Inode, errno syscall.Errno) {
+	defer log.Trace(name, "target=%q", target)("inode=%v, errno=%v", &inode, &errno)
+	dir, ok := n.node.(*vfs.Dir)
+	if !ok {
+		return nil, syscall.ENOTDIR
+	}
+	file, err := dir.Symlink(target, name)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	newNode := newNode(n.fsys, file)
+	n.fsys.setEntryOut(newNode.node, out)
+	newInode := n.NewInode(ctx, newNode, fusefs.StableAttr{Mode: out.Attr.Mode})
+	return newInode, 0
+}
+
+var _ = (fusefs.NodeSymlinker)((*Node)(nil))
+
+// Readlink reads the content of a symlink.
+func (n *Node) Readlink(ctx context.Context) (target []byte, errno syscall.Errno) {
+	defer log.Trace(n, "")("target=%q, errno=%v", &target, &errno)
+	file, ok := n.node.(*vfs.File)
+	if !ok {
+		return nil, syscall.EINVAL
+	}
+	link, err := file.Readlink()
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return []byte(link), 0
+}
+
+var _ = (fusefs.NodeReadlinker)((*Node)(nil))
+
 // Create is similar to Lookup, but should create a new
 // child. It typically also returns a FileHandle as a
 // reference for future reads/writes.
@@ -409,8 +469,13 @@ var _ = (fusefs.NodeRenamer)((*Node)(nil))
 // `dest` and return the number of bytes. If `dest` is too
 // small, it should return ERANGE and the size of the attribute.
 // If not defined, Getxattr will return ENOATTR.
-func (n *Node) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
-	return 0, syscall.ENOSYS // we never implement this
+func (n *Node) Getxattr(ctx context.Context, attr string, dest []byte) (size uint32, errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q", attr)("size=%d, errno=%v", &size, &errno)
+	value, err := vfs.Getxattr(n.node, attr)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return copyXattr(dest, value)
 }
 
 var _ fusefs.NodeGetxattrer = (*Node)(nil)
@@ -418,16 +483,18 @@ var _ fusefs.NodeGetxattrer = (*Node)(nil)
 // Setxattr should store data for the given attribute.  See
 // setxattr(2) for information about flags.
 // If not defined, Setxattr will return ENOATTR.
-func (n *Node) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
-	return syscall.ENOSYS // we never implement this
+func (n *Node) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q, flags=0x%X", attr, flags)("errno=%v", &errno)
+	return translateError(vfs.Setxattr(n.node, attr, data))
 }
 
 var _ fusefs.NodeSetxattrer = (*Node)(nil)
 
 // Removexattr should delete the given attribute.
 // If not defined, Removexattr will return ENOATTR.
-func (n *Node) Removexattr(ctx context.Context, attr string) syscall.Errno {
-	return syscall.ENOSYS // we never implement this
+func (n *Node) Removexattr(ctx context.Context, attr string) (errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q", attr)("errno=%v", &errno)
+	return translateError(vfs.Removexattr(n.node, attr))
 }
 
 var _ fusefs.NodeRemovexattrer = (*Node)(nil)
@@ -436,8 +503,27 @@ var _ fusefs.NodeRemovexattrer = (*Node)(nil)
 // `dest`. If the `dest` buffer is too small, it should return ERANGE
 // and the correct size.  If not defined, return an empty list and
 // success.
-func (n *Node) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
-	return 0, syscall.ENOSYS // we never implement this
+func (n *Node) Listxattr(ctx context.Context, dest []byte) (size uint32, errno syscall.Errno) {
+	defer log.Trace(n, "")("size=%d, errno=%v", &size, &errno)
+	names, err := vfs.Listxattr(n.node)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	var list []byte
+	for _, name := range names {
+		list = append(list, name...)
+		list = append(list, 0)
+	}
+	return copyXattr(dest, list)
+}
+
+// copyXattr copies value into dest returning ERANGE and the size
+// needed if it doesn't fit
+func copyXattr(dest, value []byte) (uint32, syscall.Errno) {
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), 0
 }
 
 var _ fusefs.NodeListxattrer = (*Node)(nil)
@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package mount2
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeXattr(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("potato"), 0666))
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+	opt := vfscommon.DefaultOpt
+	VFS := vfs.New(f, &opt)
+	defer VFS.Shutdown()
+	vfsNode, err := VFS.Stat("file")
+	require.NoError(t, err)
+	n := &Node{node: vfsNode}
+
+	// The metadata of the file can be read
+	buf := make([]byte, 64)
+	size, errno := n.Getxattr(ctx, vfs.XattrPrefix+"mode", buf)
+	assert.Equal(t, syscall.Errno(0), errno)
+	assert.Equal(t, "100", string(buf[:3]))
+	_, errno = n.Getxattr(ctx, vfs.XattrPrefix+"mode", buf[:1])
+	assert.Equal(t, syscall.ERANGE, errno)
+	_, errno = n.Getxattr(ctx, vfs.XattrPrefix+"potato", nil)
+	assert.Equal(t, syscall.ENODATA, errno)
+
+	// and changed
+	assert.Equal(t, syscall.Errno(0), n.Setxattr(ctx, vfs.XattrPrefix+"potato", []byte("jersey royal"), 0))
+	size, errno = n.Getxattr(ctx, vfs.XattrPrefix+"potato", buf)
+	assert.Equal(t, syscall.Errno(0), errno)
+	assert.Equal(t, "jersey royal", string(buf[:size]))
+
+	// and listed
+	size, errno = n.Listxattr(ctx, buf[:1])
+	assert.Equal(t, syscall.ERANGE, errno)
+	buf = make([]byte, size)
+	size, errno = n.Listxattr(ctx, buf)
+	assert.Equal(t, syscall.Errno(0), errno)
+	assert.Equal(t, uint32(len(buf)), size)
+	names := strings.Split(strings.TrimSuffix(string(buf), "\x00"), "\x00")
+	assert.Contains(t, names, vfs.XattrPrefix+"mode")
+	assert.Contains(t, names, vfs.XattrPrefix+"potato")
+
+	// Pinning is controlled with an attribute
+	_, errno = n.Getxattr(ctx, vfs.PinXattr, nil)
+	assert.Equal(t, syscall.ENODATA, errno)
+	assert.Equal(t, syscall.ENOTSUP, n.Setxattr(ctx, vfs.PinXattr, []byte("1"), 0))
+	VFS.SetCacheMode(vfscommon.CacheModeFull)
+	assert.Equal(t, syscall.Errno(0), n.Setxattr(ctx, vfs.PinXattr, []byte("1"), 0))
+	assert.True(t, VFS.IsPinned("file"))
+	size, errno = n.Getxattr(ctx, vfs.PinXattr, buf)
+	assert.Equal(t, syscall.Errno(0), errno)
+	assert.Equal(t, "1", string(buf[:size]))
+	size, errno = n.Listxattr(ctx, nil)
+	assert.Equal(t, syscall.ERANGE, errno)
+	buf = make([]byte, size)
+	_, errno = n.Listxattr(ctx, buf)
+	assert.Equal(t, syscall.Errno(0), errno)
+	assert.True(t, strings.HasPrefix(string(buf), vfs.PinXattr+"\x00"))
+	assert.Equal(t, syscall.Errno(0), n.Removexattr(ctx, vfs.PinXattr))
+	assert.Equal(t, syscall.ENODATA, n.Removexattr(ctx, vfs.PinXattr))
+	assert.False(t, VFS.IsPinned("file"))
+
+	// Other attributes aren't supported
+	assert.Equal(t, syscall.ENOTSUP, n.Setxattr(ctx, "user.potato", []byte("1"), 0))
+}
@@ -56,8 +56,8 @@ type conn struct {
 }
 
 // execCommand implements an extremely limited number of commands to
-// interoperate with the rclone sftp backend
-func (c *conn) execCommand(ctx context.Context, out io.Writer, command string) (err error) {
+// interoperate with the rclone sftp backend and scp clients
+func (c *conn) execCommand(ctx context.Context, in io.Reader, out io.Writer, command string) (err error) {
 	binary, args := command, ""
 	space := strings.Index(command, " ")
 	if space >= 0 {
@@ -67,6 +67,8 @@ func (c *conn) execCommand(ctx context.Context, out io.Writer, command string) (
 	args = shellUnEscape(args)
 	fs.Debugf(c.what, "exec command: binary = %q, args = %q", binary, args)
 	switch binary {
+	case "scp":
+		return serveSCP(c.vfs, in, out, args, c.what)
 	case "df":
 		about := c.vfs.Fs().Features().About
 		if about == nil {
@@ -243,7 +245,7 @@ func (c *conn) handleChannel(newChannel ssh.NewChannel) {
 		}
 	} else {
 		var rc = uint32(0)
-		err := c.execCommand(context.TODO(), channel, command.Command)
+		err := c.execCommand(context.TODO(), channel, channel, command.Command)
 		if err != nil {
 			rc = 1
 			_, errPrint := fmt.Fprintf(channel.Stderr(), "%v\n", err)
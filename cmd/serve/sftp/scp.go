@@ -0,0 +1,499 @@
+//go:build !plan9
+// +build !plan9
+
+package sftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// This implements the server side of the legacy scp (rcp) protocol.
+//
+// The client runs "scp -t path" to send files to us (we are the
+// sink) or "scp -f path" to fetch files from us (we are the
+// source). Each protocol message is acknowledged with a single zero
+// byte, or with 0x01 (warning) or 0x02 (fatal) followed by an error
+// message terminated by a newline.
+
+// scp protocol response codes
+const (
+	scpOK      = 0
+	scpWarning = 1
+	scpFatal   = 2
+)
+
+// scpOptions are the parsed arguments of an scp command
+type scpOptions struct {
+	sink      bool   // -t: the client is sending files to us
+	source    bool   // -f: the client is fetching files from us
+	recursive bool   // -r: directories are allowed
+	preserve  bool   // -p: modification times are sent
+	targetDir bool   // -d: the target must be a directory
+	path      string // the path to read or write
+}
+
+// parseSCPArgs parses the arguments of the scp command.
+//
+// Everything after the flags is treated as the path so paths
+// containing spaces work.
+func parseSCPArgs(args string) (opt scpOptions, err error) {
+	args = strings.TrimLeft(args, " ")
+	for strings.HasPrefix(args, "-") {
+		var flag string
+		space := strings.Index(args, " ")
+		if space >= 0 {
+			flag, args = args[:space], strings.TrimLeft(args[space+1:], " ")
+		} else {
+			flag, args = args, ""
+		}
+		if flag == "--" {
+			break
+		}
+		for _, c := range flag[1:] {
+			switch c {
+			case 't':
+				opt.sink = true
+			case 'f':
+				opt.source = true
+			case 'r':
+				opt.recursive = true
+			case 'p':
+				opt.preserve = true
+			case 'd':
+				opt.targetDir = true
+			case 'v', 'q', 'E':
+				// verbose, quiet and extended protocol are ignored
+			default:
+				return opt, fmt.Errorf("scp: unknown flag %q", c)
+			}
+		}
+	}
+	if opt.sink == opt.source {
+		return opt, errors.New("scp: exactly one of -t or -f must be supplied")
+	}
+	// Remove quoting the client may have added
+	if len(args) >= 2 && args[0] == '\'' && args[len(args)-1] == '\'' {
+		args = args[1 : len(args)-1]
+	}
+	if args == "" || args == "~" {
+		args = "."
+	}
+	opt.path = strings.TrimPrefix(args, "~/")
+	return opt, nil
+}
+
+// scpSession holds the state for a single scp transfer
+type scpSession struct {
+	vfs  *vfs.VFS
+	opt  scpOptions
+	in   *bufio.Reader
+	out  io.Writer
+	what string
+	err  error // first non fatal error which occurred
+}
+
+// serveSCP runs the scp protocol described by args over in and out
+func serveSCP(VFS *vfs.VFS, in io.Reader, out io.Writer, args string, what string) error {
+	opt, err := parseSCPArgs(args)
+	if err != nil {
+		return err
+	}
+	s := &scpSession{
+		vfs:  VFS,
+		opt:  opt,
+		in:   bufio.NewReader(in),
+		out:  out,
+		what: what,
+	}
+	if opt.sink {
+		err = s.sink()
+	} else {
+		err = s.source()
+	}
+	if err != nil {
+		return err
+	}
+	return s.err
+}
+
+// ack sends a success response to the client
+func (s *scpSession) ack() error {
+	_, err := s.out.Write([]byte{scpOK})
+	return err
+}
+
+// sendError sends an error response to the client
+func (s *scpSession) sendError(code byte, err error) error {
+	fs.Debugf(s.what, "scp: %v", err)
+	msg := strings.ReplaceAll(err.Error(), "\n", " ")
+	_, writeErr := fmt.Fprintf(s.out, "%cscp: %s\n", code, msg)
+	return writeErr
+}
+
+// fatal sends a fatal error to the client and returns it so the
+// command exits with a non zero status
+func (s *scpSession) fatal(err error) error {
+	if writeErr := s.sendError(scpFatal, err); writeErr != nil {
+		return writeErr
+	}
+	return fmt.Errorf("scp: %w", err)
+}
+
+// warn sends a non fatal error to the client and remembers it so
+// the command can return a non zero exit status
+func (s *scpSession) warn(err error) error {
+	if s.err == nil {
+		s.err = err
+	}
+	return s.sendError(scpWarning, err)
+}
+
+// readResponse reads a response from the client
+func (s *scpSession) readResponse() error {
+	code, err := s.in.ReadByte()
+	if err != nil {
+		return err
+	}
+	if code == scpOK {
+		return nil
+	}
+	msg, err := s.in.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	msg = strings.TrimSuffix(msg, "\n")
+	if code == scpWarning {
+		fs.Debugf(s.what, "scp: client warning: %s", msg)
+		return nil
+	}
+	return fmt.Errorf("scp: client error: %s", msg)
+}
+
+// validSCPName returns an error if name is not a valid leaf name
+func validSCPName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	return nil
+}
+
+// parseSCPFileHeader parses the body of a "C" or "D" message which
+// looks like "0644 123 name"
+func parseSCPFileHeader(line string) (mode os.FileMode, size int64, name string, err error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("bad header %q", line)
+	}
+	perm, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("bad mode in header %q: %w", line, err)
+	}
+	size, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("bad size in header %q", line)
+	}
+	name = parts[2]
+	if err = validSCPName(name); err != nil {
+		return 0, 0, "", err
+	}
+	return os.FileMode(perm) & os.ModePerm, size, name, nil
+}
+
+// parseSCPTimes parses the body of a "T" message which looks like
+// "mtime 0 atime 0"
+func parseSCPTimes(line string) (mtime time.Time, err error) {
+	parts := strings.Split(line, " ")
+	if len(parts) != 4 {
+		return mtime, fmt.Errorf("bad time header %q", line)
+	}
+	secs, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return mtime, fmt.Errorf("bad mtime in header %q: %w", line, err)
+	}
+	usecs, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return mtime, fmt.Errorf("bad mtime in header %q: %w", line, err)
+	}
+	return time.Unix(secs, usecs*1000), nil
+}
+
+// sink receives files from the client
+func (s *scpSession) sink() error {
+	current := path.Clean(s.opt.path)
+	isDir := false
+	node, err := s.vfs.Stat(current)
+	if err == nil {
+		isDir = node.IsDir()
+	}
+	if s.opt.targetDir && !isDir {
+		return s.fatal(fmt.Errorf("%s: not a directory", s.opt.path))
+	}
+	if err = s.ack(); err != nil {
+		return err
+	}
+	var (
+		stack    []string
+		mtime    time.Time
+		hasMtime bool
+	)
+	for {
+		line, err := s.in.ReadString('\n')
+		if err == io.EOF && line == "" {
+			if len(stack) != 0 {
+				return errors.New("scp: unexpected end of input in directory")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return s.fatal(errors.New("empty protocol message"))
+		}
+		code, body := line[0], line[1:]
+		switch code {
+		case scpWarning:
+			fs.Debugf(s.what, "scp: client warning: %s", body)
+			continue
+		case scpFatal:
+			return fmt.Errorf("scp: client error: %s", body)
+		case 'T':
+			mtime, err = parseSCPTimes(body)
+			if err != nil {
+				return s.fatal(err)
+			}
+			hasMtime = true
+			if err = s.ack(); err != nil {
+				return err
+			}
+		case 'E':
+			if len(stack) == 0 {
+				return s.fatal(errors.New("unexpected end of directory"))
+			}
+			current, stack = stack[len(stack)-1], stack[:len(stack)-1]
+			isDir = true
+			if err = s.ack(); err != nil {
+				return err
+			}
+		case 'D':
+			if !s.opt.recursive {
+				return s.fatal(errors.New("received directory without -r"))
+			}
+			_, _, name, err := parseSCPFileHeader(body)
+			if err != nil {
+				return s.fatal(err)
+			}
+			dst := current
+			if isDir {
+				dst = path.Join(current, name)
+			}
+			if err = s.vfs.MkdirAll(dst, 0777); err != nil {
+				return s.fatal(fmt.Errorf("%s: %w", dst, err))
+			}
+			if hasMtime {
+				if err = s.vfs.Chtimes(dst, mtime, mtime); err != nil {
+					fs.Debugf(s.what, "scp: failed to set modification time on %q: %v", dst, err)
+				}
+				hasMtime = false
+			}
+			stack = append(stack, current)
+			current, isDir = dst, true
+			if err = s.ack(); err != nil {
+				return err
+			}
+		case 'C':
+			_, size, name, err := parseSCPFileHeader(body)
+			if err != nil {
+				return s.fatal(err)
+			}
+			dst := current
+			if isDir {
+				dst = path.Join(current, name)
+			}
+			if err = s.ack(); err != nil {
+				return err
+			}
+			if err = s.receiveFile(dst, size); err != nil {
+				return err
+			}
+			if hasMtime {
+				if err = s.vfs.Chtimes(dst, mtime, mtime); err != nil {
+					fs.Debugf(s.what, "scp: failed to set modification time on %q: %v", dst, err)
+				}
+				hasMtime = false
+			}
+		default:
+			return s.fatal(fmt.Errorf("unknown protocol message %q", line))
+		}
+	}
+}
+
+// receiveFile reads size bytes from the client into dst and sends
+// the response.
+//
+// Errors writing the file are sent to the client as warnings so the
+// rest of the transfer can continue.
+func (s *scpSession) receiveFile(dst string, size int64) error {
+	var writeErr error
+	handle, err := s.vfs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
+	if err != nil {
+		writeErr = err
+		_, err = io.CopyN(io.Discard, s.in, size)
+	} else {
+		var n int64
+		n, err = io.CopyN(handle, s.in, size)
+		if err != nil && n != size {
+			// Work out whether it was the read or the write which failed
+			if _, discardErr := io.CopyN(io.Discard, s.in, size-n); discardErr == nil {
+				writeErr, err = err, nil
+			}
+		}
+		closeErr := handle.Close()
+		if writeErr == nil && closeErr != nil {
+			writeErr = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("scp: failed to read file data: %w", err)
+	}
+	if err = s.readResponse(); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return s.warn(fmt.Errorf("%s: %w", dst, writeErr))
+	}
+	fs.Debugf(s.what, "scp: received %q (%d bytes)", dst, size)
+	return s.ack()
+}
+
+// source sends files to the client
+func (s *scpSession) source() error {
+	if err := s.readResponse(); err != nil {
+		return err
+	}
+	p := path.Clean(s.opt.path)
+	node, err := s.vfs.Stat(p)
+	if err != nil {
+		return s.warn(fmt.Errorf("%s: %w", s.opt.path, err))
+	}
+	return s.sendNode(node)
+}
+
+// sendTimes sends the modification time of node if required
+func (s *scpSession) sendTimes(node vfs.Node) error {
+	if !s.opt.preserve {
+		return nil
+	}
+	modTime := node.ModTime()
+	secs := modTime.Unix()
+	if _, err := fmt.Fprintf(s.out, "T%d 0 %d 0\n", secs, secs); err != nil {
+		return err
+	}
+	return s.readResponse()
+}
+
+// sendNode sends a file or directory to the client
+func (s *scpSession) sendNode(node vfs.Node) error {
+	if node.IsDir() {
+		return s.sendDir(node)
+	}
+	return s.sendFile(node)
+}
+
+// sendDir sends a directory and its contents to the client
+func (s *scpSession) sendDir(node vfs.Node) error {
+	if !s.opt.recursive {
+		return s.warn(fmt.Errorf("%s: not a regular file", node.Path()))
+	}
+	if err := s.sendTimes(node); err != nil {
+		return err
+	}
+	name := node.Name()
+	if name == "" || name == "/" {
+		name = "."
+	}
+	if _, err := fmt.Fprintf(s.out, "D%04o 0 %s\n", node.Mode().Perm(), name); err != nil {
+		return err
+	}
+	if err := s.readResponse(); err != nil {
+		return err
+	}
+	dir := node.(*vfs.Dir)
+	nodes, err := dir.ReadDirAll()
+	if err != nil {
+		if err = s.warn(fmt.Errorf("%s: %w", node.Path(), err)); err != nil {
+			return err
+		}
+	}
+	for _, child := range nodes {
+		if err = s.sendNode(child); err != nil {
+			return err
+		}
+	}
+	if _, err = fmt.Fprintf(s.out, "E\n"); err != nil {
+		return err
+	}
+	return s.readResponse()
+}
+
+// sendFile sends a single file to the client
+func (s *scpSession) sendFile(node vfs.Node) error {
+	handle, err := node.Open(os.O_RDONLY)
+	if err != nil {
+		return s.warn(fmt.Errorf("%s: %w", node.Path(), err))
+	}
+	defer func() {
+		if closeErr := handle.Close(); closeErr != nil {
+			fs.Debugf(s.what, "scp: failed to close %q: %v", node.Path(), closeErr)
+		}
+	}()
+	if err = s.sendTimes(node); err != nil {
+		return err
+	}
+	size := node.Size()
+	if _, err = fmt.Fprintf(s.out, "C%04o %d %s\n", node.Mode().Perm(), size, node.Name()); err != nil {
+		return err
+	}
+	if err = s.readResponse(); err != nil {
+		return err
+	}
+	n, err := io.CopyN(s.out, handle, size)
+	if err != nil {
+		// We have promised size bytes so pad the output then
+		// report the error
+		if _, padErr := io.CopyN(s.out, zeroReader{}, size-n); padErr != nil {
+			return padErr
+		}
+		if err = s.warn(fmt.Errorf("%s: %w", node.Path(), err)); err != nil {
+			return err
+		}
+		return s.readResponse()
+	}
+	if err = s.ack(); err != nil {
+		return err
+	}
+	fs.Debugf(s.what, "scp: sent %q (%d bytes)", node.Path(), size)
+	return s.readResponse()
+}
+
+// zeroReader is an io.Reader which returns an infinite stream of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
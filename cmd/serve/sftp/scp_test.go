@@ -0,0 +1,142 @@
+//go:build !plan9
+// +build !plan9
+
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSCPArgs(t *testing.T) {
+	for _, test := range []struct {
+		args    string
+		want    scpOptions
+		wantErr bool
+	}{
+		{args: "-t /dir", want: scpOptions{sink: true, path: "/dir"}},
+		{args: "-f -- file", want: scpOptions{source: true, path: "file"}},
+		{args: "-v -r -p -d -t -- 'a file'", want: scpOptions{sink: true, recursive: true, preserve: true, targetDir: true, path: "a file"}},
+		{args: "-rpt a file", want: scpOptions{sink: true, recursive: true, preserve: true, path: "a file"}},
+		{args: "-t", want: scpOptions{sink: true, path: "."}},
+		{args: "-f ~/file", want: scpOptions{source: true, path: "file"}},
+		{args: "/file", wantErr: true},
+		{args: "-t -f /file", wantErr: true},
+		{args: "-z -t /file", wantErr: true},
+	} {
+		got, err := parseSCPArgs(test.args)
+		if test.wantErr {
+			assert.Error(t, err, test.args)
+			continue
+		}
+		require.NoError(t, err, test.args)
+		assert.Equal(t, test.want, got, test.args)
+	}
+}
+
+func newTestSCPVFS(t *testing.T) *vfs.VFS {
+	f, err := fs.NewFs(context.Background(), ":memory:"+t.Name())
+	require.NoError(t, err)
+	VFS := vfs.New(f, nil)
+	t.Cleanup(VFS.Shutdown)
+	return VFS
+}
+
+func TestSCPSink(t *testing.T) {
+	VFS := newTestSCPVFS(t)
+	modTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	var in bytes.Buffer
+	fmt.Fprintf(&in, "D0755 0 dir\n")
+	fmt.Fprintf(&in, "T%d 0 %d 0\n", modTime.Unix(), modTime.Unix())
+	fmt.Fprintf(&in, "C0644 5 hello.txt\nhello\x00")
+	fmt.Fprintf(&in, "D0755 0 sub\n")
+	fmt.Fprintf(&in, "C0644 0 empty\n\x00")
+	fmt.Fprintf(&in, "E\n")
+	fmt.Fprintf(&in, "E\n")
+	var out bytes.Buffer
+
+	err := serveSCP(VFS, &in, &out, "-r -p -t /", "test")
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{0}, 10), out.Bytes())
+
+	data, err := VFS.ReadFile("dir/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	node, err := VFS.Stat("dir/hello.txt")
+	require.NoError(t, err)
+	assert.True(t, modTime.Equal(node.ModTime()))
+
+	node, err = VFS.Stat("dir/sub/empty")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), node.Size())
+}
+
+func TestSCPSinkErrors(t *testing.T) {
+	VFS := newTestSCPVFS(t)
+
+	// directory without -r
+	var out bytes.Buffer
+	err := serveSCP(VFS, bytes.NewBufferString("D0755 0 dir\n"), &out, "-t /", "test")
+	assert.EqualError(t, err, "scp: received directory without -r")
+	assert.Contains(t, out.String(), "\x02scp: received directory without -r")
+
+	// bad file name
+	out.Reset()
+	err = serveSCP(VFS, bytes.NewBufferString("C0644 1 ../x\n"), &out, "-t /", "test")
+	assert.ErrorContains(t, err, "invalid name")
+	assert.Contains(t, out.String(), "\x02scp: invalid name")
+
+	// target must be a directory
+	out.Reset()
+	err = serveSCP(VFS, bytes.NewBufferString(""), &out, "-d -t /missing", "test")
+	assert.EqualError(t, err, "scp: /missing: not a directory")
+	assert.Contains(t, out.String(), "\x02scp: /missing: not a directory")
+
+	// unknown protocol message
+	out.Reset()
+	err = serveSCP(VFS, bytes.NewBufferString("X\n"), &out, "-t /", "test")
+	assert.EqualError(t, err, `scp: unknown protocol message "X"`)
+	assert.Contains(t, out.String(), "\x02scp: unknown protocol message")
+}
+
+func TestSCPSource(t *testing.T) {
+	VFS := newTestSCPVFS(t)
+	require.NoError(t, VFS.Mkdir("dir", 0777))
+	h, err := VFS.Create("dir/file.txt")
+	require.NoError(t, err)
+	_, err = h.Write([]byte("potato"))
+	require.NoError(t, err)
+	require.NoError(t, h.Close())
+
+	// Single file
+	in := bytes.NewBuffer(bytes.Repeat([]byte{0}, 3))
+	var out bytes.Buffer
+	err = serveSCP(VFS, in, &out, "-f dir/file.txt", "test")
+	require.NoError(t, err)
+	mode := fmt.Sprintf("%04o", VFS.Opt.FilePerms.Perm())
+	assert.Equal(t, "C"+mode+" 6 file.txt\npotato\x00", out.String())
+
+	// Directory without -r
+	out.Reset()
+	err = serveSCP(VFS, bytes.NewBuffer([]byte{0}), &out, "-f dir", "test")
+	assert.Error(t, err)
+	assert.Equal(t, "\x01scp: dir: not a regular file\n", out.String())
+
+	// Directory with -r
+	out.Reset()
+	in = bytes.NewBuffer(bytes.Repeat([]byte{0}, 5))
+	err = serveSCP(VFS, in, &out, "-r -f dir", "test")
+	require.NoError(t, err)
+	dirMode := fmt.Sprintf("%04o", VFS.Opt.DirPerms.Perm())
+	assert.Equal(t, "D"+dirMode+" 0 dir\nC"+mode+" 6 file.txt\npotato\x00E\n", out.String())
+}
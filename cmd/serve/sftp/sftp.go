@@ -71,6 +71,12 @@ The server will respond to a small number of shell commands, mainly
 md5sum, sha1sum and df, which enable it to provide support for checksums
 and the about feature when accessed from an sftp remote.
 
+It also implements the server side of the legacy scp protocol so
+clients which only speak scp (or OpenSSH scp run with ` + "`-O`" + `) can
+upload and download files, including recursively with ` + "`-r`" + ` and
+preserving modification times with ` + "`-p`" + `. rsync over ssh is not
+supported.
+
 Note that this server uses standard 32 KiB packet payload size, which
 means you must not configure the client to expect anything else, e.g.
 with the [chunk_size](/sftp/#sftp-chunk-size) option on an sftp remote.
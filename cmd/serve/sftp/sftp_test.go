@@ -9,7 +9,9 @@
 package sftp
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"strings"
 	"testing"
 
@@ -19,7 +21,9 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 )
 
 const (
@@ -71,3 +75,53 @@ func TestSftp(t *testing.T) {
 
 	servetest.Run(t, "sftp", start)
 }
+
+// TestSCPExitStatus checks scp commands which fail exit with a non
+// zero status
+func TestSCPExitStatus(t *testing.T) {
+	f, err := fs.NewFs(context.Background(), ":memory:")
+	require.NoError(t, err)
+	opt := DefaultOpt
+	opt.ListenAddr = testBindAddress
+	opt.User = testUser
+	opt.Pass = testPass
+	w := newServer(context.Background(), f, &opt)
+	require.NoError(t, w.serve())
+	defer func() {
+		w.Close()
+		w.Wait()
+	}()
+
+	client, err := ssh.Dial("tcp", w.Addr(), &ssh.ClientConfig{
+		User:            testUser,
+		Auth:            []ssh.AuthMethod{ssh.Password(testPass)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+	run := func(command, input string) error {
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		defer func() {
+			_ = session.Close()
+		}()
+		session.Stdin = bytes.NewBufferString(input)
+		return session.Run(command)
+	}
+
+	require.NoError(t, run("scp -t /", "C0644 6 file.txt\npotato\x00"))
+	for _, test := range []struct {
+		command, input string
+	}{
+		{"scp -d -t /file.txt", ""},
+		{"scp -t /", "D0755 0 dir\n"},
+		{"scp -t /", "X\n"},
+	} {
+		err := run(test.command, test.input)
+		var exitErr *ssh.ExitError
+		require.True(t, errors.As(err, &exitErr), "%s %q: %v", test.command, test.input, err)
+		assert.Equal(t, 1, exitErr.ExitStatus(), "%s %q", test.command, test.input)
+	}
+}
@@ -35,6 +35,7 @@ require (
 	github.com/jcmturner/gokrb5/v8 v8.4.4
 	github.com/jzelinskie/whirlpool v0.0.0-20201016144138-0675e54bb004
 	github.com/klauspost/compress v1.16.5
+	github.com/klauspost/reedsolomon v1.11.7
 	github.com/koofr/go-httpclient v0.0.0-20230225102643-5d51a2e9dea6
 	github.com/koofr/go-koofrclient v0.0.0-20221207135200-cbd7fc9ad6a6
 	github.com/mattn/go-colorable v0.1.13
@@ -0,0 +1,13 @@
+package file
+
+import "errors"
+
+var (
+	// ErrLocked is returned from Lock when a non blocking lock
+	// can't be taken because another process holds the lock
+	ErrLocked = errors.New("file is locked by another process")
+
+	// ErrLockNotSupported is returned from Lock on platforms which
+	// don't support file locking
+	ErrLockNotSupported = errors.New("file locking not supported on this platform")
+)
@@ -0,0 +1,22 @@
+//go:build !windows && !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !windows,!linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package file
+
+import "os"
+
+// LockImplemented is a constant indicating whether Lock does anything
+const LockImplemented = false
+
+// Lock takes an advisory lock on the whole of f which is shared
+// between processes.
+//
+// This isn't supported on this platform so it returns ErrLockNotSupported
+func Lock(f *os.File, exclusive, wait bool) error {
+	return ErrLockNotSupported
+}
+
+// Unlock releases a lock taken with Lock
+func Unlock(f *os.File) error {
+	return ErrLockNotSupported
+}
@@ -0,0 +1,44 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	if !LockImplemented {
+		t.Skip("Lock not implemented on this platform")
+	}
+	name := filepath.Join(t.TempDir(), "lock")
+	open := func() *os.File {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = f.Close() })
+		return f
+	}
+	f1, f2 := open(), open()
+
+	// Shared locks can be held together
+	require.NoError(t, Lock(f1, false, false))
+	require.NoError(t, Lock(f2, false, false))
+	require.NoError(t, Unlock(f2))
+
+	// But not with an exclusive lock
+	assert.Equal(t, ErrLocked, Lock(f2, true, false))
+	require.NoError(t, Unlock(f1))
+	require.NoError(t, Lock(f2, true, false))
+	assert.Equal(t, ErrLocked, Lock(f1, false, false))
+
+	// Waiting for the lock returns when it is released
+	done := make(chan error)
+	go func() {
+		done <- Lock(f1, true, true)
+	}()
+	require.NoError(t, Unlock(f2))
+	require.NoError(t, <-done)
+	require.NoError(t, Unlock(f1))
+}
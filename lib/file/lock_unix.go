@@ -0,0 +1,54 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package file
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// LockImplemented is a constant indicating whether Lock does anything
+const LockImplemented = true
+
+// Lock takes an advisory lock on the whole of f which is shared
+// between processes.
+//
+// If exclusive is set the lock is an exclusive lock, otherwise it is
+// a shared lock which can be held by several processes at once. If
+// wait is set Lock waits for the lock, otherwise it returns ErrLocked
+// if the lock is held by another process.
+//
+// Locks belong to the open file so a process which opens the same
+// file twice can conflict with itself.
+func Lock(f *os.File, exclusive, wait bool) error {
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+	if !wait {
+		how |= unix.LOCK_NB
+	}
+	for {
+		err := unix.Flock(int(f.Fd()), how)
+		switch err {
+		case nil:
+			return nil
+		case unix.EINTR:
+			continue
+		case unix.EWOULDBLOCK:
+			return ErrLocked
+		}
+		return &os.PathError{Op: "flock", Path: f.Name(), Err: err}
+	}
+}
+
+// Unlock releases a lock taken with Lock
+func Unlock(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	if err != nil {
+		return &os.PathError{Op: "flock", Path: f.Name(), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build windows
+// +build windows
+
+package file
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// LockImplemented is a constant indicating whether Lock does anything
+const LockImplemented = true
+
+// lock the whole file
+const lockAll = ^uint32(0)
+
+// Lock takes an advisory lock on the whole of f which is shared
+// between processes.
+//
+// If exclusive is set the lock is an exclusive lock, otherwise it is
+// a shared lock which can be held by several processes at once. If
+// wait is set Lock waits for the lock, otherwise it returns ErrLocked
+// if the lock is held by another process.
+//
+// Locks belong to the open file so a process which opens the same
+// file twice can conflict with itself.
+func Lock(f *os.File, exclusive, wait bool) error {
+	var flags uint32
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, lockAll, lockAll, &windows.Overlapped{})
+	switch err {
+	case nil:
+		return nil
+	case windows.ERROR_LOCK_VIOLATION, windows.ERROR_IO_PENDING:
+		return ErrLocked
+	}
+	return &os.PathError{Op: "LockFileEx", Path: f.Name(), Err: err}
+}
+
+// Unlock releases a lock taken with Lock
+func Unlock(f *os.File) error {
+	err := windows.UnlockFileEx(windows.Handle(f.Fd()), 0, lockAll, lockAll, &windows.Overlapped{})
+	if err != nil {
+		return &os.PathError{Op: "UnlockFileEx", Path: f.Name(), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Package hiddendir provides the machinery for overlay backends
+// which keep their own files in a directory at the root of the remote
+// they wrap and hide it from listings
+package hiddendir
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fspath"
+)
+
+// Dir is a directory at the root of a remote which is hidden from an
+// overlay of a path in that remote
+type Dir struct {
+	Name   string // name of the directory relative to the root of the remote
+	Prefix string // path of the overlay root relative to the root of the remote
+	Fs     fs.Fs  // the directory
+}
+
+// New returns the Fs for remote:rpath and the directory called name
+// at the root of remote.
+//
+// If rpath points to a file then it returns both with fs.ErrorIsFile
+// as fs.NewFs does.
+//
+// The Fs of the directory is pinned in the cache until Close is
+// called.
+func New(ctx context.Context, remote, rpath, name string) (baseFs fs.Fs, d *Dir, err error) {
+	baseFs, err = cache.Get(ctx, fspath.JoinRootPath(remote, rpath))
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, nil, fmt.Errorf("failed to derive base remote %q: %w", remote, err)
+	}
+	dirFs, err2 := cache.Get(ctx, fspath.JoinRootPath(remote, name))
+	if err2 != nil && err2 != fs.ErrorIsFile {
+		return nil, nil, fmt.Errorf("failed to make directory %q: %w", name, err2)
+	}
+	d = &Dir{
+		Name:   name,
+		Prefix: strings.Trim(rpath, "/"),
+		Fs:     dirFs,
+	}
+	if err == fs.ErrorIsFile {
+		d.Prefix = path.Dir(d.Prefix)
+		if d.Prefix == "." {
+			d.Prefix = ""
+		}
+	}
+	cache.Pin(d.Fs)
+	return baseFs, d, err
+}
+
+// Close unpins the Fs of the directory
+func (d *Dir) Close() {
+	cache.Unpin(d.Fs)
+}
+
+// Path returns the path of remote relative to the root of the remote
+func (d *Dir) Path(remote string) string {
+	return path.Join(d.Prefix, remote)
+}
+
+// IsHidden returns true if remote is the directory or inside it
+func (d *Dir) IsHidden(remote string) bool {
+	full := d.Path(remote)
+	return full == d.Name || strings.HasPrefix(full, d.Name+"/")
+}
+
+// Filter drops the directory from entries, which are modified in
+// place, and wraps the objects left with wrap.
+func (d *Dir) Filter(entries fs.DirEntries, wrap func(fs.Object) fs.Object) fs.DirEntries {
+	out := entries[:0] // work inplace
+	for _, entry := range entries {
+		if d.IsHidden(entry.Remote()) {
+			continue
+		}
+		switch x := entry.(type) {
+		case fs.Object:
+			out = append(out, wrap(x))
+		default:
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// Features returns the features of the overlay f of baseFs.
+//
+// The backend should then replace the features which need more than
+// passing through to baseFs.
+func Features(ctx context.Context, f, baseFs fs.Fs) *fs.Features {
+	stubFeatures := &fs.Features{
+		CanHaveEmptyDirectories: true,
+		ReadMimeType:            true,
+		WriteMimeType:           true,
+		SetTier:                 true,
+		GetTier:                 true,
+		ReadMetadata:            true,
+		WriteMetadata:           true,
+		UserMetadata:            true,
+	}
+	return stubFeatures.Fill(ctx, f).Mask(ctx, baseFs).WrapsFs(f, baseFs)
+}
+
+// CleanPath makes a path given to a backend command relative to the
+// root
+func CleanPath(p string) string {
+	return path.Clean("/" + p)[1:]
+}
@@ -0,0 +1,90 @@
+package hiddendir
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest/mockdir"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "dir"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "dir", "file.txt"), []byte("hello"), 0666))
+
+	baseFs, d, err := New(ctx, tempDir, "dir/sub", ".hidden")
+	require.NoError(t, err)
+	defer d.Close()
+	assert.Equal(t, "dir/sub", d.Prefix)
+	assert.Equal(t, ".hidden", d.Name)
+	assert.Equal(t, filepath.Join(tempDir, "dir", "sub"), filepath.FromSlash(baseFs.Root()))
+	assert.Equal(t, filepath.Join(tempDir, ".hidden"), filepath.FromSlash(d.Fs.Root()))
+
+	// a file uses its directory as the prefix
+	_, d2, err := New(ctx, tempDir, "dir/file.txt", ".hidden")
+	assert.Equal(t, fs.ErrorIsFile, err)
+	defer d2.Close()
+	assert.Equal(t, "dir", d2.Prefix)
+	_, d3, err := New(ctx, tempDir, "dir", ".hidden")
+	require.NoError(t, err)
+	defer d3.Close()
+	assert.Equal(t, "dir", d3.Prefix)
+}
+
+func TestIsHidden(t *testing.T) {
+	d := &Dir{Name: ".hidden"}
+	assert.True(t, d.IsHidden(".hidden"))
+	assert.True(t, d.IsHidden(".hidden/file.txt"))
+	assert.False(t, d.IsHidden(".hiddenfile"))
+	assert.False(t, d.IsHidden("dir/.hidden"))
+	assert.False(t, d.IsHidden(""))
+
+	// only the directory at the root of the remote is hidden
+	d.Prefix = "dir"
+	assert.False(t, d.IsHidden(".hidden"))
+	assert.Equal(t, "dir/.hidden", d.Path(".hidden"))
+	assert.Equal(t, "dir", d.Path(""))
+}
+
+func TestFilter(t *testing.T) {
+	d := &Dir{Name: ".hidden"}
+	entries := fs.DirEntries{
+		mockdir.New(".hidden"),
+		mockobject.Object("a.txt"),
+		mockdir.New("dir"),
+		mockobject.Object(".hidden/b.txt"),
+	}
+	var wrapped []string
+	got := d.Filter(entries, func(o fs.Object) fs.Object {
+		wrapped = append(wrapped, o.Remote())
+		return o
+	})
+	require.Len(t, got, 2)
+	assert.Equal(t, "a.txt", got[0].Remote())
+	assert.Equal(t, "dir", got[1].Remote())
+	assert.Equal(t, []string{"a.txt"}, wrapped)
+}
+
+func TestCleanPath(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"dir/", "dir"},
+		{"/dir/../file.txt", "file.txt"},
+		{"../../file.txt", "file.txt"},
+		{"a//b", "a/b"},
+	} {
+		assert.Equal(t, test.want, CleanPath(test.in), test.in)
+	}
+}
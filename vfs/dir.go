@@ -31,6 +31,7 @@ type Dir struct {
 	path    string
 	entry   fs.Directory
 	read    time.Time         // time directory entry last read
+	loaded  bool              // set if the persistent dir cache has been consulted
 	items   map[string]Node   // directory entries - can be empty but not nil
 	virtual map[string]vState // virtual directory entries - may be nil
 	sys     atomic.Value      // user defined info to be attached here
@@ -196,6 +197,9 @@ func (d *Dir) ForgetAll() (hasVirtual bool) {
 	d._purgeVirtual()
 
 	d.read = time.Time{}
+	if dc := d.vfs.dirCache; dc != nil {
+		dc.forget(d.path)
+	}
 	// Check if this dir has virtual entries
 	if len(d.virtual) != 0 {
 		hasVirtual = true
@@ -232,6 +236,9 @@ func (d *Dir) invalidateDir(absPath string) {
 		}
 		dir.mu.Unlock()
 	}
+	if dc := d.vfs.dirCache; dc != nil {
+		dc.forget(absPath)
+	}
 }
 
 // changeNotify invalidates the directory cache for the relativePath
@@ -393,6 +400,7 @@ func (d *Dir) addObject(node Node) {
 	}
 	d.virtual[leaf] = vAdd
 	fs.Debugf(d.path, "Added virtual directory entry %v: %q", vAdd, leaf)
+	d._forgetDirCache()
 	d.mu.Unlock()
 }
 
@@ -406,7 +414,11 @@ func (d *Dir) AddVirtual(leaf string, size int64, isDir bool) {
 	var node Node
 	d.mu.RLock()
 	dPath := d.path
-	_, found := d.items[leaf]
+	name := leaf
+	if !isDir {
+		name = d._linkName(leaf)
+	}
+	_, found := d.items[name]
 	d.mu.RUnlock()
 	if found {
 		// Don't overwrite existing objects
@@ -437,6 +449,7 @@ func (d *Dir) delObject(leaf string) {
 	}
 	d.virtual[leaf] = vDel
 	fs.Debugf(d.path, "Added virtual directory entry %v: %q", vDel, leaf)
+	d._forgetDirCache()
 	d.mu.Unlock()
 }
 
@@ -461,6 +474,9 @@ func (d *Dir) _readDir() error {
 	} else {
 		return nil
 	}
+	if d._readDirFromDirCache(when) {
+		return nil
+	}
 	entries, err := list.DirSorted(context.TODO(), d.f, false, d.path)
 	if err == fs.ErrorDirNotFound {
 		// We treat directory not found as empty because we
@@ -475,9 +491,100 @@ func (d *Dir) _readDir() error {
 	}
 
 	d.read = when
+	d._saveDirCache(entries, when)
 	return nil
 }
 
+// _readDirFromDirCache fills d.items from the persistent directory
+// cache the first time the directory is read, returning true if it
+// did.
+//
+// If the listing is older than --dir-cache-time it is served anyway
+// and re-read from the remote in the background.
+//
+// must be called with the lock held
+func (d *Dir) _readDirFromDirCache(when time.Time) bool {
+	dc := d.vfs.dirCache
+	if dc == nil || d.loaded {
+		return false
+	}
+	d.loaded = true
+	rec := dc.get(d.path)
+	if rec == nil {
+		return false
+	}
+	err := d._readDirFromEntries(rec.entries(d.f, d.path), nil, time.Time{})
+	if err != nil {
+		fs.Debugf(d.path, "Failed to load directory from persistent cache: %v", err)
+		return false
+	}
+	age := when.Sub(rec.Read)
+	if age > d.vfs.Opt.DirCacheTime {
+		fs.Debugf(d.path, "Loaded directory from persistent cache - revalidating (%v old)", age)
+		d.read = when
+		go d.revalidate()
+	} else {
+		fs.Debugf(d.path, "Loaded directory from persistent cache (%v old)", age)
+		d.read = rec.Read
+	}
+	return true
+}
+
+// revalidate re-reads a directory loaded from the persistent
+// directory cache from the remote
+func (d *Dir) revalidate() {
+	d.mu.RLock()
+	f, dirPath := d.f, d.path
+	d.mu.RUnlock()
+	when := time.Now()
+	entries, err := list.DirSorted(context.TODO(), f, false, dirPath)
+	if err == fs.ErrorDirNotFound {
+		// We treat directory not found as empty because we
+		// create directories on the fly
+		err = nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		fs.Errorf(d.path, "Failed to revalidate directory: %v", err)
+		d.read = time.Time{}
+		return
+	}
+	if d.path != dirPath {
+		// renamed while we were reading so let it be read again
+		d.read = time.Time{}
+		return
+	}
+	err = d._readDirFromEntries(entries, nil, time.Time{})
+	if err != nil {
+		d.read = time.Time{}
+		return
+	}
+	d.read = when
+	d._saveDirCache(entries, when)
+}
+
+// _saveDirCache writes the listing to the persistent directory cache
+// if enabled
+//
+// must be called with the lock held
+func (d *Dir) _saveDirCache(entries fs.DirEntries, when time.Time) {
+	if dc := d.vfs.dirCache; dc != nil {
+		d.loaded = true
+		dc.put(d.path, entries, when)
+	}
+}
+
+// _forgetDirCache removes the listing from the persistent directory
+// cache after a local change to the directory
+//
+// must be called with the lock held
+func (d *Dir) _forgetDirCache() {
+	if dc := d.vfs.dirCache; dc != nil {
+		dc.forget(d.path)
+	}
+}
+
 // update d.items for each dir in the DirTree below this one and
 // set the last read time - must be called with the lock held
 func (d *Dir) _readDirFromDirTree(dirTree dirtree.DirTree, when time.Time) error {
@@ -538,7 +645,7 @@ func (d *Dir) _purgeVirtual() {
 				// if writing in progress then leave virtual
 				continue
 			}
-			if d.vfs.Opt.CacheMode >= vfscommon.CacheModeMinimal && d.vfs.cache.InUse(f.Path()) {
+			if d.vfs.Opt.CacheMode >= vfscommon.CacheModeMinimal && d.vfs.cache.InUse(f.remotePath()) {
 				// if object in use or dirty then leave virtual
 				continue
 			}
@@ -624,10 +731,17 @@ func (d *Dir) _readDirFromEntries(entries fs.DirEntries, dirTree dirtree.DirTree
 	var err error
 	mv := d._newManageVirtuals()
 	for _, entry := range entries {
-		name := path.Base(entry.Remote())
-		if name == "." || name == ".." {
+		leaf := path.Base(entry.Remote())
+		if leaf == "." || leaf == ".." {
 			continue
 		}
+		name := leaf
+		if _, isObject := entry.(fs.Object); isObject {
+			if d._isRemoteLock(leaf) {
+				continue
+			}
+			name = d._linkName(leaf)
+		}
 		node := d.items[name]
 		if mv.add(d, name) {
 			continue
@@ -639,7 +753,7 @@ func (d *Dir) _readDirFromEntries(entries fs.DirEntries, dirTree dirtree.DirTree
 			if file, ok := node.(*File); node != nil && ok {
 				file.setObjectNoUpdate(obj)
 			} else {
-				node = newFile(d, d.path, obj, name)
+				node = newFile(d, d.path, obj, leaf)
 			}
 		case fs.Directory:
 			// Reuse old dir value if it exists
@@ -654,6 +768,7 @@ func (d *Dir) _readDirFromEntries(entries fs.DirEntries, dirTree dirtree.DirTree
 					dir.read = time.Time{}
 				} else {
 					dir.read = when
+					dir._saveDirCache(dirTree[dir.path], when)
 				}
 				dir.mu.Unlock()
 				if err != nil {
@@ -691,6 +806,7 @@ func (d *Dir) readDirTree() error {
 	}
 	fs.Debugf(d.path, "Reading directory tree done in %s", time.Since(when))
 	d.read = when
+	d._saveDirCache(dt[d.path], when)
 	return nil
 }
 
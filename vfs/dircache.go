@@ -0,0 +1,305 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// The persistent directory cache stores directory listings on disk
+// so that a restarted VFS can show them without listing the remote
+// again.
+//
+// Listings loaded from disk are served straight away. If they are
+// older than --dir-cache-time they are re-read from the remote in the
+// background.
+
+// dirCacheFacility is the name of the kv database
+const dirCacheFacility = "vfsdir"
+
+// dirCacheRecord is a directory listing persisted to disk
+type dirCacheRecord struct {
+	Read    time.Time       // when the listing was read from the remote
+	Entries []dirCacheEntry // the entries in the directory
+}
+
+// dirCacheEntry is a single persisted directory entry
+type dirCacheEntry struct {
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+// dirCache persists directory listings in a kv database
+type dirCache struct {
+	db   *kv.DB
+	root string // prefix for keys so VFSes on different roots don't clash
+}
+
+// newDirCache opens the persistent directory cache for f
+func newDirCache(ctx context.Context, f fs.Fs) (*dirCache, error) {
+	if !kv.Supported() {
+		return nil, kv.ErrUnsupported
+	}
+	db, err := kv.Start(ctx, dirCacheFacility, f)
+	if err != nil {
+		return nil, err
+	}
+	return &dirCache{
+		db:   db,
+		root: f.Root(),
+	}, nil
+}
+
+// key returns the database key for dirPath
+func (dc *dirCache) key(dirPath string) string {
+	return path.Join("/", dc.root, dirPath)
+}
+
+// get reads the listing for dirPath returning nil if not found
+func (dc *dirCache) get(dirPath string) *dirCacheRecord {
+	op := &kvDirGet{key: dc.key(dirPath)}
+	err := dc.db.Do(false, op)
+	if err != nil {
+		if err != kv.ErrEmpty {
+			fs.Debugf(dirPath, "vfs dir cache: failed to read: %v", err)
+		}
+		return nil
+	}
+	return op.rec
+}
+
+// put stores the listing for dirPath read at when
+func (dc *dirCache) put(dirPath string, entries fs.DirEntries, when time.Time) {
+	rec := &dirCacheRecord{
+		Read:    when,
+		Entries: make([]dirCacheEntry, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		_, isDir := entry.(fs.Directory)
+		rec.Entries = append(rec.Entries, dirCacheEntry{
+			Name:    path.Base(entry.Remote()),
+			IsDir:   isDir,
+			Size:    entry.Size(),
+			ModTime: entry.ModTime(context.TODO()),
+		})
+	}
+	err := dc.db.Do(true, &kvDirPut{key: dc.key(dirPath), rec: rec})
+	if err != nil {
+		fs.Debugf(dirPath, "vfs dir cache: failed to write: %v", err)
+	}
+}
+
+// forget removes the listing for dirPath so it will be read from the
+// remote next time
+func (dc *dirCache) forget(dirPath string) {
+	err := dc.db.Do(true, &kvDirDelete{key: dc.key(dirPath)})
+	if err != nil {
+		fs.Debugf(dirPath, "vfs dir cache: failed to forget: %v", err)
+	}
+}
+
+// close releases the database
+func (dc *dirCache) close() {
+	err := dc.db.Stop(false)
+	if err != nil {
+		fs.Debugf(nil, "vfs dir cache: failed to close: %v", err)
+	}
+}
+
+// entries turns the record back into directory entries for f
+//
+// dirPath is the path of the directory relative to the root of f
+func (rec *dirCacheRecord) entries(f fs.Fs, dirPath string) (entries fs.DirEntries) {
+	entries = make(fs.DirEntries, 0, len(rec.Entries))
+	for _, entry := range rec.Entries {
+		remote := path.Join(dirPath, entry.Name)
+		if entry.IsDir {
+			entries = append(entries, fs.NewDir(remote, entry.ModTime))
+		} else {
+			entries = append(entries, &cachedObject{
+				f:       f,
+				remote:  remote,
+				size:    entry.Size,
+				modTime: entry.ModTime,
+			})
+		}
+	}
+	return entries
+}
+
+// kvDirGet: read a directory listing
+type kvDirGet struct {
+	key string
+	rec *dirCacheRecord
+}
+
+func (op *kvDirGet) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get([]byte(op.key))
+	if len(data) == 0 {
+		return kv.ErrEmpty
+	}
+	var rec dirCacheRecord
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&rec); err != nil {
+		return fmt.Errorf("invalid record: %w", err)
+	}
+	op.rec = &rec
+	return nil
+}
+
+// kvDirPut: write a directory listing
+type kvDirPut struct {
+	key string
+	rec *dirCacheRecord
+}
+
+func (op *kvDirPut) Do(ctx context.Context, b kv.Bucket) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(op.rec); err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+	return b.Put([]byte(op.key), buf.Bytes())
+}
+
+// kvDirDelete: remove a directory listing
+type kvDirDelete struct {
+	key string
+}
+
+func (op *kvDirDelete) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Delete([]byte(op.key))
+}
+
+// cachedObject is an fs.Object restored from the persistent directory
+// cache.
+//
+// It answers questions about its size and modification time from
+// the cache and looks up the real object on the remote the first
+// time anything else is needed.
+type cachedObject struct {
+	f       fs.Fs
+	remote  string
+	size    int64
+	modTime time.Time
+
+	mu sync.Mutex
+	o  fs.Object // the real object once looked up
+}
+
+// resolved returns the real object if it has been looked up or nil
+func (o *cachedObject) resolved() fs.Object {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.o
+}
+
+// object returns the real object, looking it up if necessary
+func (o *cachedObject) object(ctx context.Context) (fs.Object, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.o == nil {
+		obj, err := o.f.NewObject(ctx, o.remote)
+		if err != nil {
+			return nil, err
+		}
+		o.o = obj
+	}
+	return o.o, nil
+}
+
+// Fs returns the Fs the object is in
+func (o *cachedObject) Fs() fs.Info {
+	return o.f
+}
+
+// String returns a description of the Object
+func (o *cachedObject) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *cachedObject) Remote() string {
+	return o.remote
+}
+
+// ModTime returns the modification time
+func (o *cachedObject) ModTime(ctx context.Context) time.Time {
+	if obj := o.resolved(); obj != nil {
+		return obj.ModTime(ctx)
+	}
+	return o.modTime
+}
+
+// Size returns the size of the file
+func (o *cachedObject) Size() int64 {
+	if obj := o.resolved(); obj != nil {
+		return obj.Size()
+	}
+	return o.size
+}
+
+// Storable returns whether the object is storable
+func (o *cachedObject) Storable() bool {
+	return true
+}
+
+// Hash returns the selected checksum of the file
+func (o *cachedObject) Hash(ctx context.Context, ty hash.Type) (string, error) {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return "", err
+	}
+	return obj.Hash(ctx, ty)
+}
+
+// SetModTime sets the modification time of the file
+func (o *cachedObject) SetModTime(ctx context.Context, modTime time.Time) error {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.SetModTime(ctx, modTime)
+}
+
+// Open opens the file for read
+func (o *cachedObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Open(ctx, options...)
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *cachedObject) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.Update(ctx, in, src, options...)
+}
+
+// Remove this object
+func (o *cachedObject) Remove(ctx context.Context) error {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.Remove(ctx)
+}
+
+// Check the interfaces are satisfied
+var _ fs.Object = (*cachedObject)(nil)
@@ -0,0 +1,88 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dirCacheNames returns the sorted names in dir
+func dirCacheNames(t *testing.T, vfs *VFS, dir string) (names []string) {
+	nodes, err := vfs.ReadDir(dir)
+	require.NoError(t, err)
+	for _, node := range nodes {
+		names = append(names, node.Name())
+	}
+	return names
+}
+
+func TestDirCachePersist(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.DirCachePersist = true
+	opt.DirCacheTime = time.Hour
+	r, vfs1 := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+
+	file1 := r.WriteObject(ctx, "dir/file1", "file1 contents", t1)
+	r.CheckRemoteItems(t, file1)
+	assert.Equal(t, []string{"file1"}, dirCacheNames(t, vfs1, "dir"))
+
+	// Add a file behind the VFS's back
+	file2 := r.WriteObject(ctx, "dir/file2", "file2", t2)
+	r.CheckRemoteItems(t, file1, file2)
+
+	// A new VFS should see the persisted listing which doesn't
+	// include file2 as it is still within --dir-cache-time
+	opt2 := opt
+	opt2.NoChecksum = !opt.NoChecksum // make sure we get a different VFS
+	vfs2 := New(r.Fremote, &opt2)
+	defer cleanupVFS(t, vfs2)
+	assert.Equal(t, []string{"file1"}, dirCacheNames(t, vfs2, "dir"))
+
+	// The file restored from disk should be readable
+	data, err := vfs2.ReadFile("dir/file1")
+	require.NoError(t, err)
+	assert.Equal(t, "file1 contents", string(data))
+	node, err := vfs2.Stat("dir/file1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(14), node.Size())
+	fstest.AssertTimeEqualWithPrecision(t, "file1", t1, node.ModTime(), r.Fremote.Precision())
+
+	// Forgetting the directory should make it be read from the remote
+	root, err := vfs2.Root()
+	require.NoError(t, err)
+	root.ForgetAll()
+	assert.Equal(t, []string{"file1", "file2"}, dirCacheNames(t, vfs2, "dir"))
+}
+
+func TestDirCacheRevalidate(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.DirCachePersist = true
+	opt.DirCacheTime = time.Hour
+	r, vfs1 := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+
+	file1 := r.WriteObject(ctx, "dir/file1", "file1 contents", t1)
+	r.CheckRemoteItems(t, file1)
+	assert.Equal(t, []string{"file1"}, dirCacheNames(t, vfs1, "dir"))
+
+	file2 := r.WriteObject(ctx, "dir/file2", "file2", t2)
+	r.CheckRemoteItems(t, file1, file2)
+
+	// With a short --dir-cache-time the persisted listing is shown
+	// but revalidated in the background
+	opt2 := opt
+	opt2.DirCacheTime = time.Nanosecond
+	vfs2 := New(r.Fremote, &opt2)
+	defer cleanupVFS(t, vfs2)
+	assert.Equal(t, []string{"file1"}, dirCacheNames(t, vfs2, "dir"))
+	assert.Eventually(t, func() bool {
+		names := dirCacheNames(t, vfs2, "dir")
+		return len(names) == 2
+	}, 10*time.Second, 10*time.Millisecond)
+}
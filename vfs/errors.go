@@ -20,6 +20,11 @@ const (
 	EBADF
 	EROFS
 	ENOSYS
+	ENOATTR
+	ENOTSUP
+	EAGAIN
+	EINTR
+	EBUSY
 )
 
 // Errors which have exact counterparts in os
@@ -38,6 +43,11 @@ var errorNames = []string{
 	EBADF:     "Bad file descriptor",
 	EROFS:     "Read only file system",
 	ENOSYS:    "Function not implemented",
+	ENOATTR:   "No such attribute",
+	ENOTSUP:   "Operation not supported",
+	EAGAIN:    "Resource temporarily unavailable",
+	EINTR:     "Interrupted system call",
+	EBUSY:     "Device or resource busy",
 }
 
 // Error renders the error as a string
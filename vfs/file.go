@@ -1,17 +1,21 @@
 package vfs
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/vfs/vfscommon"
 )
@@ -49,10 +53,14 @@ type File struct {
 	writers          []Handle                        // writers for this file
 	virtualModTime   *time.Time                      // modtime for backends with Precision == fs.ModTimeNotSupported
 	pendingModTime   time.Time                       // will be applied once o becomes available, i.e. after file was written
+	pendingMetadata  fs.Metadata                     // will be applied once o becomes available, i.e. after file was written
 	pendingRenameFun func(ctx context.Context) error // will be run/renamed after all writers close
 	sys              atomic.Value                    // user defined info to be attached here
 	nwriters         int32                           // len(writers) which is read/updated with atomic
 	appendMode       bool                            // file was opened with O_APPEND
+	isLink           bool                            // file is a symlink stored as a LinkSuffix object
+	perms            *filePerms                      // permissions read from the metadata or nil if not read yet
+	permsGen         uint64                          // incremented when perms is invalidated
 }
 
 // newFile creates a new File
@@ -66,6 +74,10 @@ func newFile(d *Dir, dPath string, o fs.Object, leaf string) *File {
 		leaf:  leaf,
 		inode: newInode(),
 	}
+	if d.vfs.Opt.Links && len(leaf) > len(LinkSuffix) && strings.HasSuffix(leaf, LinkSuffix) {
+		f.isLink = true
+		f.leaf = leaf[:len(leaf)-len(LinkSuffix)]
+	}
 	if o != nil {
 		f.size = o.Size()
 	}
@@ -92,9 +104,12 @@ func (f *File) IsDir() bool {
 
 // Mode bits of the file or directory - satisfies Node interface
 func (f *File) Mode() (mode os.FileMode) {
+	mode, _, _ = f.metadataPerms()
 	f.mu.RLock()
 	defer f.mu.RUnlock()
-	mode = f.d.vfs.Opt.FilePerms
+	if f.isLink {
+		mode |= os.ModeSymlink
+	}
 	if f.appendMode {
 		mode |= os.ModeAppend
 	}
@@ -102,6 +117,8 @@ func (f *File) Mode() (mode os.FileMode) {
 }
 
 // Name (base) of the directory - satisfies Node interface
+//
+// For symlinks this is the name without the LinkSuffix
 func (f *File) Name() (name string) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
@@ -122,6 +139,25 @@ func (f *File) Path() string {
 	return path.Join(dPath, leaf)
 }
 
+// _remotePath returns the path of the file on the remote and in the
+// cache, which for symlinks has the LinkSuffix
+//
+// use when lock is held
+func (f *File) _remotePath() string {
+	if f.isLink {
+		return f._path() + LinkSuffix
+	}
+	return f._path()
+}
+
+// remotePath returns the path of the file on the remote and in the
+// cache, which for symlinks has the LinkSuffix
+func (f *File) remotePath() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f._remotePath()
+}
+
 // Sys returns underlying data source (can be nil) - satisfies Node interface
 func (f *File) Sys() interface{} {
 	return f.sys.Load()
@@ -180,9 +216,13 @@ func (f *File) rename(ctx context.Context, destDir *Dir, newName string) error {
 		return err
 	}
 
-	oldPath := f.Path()
+	oldPath := f.remotePath()
 	// File.mu is unlocked here to call Dir.Path()
 	newPath := path.Join(destDir.Path(), newName)
+	// Symlinks keep their suffix on the remote
+	if f.isLink {
+		newPath += LinkSuffix
+	}
 
 	renameCall := func(ctx context.Context) (err error) {
 		// chain rename calls if any
@@ -273,6 +313,7 @@ func (f *File) addWriter(h Handle) {
 // delWriter removes a write handle from the file
 func (f *File) delWriter(h Handle) {
 	f.mu.Lock()
+	defer func() { _ = f.applyPendingMetadata() }()
 	defer f.applyPendingRename()
 	defer f.mu.Unlock()
 	var found = -1
@@ -334,7 +375,7 @@ func (f *File) ModTime() (modTime time.Time) {
 	}
 	// Read the modtime from a dirty item if it exists
 	if f.d.vfs.Opt.CacheMode >= vfscommon.CacheModeMinimal {
-		if item := f.d.vfs.cache.DirtyItem(f._path()); item != nil {
+		if item := f.d.vfs.cache.DirtyItem(f._remotePath()); item != nil {
 			modTime, err := item.GetModTime()
 			if err != nil {
 				fs.Errorf(f._path(), "ModTime: Item GetModTime failed: %v", err)
@@ -371,7 +412,7 @@ func (f *File) Size() int64 {
 
 	// Read the size from a dirty item if it exists
 	if f.d.vfs.Opt.CacheMode >= vfscommon.CacheModeMinimal {
-		if item := f.d.vfs.cache.DirtyItem(f._path()); item != nil {
+		if item := f.d.vfs.cache.DirtyItem(f._remotePath()); item != nil {
 			size, err := item.GetSize()
 			if err != nil {
 				fs.Errorf(f._path(), "Size: Item GetSize failed: %v", err)
@@ -404,8 +445,8 @@ func (f *File) SetModTime(modTime time.Time) error {
 	f.pendingModTime = modTime
 
 	// set the time of the file in the cache
-	if f.d.vfs.cache != nil && f.d.vfs.cache.Exists(f._path()) {
-		f.d.vfs.cache.SetModTime(f._path(), f.pendingModTime)
+	if f.d.vfs.cache != nil && f.d.vfs.cache.Exists(f._remotePath()) {
+		f.d.vfs.cache.SetModTime(f._remotePath(), f.pendingModTime)
 	}
 
 	// Only update the ModTime when there are no writers, setObject will do it
@@ -458,6 +499,136 @@ func (f *File) applyPendingModTime() error {
 	return f._applyPendingModTime()
 }
 
+// Metadata returns the metadata of the file
+//
+// This includes any changes which haven't been written to the remote
+// yet. It returns nil if the backend doesn't support metadata.
+func (f *File) Metadata() (fs.Metadata, error) {
+	f.mu.RLock()
+	o, pending, remote := f.o, f.pendingMetadata, f._remotePath()
+	f.mu.RUnlock()
+	if pending != nil {
+		return pending, nil
+	}
+	if cache := f.d.vfs.cache; cache != nil {
+		if item := cache.DirtyItem(remote); item != nil {
+			if metadata := item.GetMetadata(); metadata != nil {
+				return metadata, nil
+			}
+		}
+	}
+	if o == nil {
+		return nil, nil
+	}
+	return fs.GetMetadata(context.TODO(), o)
+}
+
+// SetMetadata replaces the metadata of the file with metadata
+//
+// If the file is being written the metadata is written with the
+// upload, otherwise the object is updated straight away.
+func (f *File) SetMetadata(metadata fs.Metadata) error {
+	if f.d.vfs.Opt.ReadOnly {
+		return EROFS
+	}
+	if !f.d.Fs().Features().WriteMetadata {
+		return ENOTSUP
+	}
+	if metadata == nil {
+		metadata = fs.Metadata{}
+	}
+	f.mu.Lock()
+	f.pendingMetadata = metadata
+	f._invalidatePerms()
+	f.mu.Unlock()
+
+	// This does nothing if there are writers, delWriter will do it
+	return f.applyPendingMetadata()
+}
+
+// maxMetadataUpdateSize is the largest object which is uploaded again
+// to change its metadata
+const maxMetadataUpdateSize = 1024 * 1024
+
+// applyPendingMetadata writes any pending metadata to the object
+//
+// If the file is waiting to be uploaded the metadata is sent with the
+// upload. Otherwise, as there is no way of setting metadata on its
+// own, small objects are uploaded again with the new metadata. The
+// metadata of larger ones is dropped and ENOTSUP returned rather than
+// keeping it in memory where it would be lost on exit.
+//
+// Call without the mutex held
+func (f *File) applyPendingMetadata() error {
+	f.mu.Lock()
+	o, metadata := f.o, f.pendingMetadata
+	if metadata == nil {
+		f.mu.Unlock()
+		return nil
+	}
+	if cache := f.d.vfs.cache; cache != nil {
+		if item := cache.DirtyItem(f._remotePath()); item != nil && item.SetMetadata(metadata) {
+			f.pendingMetadata = nil
+			fs.Debugf(f._path(), "Queued pending metadata for upload")
+			f.mu.Unlock()
+			return nil
+		}
+	}
+	if o == nil || f._writingInProgress() {
+		f.mu.Unlock()
+		return nil
+	}
+	if size := o.Size(); size < 0 || size > maxMetadataUpdateSize {
+		f.pendingMetadata = nil
+		f._invalidatePerms()
+		f.mu.Unlock()
+		fs.Debugf(o, "Can't change metadata as the file is too big to upload again")
+		return ENOTSUP
+	}
+	f.pendingMetadata = nil
+	f.mu.Unlock()
+
+	err := updateMetadata(context.TODO(), o, metadata)
+	if err != nil {
+		fs.Errorf(o, "Failed to apply pending metadata: %v", err)
+		return err
+	}
+	fs.Debugf(o, "Applied pending metadata OK")
+	return nil
+}
+
+// takePendingMetadata returns the metadata waiting to be written, if
+// any, so it can be sent with an upload
+func (f *File) takePendingMetadata() (metadata fs.Metadata) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	metadata, f.pendingMetadata = f.pendingMetadata, nil
+	return metadata
+}
+
+// updateMetadata uploads o again with metadata
+//
+// The data is read into memory first as some backends can't read and
+// write the same object at once, so o should be small.
+func updateMetadata(ctx context.Context, o fs.Object, metadata fs.Metadata) (err error) {
+	ctx, ci := fs.AddConfig(ctx)
+	ci.Metadata = true
+	in, err := o.Open(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(in)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	src := object.NewStaticObjectInfo(o.Remote(), o.ModTime(ctx), int64(len(data)), true, nil, o.Fs()).WithMetadata(metadata)
+	return o.Update(ctx, bytes.NewReader(data), src)
+}
+
 // _writingInProgress returns true of there are any open writers
 // Call with read lock held
 func (f *File) _writingInProgress() bool {
@@ -480,6 +651,7 @@ func (f *File) setSize(n int64) {
 func (f *File) setObject(o fs.Object) {
 	f.mu.Lock()
 	f.o = o
+	f._invalidatePerms()
 	_ = f._applyPendingModTime()
 	d := f.d
 	f.mu.Unlock()
@@ -493,6 +665,7 @@ func (f *File) setObject(o fs.Object) {
 func (f *File) setObjectNoUpdate(o fs.Object) {
 	f.mu.Lock()
 	f.o = o
+	f._invalidatePerms()
 	f.virtualModTime = nil
 	fs.Debugf(f._path(), "Reset virtual modtime")
 	f.mu.Unlock()
@@ -561,7 +734,7 @@ func (f *File) openWrite(flags int) (fh *WriteFileHandle, err error) {
 	}
 	// fs.Debugf(f.Path(), "File.openWrite")
 
-	fh, err = newWriteFileHandle(d, f, f.Path(), flags)
+	fh, err = newWriteFileHandle(d, f, f.remotePath(), flags)
 	if err != nil {
 		fs.Debugf(f.Path(), "File.openWrite failed: %v", err)
 		return nil, err
@@ -611,8 +784,8 @@ func (f *File) Remove() (err error) {
 
 	// Remove the object from the cache
 	wasWriting := false
-	if d.vfs.cache != nil && d.vfs.cache.Exists(f.Path()) {
-		wasWriting = d.vfs.cache.Remove(f.Path())
+	if d.vfs.cache != nil && d.vfs.cache.Exists(f.remotePath()) {
+		wasWriting = d.vfs.cache.Remove(f.remotePath())
 	}
 
 	f.muRW.Lock() // muRW must be locked before mu to avoid
@@ -738,7 +911,7 @@ func (f *File) Open(flags int) (fd Handle, err error) {
 	d := f.d
 	f.mu.RUnlock()
 	CacheMode := d.vfs.Opt.CacheMode
-	if CacheMode >= vfscommon.CacheModeMinimal && (d.vfs.cache.InUse(f.Path()) || d.vfs.cache.Exists(f.Path())) {
+	if CacheMode >= vfscommon.CacheModeMinimal && (d.vfs.cache.InUse(f.remotePath()) || d.vfs.cache.Exists(f.remotePath())) {
 		fd, err = f.openRW(flags)
 	} else if read && write {
 		if CacheMode >= vfscommon.CacheModeMinimal {
@@ -55,6 +55,14 @@ Or individual files or directories:
 
     rclone rc vfs/forget file=path/to/file dir=path/to/dir
 
+The directory cache is normally kept in memory only so it has to be
+read again from the remote each time rclone starts. If the
+!--vfs-dir-cache-persist! flag is set then directory listings are also
+stored on disk in rclone's cache directory. When rclone is restarted
+the listings are read from disk so the directory tree can be browsed
+straight away. Listings older than !--dir-cache-time! are still shown
+but are re-read from the remote in the background.
+
 ### VFS File Buffering
 
 The !--buffer-size! flag determines the amount of memory,
@@ -84,12 +92,15 @@ write simultaneously to a file.  See below for more details.
 Note that the VFS cache is separate from the cache backend and you may
 find that you need one or the other or both.
 
-    --cache-dir string                   Directory rclone will use for caching.
-    --vfs-cache-mode CacheMode           Cache mode off|minimal|writes|full (default off)
-    --vfs-cache-max-age duration         Max time since last access of objects in the cache (default 1h0m0s)
-    --vfs-cache-max-size SizeSuffix      Max total size of objects in the cache (default off)
-    --vfs-cache-poll-interval duration   Interval to poll the cache for stale objects (default 1m0s)
-    --vfs-write-back duration            Time to writeback files after last use when using cache (default 5s)
+    --cache-dir string                      Directory rclone will use for caching.
+    --vfs-cache-mode CacheMode              Cache mode off|minimal|writes|full (default off)
+    --vfs-cache-max-age duration            Max time since last access of objects in the cache (default 1h0m0s)
+    --vfs-cache-max-size SizeSuffix         Max total size of objects in the cache (default off)
+    --vfs-cache-min-free-space SizeSuffix   Target minimum free space on the disk containing the cache (default off)
+    --vfs-cache-evict-policy EvictPolicy    Which objects to evict from the cache first lru|lfu|size (default lru)
+    --vfs-cache-quota CacheQuotas           Max total size of objects under a directory in the cache as dir=size (can be repeated)
+    --vfs-cache-poll-interval duration      Interval to poll the cache for stale objects (default 1m0s)
+    --vfs-write-back duration               Time to writeback files after last use when using cache (default 5s)
 
 If run with !-vv! rclone will print the location of the file cache.  The
 files are stored in the user cache file area which is OS dependent but
@@ -106,6 +117,10 @@ seconds. If rclone is quit or dies with files that haven't been
 uploaded, these will be uploaded next time rclone is run with the same
 flags.
 
+The files waiting to be uploaded can be listed with the !vfs/queue!
+remote control command, and their uploads brought forward, delayed or
+cancelled with !vfs/queue-set-expiry! and !vfs/queue-cancel!.
+
 If using !--vfs-cache-max-size! note that the cache may exceed this size
 for two reasons.  Firstly because it is only checked every
 !--vfs-cache-poll-interval!.  Secondly because open files cannot be
@@ -115,6 +130,34 @@ from the cache first. rclone will start with files that haven't
 been accessed for the longest. This cache flushing strategy is
 efficient and more relevant files are likely to remain cached.
 
+The order files are evicted in can be changed with
+!--vfs-cache-evict-policy!:
+
+- !lru! - evict the least recently used files first (the default).
+- !lfu! - evict the files which have been opened the fewest times
+  first, oldest first if they have been opened the same number of times.
+- !size! - evict the files with the largest size multiplied by the
+  time since they were last used first, so large files which are
+  rarely used are evicted before small files which are used often.
+
+If !--vfs-cache-min-free-space! is set then when the free space on
+the disk containing the cache falls below it rclone will evict files
+from the cache as if !--vfs-cache-max-size! was reduced to free up
+enough space.
+
+The space used by the files under particular directories can be
+limited with !--vfs-cache-quota dir=size!, which can be repeated, for
+example
+
+    --vfs-cache-quota media=20G --vfs-cache-quota backups=5G
+
+When the files under a directory use more than its quota they are
+evicted using the !--vfs-cache-evict-policy! until they are within it.
+This stops large files in one directory evicting the working set in
+others. The directories are relative to the root of the VFS. These
+quotas are checked every !--vfs-cache-poll-interval! like
+!--vfs-cache-max-size!.
+
 The !--vfs-cache-max-age! will evict files from the cache
 after the set time since last access has passed. The default value of
 1 hour will start evicting files from cache that haven't been accessed
@@ -196,6 +239,49 @@ FAT/exFAT do not. Rclone will perform very badly if the cache
 directory is on a filesystem which doesn't support sparse files and it
 will log an ERROR message if one is detected.
 
+#### Pinning files for offline use
+
+With !--vfs-cache-mode full! files and directories can be pinned to
+make them available offline. Pinning downloads everything under the
+path into the cache and keeps it there regardless of
+!--vfs-cache-max-age! and !--vfs-cache-max-size!. When a change to a
+pinned file is noticed on the remote (see !--poll-interval!) it is
+downloaded again.
+
+Pin and unpin paths with the !vfs/pin! and !vfs/unpin! remote control
+commands, or on a mount by setting or removing the
+!user.rclone.pinned! extended attribute, for example
+
+    setfattr -n user.rclone.pinned -v 1 /mnt/remote/project
+    setfattr -x user.rclone.pinned /mnt/remote/project
+
+Pins are remembered in the cache directory across restarts.
+
+#### Sharing the cache between processes
+
+Normally only one rclone process may use a cache directory at once.
+With !--vfs-cache-shared! several rclone processes using the same
+remote and !--cache-dir! can share the cache, so a file downloaded by
+one of them can be read by the others without downloading it again.
+
+Each process locks the files it is using in the cache so that another
+process won't remove them when enforcing !--vfs-cache-max-age! or
+!--vfs-cache-max-size!, and a file written by one process is only
+uploaded by that process. If the process exits before the upload is
+done, the next process to start using the cache will upload it.
+
+Only one process may write to a file at once. From when a process
+opens a file for writing until it has finished uploading it, opening
+the file for writing in any other process fails with !EBUSY!, though
+it can still be opened for reading.
+
+The cache size limits apply to the cache as a whole, but pinned files
+are only kept by the process which pinned them.
+
+This flag needs file locking support from the OS so it isn't
+available on all platforms. All the processes sharing the cache must
+set it.
+
 #### Fingerprinting
 
 Various parts of the VFS use fingerprinting to see if a local file
@@ -255,6 +341,27 @@ When !--vfs-read-chunk-size-limit 500M! is specified, the result would be
 
 Setting !--vfs-read-chunk-size! to !0! or "off" disables chunked reading.
 
+#### Multi-stream downloads
+
+With !--vfs-cache-mode full! a single stream from the remote may not
+be fast enough to read large files such as videos or disk images.
+If !--vfs-download-streams! is set to more than 1 then files are
+downloaded into the cache by that many concurrent streams, each
+fetching the next !--vfs-download-chunk-size! part of the file, in
+the same way as !--multi-thread-streams! does for copies. This only
+applies to reads which have more than one chunk left to read, so
+small files are still read with a single stream.
+
+    --vfs-download-streams int             Number of concurrent streams to download with when using cache-mode full (0 or 1 to disable)
+    --vfs-download-chunk-size SizeSuffix   Size of the chunk each --vfs-download-streams stream downloads (default 16Mi)
+
+Each stream holds a chunk in memory, so this uses up to
+!--vfs-download-streams! times !--vfs-download-chunk-size! of memory
+for each open file being downloaded. The chunks are downloaded even if
+the file is only read a little way, so larger values waste more
+bandwidth for random access. These flags replace the
+!--vfs-read-chunk-size! flags for the downloads they apply to.
+
 ### VFS Performance
 
 These flags may be used to enable/disable features of the VFS for
@@ -284,6 +391,131 @@ modified files from the cache (the related global flag !--checkers! has no effec
 
     --transfers int  Number of file transfers to run in parallel (default 4)
 
+### VFS Permissions
+
+Normally every file is shown with the permissions from !--file-perms!
+and the owner from !--uid! and !--gid!, and changes made with !chmod!
+and !chown! are ignored.
+
+If !--vfs-metadata-perms! is set then the permissions and ownership
+of files are read from the !mode!, !uid! and !gid! metadata of the
+objects, where the backend supports it, falling back to the flags for
+any which are missing. On backends which can write metadata !chmod!
+and !chown! are saved back to the object in the same way as other
+metadata changes, see [VFS Metadata](#vfs-metadata). This means they
+fail with "operation not supported" on files over 1 MiB which aren't
+being written, rather than the change being lost.
+
+    --vfs-metadata-perms  Read and write file permissions and ownership from the metadata
+
+Directories don't have metadata so they always use !--dir-perms!,
+!--uid! and !--gid!, and !chmod! and !chown! of a directory fail with
+"operation not supported".
+
+The kernel only enforces the permissions if the mount has the
+!--default-permissions! flag.
+
+### VFS Metadata
+
+On mounts the metadata of files is available as extended attributes
+with a !user.rclone.! prefix, so for example the !content-type!
+metadata can be read with
+
+    getfattr -n user.rclone.content-type /mnt/remote/file.txt
+
+and all of it listed with !getfattr -d -m user.rclone. /mnt/remote/file.txt!.
+
+On backends which can write metadata (see the !Features! table in the
+overview) the attributes can also be set and removed with !setfattr!.
+If the file is being uploaded the metadata is sent with the upload.
+Otherwise, as there is no way of changing the metadata on its own,
+files up to 1 MiB are uploaded again with the new metadata. Changing
+the metadata of larger files fails with "Operation not supported"
+unless they are open for writing or waiting to be uploaded from the
+VFS cache.
+Some backends merge the metadata on upload so attributes can't be
+removed from them, in which case removing an attribute fails with
+"Operation not supported".
+
+Which metadata is available depends on the backend - see the
+[metadata](/docs/#metadata) docs for details.
+
+### VFS Symlinks
+
+By default the VFS does not support symlinks. If the !--vfs-links!
+flag is given then files with a !.rclonelink! extension are shown as
+symlinks (without the extension) and creating a symlink stores a
+!.rclonelink! file on the remote. This works on any backend.
+
+The file contains the link target as plain text, which is the same
+format the local backend uses with its !--links! flag, so a tree of
+symlinks can be copied to a remote with !rclone copy --links! and
+then used on a mount, or created on a mount and restored with
+!rclone copy --links!.
+
+    --vfs-links  Translate symlinks to/from regular files with a '.rclonelink' extension
+
+The targets of the symlinks are not interpreted by rclone, so
+relative symlinks work within the mount and absolute symlinks point
+to paths on the machine using the mount.
+
+### VFS Locking
+
+The VFS supports advisory file locks made with !flock! and !fcntl!
+(POSIX byte range locks) with !rclone mount! on Linux and FreeBSD and
+with !rclone mount2!. These
+are shared between all the processes using the mount, so for example
+two programs running !flock! on the same file will wait for each
+other. The locks are advisory so they don't stop files being read or
+written, and they are only held in memory so they are lost when
+rclone exits.
+
+Normally the locks only apply to processes using the same rclone. If
+!--vfs-lock-remote! is set then while a file is locked a lock object
+with the same name plus a !.rclonelock! extension is kept on the
+remote. Other rclone instances using this flag will wait until it is
+removed before locking the file. The lock object is rewritten every
+20 seconds and expires after a minute if not rewritten, so a lock
+held by an rclone which was killed will eventually be freed. The
+!.rclonelock! files are hidden from directory listings.
+
+    --vfs-lock-remote  Hold lock objects on the remote so file locks are seen by other rclone instances
+
+As remotes don't provide atomic operations this is best effort only
+and two instances locking a file at the same moment could both
+succeed. Taking a lock also needs several transactions with the
+remote so it will be much slower than a local lock.
+
+### VFS Overlay
+
+With !--overlay-dir /path/to/dir! the remote is never written to.
+Instead any changes are kept in the local directory given, which
+acts as a copy-on-write layer over the remote.
+
+- Files created or changed are stored in the overlay directory and
+  shadow the files on the remote. Changing a file on the remote
+  without rewriting it, for example setting its modification time,
+  copies it into the overlay first.
+- Files and directories deleted are recorded in the overlay as
+  whiteouts which hide them on the remote.
+- Renaming a directory copies the files in it which are only on the
+  remote into the overlay, and records a whiteout for the old name.
+
+The changes are kept across restarts. They can be written to the
+remote with the !vfs/overlay-commit! remote control command, which
+deletes what was deleted and then copies the changed files, or
+thrown away with !vfs/overlay-discard!. Removing the overlay directory
+while rclone isn't running also throws them away. The mount can be
+used while the changes are committed, and anything changed while the
+commit is running is kept for the next commit.
+
+This is useful for running jobs which need to change a large dataset
+without risking changing the original.
+
+The overlay directory should be on a local disk, and shouldn't be
+inside the !--cache-dir!. The VFS cache, if used, is kept separately
+from the cache used without the overlay.
+
 ### VFS Case Sensitivity
 
 Linux file systems are case-sensitive: two files can differ only
@@ -0,0 +1,140 @@
+package vfs
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// With --vfs-links symlinks are stored on the remote as small files
+// with a LinkSuffix extension containing the link target, the same
+// format the local backend uses with --links. The VFS shows them
+// without the suffix as symlinks.
+
+// LinkSuffix is the extension used for files which represent symlinks
+const LinkSuffix = ".rclonelink"
+
+// maxLinkSize is the largest link target which will be read
+const maxLinkSize = 4096
+
+// _linkName returns the name leaf should be shown as in the directory
+//
+// must be called with the lock held
+func (d *Dir) _linkName(leaf string) string {
+	if d.vfs.Opt.Links && len(leaf) > len(LinkSuffix) && strings.HasSuffix(leaf, LinkSuffix) {
+		return leaf[:len(leaf)-len(LinkSuffix)]
+	}
+	return leaf
+}
+
+// remotePath returns the path of node on the remote and in the cache
+// which is different from its Path for symlinks
+func remotePath(node Node) string {
+	if f, ok := node.(*File); ok {
+		return f.remotePath()
+	}
+	return node.Path()
+}
+
+// statRemote finds the node for remote, a path on the remote or in
+// the cache
+func (vfs *VFS) statRemote(remote string) (Node, error) {
+	if vfs.Opt.Links && len(path.Base(remote)) > len(LinkSuffix) && strings.HasSuffix(remote, LinkSuffix) {
+		node, err := vfs.Stat(remote[:len(remote)-len(LinkSuffix)])
+		if f, ok := node.(*File); err == nil && ok && f.IsSymlink() {
+			return node, nil
+		}
+	}
+	return vfs.Stat(remote)
+}
+
+// IsSymlink returns true if the file represents a symlink
+func (f *File) IsSymlink() bool {
+	return f.isLink
+}
+
+// Readlink returns the target of the symlink
+func (f *File) Readlink() (target string, err error) {
+	if !f.isLink {
+		return "", EINVAL
+	}
+	fh, err := f.Open(os.O_RDONLY)
+	if err != nil {
+		return "", err
+	}
+	defer fs.CheckClose(fh, &err)
+	data, err := io.ReadAll(io.LimitReader(fh, maxLinkSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxLinkSize {
+		fs.Errorf(f, "Symlink target too long")
+		return "", EINVAL
+	}
+	return string(data), nil
+}
+
+// Symlink creates a symlink called name in the directory pointing to
+// target
+func (d *Dir) Symlink(target, name string) (*File, error) {
+	if !d.vfs.Opt.Links {
+		return nil, ENOSYS
+	}
+	if d.vfs.Opt.ReadOnly {
+		return nil, EROFS
+	}
+	if target == "" || len(target) > maxLinkSize {
+		return nil, EINVAL
+	}
+	_, err := d.stat(name)
+	switch err {
+	case ENOENT:
+		// not found, carry on
+	case nil:
+		return nil, EEXIST
+	default:
+		fs.Errorf(d, "Dir.Symlink stat failed: %v", err)
+		return nil, err
+	}
+	file := newFile(d, d.Path(), nil, name+LinkSuffix)
+	fh, err := file.Open(os.O_WRONLY | os.O_CREATE | os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fh.WriteString(target)
+	closeErr := fh.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fs.Errorf(d, "Dir.Symlink failed to write %q: %v", name, err)
+		return nil, err
+	}
+	return file, nil
+}
+
+// Symlink creates a symlink at newpath pointing to target
+func (vfs *VFS) Symlink(target, newpath string) error {
+	dir, leaf, err := vfs.StatParent(newpath)
+	if err != nil {
+		return err
+	}
+	_, err = dir.Symlink(target, leaf)
+	return err
+}
+
+// Readlink returns the target of the symlink at name
+func (vfs *VFS) Readlink(name string) (string, error) {
+	node, err := vfs.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	file, ok := node.(*File)
+	if !ok {
+		return "", EINVAL
+	}
+	return file.Readlink()
+}
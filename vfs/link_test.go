@@ -0,0 +1,111 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkDisabled(t *testing.T) {
+	r, vfs := newTestVFS(t)
+	ctx := context.Background()
+
+	file1 := r.WriteObject(ctx, "link"+LinkSuffix, "target", t1)
+	r.CheckRemoteItems(t, file1)
+
+	// Without --vfs-links the link file is an ordinary file
+	node, err := vfs.Stat("link" + LinkSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0), node.Mode()&os.ModeSymlink)
+
+	_, err = vfs.Readlink("link" + LinkSuffix)
+	assert.Equal(t, EINVAL, err)
+	assert.Equal(t, ENOSYS, vfs.Symlink("target", "newlink"))
+}
+
+func TestLink(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.Links = true
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+
+	file1 := r.WriteObject(ctx, "dir/link"+LinkSuffix, "../file", t1)
+	r.CheckRemoteItems(t, file1)
+
+	// Existing link files show up as symlinks
+	node, err := vfs.Stat("dir/link")
+	require.NoError(t, err)
+	assert.Equal(t, "link", node.Name())
+	assert.Equal(t, os.ModeSymlink, node.Mode()&os.ModeSymlink)
+	assert.Equal(t, "dir/link", node.Path())
+	_, err = vfs.Stat("dir/link" + LinkSuffix)
+	assert.Equal(t, ENOENT, err)
+	target, err := vfs.Readlink("dir/link")
+	require.NoError(t, err)
+	assert.Equal(t, "../file", target)
+
+	// Create a new link
+	require.NoError(t, vfs.Symlink("dir/link", "link2"))
+	assert.Equal(t, EEXIST, vfs.Symlink("somewhere", "link2"))
+	target, err = vfs.Readlink("link2")
+	require.NoError(t, err)
+	assert.Equal(t, "dir/link", target)
+	file2 := fstest.NewItem("link2"+LinkSuffix, "dir/link", t1)
+	fstest.CheckListingWithPrecision(t, r.Fremote, []fstest.Item{file1, file2}, []string{"dir"}, fs.ModTimeNotSupported)
+
+	// Rename the link keeping its suffix
+	require.NoError(t, vfs.Rename("link2", "dir/link3"))
+	target, err = vfs.Readlink("dir/link3")
+	require.NoError(t, err)
+	assert.Equal(t, "dir/link", target)
+	file2.Path = "dir/link3" + LinkSuffix
+	fstest.CheckListingWithPrecision(t, r.Fremote, []fstest.Item{file1, file2}, []string{"dir"}, fs.ModTimeNotSupported)
+
+	// Remove the link
+	require.NoError(t, vfs.Remove("dir/link3"))
+	fstest.CheckListingWithPrecision(t, r.Fremote, []fstest.Item{file1}, []string{"dir"}, fs.ModTimeNotSupported)
+
+	// Readlink on a directory is invalid
+	_, err = vfs.Readlink("dir")
+	assert.Equal(t, EINVAL, err)
+}
+
+func TestLinkCache(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.Links = true
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+
+	// A link written through the cache is uploaded with its suffix
+	require.NoError(t, vfs.Mkdir("dir", 0777))
+	require.NoError(t, vfs.Symlink("target", "dir/link"))
+	node, err := vfs.Stat("dir/link")
+	require.NoError(t, err)
+	assert.Equal(t, "dir/link", node.Path())
+	assert.Equal(t, "dir/link"+LinkSuffix, remotePath(node))
+	vfs.WaitForWriters(10 * time.Second)
+	file1 := fstest.NewItem("dir/link"+LinkSuffix, "target", t1)
+	fstest.CheckListingWithPrecision(t, r.Fremote, []fstest.Item{file1}, []string{"dir"}, fs.ModTimeNotSupported)
+
+	// Pinning a link keeps the link file in the cache
+	require.NoError(t, vfs.Pin(ctx, "dir/link"))
+	assert.True(t, vfs.IsPinned("dir/link"))
+	assert.True(t, vfs.cache.IsPinned("dir/link"+LinkSuffix))
+	assert.True(t, vfs.cache.Exists("dir/link"+LinkSuffix))
+	assert.False(t, vfs.cache.Exists("dir/link"))
+	node, err = vfs.statRemote("dir/link" + LinkSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, "dir/link", node.Path())
+	require.NoError(t, vfs.Unpin("dir/link"))
+	assert.False(t, vfs.IsPinned("dir/link"))
+}
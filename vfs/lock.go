@@ -0,0 +1,349 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// The VFS keeps a table of the advisory locks held on its files so
+// that processes using the same mount can coordinate with flock and
+// fcntl locks. The locks are advisory only - they don't stop anyone
+// reading or writing the file.
+//
+// Locks are keyed on the inode of the node so they follow it through
+// renames. POSIX (fcntl) locks and flock locks are kept separately
+// as they don't interact with each other.
+//
+// With --vfs-lock-remote a lock object is also held on the remote
+// while any lock is held on a file so other rclone instances using
+// the same option can see it.
+
+// LockType is the type of an advisory lock
+type LockType int
+
+// Types of lock
+const (
+	LockUnlock LockType = iota // no lock - used to release locks
+	LockRead                   // shared lock
+	LockWrite                  // exclusive lock
+)
+
+// String turns a LockType into a string for debugging
+func (t LockType) String() string {
+	switch t {
+	case LockUnlock:
+		return "unlock"
+	case LockRead:
+		return "read"
+	case LockWrite:
+		return "write"
+	}
+	return fmt.Sprintf("LockType(%d)", int(t))
+}
+
+// LockEOF can be used as Lock.End to lock to the end of the file
+const LockEOF = math.MaxUint64
+
+// Lock describes an advisory lock on a byte range of a file
+type Lock struct {
+	Type  LockType
+	Start uint64 // first byte of the range
+	End   uint64 // last byte of the range - LockEOF for the end of the file
+	Owner uint64 // identifies the owner of the lock
+	PID   uint32 // process holding the lock - for information only
+	Flock bool   // set for flock locks, unset for POSIX locks
+}
+
+// overlaps returns true if the ranges of the locks overlap
+func (lk *Lock) overlaps(other *Lock) bool {
+	return lk.Start <= other.End && other.Start <= lk.End
+}
+
+// conflicts returns true if other would stop lk being taken
+func (lk *Lock) conflicts(other *Lock) bool {
+	return lk.Flock == other.Flock &&
+		lk.Owner != other.Owner &&
+		(lk.Type == LockWrite || other.Type == LockWrite) &&
+		lk.overlaps(other)
+}
+
+// fileLocks are the locks held on a single node
+type fileLocks struct {
+	// these are protected by lockTable.mu
+	locks     []Lock
+	changed   chan struct{} // closed when any locks are released
+	users     int           // number of callers using this
+	hasRemote bool          // set if remote is set
+
+	remoteMu sync.Mutex  // serialises changes to the locks on this node
+	remote   *remoteLock // lock object on the remote, if held
+}
+
+// lockTable holds all the advisory locks for the VFS
+type lockTable struct {
+	vfs   *VFS
+	id    string // identifies this VFS in remote locks
+	mu    sync.Mutex
+	files map[uint64]*fileLocks
+}
+
+// newLockTable makes a new lockTable for vfs
+func newLockTable(vfs *VFS) *lockTable {
+	return &lockTable{
+		vfs:   vfs,
+		id:    newRemoteLockID(),
+		files: make(map[uint64]*fileLocks),
+	}
+}
+
+// get finds or makes the fileLocks for inode
+//
+// it must be returned with put
+func (lt *lockTable) get(inode uint64) *fileLocks {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	fl := lt.files[inode]
+	if fl == nil {
+		fl = &fileLocks{
+			changed: make(chan struct{}),
+		}
+		lt.files[inode] = fl
+	}
+	fl.users++
+	return fl
+}
+
+// put returns the fileLocks got with get, removing it from the table
+// if it is no longer needed
+func (lt *lockTable) put(inode uint64, fl *fileLocks) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	fl.users--
+	if fl.users == 0 && len(fl.locks) == 0 && !fl.hasRemote {
+		delete(lt.files, inode)
+	}
+}
+
+// _conflict returns the first lock which conflicts with lk or nil
+//
+// call with lt.mu held
+func (fl *fileLocks) _conflict(lk *Lock) *Lock {
+	if lk.Type == LockUnlock {
+		return nil
+	}
+	for i := range fl.locks {
+		if lk.conflicts(&fl.locks[i]) {
+			return &fl.locks[i]
+		}
+	}
+	return nil
+}
+
+// _apply sets lk replacing any locks held by the same owner in the
+// range, splitting them if necessary.
+//
+// call with lt.mu held
+func (fl *fileLocks) _apply(lk Lock) {
+	released := false
+	locks := fl.locks[:0:0]
+	for _, old := range fl.locks {
+		if old.Flock != lk.Flock || old.Owner != lk.Owner || !old.overlaps(&lk) {
+			locks = append(locks, old)
+			continue
+		}
+		released = true
+		if old.Start < lk.Start {
+			before := old
+			before.End = lk.Start - 1
+			locks = append(locks, before)
+		}
+		if old.End > lk.End {
+			after := old
+			after.Start = lk.End + 1
+			locks = append(locks, after)
+		}
+	}
+	if lk.Type != LockUnlock {
+		locks = append(locks, lk)
+	}
+	fl.locks = locks
+	if released {
+		// wake up anyone waiting
+		close(fl.changed)
+		fl.changed = make(chan struct{})
+	}
+}
+
+// _release removes all the locks of owner
+//
+// call with lt.mu held
+func (fl *fileLocks) _release(owner uint64, flock bool) {
+	fl._apply(Lock{
+		Type:  LockUnlock,
+		Start: 0,
+		End:   LockEOF,
+		Owner: owner,
+		Flock: flock,
+	})
+}
+
+// getLock returns the first lock on node which conflicts with lk or
+// nil if there isn't one
+func (lt *lockTable) getLock(node Node, lk Lock) *Lock {
+	inode := node.Inode()
+	fl := lt.get(inode)
+	defer lt.put(inode, fl)
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if conflict := fl._conflict(&lk); conflict != nil {
+		c := *conflict
+		return &c
+	}
+	return nil
+}
+
+// setLock sets or releases lk on node
+//
+// If the lock can't be taken it returns EAGAIN and a channel which
+// will be closed or receive a value when it is worth trying again.
+func (lt *lockTable) setLock(node Node, lk Lock) (retry <-chan time.Time, wait <-chan struct{}, err error) {
+	inode := node.Inode()
+	fl := lt.get(inode)
+	defer lt.put(inode, fl)
+	fl.remoteMu.Lock()
+	defer fl.remoteMu.Unlock()
+
+	lt.mu.Lock()
+	if conflict := fl._conflict(&lk); conflict != nil {
+		wait = fl.changed
+		lt.mu.Unlock()
+		return nil, wait, EAGAIN
+	}
+	lt.mu.Unlock()
+
+	// Take the remote lock if required before taking the first
+	// lock on a file
+	if lk.Type != LockUnlock && fl.remote == nil && lt.vfs.Opt.LockRemote && node.IsFile() {
+		remote, err := acquireRemoteLock(context.TODO(), lt.vfs.f, node.Path()+remoteLockSuffix, lt.id)
+		if err == EAGAIN {
+			return time.After(remoteLockPollInterval), nil, EAGAIN
+		} else if err != nil {
+			fs.Errorf(node, "Failed to take lock on remote: %v", err)
+			return nil, nil, err
+		}
+		lt.mu.Lock()
+		fl.remote = remote
+		fl.hasRemote = true
+		lt.mu.Unlock()
+	}
+
+	lt.mu.Lock()
+	fl._apply(lk)
+	remote := fl._takeRemoteIfUnused()
+	lt.mu.Unlock()
+	if remote != nil {
+		remote.release()
+	}
+	return nil, nil, nil
+}
+
+// _takeRemoteIfUnused returns the remote lock if no locks are held on
+// the node so the caller can release it, or nil.
+//
+// call with lt.mu and fl.remoteMu held
+func (fl *fileLocks) _takeRemoteIfUnused() (remote *remoteLock) {
+	if len(fl.locks) != 0 || fl.remote == nil {
+		return nil
+	}
+	remote = fl.remote
+	fl.remote = nil
+	fl.hasRemote = false
+	return remote
+}
+
+// releaseLocks removes all the locks of owner on node
+func (lt *lockTable) releaseLocks(node Node, owner uint64, flock bool) {
+	inode := node.Inode()
+	fl := lt.get(inode)
+	defer lt.put(inode, fl)
+	fl.remoteMu.Lock()
+	defer fl.remoteMu.Unlock()
+	lt.mu.Lock()
+	fl._release(owner, flock)
+	remote := fl._takeRemoteIfUnused()
+	lt.mu.Unlock()
+	if remote != nil {
+		remote.release()
+	}
+}
+
+// shutdown releases all the locks held
+//
+// The remote locks are released after lt.mu is unlocked as that
+// needs network I/O.
+func (lt *lockTable) shutdown() {
+	var remotes []*remoteLock
+	lt.mu.Lock()
+	for inode, fl := range lt.files {
+		if fl.remote != nil {
+			remotes = append(remotes, fl.remote)
+			fl.remote = nil
+			fl.hasRemote = false
+		}
+		fl.locks = nil
+		close(fl.changed)
+		fl.changed = make(chan struct{})
+		delete(lt.files, inode)
+	}
+	lt.mu.Unlock()
+	for _, remote := range remotes {
+		remote.release()
+	}
+}
+
+// GetLock returns the first lock held on node which would stop lk
+// being taken, or nil if lk could be taken.
+func (vfs *VFS) GetLock(node Node, lk Lock) *Lock {
+	return vfs.locks.getLock(node, lk)
+}
+
+// SetLock takes or, if lk.Type is LockUnlock, releases the lock lk
+// on node.
+//
+// If a conflicting lock is held it returns EAGAIN.
+func (vfs *VFS) SetLock(node Node, lk Lock) error {
+	_, _, err := vfs.locks.setLock(node, lk)
+	return err
+}
+
+// SetLockWait is like SetLock but waits for conflicting locks to be
+// released.
+//
+// It returns EINTR if ctx is cancelled while waiting.
+func (vfs *VFS) SetLockWait(ctx context.Context, node Node, lk Lock) error {
+	for {
+		retry, wait, err := vfs.locks.setLock(node, lk)
+		if err != EAGAIN {
+			return err
+		}
+		select {
+		case <-wait:
+		case <-retry:
+		case <-ctx.Done():
+			return EINTR
+		}
+	}
+}
+
+// ReleaseLocks releases all the locks held by owner on node.
+//
+// If flock is set then the flock locks are released, otherwise the
+// POSIX locks are.
+func (vfs *VFS) ReleaseLocks(node Node, owner uint64, flock bool) {
+	vfs.locks.releaseLocks(node, owner, flock)
+}
@@ -0,0 +1,155 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockApply(t *testing.T) {
+	var fl fileLocks
+	fl.changed = make(chan struct{})
+	lk := Lock{Type: LockWrite, Start: 0, End: 99, Owner: 1}
+	fl._apply(lk)
+	assert.Equal(t, []Lock{lk}, fl.locks)
+
+	// Unlocking the middle splits the lock and wakes waiters
+	changed := fl.changed
+	fl._apply(Lock{Type: LockUnlock, Start: 10, End: 19, Owner: 1})
+	assert.Equal(t, []Lock{
+		{Type: LockWrite, Start: 0, End: 9, Owner: 1},
+		{Type: LockWrite, Start: 20, End: 99, Owner: 1},
+	}, fl.locks)
+	select {
+	case <-changed:
+	default:
+		t.Error("waiters not woken")
+	}
+
+	// Other owners and flock locks are left alone
+	fl._apply(Lock{Type: LockRead, Start: 0, End: LockEOF, Owner: 2})
+	fl._apply(Lock{Type: LockWrite, Start: 0, End: LockEOF, Owner: 1, Flock: true})
+	fl._release(1, false)
+	assert.Equal(t, []Lock{
+		{Type: LockRead, Start: 0, End: LockEOF, Owner: 2},
+		{Type: LockWrite, Start: 0, End: LockEOF, Owner: 1, Flock: true},
+	}, fl.locks)
+}
+
+func TestLockConflicts(t *testing.T) {
+	for _, test := range []struct {
+		a, b Lock
+		want bool
+	}{
+		{Lock{Type: LockRead, End: 9, Owner: 1}, Lock{Type: LockRead, End: 9, Owner: 2}, false},
+		{Lock{Type: LockRead, End: 9, Owner: 1}, Lock{Type: LockWrite, End: 9, Owner: 2}, true},
+		{Lock{Type: LockWrite, End: 9, Owner: 1}, Lock{Type: LockWrite, End: 9, Owner: 1}, false},
+		{Lock{Type: LockWrite, End: 9, Owner: 1}, Lock{Type: LockWrite, Start: 10, End: 19, Owner: 2}, false},
+		{Lock{Type: LockWrite, End: 9, Owner: 1}, Lock{Type: LockWrite, Start: 9, End: 19, Owner: 2}, true},
+		{Lock{Type: LockWrite, End: 9, Owner: 1}, Lock{Type: LockWrite, End: 9, Owner: 2, Flock: true}, false},
+	} {
+		assert.Equal(t, test.want, test.a.conflicts(&test.b), "%+v %+v", test.a, test.b)
+		assert.Equal(t, test.want, test.b.conflicts(&test.a), "%+v %+v", test.b, test.a)
+	}
+}
+
+func TestLock(t *testing.T) {
+	r, vfs := newTestVFS(t)
+	r.WriteObject(context.Background(), "file1", "file1 contents", t1)
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+
+	write1 := Lock{Type: LockWrite, Start: 0, End: LockEOF, Owner: 1, PID: 100}
+	write2 := Lock{Type: LockWrite, Start: 0, End: LockEOF, Owner: 2, PID: 200}
+	read2 := Lock{Type: LockRead, Start: 0, End: LockEOF, Owner: 2, PID: 200}
+
+	assert.Nil(t, vfs.GetLock(node, write2))
+	require.NoError(t, vfs.SetLock(node, write1))
+	assert.Equal(t, EAGAIN, vfs.SetLock(node, write2))
+	assert.Equal(t, EAGAIN, vfs.SetLock(node, read2))
+	conflict := vfs.GetLock(node, read2)
+	require.NotNil(t, conflict)
+	assert.Equal(t, write1, *conflict)
+
+	// Owner 1 can downgrade its lock so owner 2 can share it
+	read1 := write1
+	read1.Type = LockRead
+	require.NoError(t, vfs.SetLock(node, read1))
+	require.NoError(t, vfs.SetLock(node, read2))
+	assert.Equal(t, EAGAIN, vfs.SetLock(node, write1))
+
+	// Waiting times out
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	assert.Equal(t, EINTR, vfs.SetLockWait(ctx, node, write1))
+	cancel()
+
+	// Waiting succeeds when the lock is released
+	done := make(chan error)
+	go func() {
+		done <- vfs.SetLockWait(context.Background(), node, write1)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	vfs.ReleaseLocks(node, 2, false)
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for lock")
+	}
+
+	// Locks follow the file through a rename
+	require.NoError(t, vfs.Rename("file1", "file2"))
+	node2, err := vfs.Stat("file2")
+	require.NoError(t, err)
+	assert.Equal(t, EAGAIN, vfs.SetLock(node2, write2))
+
+	vfs.ReleaseLocks(node, 1, false)
+	require.NoError(t, vfs.SetLock(node2, write2))
+	vfs.ReleaseLocks(node, 2, false)
+	assert.Len(t, vfs.locks.files, 0)
+}
+
+func TestLockRemote(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.LockRemote = true
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+	r.WriteObject(ctx, "file1", "file1 contents", t1)
+
+	// A second VFS on the same remote as if it was another rclone -
+	// change an option so it isn't shared from the active cache
+	opt2 := opt
+	opt2.DirCacheTime++
+	vfs2 := New(r.Fremote, &opt2)
+	defer cleanupVFS(t, vfs2)
+
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+	node2, err := vfs2.Stat("file1")
+	require.NoError(t, err)
+
+	lk := Lock{Type: LockWrite, Start: 0, End: LockEOF, Owner: 1}
+	require.NoError(t, vfs.SetLock(node, lk))
+	_, err = r.Fremote.NewObject(ctx, "file1"+remoteLockSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, EAGAIN, vfs2.SetLock(node2, lk))
+
+	// The lock object is hidden
+	vfs.FlushDirCache()
+	_, err = vfs.Stat("file1" + remoteLockSuffix)
+	assert.Equal(t, ENOENT, err)
+
+	// Releasing the lock removes the lock object
+	vfs.ReleaseLocks(node, 1, false)
+	_, err = r.Fremote.NewObject(ctx, "file1"+remoteLockSuffix)
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	require.NoError(t, vfs2.SetLock(node2, lk))
+	assert.Equal(t, EAGAIN, vfs.SetLock(node, lk))
+	vfs2.ReleaseLocks(node2, 1, false)
+}
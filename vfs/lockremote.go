@@ -0,0 +1,180 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/random"
+)
+
+// With --vfs-lock-remote a lock object is written next to a file on
+// the remote while any lock is held on it. The lock object records
+// which rclone instance holds it and when it expires. The holder
+// rewrites it periodically so if the holder dies the lock will expire
+// and can be taken by someone else.
+//
+// This is a best effort scheme - remotes aren't atomic so two
+// instances racing to take a lock could both succeed. The lock object
+// is read back after writing it to make this unlikely.
+
+const (
+	remoteLockSuffix       = ".rclonelock"
+	remoteLockTTL          = time.Minute
+	remoteLockPollInterval = time.Second
+)
+
+// remoteLockRecord is the contents of a lock object
+type remoteLockRecord struct {
+	ID      string    // identifies the instance holding the lock
+	Host    string    // hostname of the holder - for information only
+	PID     int       // process ID of the holder - for information only
+	Expires time.Time // when the lock expires if not refreshed
+}
+
+// remoteLock is a lock object held on the remote
+type remoteLock struct {
+	f      fs.Fs
+	remote string
+	id     string
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// newRemoteLockID makes a new identifier for the lock objects of this VFS
+func newRemoteLockID() string {
+	return random.String(16)
+}
+
+// _isRemoteLock returns true if leaf should be hidden from the
+// listing because it is a lock object
+//
+// call with d.mu held
+func (d *Dir) _isRemoteLock(leaf string) bool {
+	return d.vfs.Opt.LockRemote && strings.HasSuffix(leaf, remoteLockSuffix)
+}
+
+// readRemoteLock reads the lock object at remote returning nil if
+// there isn't one
+func readRemoteLock(ctx context.Context, f fs.Fs, remote string) (record *remoteLockRecord, err error) {
+	o, err := f.NewObject(ctx, remote)
+	if err == fs.ErrorObjectNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	record = new(remoteLockRecord)
+	decodeErr := json.NewDecoder(io.LimitReader(in, maxLinkSize)).Decode(record)
+	if decodeErr != nil {
+		// A corrupted lock object is treated as expired
+		fs.Debugf(remote, "Ignoring unreadable lock object: %v", decodeErr)
+		return &remoteLockRecord{}, nil
+	}
+	return record, nil
+}
+
+// write writes the lock object with a new expiry time
+func (rl *remoteLock) write(ctx context.Context) error {
+	host, _ := os.Hostname()
+	data, err := json.Marshal(remoteLockRecord{
+		ID:      rl.id,
+		Host:    host,
+		PID:     os.Getpid(),
+		Expires: time.Now().Add(remoteLockTTL),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = operations.RcatSize(ctx, rl.f, rl.remote, io.NopCloser(bytes.NewReader(data)), int64(len(data)), time.Now(), nil)
+	return err
+}
+
+// acquireRemoteLock takes the lock object at remote for id
+//
+// It returns EAGAIN if someone else holds the lock.
+func acquireRemoteLock(ctx context.Context, f fs.Fs, remote string, id string) (*remoteLock, error) {
+	record, err := readRemoteLock(ctx, f, remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock object: %w", err)
+	}
+	if record != nil && record.ID != id && time.Now().Before(record.Expires) {
+		fs.Debugf(remote, "Lock held by %s pid %d until %v", record.Host, record.PID, record.Expires)
+		return nil, EAGAIN
+	}
+	rl := &remoteLock{
+		f:      f,
+		remote: remote,
+		id:     id,
+		stop:   make(chan struct{}),
+	}
+	err = rl.write(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write lock object: %w", err)
+	}
+	// Read it back to check we won any race
+	record, err = readRemoteLock(ctx, f, remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read back lock object: %w", err)
+	}
+	if record == nil || record.ID != id {
+		return nil, EAGAIN
+	}
+	rl.wg.Add(1)
+	go rl.refresh()
+	return rl, nil
+}
+
+// refresh rewrites the lock object until release is called
+func (rl *remoteLock) refresh() {
+	defer rl.wg.Done()
+	ticker := time.NewTicker(remoteLockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := rl.write(context.Background())
+			if err != nil {
+				fs.Errorf(rl.remote, "Failed to refresh lock object: %v", err)
+			}
+		case <-rl.stop:
+			return
+		}
+	}
+}
+
+// release stops refreshing the lock object and removes it if it is
+// still ours
+func (rl *remoteLock) release() {
+	close(rl.stop)
+	rl.wg.Wait()
+	ctx := context.Background()
+	record, err := readRemoteLock(ctx, rl.f, rl.remote)
+	if err != nil {
+		fs.Errorf(rl.remote, "Failed to read lock object: %v", err)
+		return
+	}
+	if record == nil || record.ID != rl.id {
+		fs.Debugf(rl.remote, "Lock object no longer ours - not removing")
+		return
+	}
+	o, err := rl.f.NewObject(ctx, rl.remote)
+	if err == nil {
+		err = o.Remove(ctx)
+	}
+	if err != nil {
+		fs.Errorf(rl.remote, "Failed to remove lock object: %v", err)
+	}
+}
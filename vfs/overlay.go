@@ -0,0 +1,61 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rclone/rclone/vfs/vfsoverlay"
+)
+
+// ErrNoOverlay is returned when trying to commit or discard the
+// overlay without --overlay-dir
+var ErrNoOverlay = errors.New("no overlay - need --overlay-dir")
+
+// overlay returns the overlay the VFS is using
+func (vfs *VFS) overlay() (*vfsoverlay.Fs, error) {
+	overlay, ok := vfs.f.(*vfsoverlay.Fs)
+	if !ok {
+		return nil, ErrNoOverlay
+	}
+	return overlay, nil
+}
+
+// OverlayCommit writes the changes kept in the overlay to the remote
+// and empties the overlay.
+//
+// It returns an error if there are files still to be written to the
+// overlay from the VFS cache.
+func (vfs *VFS) OverlayCommit(ctx context.Context) error {
+	overlay, err := vfs.overlay()
+	if err != nil {
+		return err
+	}
+	if vfs.cache != nil {
+		if queued := len(vfs.cache.Queue()); queued > 0 {
+			return fmt.Errorf("%d files still to be written to the overlay - try again later", queued)
+		}
+	}
+	defer vfs.FlushDirCache()
+	return overlay.Commit(ctx)
+}
+
+// OverlayDiscard throws away the changes kept in the overlay.
+func (vfs *VFS) OverlayDiscard(ctx context.Context) error {
+	overlay, err := vfs.overlay()
+	if err != nil {
+		return err
+	}
+	defer vfs.FlushDirCache()
+	return overlay.Discard(ctx)
+}
+
+// OverlayChanges returns the number of files changed and the number of
+// files and directories deleted in the overlay.
+func (vfs *VFS) OverlayChanges(ctx context.Context) (changed, deleted int, err error) {
+	overlay, err := vfs.overlay()
+	if err != nil {
+		return 0, 0, err
+	}
+	return overlay.Changes(ctx)
+}
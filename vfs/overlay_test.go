@@ -0,0 +1,73 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile writes contents to name in the VFS
+func writeFile(t *testing.T, vfs *VFS, name string, contents string) {
+	fd, err := vfs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	require.NoError(t, err)
+	_, err = fd.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+}
+
+func TestVFSOverlay(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.OverlayDir = t.TempDir()
+	opt.CacheMode = vfscommon.CacheModeWrites
+	opt.WriteBack = 0
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+	file1 := r.WriteObject(ctx, "file1", "file1 contents", t1)
+	file2 := r.WriteObject(ctx, "dir/file2", "file2 contents", t1)
+
+	// Change the files through the VFS
+	writeFile(t, vfs, "file1", "changed")
+	writeFile(t, vfs, "dir/file3", "new")
+	require.NoError(t, vfs.Remove("dir/file2"))
+	_, err := vfs.Stat("dir/file2")
+	assert.Equal(t, ENOENT, err)
+	data, err := vfs.ReadFile("file1")
+	require.NoError(t, err)
+	assert.Equal(t, "changed", string(data))
+
+	// The remote is untouched
+	r.CheckRemoteItems(t, file1, file2)
+	changed, deleted, err := vfs.OverlayChanges(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, changed)
+	assert.Equal(t, 1, deleted)
+
+	// Discarding shows the remote again
+	require.NoError(t, vfs.OverlayDiscard(ctx))
+	data, err = vfs.ReadFile("file1")
+	require.NoError(t, err)
+	assert.Equal(t, "file1 contents", string(data))
+	_, err = vfs.Stat("dir/file2")
+	require.NoError(t, err)
+
+	// Committing writes the changes to the remote
+	writeFile(t, vfs, "file1", "changed")
+	require.NoError(t, vfs.Remove("dir/file2"))
+	require.NoError(t, vfs.OverlayCommit(ctx))
+	o, err := r.Fremote.NewObject(ctx, "file1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), o.Size())
+	_, err = r.Fremote.NewObject(ctx, "dir/file2")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+}
+
+func TestVFSOverlayNone(t *testing.T) {
+	_, vfs := newTestVFS(t)
+	assert.Equal(t, ErrNoOverlay, vfs.OverlayCommit(context.Background()))
+	assert.Equal(t, ErrNoOverlay, vfs.OverlayDiscard(context.Background()))
+}
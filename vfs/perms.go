@@ -0,0 +1,180 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// With --vfs-metadata-perms the mode, uid and gid of files are read
+// from the metadata of the object rather than being set from the
+// --file-perms, --uid and --gid flags, and chmod and chown write
+// them back.
+
+// sIFREG is the file type bits for a regular file in a unix mode
+const sIFREG = 0100000
+
+// filePerms is the permissions, uid and gid of a file read from its
+// metadata
+type filePerms struct {
+	perms os.FileMode
+	uid   uint32
+	gid   uint32
+}
+
+// metadataPerms returns the permissions, uid and gid for the file
+// from its metadata falling back to the defaults from the options for
+// any which are missing.
+//
+// The values are cached in the File until its metadata changes.
+func (f *File) metadataPerms() (perms os.FileMode, uid, gid uint32) {
+	opt := &f.d.vfs.Opt
+	if !opt.MetadataPerms {
+		return opt.FilePerms, opt.UID, opt.GID
+	}
+	f.mu.RLock()
+	p, gen := f.perms, f.permsGen
+	f.mu.RUnlock()
+	if p != nil {
+		return p.perms, p.uid, p.gid
+	}
+	p = &filePerms{perms: opt.FilePerms, uid: opt.UID, gid: opt.GID}
+	metadata, err := f.Metadata()
+	if err != nil {
+		fs.Debugf(f, "Failed to read metadata for permissions: %v", err)
+		return p.perms, p.uid, p.gid
+	}
+	if v, ok := parseMetadataUint(metadata, "mode", 8); ok {
+		p.perms = os.FileMode(v) & os.ModePerm
+	}
+	if v, ok := parseMetadataUint(metadata, "uid", 10); ok {
+		p.uid = v
+	}
+	if v, ok := parseMetadataUint(metadata, "gid", 10); ok {
+		p.gid = v
+	}
+	f.mu.Lock()
+	// don't cache the values if the metadata changed while reading
+	if f.permsGen == gen {
+		f.perms = p
+	}
+	f.mu.Unlock()
+	return p.perms, p.uid, p.gid
+}
+
+// _invalidatePerms forgets the cached permissions so they are read
+// from the metadata again
+//
+// Call with the mutex held
+func (f *File) _invalidatePerms() {
+	f.perms = nil
+	f.permsGen++
+}
+
+// parseMetadataUint reads key from metadata as an unsigned integer in
+// base
+func parseMetadataUint(metadata fs.Metadata, key string, base int) (uint32, bool) {
+	value, found := metadata[key]
+	if !found {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(value, base, 32)
+	if err != nil {
+		fs.Debugf(nil, "Failed to parse metadata %s: %q: %v", key, value, err)
+		return 0, false
+	}
+	return uint32(v), true
+}
+
+// Owner returns the uid and gid of the file
+func (f *File) Owner() (uid, gid uint32) {
+	_, uid, gid = f.metadataPerms()
+	return uid, gid
+}
+
+// Chmod changes the permissions of the file to those in mode
+//
+// This does nothing unless --vfs-metadata-perms is set
+func (f *File) Chmod(mode os.FileMode) error {
+	if !f.d.vfs.Opt.MetadataPerms {
+		return nil
+	}
+	if f.d.vfs.Opt.ReadOnly {
+		return EROFS
+	}
+	metadata, err := f.Metadata()
+	if err != nil {
+		return err
+	}
+	unixMode := uint64(sIFREG)
+	if v, ok := parseMetadataUint(metadata, "mode", 8); ok {
+		unixMode = uint64(v) &^ 07777
+	}
+	unixMode |= uint64(mode.Perm())
+	newMetadata := make(fs.Metadata, len(metadata)+1)
+	newMetadata.Merge(metadata)
+	newMetadata["mode"] = strconv.FormatUint(unixMode, 8)
+	return f.SetMetadata(newMetadata)
+}
+
+// Chown changes the owner of the file to uid and gid
+//
+// A uid or gid of ^uint32(0) leaves that value unchanged.
+//
+// This does nothing unless --vfs-metadata-perms is set
+func (f *File) Chown(uid, gid uint32) error {
+	if !f.d.vfs.Opt.MetadataPerms {
+		return nil
+	}
+	if f.d.vfs.Opt.ReadOnly {
+		return EROFS
+	}
+	metadata, err := f.Metadata()
+	if err != nil {
+		return err
+	}
+	newMetadata := make(fs.Metadata, len(metadata)+2)
+	newMetadata.Merge(metadata)
+	if uid != ^uint32(0) {
+		newMetadata["uid"] = fmt.Sprint(uid)
+	}
+	if gid != ^uint32(0) {
+		newMetadata["gid"] = fmt.Sprint(gid)
+	}
+	return f.SetMetadata(newMetadata)
+}
+
+// Owner returns the uid and gid of the directory
+func (d *Dir) Owner() (uid, gid uint32) {
+	return d.vfs.Opt.UID, d.vfs.Opt.GID
+}
+
+// Chmod changes the permissions of the directory
+//
+// Directories don't have metadata so this returns ENOTSUP if
+// --vfs-metadata-perms is set and does nothing otherwise
+func (d *Dir) Chmod(mode os.FileMode) error {
+	return d.permsNotSupported()
+}
+
+// Chown changes the owner of the directory
+//
+// Directories don't have metadata so this returns ENOTSUP if
+// --vfs-metadata-perms is set and does nothing otherwise
+func (d *Dir) Chown(uid, gid uint32) error {
+	return d.permsNotSupported()
+}
+
+// permsNotSupported returns the error for changing the permissions of
+// a directory
+func (d *Dir) permsNotSupported() error {
+	if !d.vfs.Opt.MetadataPerms {
+		return nil
+	}
+	if d.vfs.Opt.ReadOnly {
+		return EROFS
+	}
+	return ENOTSUP
+}
@@ -0,0 +1,120 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataPermsDisabled(t *testing.T) {
+	r, vfs := newTestVFS(t)
+	r.WriteObject(context.Background(), "file1", "file1 contents", t1)
+
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+	assert.Equal(t, vfs.Opt.FilePerms, node.Mode().Perm())
+
+	// Changes are ignored
+	require.NoError(t, node.Chmod(0600))
+	require.NoError(t, node.Chown(1, 1))
+	assert.Equal(t, vfs.Opt.FilePerms, node.Mode().Perm())
+	uid, gid := node.Owner()
+	assert.Equal(t, vfs.Opt.UID, uid)
+	assert.Equal(t, vfs.Opt.GID, gid)
+}
+
+func TestMetadataPerms(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Local backend doesn't store permissions on Windows")
+	}
+	opt := vfscommon.DefaultOpt
+	opt.MetadataPerms = true
+	r, vfs := newTestVFSOpt(t, &opt)
+	if !r.Fremote.Features().WriteMetadata {
+		t.Skip("Backend doesn't support writing metadata")
+	}
+	ctx := context.Background()
+	r.WriteObject(ctx, "file1", "file1 contents", t1)
+
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+
+	// Read the permissions from the metadata
+	fi, err := os.Stat(r.Fremote.Root() + "/file1")
+	require.NoError(t, err)
+	assert.Equal(t, fi.Mode().Perm(), node.Mode().Perm())
+
+	// Change them and check they are persisted
+	require.NoError(t, node.Chmod(0640))
+	assert.Equal(t, os.FileMode(0640), node.Mode().Perm())
+	assert.Equal(t, "100640", remoteMetadata(t, r.Fremote, "file1")["mode"])
+	fi, err = os.Stat(r.Fremote.Root() + "/file1")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+	assert.True(t, fi.Mode().IsRegular())
+
+	// Change the ownership to the current user leaving gid alone
+	_, oldGID := node.Owner()
+	require.NoError(t, node.Chown(uint32(os.Getuid()), ^uint32(0)))
+	uid, gid := node.Owner()
+	assert.Equal(t, uint32(os.Getuid()), uid)
+	assert.Equal(t, oldGID, gid)
+
+	// The contents should be unchanged
+	data, err := vfs.ReadFile("file1")
+	require.NoError(t, err)
+	assert.Equal(t, "file1 contents", string(data))
+
+	// The permissions are cached until the file is read from the
+	// remote again
+	require.NoError(t, os.Chmod(r.Fremote.Root()+"/file1", 0604))
+	assert.Equal(t, os.FileMode(0640), node.Mode().Perm())
+	vfs.FlushDirCache()
+	node, err = vfs.Stat("file1")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0604), node.Mode().Perm())
+
+	// Directories have no metadata to keep the changes in
+	root, err := vfs.Root()
+	require.NoError(t, err)
+	assert.Equal(t, ENOTSUP, root.Chmod(0700))
+	assert.Equal(t, ENOTSUP, root.Chown(1, 1))
+	assert.Equal(t, vfs.Opt.DirPerms.Perm(), root.Mode().Perm())
+}
+
+func TestMetadataPermsLargeFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Local backend doesn't store permissions on Windows")
+	}
+	opt := vfscommon.DefaultOpt
+	opt.MetadataPerms = true
+	r, vfs := newTestVFSOpt(t, &opt)
+	if !r.Fremote.Features().WriteMetadata {
+		t.Skip("Backend doesn't support writing metadata")
+	}
+	ctx := context.Background()
+	r.WriteObject(ctx, "file1", strings.Repeat("x", maxMetadataUpdateSize+1), t1)
+
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+	mode := node.Mode().Perm()
+	uid, gid := node.Owner()
+
+	// Large files aren't uploaded again so the changes fail rather
+	// than being kept only in memory
+	assert.Equal(t, ENOTSUP, node.Chmod(0640))
+	assert.Equal(t, mode, node.Mode().Perm())
+	assert.Equal(t, ENOTSUP, node.Chown(uint32(os.Getuid()), uint32(os.Getgid())))
+	gotUID, gotGID := node.Owner()
+	assert.Equal(t, uid, gotUID)
+	assert.Equal(t, gid, gotGID)
+	fi, err := os.Stat(r.Fremote.Root() + "/file1")
+	require.NoError(t, err)
+	assert.Equal(t, mode, fi.Mode().Perm())
+}
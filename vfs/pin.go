@@ -0,0 +1,154 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscache"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// ErrPinNeedsFullCache is returned when trying to pin without
+// --vfs-cache-mode full
+var ErrPinNeedsFullCache = errors.New("pinning requires --vfs-cache-mode full")
+
+// PinXattr is the extended attribute mounts use to control pinning.
+//
+// Setting it to any value pins the entry and removing it unpins it.
+const PinXattr = "user.rclone.pinned"
+
+// Pin makes the file or directory at name available offline.
+//
+// The whole tree at name is downloaded into the cache and then kept
+// there regardless of the cache age and size limits. Changes to
+// pinned files noticed on the remote are fetched again.
+func (vfs *VFS) Pin(ctx context.Context, name string) error {
+	if vfs.cache == nil || vfs.Opt.CacheMode < vfscommon.CacheModeFull {
+		return ErrPinNeedsFullCache
+	}
+	name = strings.Trim(name, "/")
+	node, err := vfs.Stat(name)
+	if err != nil {
+		return err
+	}
+	err = vfs.cache.Pin(remotePath(node))
+	if err != nil {
+		return err
+	}
+	return fetchNode(ctx, vfs.cache, node)
+}
+
+// PinInBackground is like Pin but returns as soon as the pin has
+// been recorded, downloading the data in the background.
+func (vfs *VFS) PinInBackground(name string) error {
+	if vfs.cache == nil || vfs.Opt.CacheMode < vfscommon.CacheModeFull {
+		return ErrPinNeedsFullCache
+	}
+	name = strings.Trim(name, "/")
+	node, err := vfs.Stat(name)
+	if err != nil {
+		return err
+	}
+	cache := vfs.cache
+	name = remotePath(node)
+	err = cache.Pin(name)
+	if err != nil {
+		return err
+	}
+	go vfs.refreshPin(context.Background(), cache, name)
+	return nil
+}
+
+// Unpin removes the pin from name so its contents can be evicted
+// from the cache as normal.
+func (vfs *VFS) Unpin(name string) error {
+	if vfs.cache == nil {
+		return ErrPinNeedsFullCache
+	}
+	return vfs.cache.Unpin(vfs.pinName(name))
+}
+
+// IsPinned returns true if name is pinned either directly or through
+// one of its parent directories.
+func (vfs *VFS) IsPinned(name string) bool {
+	if vfs.cache == nil {
+		return false
+	}
+	return vfs.cache.IsPinned(vfs.pinName(name))
+}
+
+// pinName returns the name the cache keeps the pin of name under,
+// which is the path of the entry on the remote
+func (vfs *VFS) pinName(name string) string {
+	name = strings.Trim(name, "/")
+	if node, err := vfs.Stat(name); err == nil {
+		return remotePath(node)
+	}
+	return name
+}
+
+// fetchNode downloads node and everything under it into the cache
+func fetchNode(ctx context.Context, cache *vfscache.Cache, node Node) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	switch x := node.(type) {
+	case *Dir:
+		nodes, err := x.ReadDirAll()
+		if err != nil {
+			return err
+		}
+		var lastErr error
+		for _, child := range nodes {
+			err = fetchNode(ctx, cache, child)
+			if err != nil {
+				fs.Errorf(child.Path(), "vfs pin: failed to fetch: %v", err)
+				lastErr = err
+			}
+		}
+		return lastErr
+	case *File:
+		o := x.getObject()
+		if o == nil {
+			// not uploaded yet so the data is already local
+			return nil
+		}
+		return cache.Fetch(ctx, x.remotePath(), o)
+	}
+	return fmt.Errorf("vfs pin: unknown node type %T", node)
+}
+
+// refreshPin fetches name, a path on the remote, into cache again
+func (vfs *VFS) refreshPin(ctx context.Context, cache *vfscache.Cache, name string) {
+	node, err := vfs.statRemote(name)
+	if err != nil {
+		// Deleted entries need no refreshing
+		return
+	}
+	err = fetchNode(ctx, cache, node)
+	if err != nil {
+		fs.Errorf(name, "vfs pin: failed to refresh: %v", err)
+	}
+}
+
+// refreshPins fetches all the paths pinned in cache making sure they
+// are up to date with the remote
+func (vfs *VFS) refreshPins(ctx context.Context, cache *vfscache.Cache) {
+	for _, name := range cache.Pins() {
+		vfs.refreshPin(ctx, cache, name)
+	}
+}
+
+// changeNotify is called by the backend when something changes on
+// the remote. It invalidates the directory cache and refreshes any
+// pinned entries which have changed.
+func (vfs *VFS) changeNotify(relativePath string, entryType fs.EntryType) {
+	vfs.root.changeNotify(relativePath, entryType)
+	cache := vfs.cache
+	if cache != nil && vfs.Opt.CacheMode >= vfscommon.CacheModeFull && cache.IsPinned(relativePath) {
+		go vfs.refreshPin(context.Background(), cache, relativePath)
+	}
+}
@@ -0,0 +1,73 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVFSPinNeedsFullCache(t *testing.T) {
+	_, vfs := newTestVFS(t)
+
+	assert.Equal(t, ErrPinNeedsFullCache, vfs.Pin(context.Background(), "dir"))
+	assert.Equal(t, ErrPinNeedsFullCache, vfs.Unpin("dir"))
+	assert.False(t, vfs.IsPinned("dir"))
+}
+
+func TestVFSPin(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.CachePollInterval = 0
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+
+	file1 := r.WriteObject(ctx, "dir/file1", "file1 contents", time.Now())
+	file2 := r.WriteObject(ctx, "dir/sub/file2", "file2", time.Now())
+	file3 := r.WriteObject(ctx, "other", "not pinned", time.Now())
+	r.CheckRemoteItems(t, file1, file2, file3)
+
+	assert.Error(t, vfs.Pin(ctx, "notfound"))
+
+	require.NoError(t, vfs.Pin(ctx, "/dir/"))
+	assert.True(t, vfs.IsPinned("dir/sub/file2"))
+	assert.False(t, vfs.IsPinned("other"))
+
+	for _, name := range []string{"dir/file1", "dir/sub/file2"} {
+		assert.True(t, vfs.cache.Item(name).Exists(), name)
+	}
+	assert.False(t, vfs.cache.Exists("other"))
+
+	require.NoError(t, vfs.Unpin("dir"))
+	assert.False(t, vfs.IsPinned("dir/file1"))
+}
+
+func TestRcPin(t *testing.T) {
+	opt := vfscommon.DefaultOpt
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.CachePollInterval = 0
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+	file1 := r.WriteObject(ctx, "dir/file1", "file1 contents", time.Now())
+	r.CheckRemoteItems(t, file1)
+
+	pin := rc.Calls.Get("vfs/pin")
+	unpin := rc.Calls.Get("vfs/unpin")
+
+	out, err := pin.Fn(ctx, rc.Params{"fs": fs.ConfigString(r.Fremote), "path": "dir"})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{"pins": []string{"dir"}}, out)
+	assert.True(t, vfs.IsPinned("dir/file1"))
+
+	out, err = unpin.Fn(ctx, rc.Params{"fs": fs.ConfigString(r.Fremote), "path": "dir"})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{"pins": []string{}}, out)
+
+	_, err = unpin.Fn(ctx, rc.Params{"fs": fs.ConfigString(r.Fremote), "path": "dir"})
+	assert.Error(t, err)
+}
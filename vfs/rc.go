@@ -11,6 +11,7 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs/vfscache/writeback"
 )
 
 const getVFSHelp = ` 
@@ -407,6 +408,7 @@ This returns stats for the selected VFS.
             "outOfSpace": false,
             "path": "/home/user/.cache/rclone/vfs/local/mnt/a",
             "pathMeta": "/home/user/.cache/rclone/vfsMeta/local/mnt/a",
+            "pins": [],
             "uploadsInProgress": 0,
             "uploadsQueued": 0
         },
@@ -437,3 +439,304 @@ func rcStats(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	}
 	return vfs.Stats(), nil
 }
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/queue",
+		Title: "Queue info for a VFS.",
+		Help: `
+This returns info about the upload queue for the selected VFS.
+
+This is only useful if --vfs-cache-mode > off. If you call it when
+the --vfs-cache-mode is off, it will return an error.
+
+Files which are waiting to be uploaded or are uploading are listed,
+with the uploading ones first and the rest in the order they will be
+uploaded. Files are only removed from the queue once they have been
+uploaded successfully, so along with any files which are still open
+for writing these are the files with changes which haven't been saved
+to the remote yet.
+
+    rclone rc vfs/queue
+
+This returns
+
+    {
+        "queue": // an array of files queued for upload
+        [
+            {
+                "name":      "file",   // string: name (full path) of the file
+                "id":        123,      // integer: id of this item in the queue
+                "size":      79,       // integer: size of the file in bytes
+                "expiry":    1.5,      // float: seconds until the next upload attempt - lowest goes first
+                "tries":     1,        // integer: number of times we have tried to upload
+                "delay":     5.0,      // float: seconds between upload attempts
+                "uploading": false,    // boolean: true if item is being uploaded
+                "error":     "..."     // string: error from the last upload attempt if any
+            },
+        ],
+    }
+
+The expiry time is the time until the file is next eligible for
+upload. It may go negative if the file is waiting for one of the
+--transfers slots.
+
+The id can be used with vfs/queue-set-expiry and
+vfs/queue-cancel.
+` + getVFSHelp,
+		Fn: rcQueue,
+	})
+	rc.Add(rc.Call{
+		Path:  "vfs/queue-set-expiry",
+		Title: "Set the expiry time for an item queued for upload.",
+		Help: `
+Use this to adjust the expiry time for an item in the upload queue,
+as returned by vfs/queue. You need to pass the id of the item and
+the new expiry in seconds. Items are uploaded in expiry order, so
+this can be used to change the order of uploads, to delay an upload
+or to retry a failed upload immediately.
+
+If relative is not set, or false, then the item will be uploaded
+expiry seconds from now, so an expiry of 0 uploads it as soon as
+possible.
+
+    rclone rc vfs/queue-set-expiry id=123 expiry=0
+
+If relative is true then expiry is added to the current expiry of
+the item, and may be negative to bring it forward.
+
+    rclone rc vfs/queue-set-expiry id=123 expiry=3600 relative=true
+
+The expiry of an item which is uploading can't be changed.
+` + getVFSHelp,
+		Fn: rcQueueSetExpiry,
+	})
+	rc.Add(rc.Call{
+		Path:  "vfs/queue-cancel",
+		Title: "Cancel the upload of an item queued for upload.",
+		Help: `
+This removes the item with id, as returned by vfs/queue, from the
+upload queue, cancelling the upload if it is in progress.
+
+    rclone rc vfs/queue-cancel id=123
+
+The changes to the file are kept in the cache and it is queued for
+upload again the next time it is closed or rclone is restarted with
+the same cache.
+` + getVFSHelp,
+		Fn: rcQueueCancel,
+	})
+}
+
+// getCache gets the VFS cache from in returning an error if there
+// isn't one
+func getCache(in rc.Params) (vfs *VFS, err error) {
+	vfs, err = getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.cache == nil {
+		return nil, errors.New("need --vfs-cache-mode > off")
+	}
+	return vfs, nil
+}
+
+func rcQueue(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getCache(in)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"queue": vfs.cache.Queue(),
+	}, nil
+}
+
+func rcQueueSetExpiry(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getCache(in)
+	if err != nil {
+		return nil, err
+	}
+	id, err := in.GetInt64("id")
+	if err != nil {
+		return nil, err
+	}
+	expiry, err := in.GetFloat64("expiry")
+	if err != nil {
+		return nil, err
+	}
+	relative, err := in.GetBool("relative")
+	if err != nil && !rc.IsErrParamNotFound(err) {
+		return nil, err
+	}
+	err = vfs.cache.QueueSetExpiry(writeback.Handle(id), time.Duration(expiry*float64(time.Second)), relative)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{}, nil
+}
+
+func rcQueueCancel(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getCache(in)
+	if err != nil {
+		return nil, err
+	}
+	id, err := in.GetInt64("id")
+	if err != nil {
+		return nil, err
+	}
+	err = vfs.cache.QueueCancel(writeback.Handle(id))
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/overlay-commit",
+		Title: "Write the changes in the overlay to the remote.",
+		Help: `
+This needs the VFS to be using an overlay set with --overlay-dir.
+
+It deletes the files and directories deleted in the overlay from the
+remote then copies the files changed in the overlay to it. The
+overlay is emptied afterwards.
+
+    rclone rc vfs/overlay-commit
+
+This returns the number of files changed and the number of files and
+directories deleted.
+
+    {
+        "changed": 3,
+        "deleted": 1
+    }
+
+If files written through the VFS are still waiting to be written to
+the overlay from the VFS cache (see vfs/queue) this returns an error.
+` + getVFSHelp,
+		Fn: rcOverlayCommit,
+	})
+	rc.Add(rc.Call{
+		Path:  "vfs/overlay-discard",
+		Title: "Throw away the changes in the overlay.",
+		Help: `
+This needs the VFS to be using an overlay set with --overlay-dir.
+
+It empties the overlay so the VFS shows the remote as it is.
+
+    rclone rc vfs/overlay-discard
+
+This returns the number of files changed and the number of files and
+directories deleted which were thrown away, as vfs/overlay-commit.
+` + getVFSHelp,
+		Fn: rcOverlayDiscard,
+	})
+}
+
+func rcOverlayCommit(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	changed, deleted, err := vfs.OverlayChanges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = vfs.OverlayCommit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"changed": changed,
+		"deleted": deleted,
+	}, nil
+}
+
+func rcOverlayDiscard(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	changed, deleted, err := vfs.OverlayChanges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = vfs.OverlayDiscard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"changed": changed,
+		"deleted": deleted,
+	}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/pin",
+		Title: "Make a file or directory available offline.",
+		Help: `
+This downloads the file or directory tree at path into the VFS cache
+and keeps it there regardless of --vfs-cache-max-age and
+--vfs-cache-max-size. Pinned entries which change on the remote are
+downloaded again when the change is noticed.
+
+This needs --vfs-cache-mode full.
+
+    rclone rc vfs/pin path=projects/current
+
+This can take a long time for large trees so you may wish to run it
+with _async=true. The pins persist across restarts and can be seen in
+vfs/stats under "diskCache" in "pins".
+` + getVFSHelp,
+		Fn: rcPin,
+	})
+	rc.Add(rc.Call{
+		Path:  "vfs/unpin",
+		Title: "Stop keeping a file or directory available offline.",
+		Help: `
+This removes a pin added with vfs/pin so the cached data can be
+evicted from the VFS cache as normal.
+
+    rclone rc vfs/unpin path=projects/current
+` + getVFSHelp,
+		Fn: rcUnpin,
+	})
+}
+
+func rcPin(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	path, err := in.GetString("path")
+	if err != nil {
+		return nil, err
+	}
+	err = vfs.Pin(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"pins": vfs.cache.Pins(),
+	}, nil
+}
+
+func rcUnpin(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	path, err := in.GetString("path")
+	if err != nil {
+		return nil, err
+	}
+	err = vfs.Unpin(path)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"pins": vfs.cache.Pins(),
+	}, nil
+}
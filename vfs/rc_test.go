@@ -2,11 +2,14 @@ package vfs
 
 import (
 	"context"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs/vfscache/writeback"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -125,3 +128,60 @@ func TestRcStats(t *testing.T) {
 	assert.Equal(t, 1, out["metadataCache"].(rc.Params)["dirs"])
 	assert.Equal(t, vfs.Opt, out["opt"].(vfscommon.Options))
 }
+
+func TestRcQueueNoCache(t *testing.T) {
+	_, _, call := rcNewRun(t, "vfs/queue")
+	_, err := call.Fn(context.Background(), nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "need --vfs-cache-mode")
+}
+
+func TestRcQueue(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping test on non local remote")
+	}
+	opt := vfscommon.DefaultOpt
+	opt.CacheMode = vfscommon.CacheModeWrites
+	opt.WriteBack = time.Hour
+	_, vfs := newTestVFSOpt(t, &opt)
+	call := rc.Calls.Get("vfs/queue")
+
+	fd, err := vfs.OpenFile("file1", os.O_CREATE|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = fd.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+
+	out, err := call.Fn(context.Background(), nil)
+	require.NoError(t, err)
+	queue := out["queue"].([]writeback.QueueInfo)
+	require.Len(t, queue, 1)
+	assert.Equal(t, "file1", queue[0].Name)
+	assert.Equal(t, int64(5), queue[0].Size)
+	assert.InDelta(t, time.Hour.Seconds(), queue[0].Expiry, 10)
+	id := int64(queue[0].ID)
+
+	setExpiry := rc.Calls.Get("vfs/queue-set-expiry")
+	_, err = setExpiry.Fn(context.Background(), rc.Params{"id": id, "expiry": -1800, "relative": true})
+	require.NoError(t, err)
+	out, err = call.Fn(context.Background(), nil)
+	require.NoError(t, err)
+	queue = out["queue"].([]writeback.QueueInfo)
+	require.Len(t, queue, 1)
+	assert.InDelta(t, 1800, queue[0].Expiry, 10)
+
+	cancel := rc.Calls.Get("vfs/queue-cancel")
+	_, err = cancel.Fn(context.Background(), rc.Params{"id": id})
+	require.NoError(t, err)
+	_, err = cancel.Fn(context.Background(), rc.Params{"id": id})
+	assert.Equal(t, writeback.ErrorIDNotFound, err)
+	out, err = call.Fn(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Len(t, out["queue"], 0)
+
+	// The changes are still in the cache
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), node.Size())
+	require.NoError(t, vfs.Remove("file1"))
+}
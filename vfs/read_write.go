@@ -1,6 +1,7 @@
 package vfs
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -33,7 +34,7 @@ type RWFileHandle struct {
 func newRWFileHandle(d *Dir, f *File, flags int) (fh *RWFileHandle, err error) {
 	defer log.Trace(f.Path(), "")("err=%v", &err)
 	// get an item to represent this from the cache
-	item := d.vfs.cache.Item(f.Path())
+	item := d.vfs.cache.Item(f.remotePath())
 
 	exists := f.exists() || (item.Exists() && !item.WrittenBack())
 
@@ -59,6 +60,17 @@ func newRWFileHandle(d *Dir, f *File, flags int) (fh *RWFileHandle, err error) {
 		item.Dirty()
 	}
 
+	// open the item now if the cache is shared to find out
+	// whether another process is writing it
+	if !fh.readOnly() && d.vfs.cache.Shared() {
+		fh.mu.Lock()
+		err = fh.openPending()
+		fh.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if !fh.readOnly() {
 		fh.file.addWriter(fh)
 	}
@@ -89,8 +101,14 @@ func (fh *RWFileHandle) openPending() (err error) {
 	defer fh.file.muRW.Unlock()
 
 	o := fh.file.getObject()
-	err = fh.item.Open(o)
-	if err != nil {
+	if fh.readOnly() {
+		err = fh.item.Open(o)
+	} else {
+		err = fh.item.OpenWrite(o)
+	}
+	if errors.Is(err, vfscache.ErrWriting) {
+		return EBUSY
+	} else if err != nil {
 		return fmt.Errorf("open RW handle failed to open cache file: %w", err)
 	}
 
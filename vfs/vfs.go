@@ -26,6 +26,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
@@ -39,6 +40,7 @@ import (
 	"github.com/rclone/rclone/fs/walk"
 	"github.com/rclone/rclone/vfs/vfscache"
 	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsoverlay"
 )
 
 // Node represents either a directory (*Dir) or a file (*File)
@@ -47,6 +49,9 @@ type Node interface {
 	IsFile() bool
 	Inode() uint64
 	SetModTime(modTime time.Time) error
+	Owner() (uid, gid uint32)
+	Chmod(mode os.FileMode) error
+	Chown(uid, gid uint32) error
 	Sync() error
 	Remove() error
 	RemoveAll() error
@@ -159,10 +164,13 @@ var (
 // VFS represents the top level filing system
 type VFS struct {
 	f           fs.Fs
+	cacheName   string // key of this VFS in the active cache
 	root        *Dir
 	Opt         vfscommon.Options
 	cache       *vfscache.Cache
 	cancelCache context.CancelFunc
+	dirCache    *dirCache  // persistent directory cache - may be nil
+	locks       *lockTable // advisory file locks
 	usageMu     sync.Mutex
 	usageTime   time.Time
 	usage       *fs.Usage
@@ -181,8 +189,9 @@ var (
 func NewVfs(f fs.Fs, opt *vfscommon.Options, cacheName string) *VFS {
 	fsDir := fs.NewDir("", time.Now())
 	vfs := &VFS{
-		f:     f,
-		inUse: int32(1),
+		f:         f,
+		cacheName: cacheName,
+		inUse:     int32(1),
 	}
 
 	// Make a copy of the options
@@ -205,6 +214,31 @@ func NewVfs(f fs.Fs, opt *vfscommon.Options, cacheName string) *VFS {
 	// Put the VFS into the active cache
 	active[cacheName] = append(active[cacheName], vfs)
 
+	// Keep any changes in the overlay rather than the remote
+	if vfs.Opt.OverlayDir != "" {
+		overlay, err := vfsoverlay.NewFs(context.TODO(), f, vfs.Opt.OverlayDir)
+		if err != nil {
+			fs.Errorf(f, "Failed to open overlay - making read only: %v", err)
+			vfs.Opt.ReadOnly = true
+		} else {
+			f = overlay
+			vfs.f = f
+		}
+	}
+
+	// Open the persistent directory cache if required
+	if vfs.Opt.DirCachePersist {
+		dc, err := newDirCache(context.TODO(), f)
+		if err != nil {
+			fs.Errorf(f, "Failed to open persistent directory cache - disabling: %v", err)
+		} else {
+			vfs.dirCache = dc
+		}
+	}
+
+	// Make the table of file locks
+	vfs.locks = newLockTable(vfs)
+
 	// Create root directory
 	vfs.root = newDir(vfs, f, nil, fsDir)
 
@@ -212,7 +246,7 @@ func NewVfs(f fs.Fs, opt *vfscommon.Options, cacheName string) *VFS {
 	features := vfs.f.Features()
 	if do := features.ChangeNotify; do != nil {
 		vfs.pollChan = make(chan time.Duration)
-		do(context.TODO(), vfs.root.changeNotify, vfs.pollChan)
+		do(context.TODO(), vfs.changeNotify, vfs.pollChan)
 		vfs.pollChan <- vfs.Opt.PollInterval
 	} else if vfs.Opt.PollInterval > 0 {
 		fs.Infof(f, "poll-interval is not supported by this remote")
@@ -236,7 +270,7 @@ func NewVfs(f fs.Fs, opt *vfscommon.Options, cacheName string) *VFS {
 
 func getVfsFromCache(cacheName string, f fs.Fs, vfs *VFS) *VFS {
 	for _, activeVFS := range active[cacheName] {
-		if vfs.Opt == activeVFS.Opt {
+		if reflect.DeepEqual(vfs.Opt, activeVFS.Opt) {
 			fs.Debugf(f, "Re-using VFS from active cache")
 			atomic.AddInt32(&activeVFS.inUse, 1)
 			return activeVFS
@@ -311,6 +345,10 @@ func (vfs *VFS) SetCacheMode(cacheMode vfscommon.CacheMode) {
 		vfs.Opt.CacheMode = cacheMode
 		vfs.cancelCache = cancel
 		vfs.cache = cache
+		// Make sure anything pinned is up to date
+		if cacheMode >= vfscommon.CacheModeFull {
+			go vfs.refreshPins(ctx, cache)
+		}
 	}
 }
 
@@ -331,18 +369,23 @@ func (vfs *VFS) Shutdown() {
 
 	// Remove from active cache
 	activeMu.Lock()
-	configName := fs.ConfigString(vfs.f)
-	activeVFSes := active[configName]
+	activeVFSes := active[vfs.cacheName]
 	for i, activeVFS := range activeVFSes {
 		if activeVFS == vfs {
 			activeVFSes[i] = nil
-			active[configName] = append(activeVFSes[:i], activeVFSes[i+1:]...)
+			active[vfs.cacheName] = append(activeVFSes[:i], activeVFSes[i+1:]...)
 			break
 		}
 	}
 	activeMu.Unlock()
 
 	vfs.shutdownCache()
+
+	vfs.locks.shutdown()
+
+	if vfs.dirCache != nil {
+		vfs.dirCache.close()
+	}
 }
 
 // CleanUp deletes the contents of the on disk cache
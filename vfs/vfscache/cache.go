@@ -9,7 +9,6 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -46,20 +45,26 @@ type Cache struct {
 	opt        *vfscommon.Options   // vfs Options
 	root       string               // root of the cache directory
 	metaRoot   string               // root of the cache metadata directory
+	pinsPath   string               // path of the file the pins are persisted in
+	lockRoot   string               // root of the item lock files if shared
+	writeRoot  string               // root of the item write lock files if shared
+	shared     bool                 // set if the cache is shared with other processes
 	hashType   hash.Type            // hash to use locally and remotely
 	hashOption *fs.HashesOption     // corresponding OpenOption
 	writeback  *writeback.WriteBack // holds Items for writeback
 	avFn       AddVirtualFn         // if set, can be called to add dir entries
 
-	mu            sync.Mutex       // protects the following variables
-	cond          sync.Cond        // cond lock for synchronous cache cleaning
-	item          map[string]*Item // files/directories in the cache
-	errItems      map[string]error // items in error state
-	used          int64            // total size of files in the cache
-	outOfSpace    bool             // out of space
-	cleanerKicked bool             // some thread kicked the cleaner upon out of space
-	kickerMu      sync.Mutex       // mutex for cleanerKicked
-	kick          chan struct{}    // channel for kicking clear to start
+	mu            sync.Mutex          // protects the following variables
+	cond          sync.Cond           // cond lock for synchronous cache cleaning
+	item          map[string]*Item    // files/directories in the cache
+	errItems      map[string]error    // items in error state
+	pins          map[string]struct{} // paths exempt from eviction
+	used          int64               // total size of files in the cache
+	outOfSpace    bool                // out of space
+	noFreeSpace   bool                // set if the free space can't be read
+	cleanerKicked bool                // some thread kicked the cleaner upon out of space
+	kickerMu      sync.Mutex          // mutex for cleanerKicked
+	kick          chan struct{}       // channel for kicking clear to start
 
 }
 
@@ -120,6 +125,7 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 		opt:        opt,
 		root:       dataOSPath,
 		metaRoot:   metaOSPath,
+		pinsPath:   filepath.Join(file.UNCPath(filepath.Join(parentOSPath, "vfsPin", relativeDirOSPath)), pinsFileName),
 		item:       make(map[string]*Item),
 		errItems:   make(map[string]error),
 		hashType:   hashType,
@@ -128,6 +134,26 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 		avFn:       avFn,
 	}
 
+	// Set up the lock files if sharing the cache
+	if opt.CacheShared {
+		if !file.LockImplemented {
+			fs.Errorf(nil, "vfs cache: --vfs-cache-shared is not supported on this platform - ignoring")
+		} else if c.lockRoot, err = createRootDir(parentOSPath, "vfsLock", relativeDirOSPath); err != nil {
+			return nil, fmt.Errorf("failed to create lock cache directory: %w", err)
+		} else if c.writeRoot, err = createRootDir(parentOSPath, "vfsWriteLock", relativeDirOSPath); err != nil {
+			return nil, fmt.Errorf("failed to create write lock cache directory: %w", err)
+		} else {
+			c.shared = true
+			fs.Debugf(nil, "vfs cache: lock root is %q", c.lockRoot)
+		}
+	}
+
+	// load in the pins off disk
+	err = c.loadPins()
+	if err != nil {
+		return nil, err
+	}
+
 	// load in the cache and metadata off disk
 	err = c.reload(ctx)
 	if err != nil {
@@ -153,6 +179,7 @@ func (c *Cache) Stats() (out rc.Params) {
 	out["path"] = c.root
 	out["pathMeta"] = c.metaRoot
 	out["hashType"] = c.hashType
+	out["shared"] = c.shared
 
 	uploadsInProgress, uploadsQueued := c.writeback.Stats()
 	out["uploadsInProgress"] = uploadsInProgress
@@ -165,10 +192,36 @@ func (c *Cache) Stats() (out rc.Params) {
 	out["erroredFiles"] = len(c.errItems)
 	out["bytesUsed"] = c.used
 	out["outOfSpace"] = c.outOfSpace
+	out["pins"] = c._pinList()
 
 	return out
 }
 
+// Queue returns information about the items waiting to be uploaded
+// or uploading
+func (c *Cache) Queue() []writeback.QueueInfo {
+	return c.writeback.Queue()
+}
+
+// QueueSetExpiry sets the time the queued item with id will next be
+// uploaded to now plus expiry, or its current upload time plus expiry
+// if relative is set.
+func (c *Cache) QueueSetExpiry(id writeback.Handle, expiry time.Duration, relative bool) error {
+	return c.writeback.SetExpiry(id, expiry, relative)
+}
+
+// QueueCancel removes the item with id from the writeback queue,
+// cancelling its upload if it is in progress.
+//
+// The changes are kept in the cache and will be queued for upload
+// again when the file is next closed or rclone is restarted.
+func (c *Cache) QueueCancel(id writeback.Handle) error {
+	if !c.writeback.Remove(id) {
+		return writeback.ErrorIDNotFound
+	}
+	return nil
+}
+
 // createDir creates a directory path, along with any necessary parents
 func createDir(dir string) error {
 	return file.MkdirAll(dir, 0700)
@@ -486,16 +539,34 @@ func (c *Cache) SetModTime(name string, modTime time.Time) {
 func (c *Cache) CleanUp() error {
 	err1 := os.RemoveAll(c.root)
 	err2 := os.RemoveAll(c.metaRoot)
+	err3 := os.RemoveAll(filepath.Dir(c.pinsPath))
+	var err4 error
+	if c.lockRoot != "" {
+		err4 = os.RemoveAll(c.lockRoot)
+		if err := os.RemoveAll(c.writeRoot); err4 == nil {
+			err4 = err
+		}
+	}
 	if err1 != nil {
 		return err1
 	}
-	return err2
+	if err2 != nil {
+		return err2
+	}
+	if err3 != nil {
+		return err3
+	}
+	return err4
 }
 
 // walk walks the cache calling the function
 func (c *Cache) walk(dir string, fn func(osPath string, fi os.FileInfo, name string) error) error {
 	return filepath.Walk(dir, func(osPath string, fi os.FileInfo, err error) error {
 		if err != nil {
+			// Files may be removed while walking a shared cache
+			if c.shared && os.IsNotExist(err) {
+				return nil
+			}
 			return err
 		}
 		// Find path relative to the cache root
@@ -521,7 +592,7 @@ func (c *Cache) walk(dir string, fn func(osPath string, fi os.FileInfo, name str
 func (c *Cache) reload(ctx context.Context) error {
 	for _, dir := range []string{c.root, c.metaRoot} {
 		err := c.walk(dir, func(osPath string, fi os.FileInfo, name string) error {
-			if fi.IsDir() {
+			if fi.IsDir() || strings.HasSuffix(name, metaTempSuffix) {
 				return nil
 			}
 			item, found := c.get(name)
@@ -569,7 +640,12 @@ func (c *Cache) KickCleaner() {
 
 // removeNotInUse removes items not in use with a possible maxAge cutoff
 // called with cache mutex locked and up-to-date c.used (as we update it directly here)
-func (c *Cache) removeNotInUse(item *Item, maxAge time.Duration, emptyOnly bool) {
+//
+// It returns the space freed.
+func (c *Cache) removeNotInUse(item *Item, maxAge time.Duration, emptyOnly bool) (spaceFreed int64) {
+	if c._isPinned(item.name) {
+		return 0
+	}
 	removed, spaceFreed := item.RemoveNotInUse(maxAge, emptyOnly)
 	// The item space might be freed even if we get an error after the cache file is removed
 	// The item will not be removed or reset the cache data is dirty (DataDirty)
@@ -581,6 +657,29 @@ func (c *Cache) removeNotInUse(item *Item, maxAge time.Duration, emptyOnly bool)
 	} else {
 		fs.Debugf(nil, "vfs cache RemoveNotInUse (maxAge=%d, emptyOnly=%v): item %s not removed, freed %d bytes", maxAge, emptyOnly, item.GetName(), spaceFreed)
 	}
+	return spaceFreed
+}
+
+// _resetItem resets the cache file of item to free space, removing
+// it if it isn't in use.
+//
+// called with cache mutex locked and up-to-date c.used (as we update it directly here)
+//
+// It returns the space freed.
+func (c *Cache) _resetItem(item *Item, caller string) (spaceFreed int64) {
+	resetResult, spaceFreed, err := item.Reset()
+	// The item space might be freed even if we get an error after the cache file is removed
+	// The item will not be removed or reset if the cache data is dirty (DataDirty)
+	c.used -= spaceFreed
+	fs.Infof(nil, "vfs cache %s item.Reset %s: %s, freed %d bytes", caller, item.GetName(), resetResult.String(), spaceFreed)
+	if resetResult == RemovedNotInUse {
+		delete(c.item, item.name)
+	}
+	if err != nil {
+		fs.Errorf(nil, "vfs cache %s item.Reset %s reset failed, err = %v, freed %d bytes", caller, item.GetName(), err, spaceFreed)
+		c.errItems[item.name] = err
+	}
+	return spaceFreed
 }
 
 // Retry failed resets during purgeClean()
@@ -611,38 +710,27 @@ func (c *Cache) purgeClean(quota int64) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	var items Items
+	var items []*Item
 
 	if quota <= 0 || c.used < quota {
 		return
 	}
 
-	// Make a slice of clean cache files
+	// Make a slice of clean cache files which aren't pinned
 	for _, item := range c.item {
-		if !item.IsDirty() {
+		if !item.IsDirty() && !c._isPinned(item.name) {
 			items = append(items, item)
 		}
 	}
 
-	sort.Sort(items)
+	c.sortForEviction(items)
 
 	// Reset items until the quota is OK
 	for _, item := range items {
 		if c.used < quota {
 			break
 		}
-		resetResult, spaceFreed, err := item.Reset()
-		// The item space might be freed even if we get an error after the cache file is removed
-		// The item will not be removed or reset if the cache data is dirty (DataDirty)
-		c.used -= spaceFreed
-		fs.Infof(nil, "vfs cache purgeClean item.Reset %s: %s, freed %d bytes", item.GetName(), resetResult.String(), spaceFreed)
-		if resetResult == RemovedNotInUse {
-			delete(c.item, item.name)
-		}
-		if err != nil {
-			fs.Errorf(nil, "vfs cache purgeClean item.Reset %s reset failed, err = %v, freed %d bytes", item.GetName(), err, spaceFreed)
-			c.errItems[item.name] = err
-		}
+		c._resetItem(item, "purgeClean")
 	}
 
 	// Reset outOfSpace without checking whether we have reduced cache space below the quota.
@@ -706,16 +794,16 @@ func (c *Cache) purgeOverQuota(quota int64) {
 		return
 	}
 
-	var items Items
+	var items []*Item
 
-	// Make a slice of unused files
+	// Make a slice of unused files which aren't pinned
 	for _, item := range c.item {
-		if !item.inUse() {
+		if !item.inUse() && !c._isPinned(item.name) {
 			items = append(items, item)
 		}
 	}
 
-	sort.Sort(items)
+	c.sortForEviction(items)
 
 	// Remove items until the quota is OK
 	for _, item := range items {
@@ -734,6 +822,10 @@ func (c *Cache) clean(kicked bool) {
 	if os.IsNotExist(err) {
 		return
 	}
+	// Pick up changes made by other processes sharing the cache
+	if c.shared {
+		c.rescan(context.Background())
+	}
 	c.updateUsed()
 	c.mu.Lock()
 	oldItems, oldUsed := len(c.item), fs.SizeSuffix(c.used)
@@ -742,13 +834,27 @@ func (c *Cache) clean(kicked bool) {
 	// Remove any files that are over age
 	c.purgeOld(c.opt.CacheMaxAge)
 
+	// Keep the directories with quotas within them
+	for _, q := range c.opt.CacheQuotas {
+		c.purgeDirOverQuota(q.Dir, int64(q.Size))
+	}
+
+	// Reduce the maximum cache size if short of free space
+	maxSize := int64(c.opt.CacheMaxSize)
+	if minFree := int64(c.opt.CacheMinFreeSpace); minFree > 0 {
+		c.updateUsed()
+		if quota, ok := c.freeSpaceQuota(minFree); ok && (maxSize <= 0 || quota < maxSize) {
+			maxSize = quota
+		}
+	}
+
 	// If have a maximum cache size...
-	if int64(c.opt.CacheMaxSize) > 0 {
+	if maxSize > 0 {
 		// Remove files not in use until cache size is below quota starting from the oldest first
-		c.purgeOverQuota(int64(c.opt.CacheMaxSize))
+		c.purgeOverQuota(maxSize)
 
 		// Remove cache files that are not dirty if we are still above the max cache size
-		c.purgeClean(int64(c.opt.CacheMaxSize))
+		c.purgeClean(maxSize)
 		c.retryFailedResets()
 	}
 
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -533,10 +534,18 @@ func (dl *downloader) open(offset int64) (err error) {
 	// }
 	// in0, err := operations.NewReOpen(dl.dls.ctx, dl.dls.src, ci.LowLevelRetries, dl.dls.item.c.hashOption, rangeOption)
 
-	in0 := chunkedreader.New(context.TODO(), dl.dls.src, int64(dl.dls.opt.ChunkSize), int64(dl.dls.opt.ChunkSizeLimit))
-	_, err = in0.Seek(offset, 0)
-	if err != nil {
-		return fmt.Errorf("vfs reader: failed to open source file: %w", err)
+	var in0 io.ReadCloser
+	streams, chunkSize := dl.dls.opt.DownloadStreams, int64(dl.dls.opt.DownloadChunkSize)
+	if streams > 1 && chunkSize > 0 && size-offset > chunkSize {
+		// Download large files using concurrent streams
+		in0 = newMultiStreamReader(dl.dls.ctx, dl.dls.src, offset, chunkSize, streams)
+	} else {
+		in := chunkedreader.New(context.TODO(), dl.dls.src, int64(dl.dls.opt.ChunkSize), int64(dl.dls.opt.ChunkSizeLimit))
+		_, err = in.Seek(offset, 0)
+		if err != nil {
+			return fmt.Errorf("vfs reader: failed to open source file: %w", err)
+		}
+		in0 = in
 	}
 	dl.in = dl.tr.Account(dl.dls.ctx, in0).WithBuffer() // account and buffer the transfer
 
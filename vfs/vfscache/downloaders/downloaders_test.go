@@ -88,15 +88,17 @@ func TestDownloaders(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, size, src.Size())
 
-	newTest := func() (*testItem, *Downloaders) {
+	newTestOpt := func(opt vfscommon.Options) (*testItem, *Downloaders) {
 		item := &testItem{
 			t:    t,
 			size: size,
 		}
-		opt := vfscommon.DefaultOpt
 		dls := New(item, &opt, remote, src)
 		return item, dls
 	}
+	newTest := func() (*testItem, *Downloaders) {
+		return newTestOpt(vfscommon.DefaultOpt)
+	}
 	cancel := func(dls *Downloaders) {
 		assert.NoError(t, dls.Close(nil))
 	}
@@ -127,4 +129,57 @@ func TestDownloaders(t *testing.T) {
 		time.Sleep(time.Second)
 		assert.True(t, item.HasRange(r))
 	})
+
+	t.Run("DownloadMultiStream", func(t *testing.T) {
+		opt := vfscommon.DefaultOpt
+		opt.DownloadStreams = 4
+		opt.DownloadChunkSize = 1024 * 1024
+		item, dls := newTestOpt(opt)
+		defer cancel(dls)
+
+		for _, r := range []ranges.Range{
+			{Pos: 100, Size: 250},
+			{Pos: 3*1024*1024 + 17, Size: 5 * 1024 * 1024},
+			{Pos: size - 250, Size: 250},
+		} {
+			err := dls.Download(r)
+			require.NoError(t, err)
+			assert.True(t, item.HasRange(r))
+		}
+	})
+}
+
+func TestMultiStreamReader(t *testing.T) {
+	r := fstest.NewRun(t)
+
+	var (
+		ctx    = context.Background()
+		remote = "potato.txt"
+		size   = int64(5*1024*1024 + 123)
+	)
+
+	in := io.NopCloser(readers.NewPatternReader(size))
+	src, err := operations.RcatSize(ctx, r.Fremote, remote, in, size, time.Now(), nil)
+	require.NoError(t, err)
+
+	for _, offset := range []int64{0, 1, 1024*1024 - 1, size - 1, size} {
+		msr := newMultiStreamReader(ctx, src, offset, 1024*1024, 3)
+		got, err := io.ReadAll(msr)
+		require.NoError(t, err)
+		require.NoError(t, msr.Close())
+
+		want := readers.NewPatternReader(size)
+		_, err = want.Seek(offset, io.SeekStart)
+		require.NoError(t, err)
+		wantBytes, err := io.ReadAll(want)
+		require.NoError(t, err)
+		assert.Equal(t, wantBytes, got, "offset %d", offset)
+	}
+
+	// Closing part way through stops the fetches
+	msr := newMultiStreamReader(ctx, src, 0, 1024*1024, 3)
+	buf := make([]byte, 100)
+	_, err = io.ReadFull(msr, buf)
+	require.NoError(t, err)
+	require.NoError(t, msr.Close())
 }
@@ -0,0 +1,124 @@
+package downloaders
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// multiStreamReader reads an object from an offset to the end using
+// a number of concurrent ranged streams, returning the data in order.
+//
+// The object is split into chunks and up to streams chunks are
+// downloaded ahead of the reader at once, so this uses up to
+// streams*chunkSize bytes of memory.
+//
+// Read should only be called from one goroutine at once.
+type multiStreamReader struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	src       fs.Object
+	size      int64          // size of the object
+	chunkSize int64          // size of each chunk
+	streams   int            // max number of chunks to fetch at once
+	next      int64          // offset of the next chunk to fetch
+	chunks    []*streamChunk // chunks being fetched in file order
+	wg        sync.WaitGroup // for the fetching goroutines
+}
+
+// streamChunk is a part of the object being fetched
+type streamChunk struct {
+	offset int64
+	buf    []byte
+	pos    int           // how much of buf has been read
+	err    error         // error from fetching - read after done is closed
+	done   chan struct{} // closed when the fetch is finished
+}
+
+// newMultiStreamReader starts reading src from offset with streams
+// concurrent streams of chunkSize
+func newMultiStreamReader(ctx context.Context, src fs.Object, offset int64, chunkSize int64, streams int) *multiStreamReader {
+	ctx, cancel := context.WithCancel(ctx)
+	msr := &multiStreamReader{
+		ctx:       ctx,
+		cancel:    cancel,
+		src:       src,
+		size:      src.Size(),
+		chunkSize: chunkSize,
+		streams:   streams,
+		next:      offset,
+	}
+	msr.fill()
+	return msr
+}
+
+// fill starts fetching chunks until streams are running or the end
+// of the object is reached
+func (msr *multiStreamReader) fill() {
+	for len(msr.chunks) < msr.streams && msr.next < msr.size {
+		size := msr.chunkSize
+		if remaining := msr.size - msr.next; size > remaining {
+			size = remaining
+		}
+		c := &streamChunk{
+			offset: msr.next,
+			buf:    make([]byte, size),
+			done:   make(chan struct{}),
+		}
+		msr.next += size
+		msr.chunks = append(msr.chunks, c)
+		msr.wg.Add(1)
+		go func() {
+			defer msr.wg.Done()
+			c.err = msr.fetch(c)
+			close(c.done)
+		}()
+	}
+}
+
+// fetch downloads the chunk into its buffer
+func (msr *multiStreamReader) fetch(c *streamChunk) (err error) {
+	ci := fs.GetConfig(msr.ctx)
+	in, err := operations.NewReOpen(msr.ctx, msr.src, ci.LowLevelRetries, &fs.RangeOption{Start: c.offset, End: c.offset + int64(len(c.buf)) - 1})
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	_, err = io.ReadFull(in, c.buf)
+	return err
+}
+
+// Read reads up to len(p) bytes into p
+func (msr *multiStreamReader) Read(p []byte) (n int, err error) {
+	if len(msr.chunks) == 0 {
+		return 0, io.EOF
+	}
+	c := msr.chunks[0]
+	select {
+	case <-c.done:
+	case <-msr.ctx.Done():
+		return 0, msr.ctx.Err()
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	n = copy(p, c.buf[c.pos:])
+	c.pos += n
+	if c.pos >= len(c.buf) {
+		// Finished with this chunk so start the next one
+		msr.chunks[0] = nil
+		msr.chunks = msr.chunks[1:]
+		msr.fill()
+	}
+	return n, nil
+}
+
+// Close stops any fetches in progress
+func (msr *multiStreamReader) Close() error {
+	msr.cancel()
+	msr.wg.Wait()
+	return nil
+}
@@ -0,0 +1,151 @@
+package vfscache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// evictItem is a snapshot of the item info the eviction policies use
+type evictItem struct {
+	item  *Item
+	atime time.Time
+	opens int64
+	score float64 // bigger is evicted first for EvictSize
+}
+
+// sortForEviction sorts items into the order they should be evicted
+// according to --vfs-cache-evict-policy.
+func (c *Cache) sortForEviction(items []*Item) {
+	now := time.Now()
+	eis := make([]evictItem, len(items))
+	for i, item := range items {
+		size := item.getDiskSize()
+		item.mu.Lock()
+		eis[i] = evictItem{
+			item:  item,
+			atime: item.info.ATime,
+			opens: item.info.Opens,
+		}
+		item.mu.Unlock()
+		eis[i].score = float64(size) * now.Sub(eis[i].atime).Seconds()
+	}
+	policy := c.opt.CacheEvictPolicy
+	sort.SliceStable(eis, func(i, j int) bool {
+		a, b := &eis[i], &eis[j]
+		switch policy {
+		case vfscommon.EvictLFU:
+			if a.opens != b.opens {
+				return a.opens < b.opens
+			}
+		case vfscommon.EvictSize:
+			if a.score != b.score {
+				return a.score > b.score
+			}
+		}
+		return a.atime.Before(b.atime)
+	})
+	for i := range eis {
+		items[i] = eis[i].item
+	}
+}
+
+// inDir returns true if name is dir or is inside it
+func inDir(name, dir string) bool {
+	return dir == "" || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// purgeDirOverQuota evicts files under dir until the space they use
+// in the cache is below quota, removing files which are not in use
+// first then resetting clean files which are.
+func (c *Cache) purgeDirOverQuota(dir string, quota int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		items []*Item
+		used  int64
+	)
+	for name, item := range c.item {
+		if inDir(name, dir) {
+			used += item.getDiskSize()
+			if !c._isPinned(name) {
+				items = append(items, item)
+			}
+		}
+	}
+	if used < quota {
+		return
+	}
+	fs.Infof(nil, "vfs cache: %q is using %v which is over its quota of %v", dir, fs.SizeSuffix(used), fs.SizeSuffix(quota))
+
+	c.sortForEviction(items)
+
+	// Remove items not in use until the quota is OK
+	for _, item := range items {
+		if used < quota {
+			return
+		}
+		if !item.inUse() {
+			used -= c.removeNotInUse(item, 0, false)
+		}
+	}
+
+	// Then reset clean items which are in use
+	for _, item := range items {
+		if used < quota {
+			return
+		}
+		if c.item[item.name] == item && !item.IsDirty() {
+			used -= c._resetItem(item, "purgeDirOverQuota")
+		}
+	}
+}
+
+// freeSpaceQuota returns the size the cache needs to be reduced to so
+// there is at least minFree space on the disk containing the cache.
+//
+// It returns ok false if there is enough free space already or the
+// free space can't be read. If the free space can't be read the error
+// is logged once and --vfs-cache-min-free-space is disabled.
+func (c *Cache) freeSpaceQuota(minFree int64) (quota int64, ok bool) {
+	c.mu.Lock()
+	noFreeSpace := c.noFreeSpace
+	c.mu.Unlock()
+	if noFreeSpace {
+		return 0, false
+	}
+	var usage *fs.Usage
+	err := errors.New("reading free space not supported")
+	if doAbout := c.fcache.Features().About; doAbout != nil {
+		usage, err = doAbout(context.Background())
+		if err == nil && usage.Free == nil {
+			err = errors.New("free space not reported")
+		}
+	}
+	if err != nil {
+		fs.Errorf(nil, "vfs cache: disabling --vfs-cache-min-free-space as failed to read free space: %v", err)
+		c.mu.Lock()
+		c.noFreeSpace = true
+		c.mu.Unlock()
+		return 0, false
+	}
+	free := *usage.Free
+	if free >= minFree {
+		return 0, false
+	}
+	c.mu.Lock()
+	quota = c.used - (minFree - free)
+	c.mu.Unlock()
+	fs.Infof(nil, "vfs cache: free space %v is below --vfs-cache-min-free-space %v so reducing cache to %v", fs.SizeSuffix(free), fs.SizeSuffix(minFree), fs.SizeSuffix(quota))
+	if quota < 1 {
+		// a quota of 0 means no quota so evict everything possible
+		quota = 1
+	}
+	return quota, true
+}
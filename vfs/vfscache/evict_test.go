@@ -0,0 +1,154 @@
+package vfscache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// names returns the names of the items
+func names(items []*Item) (out []string) {
+	for _, item := range items {
+		out = append(out, item.name)
+	}
+	return out
+}
+
+func TestCacheSortForEviction(t *testing.T) {
+	_, c := newTestCache(t)
+	now := time.Now()
+
+	// small and hot, opened often long ago
+	small := c.Item("small")
+	itemWrite(t, small, "a")
+	require.NoError(t, small.Close(nil))
+	small.info.ATime = now.Add(-3 * time.Hour)
+	small.info.Opens = 10
+
+	// big and cold, opened once a while ago
+	big := c.Item("big")
+	itemWrite(t, big, "0123456789012345678901234567890123456789")
+	require.NoError(t, big.Close(nil))
+	big.info.ATime = now.Add(-2 * time.Hour)
+	big.info.Opens = 1
+
+	// medium, opened a few times recently
+	medium := c.Item("medium")
+	itemWrite(t, medium, "0123456789")
+	require.NoError(t, medium.Close(nil))
+	medium.info.ATime = now.Add(-time.Hour)
+	medium.info.Opens = 3
+
+	for _, test := range []struct {
+		policy vfscommon.EvictPolicy
+		want   []string
+	}{
+		{vfscommon.EvictLRU, []string{"small", "big", "medium"}},
+		{vfscommon.EvictLFU, []string{"big", "medium", "small"}},
+		{vfscommon.EvictSize, []string{"big", "medium", "small"}},
+	} {
+		c.opt.CacheEvictPolicy = test.policy
+		items := []*Item{medium, small, big}
+		c.sortForEviction(items)
+		assert.Equal(t, test.want, names(items), test.policy.String())
+	}
+}
+
+func TestCachePurgeDirOverQuota(t *testing.T) {
+	r, c := newTestCache(t)
+
+	media1 := c.Item("media/film1")
+	itemWrite(t, media1, "0123456789")
+	require.NoError(t, media1.Close(nil))
+	media1.info.ATime = time.Now().Add(-time.Hour)
+
+	media2 := c.Item("media/film2")
+	itemWrite(t, media2, "0123456789")
+	require.NoError(t, media2.Close(nil))
+
+	src := c.Item("src/main.go")
+	itemWrite(t, src, "package main")
+	require.NoError(t, src.Close(nil))
+	src.info.ATime = time.Now().Add(-2 * time.Hour)
+
+	// Under quota so nothing happens
+	c.purgeDirOverQuota("media", 100)
+	assert.Len(t, itemAsString(c), 3)
+
+	// Only the oldest file in the directory is removed
+	c.updateUsed()
+	c.purgeDirOverQuota("media", 15)
+	assert.Equal(t, []string{
+		`name="media/film2" opens=0 size=10`,
+		`name="src/main.go" opens=0 size=12`,
+	}, itemAsString(c))
+	assert.Equal(t, int64(22), c.used)
+
+	// Files in use are reset rather than removed
+	obj, err := r.Fremote.NewObject(context.Background(), "media/film2")
+	require.NoError(t, err)
+	require.NoError(t, media2.Open(obj))
+	c.purgeDirOverQuota("media", 1)
+	assert.Equal(t, []string{
+		`name="media/film2" opens=1 size=10`,
+		`name="src/main.go" opens=0 size=12`,
+	}, itemAsString(c))
+	assert.Equal(t, int64(12), c.used)
+	require.NoError(t, media2.Close(nil))
+}
+
+func TestCacheFreeSpaceQuota(t *testing.T) {
+	_, c := newTestCache(t)
+	if c.fcache.Features().About == nil {
+		t.Skip("About not supported")
+	}
+	item := c.Item("potato")
+	itemWrite(t, item, "hello")
+	require.NoError(t, item.Close(nil))
+	c.updateUsed()
+
+	// Plenty of space
+	_, ok := c.freeSpaceQuota(1)
+	assert.False(t, ok)
+
+	// Not enough space whatever we do so evict everything
+	quota, ok := c.freeSpaceQuota(1 << 62)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), quota)
+}
+
+// aboutErrorFs is an fs.Fs whose About always fails
+type aboutErrorFs struct {
+	fs.Fs
+}
+
+// Features returns the features of the wrapped Fs with a failing About
+func (f aboutErrorFs) Features() *fs.Features {
+	features := *f.Fs.Features()
+	features.About = func(ctx context.Context) (*fs.Usage, error) {
+		return nil, errors.New("potato")
+	}
+	return &features
+}
+
+func TestCacheFreeSpaceQuotaError(t *testing.T) {
+	_, c := newTestCache(t)
+	fcache := c.fcache
+	c.fcache = aboutErrorFs{Fs: fcache}
+	defer func() { c.fcache = fcache }()
+
+	_, ok := c.freeSpaceQuota(1 << 62)
+	assert.False(t, ok)
+	assert.True(t, c.noFreeSpace)
+
+	// disabled now so the failing About isn't called again
+	c.fcache = fcache
+	_, ok = c.freeSpaceQuota(1 << 62)
+	assert.False(t, ok)
+}
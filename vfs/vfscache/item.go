@@ -68,16 +68,21 @@ type Item struct {
 	pendingAccesses int                      // number of threads - cache reset not allowed if not zero
 	modified        bool                     // set if the file has been modified since the last Open
 	beingReset      bool                     // cache cleaner is resetting the cache file, access not allowed
+	lock            *itemLock                // shared lock held while in use if the cache is shared
+	writeLock       *itemLock                // write lock held while writing if the cache is shared
+	dirtyElsewhere  bool                     // set if the item is dirty because another process changed it
 }
 
 // Info is persisted to backing store
 type Info struct {
 	ModTime     time.Time     // last time file was modified
 	ATime       time.Time     // last time file was accessed
+	Opens       int64         // number of times the file has been opened
 	Size        int64         // size of the file
 	Rs          ranges.Ranges // which parts of the file are present
 	Fingerprint string        // fingerprint of remote object
 	Dirty       bool          // set if the backing file has been modified
+	Metadata    fs.Metadata   // if set, metadata to write to the object when it is uploaded
 }
 
 // Items are a slice of *Item ordered by ATime
@@ -94,11 +99,12 @@ const (
 	RemovedNotInUse                         // Item not used. Remove instead of reset
 	ResetFailed                             // Reset failed with an error
 	ResetComplete                           // Reset completed successfully
+	SkippedShared                           // Item may be in use by another process sharing the cache
 )
 
 func (rr ResetResult) String() string {
 	return [...]string{"Dirty item skipped", "In-access item skipped", "Empty item skipped",
-		"Not-in-use item removed", "Item reset failed", "Item reset completed"}[rr]
+		"Not-in-use item removed", "Item reset failed", "Item reset completed", "Shared item skipped"}[rr]
 }
 
 func (v Items) Len() int      { return len(v) }
@@ -139,10 +145,22 @@ func newItem(c *Cache, name string) (item *Item) {
 		},
 	}
 	item.cond = sync.Cond{L: &item.mu}
+
+	// If the cache is shared only tidy up inconsistent files if
+	// no other process is using the item as it may be creating it
+	tidy := true
+	if c.shared {
+		var l *itemLock
+		l, tidy = item._lockExclusive()
+		if l != nil {
+			defer l.unlock(false)
+		}
+	}
+
 	// check the cache file exists
 	osPath := c.toOSPath(name)
 	fi, statErr := os.Stat(osPath)
-	if statErr != nil {
+	if statErr != nil && tidy {
 		if os.IsNotExist(statErr) {
 			item._removeMeta("cache file doesn't exist")
 		} else {
@@ -152,9 +170,9 @@ func newItem(c *Cache, name string) (item *Item) {
 
 	// Try to load the metadata
 	exists, err := item.load()
-	if !exists {
+	if !exists && tidy {
 		item._removeFile("metadata doesn't exist")
-	} else if err != nil {
+	} else if err != nil && tidy {
 		item.remove(fmt.Sprintf("failed to load metadata: %v", err))
 	}
 
@@ -186,6 +204,13 @@ func (item *Item) getDiskSize() int64 {
 func (item *Item) load() (exists bool, err error) {
 	item.mu.Lock()
 	defer item.mu.Unlock()
+	return item._load()
+}
+
+// _load reads an item from the disk or returns nil if not found
+//
+// call with the lock held
+func (item *Item) _load() (exists bool, err error) {
 	osPathMeta := item.c.toOSPathMeta(item.name) // No locking in Cache
 	in, err := os.Open(osPathMeta)
 	if err != nil {
@@ -208,6 +233,27 @@ func (item *Item) load() (exists bool, err error) {
 // call with the lock held
 func (item *Item) _save() (err error) {
 	osPathMeta := item.c.toOSPathMeta(item.name) // No locking in Cache
+	if !item.c.shared {
+		return item._saveTo(osPathMeta)
+	}
+	// Write to a temporary file and rename it so other processes
+	// sharing the cache never read a partially written file
+	tmpPath := fmt.Sprintf("%s.%d%s", osPathMeta, os.Getpid(), metaTempSuffix)
+	err = item._saveTo(tmpPath)
+	if err == nil {
+		err = os.Rename(tmpPath, osPathMeta)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("vfs cache item: failed to write metadata: %w", err)
+	}
+	return nil
+}
+
+// _saveTo writes the item metadata to osPathMeta
+//
+// call with the lock held
+func (item *Item) _saveTo(osPathMeta string) (err error) {
 	out, err := os.Create(osPathMeta)
 	if err != nil {
 		return fmt.Errorf("vfs cache item: failed to write metadata: %w", err)
@@ -427,6 +473,7 @@ func (item *Item) Exists() bool {
 //
 // call with lock held
 func (item *Item) _dirty() {
+	item.dirtyElsewhere = false
 	item.info.ModTime = time.Now()
 	item.info.ATime = item.info.ModTime
 	if !item.modified {
@@ -460,6 +507,45 @@ func (item *Item) IsDirty() bool {
 	return item.info.Dirty
 }
 
+// SetMetadata sets the metadata to be written to the object when the
+// item is next uploaded.
+//
+// It returns false if the item is not dirty so there is no upload
+// pending to carry the metadata.
+func (item *Item) SetMetadata(metadata fs.Metadata) bool {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if !item.info.Dirty {
+		return false
+	}
+	item.info.Metadata = metadata
+	err := item._save()
+	if err != nil {
+		fs.Errorf(item.name, "vfs cache: SetMetadata: failed to save item info: %v", err)
+	}
+	return true
+}
+
+// GetMetadata returns the metadata waiting to be written to the object
+// or nil if there is none
+func (item *Item) GetMetadata() fs.Metadata {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	return item.info.Metadata
+}
+
+// metadataObject overrides the metadata of the object it wraps so
+// that it is written to the remote on upload
+type metadataObject struct {
+	fs.Object
+	metadata fs.Metadata
+}
+
+// Metadata returns the metadata to be written - satisfies fs.Metadataer
+func (o *metadataObject) Metadata(ctx context.Context) (fs.Metadata, error) {
+	return o.metadata, nil
+}
+
 // Create the cache file and store the metadata on disk
 // Called with item.mu locked
 func (item *Item) _createFile(osPath string) (err error) {
@@ -511,6 +597,25 @@ func (item *Item) Open(o fs.Object) (err error) {
 	return err
 }
 
+// OpenWrite opens the item as Open does for writing to.
+//
+// If the cache is shared and another process is writing the item
+// then it returns ErrWriting.
+func (item *Item) OpenWrite(o fs.Object) (err error) {
+	err = item.Open(o)
+	if err != nil {
+		return err
+	}
+	item.mu.Lock()
+	err = item._lockWrite()
+	item.mu.Unlock()
+	if err != nil {
+		_ = item.Close(nil)
+		return err
+	}
+	return nil
+}
+
 // Open the local file from the object passed in (which may be nil)
 // which implies we are about to create the file
 func (item *Item) open(o fs.Object) (err error) {
@@ -518,18 +623,26 @@ func (item *Item) open(o fs.Object) (err error) {
 	item.mu.Lock()
 	defer item.mu.Unlock()
 
-	item.info.ATime = time.Now()
-
 	osPath, err := item.c.createItemDir(item.name) // No locking in Cache
 	if err != nil {
 		return fmt.Errorf("vfs cache item: createItemDir failed: %w", err)
 	}
 
+	if item.opens == 0 {
+		err = item._lockShared()
+		if err != nil {
+			return err
+		}
+	}
+
 	err = item._checkObject(o)
 	if err != nil {
+		item._unlockShared()
 		return fmt.Errorf("vfs cache item: check object failed: %w", err)
 	}
 
+	item.info.ATime = time.Now()
+	item.info.Opens++
 	item.opens++
 	if item.opens != 1 {
 		return nil
@@ -540,6 +653,7 @@ func (item *Item) open(o fs.Object) (err error) {
 		item._remove("item.open failed on _createFile, remove cache data/metadata files")
 		item.fd = nil
 		item.opens--
+		item._unlockShared()
 		return fmt.Errorf("vfs cache item: create cache file failed: %w", err)
 	}
 	// Unlock the Item.mu so we can call some methods which take Cache.mu
@@ -588,15 +702,23 @@ func (item *Item) _store(ctx context.Context, storeFn StoreFn) (err error) {
 
 	// Object has disappeared if cacheObj == nil
 	if cacheObj != nil {
-		o, name := item.o, item.name
+		o, name, metadata := item.o, item.name, item.info.Metadata
+		var src fs.Object = cacheObj
+		if metadata != nil {
+			var ci *fs.ConfigInfo
+			ctx, ci = fs.AddConfig(ctx)
+			ci.Metadata = true
+			src = &metadataObject{Object: cacheObj, metadata: metadata}
+		}
 		item.mu.Unlock()
-		o, err := operations.Copy(ctx, item.c.fremote, o, name, cacheObj)
+		o, err := operations.Copy(ctx, item.c.fremote, o, name, src)
 		item.mu.Lock()
 		if err != nil {
 			return fmt.Errorf("vfs cache: failed to transfer file from cache to remote: %w", err)
 		}
 		item.o = o
 		item._updateFingerprint()
+		item.info.Metadata = nil
 	}
 
 	// Write the object back to the VFS layer before we mark it as
@@ -617,6 +739,7 @@ func (item *Item) _store(ctx context.Context, storeFn StoreFn) (err error) {
 	if err != nil {
 		fs.Errorf(item.name, "vfs cache: failed to write metadata file: %v", err)
 	}
+	item._unlockShared()
 
 	return nil
 }
@@ -659,7 +782,7 @@ func (item *Item) Close(storeFn StoreFn) (err error) {
 	// FIXME It would be nice to do this asynchronously however it
 	// would require keeping the downloaders alive after the item
 	// has been closed
-	if item.info.Dirty && item.o != nil {
+	if item.info.Dirty && !item.dirtyElsewhere && item.o != nil {
 		err = item._ensure(0, item.info.Size)
 		if err != nil {
 			return fmt.Errorf("vfs cache: failed to download missing parts of cache file: %w", err)
@@ -713,8 +836,9 @@ func (item *Item) Close(storeFn StoreFn) (err error) {
 		}
 	}
 
-	// upload the file to backing store if changed
-	if item.info.Dirty {
+	// upload the file to backing store if changed - if another
+	// process sharing the cache changed it then it will upload it
+	if item.info.Dirty && !item.dirtyElsewhere {
 		fs.Infof(item.name, "vfs cache: queuing for upload in %v", item.c.opt.WriteBack)
 		if syncWriteBack {
 			// do synchronous writeback
@@ -724,7 +848,7 @@ func (item *Item) Close(storeFn StoreFn) (err error) {
 			item.c.writeback.SetID(&item.writeBackID)
 			id := item.writeBackID
 			item.mu.Unlock()
-			item.c.writeback.Add(id, item.name, item.modified, func(ctx context.Context) error {
+			item.c.writeback.Add(id, item.name, item.info.Size, item.modified, func(ctx context.Context) error {
 				return item.store(ctx, storeFn)
 			})
 			item.mu.Lock()
@@ -734,6 +858,8 @@ func (item *Item) Close(storeFn StoreFn) (err error) {
 	// mark as not modified now we have uploaded or queued for upload
 	item.modified = false
 
+	item._unlockShared()
+
 	return err
 }
 
@@ -750,6 +876,18 @@ func (item *Item) reload(ctx context.Context) error {
 	if !dirty {
 		return nil
 	}
+	// If the cache is shared check the process which changed the
+	// item has gone away before uploading it
+	if item.c.shared {
+		item.mu.Lock()
+		l, ok := item._lockExclusive()
+		item.mu.Unlock()
+		if !ok {
+			fs.Debugf(item.name, "vfs cache: not uploading as in use by another process")
+			return nil
+		}
+		l.unlock(false)
+	}
 	// see if the object still exists
 	obj, _ := item.c.fremote.NewObject(ctx, item.name)
 	// open the file with the object (or nil)
@@ -757,6 +895,18 @@ func (item *Item) reload(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	// we are taking over the upload
+	item.mu.Lock()
+	err = item._lockWrite()
+	if err == nil {
+		item.dirtyElsewhere = false
+	}
+	item.mu.Unlock()
+	if err != nil {
+		_ = item.Close(nil)
+		fs.Debugf(item.name, "vfs cache: not uploading: %v", err)
+		return nil
+	}
 	// close the file to execute the writeback if needed
 	err = item.Close(nil)
 	if err != nil {
@@ -919,6 +1069,13 @@ func (item *Item) RemoveNotInUse(maxAge time.Duration, emptyOnly bool) (removed
 	if removeIt {
 		spaceUsed := item.info.Rs.Size()
 		if !emptyOnly || spaceUsed == 0 {
+			l, ok := item._lockExclusive()
+			if !ok {
+				return
+			}
+			if l != nil {
+				defer l.unlock(true)
+			}
 			spaceFreed = spaceUsed
 			removed = true
 			if item._remove("Removing old cache file not in use") {
@@ -937,6 +1094,13 @@ func (item *Item) Reset() (rr ResetResult, spaceFreed int64, err error) {
 
 	// The item is not being used now.  Just remove it instead of resetting it.
 	if item.opens == 0 && !item.info.Dirty {
+		l, ok := item._lockExclusive()
+		if !ok {
+			return SkippedShared, 0, nil
+		}
+		if l != nil {
+			defer l.unlock(true)
+		}
 		spaceFreed = item.info.Rs.Size()
 		if item._remove("Removing old cache file not in use") {
 			fs.Errorf(item.name, "item removed when it was writing/uploaded")
@@ -949,6 +1113,11 @@ func (item *Item) Reset() (rr ResetResult, spaceFreed int64, err error) {
 		return SkippedDirty, 0, nil
 	}
 
+	// do not reset a file another process sharing the cache could be reading
+	if item.c.shared {
+		return SkippedShared, 0, nil
+	}
+
 	/* A wait on pendingAccessCnt to become 0 can lead to deadlock when an item.Open bumps
 	   up the pendingAccesses count, calls item.open, which calls cache.put. The cache.put
 	   operation needs the cache mutex, which is held here.  We skip this file now. The
@@ -1419,6 +1588,22 @@ func (item *Item) rename(name string, newName string, newObj fs.Object) (err err
 		err = err2
 	}
 
+	// Move the shared lock to the new name
+	if item.lock != nil {
+		item.lock.unlock(false)
+		item.lock, err2 = item.c.lockItem(item.c.lockRoot, newName, false, true)
+		if err2 != nil {
+			err = fmt.Errorf("vfs cache item: failed to lock renamed item: %w", err2)
+		}
+	}
+	if item.writeLock != nil {
+		item.writeLock.unlock(true)
+		item.writeLock, err2 = item.c.lockItem(item.c.writeRoot, newName, true, false)
+		if err2 != nil {
+			err = fmt.Errorf("vfs cache item: failed to lock renamed item for writing: %w", err2)
+		}
+	}
+
 	item.mu.Unlock()
 
 	// close downloader and cancel writebacks with mutex unlocked
@@ -0,0 +1,174 @@
+package vfscache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Pinned paths are kept in the cache regardless of the
+// --vfs-cache-max-age and --vfs-cache-max-size limits. A pin on a
+// directory applies to everything below it.
+//
+// The set of pins is persisted to a JSON file in the vfsPin cache
+// directory so it survives restarts.
+
+// pinsFileName is the name of the file the pins are stored in
+const pinsFileName = "pins.json"
+
+// loadPins reads the persisted pins from disk
+//
+// It is called before the cache has started so no locking is needed
+func (c *Cache) loadPins() error {
+	c.pins = make(map[string]struct{})
+	in, err := os.Open(c.pinsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read pins: %w", err)
+	}
+	defer fs.CheckClose(in, &err)
+	var pins []string
+	err = json.NewDecoder(in).Decode(&pins)
+	if err != nil {
+		return fmt.Errorf("failed to decode pins: %w", err)
+	}
+	for _, name := range pins {
+		c.pins[clean(name)] = struct{}{}
+	}
+	return nil
+}
+
+// _savePins writes the pins to disk
+//
+// call with c.mu held
+func (c *Cache) _savePins() (err error) {
+	pins := c._pinList()
+	err = createDir(filepath.Dir(c.pinsPath))
+	if err != nil {
+		return fmt.Errorf("failed to create pins directory: %w", err)
+	}
+	out, err := os.Create(c.pinsPath)
+	if err != nil {
+		return fmt.Errorf("failed to write pins: %w", err)
+	}
+	defer fs.CheckClose(out, &err)
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(pins)
+}
+
+// _pinList returns the pins as a sorted slice
+//
+// call with c.mu held
+func (c *Cache) _pinList() []string {
+	pins := make([]string, 0, len(c.pins))
+	for name := range c.pins {
+		pins = append(pins, name)
+	}
+	sort.Strings(pins)
+	return pins
+}
+
+// _isPinned returns true if name or one of its parents is pinned
+//
+// call with c.mu held
+func (c *Cache) _isPinned(name string) bool {
+	for {
+		if _, ok := c.pins[name]; ok {
+			return true
+		}
+		if name == "" {
+			return false
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			name = ""
+		} else {
+			name = name[:i]
+		}
+	}
+}
+
+// IsPinned returns true if name or one of its parent directories is
+// pinned
+//
+// name should be a remote path not an osPath
+func (c *Cache) IsPinned(name string) bool {
+	name = clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c._isPinned(name)
+}
+
+// Pins returns a sorted list of the pinned paths
+func (c *Cache) Pins() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c._pinList()
+}
+
+// Pin marks name and everything below it as exempt from cache
+// eviction
+//
+// name should be a remote path not an osPath
+func (c *Cache) Pin(name string) error {
+	name = clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pins[name]; ok {
+		return nil
+	}
+	c.pins[name] = struct{}{}
+	fs.Infof(name, "vfs cache: pinned")
+	return c._savePins()
+}
+
+// Unpin removes the pin from name so it can be evicted from the cache
+// as normal
+//
+// name should be a remote path not an osPath
+func (c *Cache) Unpin(name string) error {
+	name = clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pins[name]; !ok {
+		return fmt.Errorf("%q is not pinned", name)
+	}
+	delete(c.pins, name)
+	fs.Infof(name, "vfs cache: unpinned")
+	return c._savePins()
+}
+
+// Fetch downloads the whole of o into the cache as name
+//
+// If the cached copy is out of date with respect to o it will be
+// discarded and fetched again.
+func (c *Cache) Fetch(ctx context.Context, name string, o fs.Object) (err error) {
+	item := c.Item(name)
+	err = item.Open(o)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := item.Close(nil)
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	item.preAccess()
+	defer item.postAccess()
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return item._ensure(0, item.info.Size)
+}
@@ -0,0 +1,85 @@
+package vfscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachePinIsPinned(t *testing.T) {
+	_, c := newTestCache(t)
+
+	assert.False(t, c.IsPinned("dir/file"))
+	require.NoError(t, c.Pin("/dir/"))
+	assert.True(t, c.IsPinned("dir"))
+	assert.True(t, c.IsPinned("dir/file"))
+	assert.True(t, c.IsPinned("dir/sub/file"))
+	assert.False(t, c.IsPinned("dir2/file"))
+	assert.False(t, c.IsPinned("di"))
+	assert.False(t, c.IsPinned(""))
+	assert.Equal(t, []string{"dir"}, c.Pins())
+
+	require.NoError(t, c.Unpin("dir"))
+	assert.False(t, c.IsPinned("dir/file"))
+	assert.Error(t, c.Unpin("dir"))
+
+	require.NoError(t, c.Pin(""))
+	assert.True(t, c.IsPinned("anything/at/all"))
+}
+
+func TestCachePinPersist(t *testing.T) {
+	_, c := newTestCache(t)
+
+	require.NoError(t, c.Pin("a/b"))
+	require.NoError(t, c.Pin("c"))
+
+	c.pins = nil
+	require.NoError(t, c.loadPins())
+	assert.Equal(t, []string{"a/b", "c"}, c.Pins())
+}
+
+func TestCachePinPurge(t *testing.T) {
+	_, c := newTestCache(t)
+
+	require.NoError(t, c.Pin("pinned"))
+
+	for _, name := range []string{"pinned/potato", "other/potato"} {
+		item := c.Item(name)
+		itemWrite(t, item, "hello")
+		require.NoError(t, item.Close(nil))
+	}
+	c.updateUsed()
+
+	c.purgeOld(-10 * time.Second)
+	assert.Equal(t, []string{
+		`name="pinned/potato" opens=0 size=5`,
+	}, itemAsString(c))
+
+	c.purgeOverQuota(1)
+	c.purgeClean(1)
+	assert.Equal(t, []string{
+		`name="pinned/potato" opens=0 size=5`,
+	}, itemAsString(c))
+}
+
+func TestCacheFetch(t *testing.T) {
+	r, c := newTestCache(t)
+	ctx := context.Background()
+
+	contents := "hello world"
+	file1 := r.WriteObject(ctx, "dir/file1", contents, time.Now())
+	r.CheckRemoteItems(t, file1)
+	o, err := r.Fremote.NewObject(ctx, "dir/file1")
+	require.NoError(t, err)
+
+	require.NoError(t, c.Fetch(ctx, "dir/file1", o))
+
+	item := c.Item("dir/file1")
+	assert.True(t, item.present())
+	assert.Equal(t, []string{
+		`name="dir/file1" opens=0 size=11`,
+	}, itemAsString(c))
+}
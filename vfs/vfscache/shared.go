@@ -0,0 +1,270 @@
+package vfscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// With --vfs-cache-shared several rclone processes may use the same
+// cache directory at once.
+//
+// Each item has a lock file in the vfsLock directory. A process holds
+// a shared lock on it while the item is in use by that process (open
+// or dirty) and anything which would remove or take over an item
+// (evicting it from the cache or uploading a dirty item left by a
+// process which has gone away) must get an exclusive lock on it
+// without waiting first. This means an item in use by any process
+// is never removed from under it.
+//
+// Each item also has a write lock file in the vfsWriteLock directory.
+// A process holds an exclusive lock on it from when it opens the item
+// for writing until it has finished uploading it, so only one process
+// writes an item at once. Opening an item for writing in another
+// process fails with ErrWriting rather than waiting.
+//
+// When a process starts using an item it reloads the metadata from
+// disk as another process may have changed it, and the metadata is
+// written atomically so it is never seen half written. The cleaner
+// rescans the metadata on disk before cleaning so the quota covers
+// the items of all the processes.
+
+// metaTempSuffix is the suffix of temporary metadata files
+const metaTempSuffix = ".rclone-tmp"
+
+// ErrWriting is returned when opening an item for writing which
+// another process sharing the cache is writing
+var ErrWriting = errors.New("vfs cache: item is being written by another process")
+
+// itemLock is a lock file shared between the processes using the cache
+type itemLock struct {
+	path string
+	fd   *os.File
+}
+
+// Shared returns whether the cache is shared with other processes
+func (c *Cache) Shared() bool {
+	return c.shared
+}
+
+// lockItem opens and locks the lock file for name in root.
+//
+// If exclusive is set it takes an exclusive lock otherwise a shared
+// one. If wait is not set it returns file.ErrLocked if the lock is
+// held by another process.
+func (c *Cache) lockItem(root, name string, exclusive, wait bool) (l *itemLock, err error) {
+	osPath := filepath.Join(root, toOSPath(name))
+	err = createDir(vfscommon.OSFindParent(osPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	for {
+		fd, err := os.OpenFile(osPath, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+		err = file.Lock(fd, exclusive, wait)
+		if err != nil {
+			_ = fd.Close()
+			return nil, err
+		}
+		// The lock file may have been removed by the process we
+		// were waiting for so check we locked the current one.
+		fi, err := fd.Stat()
+		if err == nil {
+			var pathFi os.FileInfo
+			pathFi, err = os.Stat(osPath)
+			if err == nil && os.SameFile(fi, pathFi) {
+				return &itemLock{path: osPath, fd: fd}, nil
+			}
+		}
+		_ = fd.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to check lock file: %w", err)
+		}
+	}
+}
+
+// unlock releases the lock, removing the lock file if remove is set.
+//
+// Only remove the lock file if holding an exclusive lock.
+func (l *itemLock) unlock(remove bool) {
+	if remove {
+		err := os.Remove(l.path)
+		if err != nil && !os.IsNotExist(err) {
+			fs.Errorf(l.path, "vfs cache: failed to remove lock file: %v", err)
+		}
+	}
+	err := l.fd.Close() // closing releases the lock
+	if err != nil {
+		fs.Errorf(l.path, "vfs cache: failed to close lock file: %v", err)
+	}
+}
+
+// _lockShared takes a shared lock on the item if the cache is shared
+// and it isn't held already.
+//
+// As another process may have changed the item while it wasn't locked
+// this reloads the metadata from disk.
+//
+// call with the lock held
+func (item *Item) _lockShared() (err error) {
+	if !item.c.shared || item.lock != nil {
+		return nil
+	}
+	item.lock, err = item.c.lockItem(item.c.lockRoot, item.name, false, true)
+	if err != nil {
+		return fmt.Errorf("vfs cache: failed to lock item: %w", err)
+	}
+	exists, err := item._load()
+	if !exists || err != nil {
+		if err != nil && exists {
+			fs.Errorf(item.name, "vfs cache: ignoring metadata: %v", err)
+		}
+		item.info.clean()
+	}
+	// A dirty item we haven't got locked is being uploaded by
+	// another process.
+	item.dirtyElsewhere = item.info.Dirty
+	return nil
+}
+
+// _lockWrite takes the write lock on the item if the cache is shared
+// and it isn't held already.
+//
+// It returns ErrWriting if another process is writing the item.
+//
+// call with the lock held
+func (item *Item) _lockWrite() (err error) {
+	if !item.c.shared || item.writeLock != nil {
+		return nil
+	}
+	item.writeLock, err = item.c.lockItem(item.c.writeRoot, item.name, true, false)
+	if errors.Is(err, file.ErrLocked) {
+		return ErrWriting
+	} else if err != nil {
+		return fmt.Errorf("vfs cache: failed to lock item for writing: %w", err)
+	}
+	if item.dirtyElsewhere {
+		// The other process has finished with the item since we
+		// loaded the metadata so load it again.
+		_, err = item._load()
+		if err != nil {
+			fs.Errorf(item.name, "vfs cache: failed to refresh metadata: %v", err)
+		}
+		item.dirtyElsewhere = item.info.Dirty
+	}
+	return nil
+}
+
+// _unlockShared releases the shared lock and the write lock on the
+// item if this process has finished with it.
+//
+// call with the lock held
+func (item *Item) _unlockShared() {
+	if item.opens != 0 || (item.info.Dirty && !item.dirtyElsewhere) {
+		return
+	}
+	if item.writeLock != nil {
+		item.writeLock.unlock(true)
+		item.writeLock = nil
+	}
+	if item.lock != nil {
+		item.lock.unlock(false)
+		item.lock = nil
+	}
+}
+
+// _lockExclusive gets an exclusive lock on the item if the cache is
+// shared so it can be removed.
+//
+// It returns ok false if the item is in use by another process.
+//
+// call with the lock held
+func (item *Item) _lockExclusive() (l *itemLock, ok bool) {
+	if !item.c.shared {
+		return nil, true
+	}
+	if item.lock != nil {
+		// We are using the item ourselves
+		return nil, false
+	}
+	l, err := item.c.lockItem(item.c.lockRoot, item.name, true, false)
+	if err != nil {
+		if !errors.Is(err, file.ErrLocked) {
+			fs.Errorf(item.name, "vfs cache: failed to lock item: %v", err)
+		}
+		return nil, false
+	}
+	return l, true
+}
+
+// refreshShared reloads the metadata of an item this process isn't
+// using from disk as another process may have changed it.
+//
+// It returns false if the item is no longer in the cache.
+func (item *Item) refreshShared() bool {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if item.lock != nil {
+		// In use by us so the info is up to date
+		return true
+	}
+	exists, err := item._load()
+	if !exists {
+		item.info.clean()
+		return false
+	}
+	if err != nil {
+		fs.Errorf(item.name, "vfs cache: failed to refresh metadata: %v", err)
+	}
+	return true
+}
+
+// rescan updates the items in the cache from the metadata on disk so
+// that items which other processes have added, changed or removed are
+// accounted for.
+func (c *Cache) rescan(ctx context.Context) {
+	c.mu.Lock()
+	items := make([]*Item, 0, len(c.item))
+	for _, item := range c.item {
+		items = append(items, item)
+	}
+	c.mu.Unlock()
+
+	// Refresh the items we know about
+	for _, item := range items {
+		if !item.refreshShared() {
+			c.mu.Lock()
+			if c.item[item.name] == item && !item.inUse() {
+				delete(c.item, item.name)
+			}
+			c.mu.Unlock()
+		}
+	}
+
+	// Add any new items
+	err := c.walk(c.metaRoot, func(osPath string, fi os.FileInfo, name string) error {
+		if fi.IsDir() || strings.HasSuffix(name, metaTempSuffix) {
+			return nil
+		}
+		item, found := c.get(name)
+		if !found {
+			err := item.reload(ctx)
+			if err != nil {
+				fs.Errorf(name, "vfs cache: failed to reload item: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fs.Errorf(nil, "vfs cache: failed to rescan shared cache: %v", err)
+	}
+}
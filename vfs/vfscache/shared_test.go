@@ -0,0 +1,244 @@
+package vfscache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSharedCaches makes two caches sharing the same directory as
+// if they were in different processes
+func newTestSharedCaches(t *testing.T) (r *fstest.Run, c1, c2 *Cache) {
+	if !file.LockImplemented {
+		t.Skip("file locking not supported on this platform")
+	}
+	opt := vfscommon.DefaultOpt
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	opt.CacheShared = true
+	r, c1 = newTestCacheOpt(t, opt)
+	require.True(t, c1.shared)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	c2, err := New(ctx, r.Fremote, &opt, addVirtual)
+	require.NoError(t, err)
+	assert.Equal(t, c1.root, c2.root)
+	return r, c1, c2
+}
+
+func TestCacheSharedEviction(t *testing.T) {
+	r, c1, c2 := newTestSharedCaches(t)
+	ctx := context.Background()
+	r.WriteObject(ctx, "potato", "hello", time.Now())
+	o, err := r.Fremote.NewObject(ctx, "potato")
+	require.NoError(t, err)
+
+	// Read the file into the cache with c1 and keep it open
+	item1 := c1.Item("potato")
+	require.NoError(t, item1.Open(o))
+	buf := make([]byte, 5)
+	_, err = item1.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// c2 sees it but can't remove it
+	c2.rescan(ctx)
+	assert.Equal(t, []string{`name="potato" opens=0 size=5`}, itemAsString(c2))
+	c2.purgeOverQuota(1)
+	assertPathExist(t, c2.toOSPath("potato"))
+	rr, _, err := c2.Item("potato").Reset()
+	require.NoError(t, err)
+	assert.Equal(t, SkippedShared, rr)
+	assertPathExist(t, c2.toOSPath("potato"))
+
+	// Once c1 has finished with it c2 counts it in the quota and
+	// can remove it
+	require.NoError(t, item1.Close(nil))
+	c2.rescan(ctx)
+	assert.Equal(t, int64(5), c2.updateUsed())
+	c2.purgeOverQuota(1)
+	assertPathNotExist(t, c2.toOSPath("potato"))
+	assertPathNotExist(t, c2.toOSPathMeta("potato"))
+	assertPathNotExist(t, filepath.Join(c2.lockRoot, "potato"))
+	assert.Equal(t, []string(nil), itemAsString(c2))
+
+	// And c1 notices it has gone
+	c1.rescan(ctx)
+	assert.Equal(t, []string(nil), itemAsString(c1))
+}
+
+func TestCacheSharedDirty(t *testing.T) {
+	r, c1, c2 := newTestSharedCaches(t)
+	ctx := context.Background()
+
+	// Write a file with c1 and keep it open
+	item1 := c1.Item("potato")
+	itemWrite(t, item1, "hello")
+
+	// c2 can read it but doesn't upload it
+	item2 := c2.Item("potato")
+	require.NoError(t, item2.Open(nil))
+	assert.True(t, item2.IsDirty())
+	buf := make([]byte, 5)
+	_, err := item2.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	require.NoError(t, item2.Close(nil))
+	_, err = r.Fremote.NewObject(ctx, "potato")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	// A new process starting doesn't take over the upload
+	c3, err := New(ctx, r.Fremote, c1.opt, addVirtual)
+	require.NoError(t, err)
+	_, err = r.Fremote.NewObject(ctx, "potato")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	assert.True(t, c3.Item("potato").IsDirty())
+
+	// c1 uploads it when closed
+	require.NoError(t, item1.Close(nil))
+	o, err := r.Fremote.NewObject(ctx, "potato")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), o.Size())
+}
+
+func TestCacheSharedWrite(t *testing.T) {
+	r, c1, c2 := newTestSharedCaches(t)
+	ctx := context.Background()
+
+	// Write a file with c1 and keep it open
+	item1 := c1.Item("potato")
+	require.NoError(t, item1.OpenWrite(nil))
+	_, err := item1.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+
+	// c2 can't open it for writing
+	item2 := c2.Item("potato")
+	assert.Equal(t, ErrWriting, item2.OpenWrite(nil))
+	assert.Equal(t, 0, item2.opens)
+
+	// Until c1 has uploaded it
+	require.NoError(t, item1.Close(nil))
+	require.NoError(t, item2.OpenWrite(nil))
+	assert.False(t, item2.IsDirty())
+	require.NoError(t, item2.Close(nil))
+	o, err := r.Fremote.NewObject(ctx, "potato")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), o.Size())
+}
+
+// Environment variables used to run TestCacheSharedWriteHelper
+const (
+	sharedHelperCacheDir = "RCLONE_TEST_VFS_SHARED_CACHE_DIR"
+	sharedHelperRemote   = "RCLONE_TEST_VFS_SHARED_REMOTE"
+)
+
+// TestCacheSharedWriteHelper is run in another process by
+// TestCacheSharedWriteProcesses. It writes an item and keeps it open
+// until its stdin is closed.
+func TestCacheSharedWriteHelper(t *testing.T) {
+	remote := os.Getenv(sharedHelperRemote)
+	if remote == "" {
+		t.Skip("only run by TestCacheSharedWriteProcesses")
+	}
+	require.NoError(t, config.SetCacheDir(os.Getenv(sharedHelperCacheDir)))
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, remote)
+	require.NoError(t, err)
+	opt := vfscommon.DefaultOpt
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	opt.CacheShared = true
+	c, err := New(ctx, f, &opt, nil)
+	require.NoError(t, err)
+
+	item := c.Item("potato")
+	require.NoError(t, item.OpenWrite(nil))
+	_, err = item.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+	fmt.Println("writing")
+	_, _ = io.Copy(io.Discard, os.Stdin)
+	require.NoError(t, item.Close(nil))
+}
+
+func TestCacheSharedWriteProcesses(t *testing.T) {
+	if !file.LockImplemented {
+		t.Skip("file locking not supported on this platform")
+	}
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() {
+		_ = config.SetCacheDir(oldCacheDir)
+	}()
+	opt := vfscommon.DefaultOpt
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	opt.CacheShared = true
+	r, c := newTestCacheOpt(t, opt)
+	ctx := context.Background()
+
+	// Start writing the file in another process
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCacheSharedWriteHelper$")
+	cmd.Env = append(os.Environ(),
+		sharedHelperCacheDir+"="+config.GetCacheDir(),
+		sharedHelperRemote+"="+r.Fremote.Root(),
+	)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() && scanner.Text() != "writing" {
+	}
+
+	// We can't write it
+	item := c.Item("potato")
+	assert.Equal(t, ErrWriting, item.OpenWrite(nil))
+
+	// But we can read it
+	require.NoError(t, item.Open(nil))
+	assert.True(t, item.IsDirty())
+	buf := make([]byte, 5)
+	_, err = item.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	require.NoError(t, item.Close(nil))
+
+	// Once the other process has uploaded it and exited we can
+	// write it
+	require.NoError(t, stdin.Close())
+	for scanner.Scan() {
+	}
+	require.NoError(t, cmd.Wait())
+	o, err := r.Fremote.NewObject(ctx, "potato")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), o.Size())
+	require.NoError(t, item.OpenWrite(o))
+	_, err = item.WriteAt([]byte("HELLO"), 0)
+	require.NoError(t, err)
+	require.NoError(t, item.Close(nil))
+	o, err = r.Fremote.NewObject(ctx, "potato")
+	require.NoError(t, err)
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "HELLO", string(data))
+}
@@ -6,6 +6,7 @@ import (
 	"container/heap"
 	"context"
 	"errors"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,6 +19,9 @@ const (
 	maxUploadDelay = 5 * time.Minute // max delay between upload attempts
 )
 
+// ErrorIDNotFound is returned when an item is not found in the queue
+var ErrorIDNotFound = errors.New("id not found in queue")
+
 // PutFn is the interface that item provides to store the data
 type PutFn func(context.Context) error
 
@@ -62,6 +66,7 @@ func New(ctx context.Context, opt *vfscommon.Options) *WriteBack {
 // writeBack.mu must be held to manipulate this
 type writeBackItem struct {
 	name      string             // name of the item so we don't have to read it from item
+	size      int64              // size of the item so we don't have to read it from item
 	id        Handle             // id of the item
 	index     int                // index into the priority queue for update
 	expiry    time.Time          // When this expires we will write it back
@@ -72,6 +77,7 @@ type writeBackItem struct {
 	putFn     PutFn              // To write the object data
 	tries     int                // number of times we have tried to upload
 	delay     time.Duration      // delay between upload attempts
+	err       error              // error from the last upload attempt
 }
 
 // A writeBackItems implements a priority queue by implementing
@@ -135,10 +141,11 @@ func (wb *WriteBack) _newExpiry() time.Time {
 // make a new writeBackItem
 //
 // call with the lock held
-func (wb *WriteBack) _newItem(id Handle, name string) *writeBackItem {
+func (wb *WriteBack) _newItem(id Handle, name string, size int64) *writeBackItem {
 	wb.SetID(&id)
 	wbItem := &writeBackItem{
 		name:   name,
+		size:   size,
 		expiry: wb._newExpiry(),
 		delay:  wb.opt.WriteBack,
 		id:     id,
@@ -256,14 +263,15 @@ func (wb *WriteBack) SetID(pid *Handle) {
 //
 // If modified is false then it it doesn't cancel a pending upload if
 // there is one as there is no need.
-func (wb *WriteBack) Add(id Handle, name string, modified bool, putFn PutFn) Handle {
+func (wb *WriteBack) Add(id Handle, name string, size int64, modified bool, putFn PutFn) Handle {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
 	wbItem, ok := wb.lookup[id]
 	if !ok {
-		wbItem = wb._newItem(id, name)
+		wbItem = wb._newItem(id, name, size)
 	} else {
+		wbItem.size = size
 		if wbItem.uploading && modified {
 			// We are uploading already so cancel the upload
 			wb._cancelUpload(wbItem)
@@ -361,6 +369,9 @@ func (wb *WriteBack) upload(ctx context.Context, wbItem *writeBackItem) {
 
 	if err != nil {
 		// FIXME should this have a max number of transfer attempts?
+		if !errors.Is(err, context.Canceled) {
+			wbItem.err = err
+		}
 		wbItem.delay *= 2
 		if wbItem.delay > maxUploadDelay {
 			wbItem.delay = maxUploadDelay
@@ -457,6 +468,86 @@ func (wb *WriteBack) processItems(ctx context.Context) {
 	}
 }
 
+// QueueInfo is information about an item in the writeback queue
+type QueueInfo struct {
+	Name      string  `json:"name"`            // name (full path) of the file
+	ID        Handle  `json:"id"`              // id of the queue item
+	Size      int64   `json:"size"`            // size of the file in bytes
+	Expiry    float64 `json:"expiry"`          // seconds until the next upload attempt - lowest goes first
+	Tries     int     `json:"tries"`           // number of times we have tried to upload
+	Delay     float64 `json:"delay"`           // delay between upload attempts in seconds
+	Uploading bool    `json:"uploading"`       // true if the item is being uploaded
+	Error     string  `json:"error,omitempty"` // error from the last upload attempt if any
+}
+
+// Queue returns information about the items waiting to be uploaded
+// or uploading, with the uploading items first then the rest in the
+// order they will be uploaded.
+func (wb *WriteBack) Queue() []QueueInfo {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	now := time.Now()
+	queue := make([]QueueInfo, 0, len(wb.lookup))
+	for _, wbItem := range wb.lookup {
+		qi := QueueInfo{
+			Name:      wbItem.name,
+			ID:        wbItem.id,
+			Size:      wbItem.size,
+			Expiry:    wbItem.expiry.Sub(now).Seconds(),
+			Tries:     wbItem.tries,
+			Delay:     wbItem.delay.Seconds(),
+			Uploading: wbItem.uploading,
+		}
+		if qi.Uploading {
+			qi.Expiry = 0
+		}
+		if wbItem.err != nil {
+			qi.Error = wbItem.err.Error()
+		}
+		queue = append(queue, qi)
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		a, b := &queue[i], &queue[j]
+		if a.Uploading != b.Uploading {
+			return a.Uploading
+		}
+		if a.Expiry != b.Expiry {
+			return a.Expiry < b.Expiry
+		}
+		return a.ID < b.ID
+	})
+	return queue
+}
+
+// SetExpiry sets the time the item with id will next be uploaded to
+// now plus expiry or, if relative is set, to its current upload time
+// plus expiry.
+//
+// Setting it in the past makes the item upload as soon as possible.
+//
+// It returns ErrorIDNotFound if the item isn't in the queue and an
+// error if it is uploading already.
+func (wb *WriteBack) SetExpiry(id Handle, expiry time.Duration, relative bool) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	wbItem, ok := wb.lookup[id]
+	if !ok {
+		return ErrorIDNotFound
+	}
+	if wbItem.uploading {
+		return errors.New("can't set the expiry of an item which is uploading")
+	}
+	newExpiry := time.Now()
+	if relative {
+		newExpiry = wbItem.expiry
+	}
+	wb.items._update(wbItem, newExpiry.Add(expiry))
+	wb._resetTimer()
+	return nil
+}
+
 // Stats return the number of uploads in progress and queued
 func (wb *WriteBack) Stats() (uploadsInProgress, uploadsQueued int) {
 	wb.mu.Lock()
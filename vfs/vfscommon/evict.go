@@ -0,0 +1,103 @@
+package vfscommon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// EvictPolicy controls which files are removed from the cache first
+// when it is over quota
+type EvictPolicy byte
+
+// EvictPolicy options
+const (
+	EvictLRU  EvictPolicy = iota // least recently used first
+	EvictLFU                     // least frequently used first
+	EvictSize                    // largest and least recently used first
+)
+
+var evictPolicyToString = []string{
+	EvictLRU:  "lru",
+	EvictLFU:  "lfu",
+	EvictSize: "size",
+}
+
+// String turns an EvictPolicy into a string
+func (p EvictPolicy) String() string {
+	if p >= EvictPolicy(len(evictPolicyToString)) {
+		return fmt.Sprintf("EvictPolicy(%d)", p)
+	}
+	return evictPolicyToString[p]
+}
+
+// Set an EvictPolicy
+func (p *EvictPolicy) Set(s string) error {
+	for n, name := range evictPolicyToString {
+		if s != "" && name == s {
+			*p = EvictPolicy(n)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown cache evict policy %q", s)
+}
+
+// Type of the value
+func (p *EvictPolicy) Type() string {
+	return "EvictPolicy"
+}
+
+// UnmarshalJSON makes sure the value can be parsed as a string or integer in JSON
+func (p *EvictPolicy) UnmarshalJSON(in []byte) error {
+	return fs.UnmarshalJSONFlag(in, p, func(i int64) error {
+		if i < 0 || i >= int64(len(evictPolicyToString)) {
+			return fmt.Errorf("unknown cache evict policy %d", i)
+		}
+		*p = EvictPolicy(i)
+		return nil
+	})
+}
+
+// CacheQuota limits the space the files under a directory may use in
+// the cache
+type CacheQuota struct {
+	Dir  string        // directory relative to the root of the VFS
+	Size fs.SizeSuffix // max total size of the files under Dir
+}
+
+// CacheQuotas is a list of CacheQuota which can be set with a
+// repeated flag of the form dir=size
+type CacheQuotas []CacheQuota
+
+// String turns CacheQuotas into a string
+func (qs CacheQuotas) String() string {
+	out := make([]string, len(qs))
+	for i, q := range qs {
+		out[i] = q.Dir + "=" + q.Size.String()
+	}
+	return strings.Join(out, ",")
+}
+
+// Set adds a CacheQuota of the form dir=size
+func (qs *CacheQuotas) Set(s string) error {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return fmt.Errorf("cache quota %q must be of the form dir=size", s)
+	}
+	q := CacheQuota{Dir: strings.Trim(s[:i], "/")}
+	err := q.Size.Set(s[i+1:])
+	if err != nil {
+		return fmt.Errorf("bad size in cache quota %q: %w", s, err)
+	}
+	if q.Size <= 0 {
+		return fmt.Errorf("size in cache quota %q must be positive", s)
+	}
+	*qs = append(*qs, q)
+	return nil
+}
+
+// Type of the value
+func (qs *CacheQuotas) Type() string {
+	return "CacheQuotas"
+}
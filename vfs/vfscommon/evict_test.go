@@ -0,0 +1,58 @@
+package vfscommon
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Check EvictPolicy and CacheQuotas satisfy the pflag interface
+var (
+	_ pflag.Value = (*EvictPolicy)(nil)
+	_ pflag.Value = (*CacheQuotas)(nil)
+)
+
+// Check EvictPolicy it satisfies the json.Unmarshaller interface
+var _ json.Unmarshaler = (*EvictPolicy)(nil)
+
+func TestEvictPolicy(t *testing.T) {
+	assert.Equal(t, "lru", EvictLRU.String())
+	assert.Equal(t, "size", EvictSize.String())
+	assert.Equal(t, "EvictPolicy(17)", EvictPolicy(17).String())
+
+	var p EvictPolicy
+	assert.Equal(t, "EvictPolicy", p.Type())
+	require.NoError(t, p.Set("lfu"))
+	assert.Equal(t, EvictLFU, p)
+	assert.Error(t, p.Set("potato"))
+	assert.Error(t, p.Set(""))
+
+	require.NoError(t, json.Unmarshal([]byte(`"size"`), &p))
+	assert.Equal(t, EvictSize, p)
+	require.NoError(t, json.Unmarshal([]byte(`0`), &p))
+	assert.Equal(t, EvictLRU, p)
+	assert.Error(t, json.Unmarshal([]byte(`99`), &p))
+}
+
+func TestCacheQuotas(t *testing.T) {
+	var qs CacheQuotas
+	assert.Equal(t, "CacheQuotas", qs.Type())
+	assert.Equal(t, "", qs.String())
+
+	require.NoError(t, qs.Set("/media/=10G"))
+	require.NoError(t, qs.Set("src=1M"))
+	assert.Equal(t, CacheQuotas{
+		{Dir: "media", Size: 10 * fs.Gibi},
+		{Dir: "src", Size: fs.Mebi},
+	}, qs)
+	assert.Equal(t, "media=10Gi,src=1Mi", qs.String())
+
+	for _, bad := range []string{"media", "media=potato", "media=0", "media=off"} {
+		assert.Error(t, qs.Set(bad), bad)
+	}
+	assert.Len(t, qs, 2)
+}
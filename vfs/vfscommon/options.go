@@ -13,8 +13,13 @@ type Options struct {
 	NoSeek             bool          // don't allow seeking if set
 	NoChecksum         bool          // don't check checksums if set
 	ReadOnly           bool          // if set VFS is read only
+	OverlayDir         string        // if set keep changes in this local directory instead of writing them to the remote
 	NoModTime          bool          // don't read mod times for files
 	DirCacheTime       time.Duration // how long to consider directory listing cache valid
+	DirCachePersist    bool          // if set keep the directory listings on disk between runs
+	Links              bool          // if set interpret link files as symlinks
+	MetadataPerms      bool          // if set read and write file mode and ownership from metadata
+	LockRemote         bool          // if set hold lock objects on the remote while files are locked
 	PollInterval       time.Duration
 	Umask              int
 	UID                uint32
@@ -26,12 +31,18 @@ type Options struct {
 	CacheMode          CacheMode
 	CacheMaxAge        time.Duration
 	CacheMaxSize       fs.SizeSuffix
+	CacheMinFreeSpace  fs.SizeSuffix // if > 0 evict files to keep at least this much free space on the cache disk
+	CacheEvictPolicy   EvictPolicy   // which files to evict first when the cache is over quota
+	CacheQuotas        CacheQuotas   // max sizes for the files under directories in the cache
 	CachePollInterval  time.Duration
+	CacheShared        bool // if set the cache may be used by several rclone processes at once
 	CaseInsensitive    bool
 	WriteWait          time.Duration // time to wait for in-sequence write
 	ReadWait           time.Duration // time to wait for in-sequence read
 	WriteBack          time.Duration // time to wait before writing back dirty files
 	ReadAhead          fs.SizeSuffix // bytes to read ahead in cache mode "full"
+	DownloadStreams    int           // number of concurrent streams to download files into the cache with
+	DownloadChunkSize  fs.SizeSuffix // size of the chunk each stream downloads
 	UsedIsSize         bool          // if true, use the `rclone size` algorithm for Used size
 	FastFingerprint    bool          // if set use fast fingerprints
 	DiskSpaceTotalSize fs.SizeSuffix
@@ -56,11 +67,15 @@ var DefaultOpt = Options{
 	ChunkSize:          128 * fs.Mebi,
 	ChunkSizeLimit:     -1,
 	CacheMaxSize:       -1,
+	CacheMinFreeSpace:  -1,
+	CacheEvictPolicy:   EvictLRU,
 	CaseInsensitive:    runtime.GOOS == "windows" || runtime.GOOS == "darwin", // default to true on Windows and Mac, false otherwise
 	WriteWait:          1000 * time.Millisecond,
 	ReadWait:           20 * time.Millisecond,
 	WriteBack:          5 * time.Second,
 	ReadAhead:          0 * fs.Mebi,
+	DownloadStreams:    0,
+	DownloadChunkSize:  16 * fs.Mebi,
 	UsedIsSize:         false,
 	DiskSpaceTotalSize: -1,
 }
@@ -22,14 +22,23 @@ func AddFlags(flagSet *pflag.FlagSet) {
 	flags.BoolVarP(flagSet, &Opt.NoChecksum, "no-checksum", "", Opt.NoChecksum, "Don't compare checksums on up/download")
 	flags.BoolVarP(flagSet, &Opt.NoSeek, "no-seek", "", Opt.NoSeek, "Don't allow seeking in files")
 	flags.DurationVarP(flagSet, &Opt.DirCacheTime, "dir-cache-time", "", Opt.DirCacheTime, "Time to cache directory entries for")
+	flags.BoolVarP(flagSet, &Opt.DirCachePersist, "vfs-dir-cache-persist", "", Opt.DirCachePersist, "Keep directory listings on disk so they are available after a restart")
 	flags.DurationVarP(flagSet, &Opt.PollInterval, "poll-interval", "", Opt.PollInterval, "Time to wait between polling for changes, must be smaller than dir-cache-time and only on supported remotes (set 0 to disable)")
+	flags.BoolVarP(flagSet, &Opt.Links, "vfs-links", "", Opt.Links, "Translate symlinks to/from regular files with a '.rclonelink' extension")
 	flags.BoolVarP(flagSet, &Opt.ReadOnly, "read-only", "", Opt.ReadOnly, "Only allow read-only access")
+	flags.StringVarP(flagSet, &Opt.OverlayDir, "overlay-dir", "", Opt.OverlayDir, "Keep changes in this local directory instead of writing them to the remote")
 	flags.FVarP(flagSet, &Opt.CacheMode, "vfs-cache-mode", "", "Cache mode off|minimal|writes|full")
 	flags.DurationVarP(flagSet, &Opt.CachePollInterval, "vfs-cache-poll-interval", "", Opt.CachePollInterval, "Interval to poll the cache for stale objects")
+	flags.BoolVarP(flagSet, &Opt.CacheShared, "vfs-cache-shared", "", Opt.CacheShared, "Allow the cache to be shared by several rclone processes at once")
 	flags.DurationVarP(flagSet, &Opt.CacheMaxAge, "vfs-cache-max-age", "", Opt.CacheMaxAge, "Max time since last access of objects in the cache")
 	flags.FVarP(flagSet, &Opt.CacheMaxSize, "vfs-cache-max-size", "", "Max total size of objects in the cache")
+	flags.FVarP(flagSet, &Opt.CacheMinFreeSpace, "vfs-cache-min-free-space", "", "Target minimum free space on the disk containing the cache")
+	flags.FVarP(flagSet, &Opt.CacheEvictPolicy, "vfs-cache-evict-policy", "", "Which objects to evict from the cache first lru|lfu|size")
+	flags.FVarP(flagSet, &Opt.CacheQuotas, "vfs-cache-quota", "", "Max total size of objects under a directory in the cache as dir=size (can be repeated)")
 	flags.FVarP(flagSet, &Opt.ChunkSize, "vfs-read-chunk-size", "", "Read the source objects in chunks")
 	flags.FVarP(flagSet, &Opt.ChunkSizeLimit, "vfs-read-chunk-size-limit", "", "If greater than --vfs-read-chunk-size, double the chunk size after each chunk read, until the limit is reached ('off' is unlimited)")
+	flags.BoolVarP(flagSet, &Opt.MetadataPerms, "vfs-metadata-perms", "", Opt.MetadataPerms, "Read and write file permissions and ownership from the metadata")
+	flags.BoolVarP(flagSet, &Opt.LockRemote, "vfs-lock-remote", "", Opt.LockRemote, "Hold lock objects on the remote so file locks are seen by other rclone instances")
 	flags.FVarP(flagSet, DirPerms, "dir-perms", "", "Directory permissions")
 	flags.FVarP(flagSet, FilePerms, "file-perms", "", "File permissions")
 	flags.BoolVarP(flagSet, &Opt.CaseInsensitive, "vfs-case-insensitive", "", Opt.CaseInsensitive, "If a file name not found, find a case insensitive match")
@@ -37,6 +46,8 @@ func AddFlags(flagSet *pflag.FlagSet) {
 	flags.DurationVarP(flagSet, &Opt.ReadWait, "vfs-read-wait", "", Opt.ReadWait, "Time to wait for in-sequence read before seeking")
 	flags.DurationVarP(flagSet, &Opt.WriteBack, "vfs-write-back", "", Opt.WriteBack, "Time to writeback files after last use when using cache")
 	flags.FVarP(flagSet, &Opt.ReadAhead, "vfs-read-ahead", "", "Extra read ahead over --buffer-size when using cache-mode full")
+	flags.IntVarP(flagSet, &Opt.DownloadStreams, "vfs-download-streams", "", Opt.DownloadStreams, "Number of concurrent streams to download with when using cache-mode full (0 or 1 to disable)")
+	flags.FVarP(flagSet, &Opt.DownloadChunkSize, "vfs-download-chunk-size", "", "Size of the chunk each --vfs-download-streams stream downloads")
 	flags.BoolVarP(flagSet, &Opt.UsedIsSize, "vfs-used-is-size", "", Opt.UsedIsSize, "Use the `rclone size` algorithm for Used size")
 	flags.BoolVarP(flagSet, &Opt.FastFingerprint, "vfs-fast-fingerprint", "", Opt.FastFingerprint, "Use fast (less accurate) fingerprints for change detection")
 	flags.FVarP(flagSet, &Opt.DiskSpaceTotalSize, "vfs-disk-space-total-size", "", "Specify the total space of disk")
@@ -0,0 +1,717 @@
+// Package vfsoverlay implements a copy-on-write overlay of a local
+// directory over a remote which is never written to.
+//
+// Files written or changed are stored in the upper layer, a local
+// directory, and shadow the files in the lower layer, the remote.
+// Files and directories deleted from the lower layer are recorded as
+// whiteouts which hide them and everything below them. The changes
+// are only written to the remote by Commit.
+package vfsoverlay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	fssync "github.com/rclone/rclone/fs/sync"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+const (
+	upperDirName      = "upper"          // directory in the overlay dir holding the upper layer
+	commitDirName     = "commit"         // directory in the overlay dir holding the changes being committed
+	whiteoutsFileName = "whiteouts.json" // file in the overlay dir holding the whiteouts
+	tempSuffix        = ".rclone-tmp"    // suffix for the whiteouts file while it is written
+)
+
+// Fs is an overlay of a local directory over a remote
+type Fs struct {
+	name       string       // name of this Fs
+	dir        string       // the overlay directory
+	upperDir   string       // OS path of the upper layer
+	lower      fs.Fs        // the remote - only written to by Commit
+	upper      fs.Fs        // local directory holding the changes
+	features   *fs.Features // optional features
+	commitMu   sync.Mutex   // held while committing or discarding the changes
+	mu         sync.Mutex   // protects the following variables
+	whiteouts  map[string]struct{}
+	committing bool // set while a commit is writing to the remote
+}
+
+// NewFs makes an overlay over lower storing the changes in dir.
+func NewFs(ctx context.Context, lower fs.Fs, dir string) (*Fs, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make overlay dir absolute: %w", err)
+	}
+	f := &Fs{
+		// Give the overlay its own name so the VFS cache of the
+		// overlay isn't shared with the VFS cache of the remote
+		name:      fmt.Sprintf("%s{overlay-%08x}", lower.Name(), crc32.ChecksumIEEE([]byte(dir))),
+		dir:       dir,
+		upperDir:  filepath.Join(dir, upperDirName),
+		lower:     lower,
+		whiteouts: make(map[string]struct{}),
+	}
+	err = os.MkdirAll(f.upperDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create overlay dir: %w", err)
+	}
+	f.upper, err = cache.Get(ctx, f.upperDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open overlay dir: %w", err)
+	}
+	err = f.loadWhiteouts()
+	if err != nil {
+		return nil, err
+	}
+	f.features = (&fs.Features{
+		CaseInsensitive:         lower.Features().CaseInsensitive,
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f)
+	if lower.Features().ChangeNotify == nil {
+		f.features.ChangeNotify = nil
+	}
+	if lower.Features().About == nil {
+		f.features.About = nil
+	}
+	return f, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string { return f.name }
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string { return f.lower.Root() }
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("%v overlaid by %q", f.lower, f.dir)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features { return f.features }
+
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	lower, upper := f.lower.Precision(), f.upper.Precision()
+	if lower == fs.ModTimeNotSupported || lower > upper {
+		return lower
+	}
+	return upper
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set { return f.lower.Hashes() }
+
+// UnWrap returns the Fs that this Fs is overlaying
+func (f *Fs) UnWrap() fs.Fs { return f.lower }
+
+// whiteoutsPath returns the path of the whiteouts file
+func (f *Fs) whiteoutsPath() string {
+	return filepath.Join(f.dir, whiteoutsFileName)
+}
+
+// loadWhiteouts reads the whiteouts from disk if present
+func (f *Fs) loadWhiteouts() error {
+	data, err := os.ReadFile(f.whiteoutsPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read overlay whiteouts: %w", err)
+	}
+	var whiteouts []string
+	err = json.Unmarshal(data, &whiteouts)
+	if err != nil {
+		return fmt.Errorf("failed to parse overlay whiteouts: %w", err)
+	}
+	for _, remote := range whiteouts {
+		f.whiteouts[remote] = struct{}{}
+	}
+	return nil
+}
+
+// _saveWhiteouts writes the whiteouts to disk atomically
+//
+// call with the lock held
+func (f *Fs) _saveWhiteouts() error {
+	whiteouts := make([]string, 0, len(f.whiteouts))
+	for remote := range f.whiteouts {
+		whiteouts = append(whiteouts, remote)
+	}
+	sort.Strings(whiteouts)
+	data, err := json.MarshalIndent(whiteouts, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal overlay whiteouts: %w", err)
+	}
+	tmp := f.whiteoutsPath() + tempSuffix
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write overlay whiteouts: %w", err)
+	}
+	err = os.Rename(tmp, f.whiteoutsPath())
+	if err != nil {
+		return fmt.Errorf("failed to write overlay whiteouts: %w", err)
+	}
+	return nil
+}
+
+// parent returns the parent directory of remote with the root as ""
+func parent(remote string) string {
+	dir := path.Dir(remote)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
+
+// _lowerHidden returns true if remote in the lower layer is hidden by
+// a whiteout on it or one of its parents
+//
+// call with the lock held
+func (f *Fs) _lowerHidden(remote string) bool {
+	for p := remote; p != ""; p = parent(p) {
+		if _, found := f.whiteouts[p]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerHidden returns true if remote in the lower layer is hidden
+func (f *Fs) lowerHidden(remote string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f._lowerHidden(remote)
+}
+
+// isCommitting returns true if a commit is writing to the remote
+func (f *Fs) isCommitting() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.committing
+}
+
+// whiteout hides remote in the lower layer if it is there
+//
+// While a commit is running the lower layer is changing under us so
+// the whiteout is always recorded.
+func (f *Fs) whiteout(ctx context.Context, remote string, isDir bool) error {
+	if !f.isCommitting() {
+		if f.lowerHidden(remote) {
+			return nil
+		}
+		var err error
+		if isDir {
+			_, err = f.lower.List(ctx, remote)
+		} else {
+			_, err = f.lower.NewObject(ctx, remote)
+		}
+		if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorDirNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Any whiteouts below remote are now covered by this one
+	prefix := remote + "/"
+	for p := range f.whiteouts {
+		if len(p) > len(prefix) && p[:len(prefix)] == prefix {
+			delete(f.whiteouts, p)
+		}
+	}
+	f.whiteouts[remote] = struct{}{}
+	return f._saveWhiteouts()
+}
+
+// isNotFound returns true if err means a file or directory wasn't found
+func isNotFound(err error) bool {
+	return errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorDirNotFound) || errors.Is(err, fs.ErrorIsDir) || os.IsNotExist(err)
+}
+
+// List the objects and directories in dir into entries, the upper
+// layer shadowing the lower one.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	upperEntries, upperErr := f.upper.List(ctx, dir)
+	if upperErr != nil && !isNotFound(upperErr) {
+		return nil, upperErr
+	}
+	var lowerEntries fs.DirEntries
+	lowerErr := fs.ErrorDirNotFound
+	if !f.lowerHidden(dir) {
+		lowerEntries, lowerErr = f.lower.List(ctx, dir)
+		if lowerErr != nil && !isNotFound(lowerErr) {
+			return nil, lowerErr
+		}
+	}
+	if upperErr != nil && lowerErr != nil {
+		return nil, fs.ErrorDirNotFound
+	}
+	seen := make(map[string]struct{}, len(upperEntries))
+	for _, entry := range upperEntries {
+		seen[entry.Remote()] = struct{}{}
+		if o, ok := entry.(fs.Object); ok {
+			entry = f.newObject(o, true)
+		}
+		entries = append(entries, entry)
+	}
+	for _, entry := range lowerEntries {
+		if _, found := seen[entry.Remote()]; found || f.lowerHidden(entry.Remote()) {
+			continue
+		}
+		if o, ok := entry.(fs.Object); ok {
+			entry = f.newObject(o, false)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote, looking in the upper layer
+// first.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o, err := f.upper.NewObject(ctx, remote)
+	if err == nil {
+		return f.newObject(o, true), nil
+	} else if !isNotFound(err) {
+		return nil, err
+	}
+	if f.lowerHidden(remote) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	o, err = f.lower.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o, false), nil
+}
+
+// Put in to the upper layer
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o, err := f.upper.Put(ctx, in, src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o, true), nil
+}
+
+// PutStream uploads to the upper layer with indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	do := f.upper.Features().PutStream
+	if do == nil {
+		return nil, fs.ErrorNotImplemented
+	}
+	o, err := do(ctx, in, src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o, true), nil
+}
+
+// Mkdir makes the directory in the upper layer
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return f.upper.Mkdir(ctx, dir)
+}
+
+// Rmdir removes the directory if it is empty, hiding it in the lower
+// layer.
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	entries, err := f.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 0 {
+		return fs.ErrorDirectoryNotEmpty
+	}
+	err = f.upper.Rmdir(ctx, dir)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return f.whiteout(ctx, dir, true)
+}
+
+// Move src to remote, copying it to the upper layer if needed and
+// hiding it in the lower layer.
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || srcObj.f != f {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	var (
+		dst fs.Object
+		err error
+	)
+	if srcObj.upper {
+		do := f.upper.Features().Move
+		if do == nil {
+			return nil, fs.ErrorCantMove
+		}
+		dst, err = do(ctx, srcObj.Object, remote)
+	} else {
+		dst, err = operations.Copy(ctx, f.upper, nil, remote, srcObj.Object)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = f.whiteout(ctx, src.Remote(), false)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(dst, true), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote.
+//
+// Anything in the directory which is only in the lower layer is
+// copied to the upper layer first, then the directory is moved in the
+// upper layer and hidden in the lower one.
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || srcFs != f {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	do := f.upper.Features().DirMove
+	if do == nil {
+		return fs.ErrorCantDirMove
+	}
+	_, err := f.List(ctx, dstRemote)
+	if err == nil {
+		return fs.ErrorDirExists
+	} else if !isNotFound(err) {
+		return err
+	}
+	err = f.upper.Mkdir(ctx, srcRemote)
+	if err != nil {
+		return err
+	}
+	err = walk.ListR(ctx, f, srcRemote, true, -1, walk.ListAll, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			switch x := entry.(type) {
+			case *Object:
+				err := x.copyUp(ctx)
+				if err != nil {
+					return err
+				}
+			case fs.Directory:
+				err := f.upper.Mkdir(ctx, x.Remote())
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("overlay: failed to copy up directory: %w", err)
+	}
+	err = do(ctx, f.upper, srcRemote, dstRemote)
+	if err != nil {
+		return err
+	}
+	return f.whiteout(ctx, srcRemote, true)
+}
+
+// About gets quota information from the remote
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	do := f.lower.Features().About
+	if do == nil {
+		return nil, errors.New("about not supported")
+	}
+	return do(ctx)
+}
+
+// ChangeNotify calls the passed function with a path that has had
+// changes on the remote.
+func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType), pollIntervalChan <-chan time.Duration) {
+	if do := f.lower.Features().ChangeNotify; do != nil {
+		do(ctx, notifyFunc, pollIntervalChan)
+	}
+}
+
+// reset empties the upper layer and the whiteouts
+//
+// call with the lock held
+func (f *Fs) _reset() error {
+	err := os.RemoveAll(f.upperDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove overlay dir: %w", err)
+	}
+	err = os.MkdirAll(f.upperDir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create overlay dir: %w", err)
+	}
+	f.whiteouts = make(map[string]struct{})
+	return f._saveWhiteouts()
+}
+
+// Commit writes the changes in the overlay to the remote then
+// removes them from the overlay.
+//
+// The changes are copied to a snapshot first so the overlay can be
+// used while they are written to the remote. Files and directories
+// removed in the overlay are deleted from the remote first then the
+// files in the upper layer are copied to it. Anything changed in the
+// overlay while the commit is running is left for the next commit.
+func (f *Fs) Commit(ctx context.Context) error {
+	f.commitMu.Lock()
+	defer f.commitMu.Unlock()
+
+	f.mu.Lock()
+	whiteouts := make([]string, 0, len(f.whiteouts))
+	for remote := range f.whiteouts {
+		whiteouts = append(whiteouts, remote)
+	}
+	sort.Strings(whiteouts)
+	f.committing = true
+	snapshot, err := f._snapshot(ctx)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.committing = false
+		f.mu.Unlock()
+		err := os.RemoveAll(f.commitDir())
+		if err != nil {
+			fs.Errorf(nil, "overlay commit: failed to remove snapshot: %v", err)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, remote := range whiteouts {
+		o, err := f.lower.NewObject(ctx, remote)
+		if err == nil {
+			err = operations.DeleteFile(ctx, o)
+		} else if isNotFound(err) {
+			err = operations.Purge(ctx, f.lower, remote)
+			if isNotFound(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("overlay commit: failed to remove %q: %w", remote, err)
+		}
+	}
+	err = fssync.CopyDir(ctx, f.lower, snapshot, true)
+	if err != nil {
+		return fmt.Errorf("overlay commit: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, remote := range whiteouts {
+		delete(f.whiteouts, remote)
+	}
+	err = f._saveWhiteouts()
+	if err != nil {
+		return err
+	}
+	return f._removeCommitted(ctx, snapshot)
+}
+
+// commitDir returns the OS path of the snapshot of the changes being
+// committed
+func (f *Fs) commitDir() string {
+	return filepath.Join(f.dir, commitDirName)
+}
+
+// _snapshot copies the upper layer to the commit directory and
+// returns it.
+//
+// call with the lock held
+func (f *Fs) _snapshot(ctx context.Context) (fs.Fs, error) {
+	err := os.RemoveAll(f.commitDir())
+	if err == nil {
+		err = os.MkdirAll(f.commitDir(), 0700)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("overlay commit: failed to create snapshot dir: %w", err)
+	}
+	snapshot, err := cache.Get(ctx, f.commitDir())
+	if err != nil {
+		return nil, fmt.Errorf("overlay commit: failed to open snapshot dir: %w", err)
+	}
+	err = fssync.CopyDir(ctx, snapshot, f.upper, true)
+	if err != nil {
+		return nil, fmt.Errorf("overlay commit: failed to snapshot changes: %w", err)
+	}
+	return snapshot, nil
+}
+
+// _removeCommitted removes the files in the snapshot from the upper
+// layer unless they have been changed since, then any directories
+// in the snapshot which are empty.
+//
+// call with the lock held
+func (f *Fs) _removeCommitted(ctx context.Context, snapshot fs.Fs) error {
+	var dirs []string
+	err := walk.ListR(ctx, snapshot, "", true, -1, walk.ListAll, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			switch x := entry.(type) {
+			case fs.Object:
+				o, err := f.upper.NewObject(ctx, x.Remote())
+				if isNotFound(err) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				if o.Size() != x.Size() || !o.ModTime(ctx).Equal(x.ModTime(ctx)) {
+					continue
+				}
+				err = o.Remove(ctx)
+				if err != nil {
+					return err
+				}
+			case fs.Directory:
+				dirs = append(dirs, x.Remote())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("overlay commit: failed to remove committed changes: %w", err)
+	}
+	// Remove the deepest directories first and leave any which
+	// aren't empty
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		_ = f.upper.Rmdir(ctx, dir)
+	}
+	return nil
+}
+
+// Discard throws away the changes in the overlay.
+func (f *Fs) Discard(ctx context.Context) error {
+	f.commitMu.Lock()
+	defer f.commitMu.Unlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f._reset()
+}
+
+// Changes returns the number of files and directories in the upper
+// layer and the number of whiteouts.
+func (f *Fs) Changes(ctx context.Context) (upper, whiteouts int, err error) {
+	err = operations.ListFn(ctx, f.upper, func(o fs.Object) {
+		upper++
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return upper, len(f.whiteouts), nil
+}
+
+// Object is an object in the overlay
+type Object struct {
+	fs.Object
+	f     *Fs
+	upper bool // set if the object is in the upper layer
+}
+
+// newObject wraps o which is in the upper layer if upper is set
+func (f *Fs) newObject(o fs.Object, upper bool) *Object {
+	return &Object{
+		Object: o,
+		f:      f,
+		upper:  upper,
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info { return o.f }
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object { return o.Object }
+
+// String returns a description of the Object
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.Object.String()
+}
+
+// copyUp copies the object into the upper layer if it isn't there
+// already
+func (o *Object) copyUp(ctx context.Context) error {
+	if o.upper {
+		return nil
+	}
+	dst, err := operations.Copy(ctx, o.f.upper, nil, o.Remote(), o.Object)
+	if err != nil {
+		return fmt.Errorf("overlay: failed to copy up: %w", err)
+	}
+	o.Object = dst
+	o.upper = true
+	return nil
+}
+
+// SetModTime sets the modification time of the object, copying it to
+// the upper layer first if needed.
+func (o *Object) SetModTime(ctx context.Context, t time.Time) error {
+	err := o.copyUp(ctx)
+	if err != nil {
+		return err
+	}
+	return o.Object.SetModTime(ctx, t)
+}
+
+// Update the object with the contents of in, writing it to the upper
+// layer.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	if o.upper {
+		return o.Object.Update(ctx, in, src, options...)
+	}
+	dst, err := o.f.upper.Put(ctx, in, fs.NewOverrideRemote(src, o.Remote()), options...)
+	if err != nil {
+		return err
+	}
+	o.Object = dst
+	o.upper = true
+	return nil
+}
+
+// Remove the object from the upper layer, hiding it in the lower one.
+func (o *Object) Remove(ctx context.Context) error {
+	if o.upper {
+		err := o.Object.Remove(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return o.f.whiteout(ctx, o.Remote(), false)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.PutStreamer     = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.ChangeNotifier  = (*Fs)(nil)
+	_ fs.UnWrapper       = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+	_ fs.ObjectUnWrapper = (*Object)(nil)
+)
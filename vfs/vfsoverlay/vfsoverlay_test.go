@@ -0,0 +1,269 @@
+package vfsoverlay
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local" // import the local backend
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain drives the tests
+func TestMain(m *testing.M) {
+	fstest.TestMain(m)
+}
+
+// newTestOverlay makes a remote with some files and an overlay over it
+func newTestOverlay(t *testing.T) (r *fstest.Run, f *Fs, dir string) {
+	r = fstest.NewRun(t)
+	ctx := context.Background()
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := r.WriteObject(ctx, "file1", "file1 contents", t1)
+	file2 := r.WriteObject(ctx, "dir/file2", "file2 contents", t1)
+	r.CheckRemoteItems(t, file1, file2)
+	dir = t.TempDir()
+	f, err := NewFs(ctx, r.Fremote, dir)
+	require.NoError(t, err)
+	return r, f, dir
+}
+
+// listNames lists the names in dir of the overlay
+func listNames(t *testing.T, f fs.Fs, dir string) (names []string) {
+	entries, err := f.List(context.Background(), dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		names = append(names, entry.Remote())
+	}
+	return names
+}
+
+// put uploads contents to remote in f
+func put(t *testing.T, f fs.Fs, remote, contents string) fs.Object {
+	src := object.NewStaticObjectInfo(remote, time.Now(), int64(len(contents)), true, nil, nil)
+	o, err := f.Put(context.Background(), bytes.NewBufferString(contents), src)
+	require.NoError(t, err)
+	return o
+}
+
+// read reads the contents of remote in f
+func read(t *testing.T, f fs.Fs, remote string) string {
+	o, err := f.NewObject(context.Background(), remote)
+	require.NoError(t, err)
+	in, err := o.Open(context.Background())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, in.Close()) }()
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestOverlayReadWrite(t *testing.T) {
+	r, f, _ := newTestOverlay(t)
+	ctx := context.Background()
+	assert.ElementsMatch(t, []string{"file1", "dir"}, listNames(t, f, ""))
+
+	// Writes go to the upper layer only
+	put(t, f, "file1", "changed")
+	put(t, f, "dir/file3", "new")
+	assert.Equal(t, "changed", read(t, f, "file1"))
+	assert.Equal(t, "file1 contents", read(t, r.Fremote, "file1"))
+	assert.ElementsMatch(t, []string{"dir/file2", "dir/file3"}, listNames(t, f, "dir"))
+	_, err := r.Fremote.NewObject(ctx, "dir/file3")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	// Updating an object in the lower layer copies it up
+	o, err := f.NewObject(ctx, "dir/file2")
+	require.NoError(t, err)
+	assert.False(t, o.(*Object).upper)
+	src := object.NewStaticObjectInfo("dir/file2", time.Now(), 7, true, nil, nil)
+	require.NoError(t, o.Update(ctx, bytes.NewBufferString("updated"), src))
+	assert.Equal(t, "updated", read(t, f, "dir/file2"))
+	assert.Equal(t, "file2 contents", read(t, r.Fremote, "dir/file2"))
+
+	// Moving is done in the overlay
+	o, err = f.NewObject(ctx, "file1")
+	require.NoError(t, err)
+	_, err = f.Move(ctx, o, "file1-moved")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"file1-moved", "dir"}, listNames(t, f, ""))
+	assert.Equal(t, "file1 contents", read(t, r.Fremote, "file1"))
+}
+
+func TestOverlayWhiteouts(t *testing.T) {
+	r, f, dir := newTestOverlay(t)
+	ctx := context.Background()
+
+	// Removing a lower file hides it
+	o, err := f.NewObject(ctx, "file1")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	_, err = f.NewObject(ctx, "file1")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	assert.Equal(t, []string{"dir"}, listNames(t, f, ""))
+	assert.Equal(t, "file1 contents", read(t, r.Fremote, "file1"))
+
+	// A file can be made in its place
+	put(t, f, "file1", "again")
+	assert.Equal(t, "again", read(t, f, "file1"))
+
+	// Removing a lower directory hides everything in it
+	assert.Equal(t, fs.ErrorDirectoryNotEmpty, f.Rmdir(ctx, "dir"))
+	o, err = f.NewObject(ctx, "dir/file2")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	require.NoError(t, f.Rmdir(ctx, "dir"))
+	assert.Equal(t, []string{"file1"}, listNames(t, f, ""))
+	_, err = f.List(ctx, "dir")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+
+	// Remaking the directory doesn't bring back its contents
+	require.NoError(t, f.Mkdir(ctx, "dir"))
+	assert.Equal(t, []string(nil), listNames(t, f, "dir"))
+
+	// The whiteouts persist
+	f2, err := NewFs(ctx, r.Fremote, dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"file1": {}, "dir": {}}, f2.whiteouts)
+	changed, deleted, err := f2.Changes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	assert.Equal(t, 2, deleted)
+}
+
+func TestOverlayCommit(t *testing.T) {
+	r, f, _ := newTestOverlay(t)
+	ctx := context.Background()
+
+	put(t, f, "file3", "new")
+	o, err := f.NewObject(ctx, "dir/file2")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	require.NoError(t, f.Mkdir(ctx, "empty"))
+
+	require.NoError(t, f.Commit(ctx))
+	assert.ElementsMatch(t, []string{"file1", "file3", "dir", "empty"}, listNames(t, r.Fremote, ""))
+	assert.Equal(t, "new", read(t, r.Fremote, "file3"))
+	_, err = r.Fremote.NewObject(ctx, "dir/file2")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	// The overlay is empty afterwards
+	changed, deleted, err := f.Changes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, changed)
+	assert.Equal(t, 0, deleted)
+}
+
+// blockingFs is a remote whose Put waits for release to be closed
+// after saying it has started
+type blockingFs struct {
+	fs.Fs
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+// Features returns the optional features of this Fs - none so the
+// commit has to use Put
+func (f *blockingFs) Features() *fs.Features {
+	return (&fs.Features{}).Fill(context.Background(), f)
+}
+
+// Put waits until released then uploads to the wrapped Fs
+func (f *blockingFs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	f.once.Do(func() { close(f.started) })
+	<-f.release
+	return f.Fs.Put(ctx, in, src, options...)
+}
+
+func TestOverlayCommitConcurrent(t *testing.T) {
+	r, _, dir := newTestOverlay(t)
+	ctx := context.Background()
+	lower := &blockingFs{
+		Fs:      r.Fremote,
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	f, err := NewFs(ctx, lower, dir)
+	require.NoError(t, err)
+
+	put(t, f, "file3", "new")
+	put(t, f, "file4", "new")
+	o, err := f.NewObject(ctx, "dir/file2")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+
+	done := make(chan error)
+	go func() {
+		done <- f.Commit(ctx)
+	}()
+	<-lower.started
+
+	// The overlay can be used and changed while the commit is
+	// writing to the remote
+	assert.ElementsMatch(t, []string{"file1", "file3", "file4", "dir"}, listNames(t, f, ""))
+	put(t, f, "file3", "changed")
+	o, err = f.NewObject(ctx, "file4")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	close(lower.release)
+	require.NoError(t, <-done)
+
+	// The snapshot was committed
+	assert.Equal(t, "new", read(t, r.Fremote, "file3"))
+	assert.Equal(t, "new", read(t, r.Fremote, "file4"))
+	_, err = r.Fremote.NewObject(ctx, "dir/file2")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	// The changes made during the commit are kept
+	assert.ElementsMatch(t, []string{"file1", "file3", "dir"}, listNames(t, f, ""))
+	assert.Equal(t, "changed", read(t, f, "file3"))
+	changed, deleted, err := f.Changes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	assert.Equal(t, 1, deleted)
+}
+
+func TestOverlayDirMove(t *testing.T) {
+	r, f, _ := newTestOverlay(t)
+	ctx := context.Background()
+	put(t, f, "dir/file3", "new")
+	require.NoError(t, f.Mkdir(ctx, "dir/empty"))
+
+	assert.Equal(t, fs.ErrorDirExists, f.DirMove(ctx, f, "dir", "dir"))
+	require.NoError(t, f.DirMove(ctx, f, "dir", "moved"))
+	assert.ElementsMatch(t, []string{"file1", "moved"}, listNames(t, f, ""))
+	assert.ElementsMatch(t, []string{"moved/file2", "moved/file3", "moved/empty"}, listNames(t, f, "moved"))
+	assert.Equal(t, "file2 contents", read(t, f, "moved/file2"))
+	_, err := f.List(ctx, "dir")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+
+	// The remote isn't changed until the commit
+	assert.Equal(t, "file2 contents", read(t, r.Fremote, "dir/file2"))
+	assert.Equal(t, map[string]struct{}{"dir": {}}, f.whiteouts)
+	require.NoError(t, f.Commit(ctx))
+	assert.ElementsMatch(t, []string{"file1", "moved"}, listNames(t, r.Fremote, ""))
+	assert.ElementsMatch(t, []string{"moved/file2", "moved/file3", "moved/empty"}, listNames(t, r.Fremote, "moved"))
+}
+
+func TestOverlayDiscard(t *testing.T) {
+	r, f, _ := newTestOverlay(t)
+	ctx := context.Background()
+
+	put(t, f, "file1", "changed")
+	o, err := f.NewObject(ctx, "dir/file2")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+
+	require.NoError(t, f.Discard(ctx))
+	assert.Equal(t, "file1 contents", read(t, f, "file1"))
+	assert.Equal(t, "file2 contents", read(t, f, "dir/file2"))
+	assert.Equal(t, "file1 contents", read(t, r.Fremote, "file1"))
+}
@@ -66,9 +66,17 @@ func (fh *WriteFileHandle) openPending() (err error) {
 	}
 	var pipeReader *io.PipeReader
 	pipeReader, fh.pipeWriter = io.Pipe()
+	// Send any metadata set before the file was opened with the upload
+	ctx := context.TODO()
+	metadata := fh.file.takePendingMetadata()
+	if metadata != nil {
+		var ci *fs.ConfigInfo
+		ctx, ci = fs.AddConfig(ctx)
+		ci.Metadata = true
+	}
 	go func() {
 		// NB Rcat deals with Stats.Transferring, etc.
-		o, err := operations.Rcat(context.TODO(), fh.file.Fs(), fh.remote, pipeReader, time.Now(), nil)
+		o, err := operations.Rcat(ctx, fh.file.Fs(), fh.remote, pipeReader, time.Now(), metadata)
 		if err != nil {
 			fs.Errorf(fh.remote, "WriteFileHandle.New Rcat failed: %v", err)
 		}
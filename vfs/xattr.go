@@ -0,0 +1,154 @@
+package vfs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Extended attributes are used by the mounts to give access to
+// features of the VFS which don't fit into the normal file system
+// calls.
+//
+// The metadata of an object is shown with each key prefixed with
+// XattrPrefix. On backends which can write metadata the attributes
+// can be changed too. PinXattr controls pinning.
+
+// XattrPrefix is the prefix for extended attributes showing metadata
+const XattrPrefix = "user.rclone."
+
+// Listxattr returns the names of the extended attributes of node
+func Listxattr(node Node) (names []string, err error) {
+	if node.VFS().IsPinned(node.Path()) {
+		names = append(names, PinXattr)
+	}
+	metadata, err := nodeMetadata(node)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, XattrPrefix+k)
+	}
+	sort.Strings(keys)
+	return append(names, keys...), nil
+}
+
+// Getxattr returns the value of the extended attribute name of node
+//
+// It returns ENOATTR if the attribute isn't set
+func Getxattr(node Node, name string) (value []byte, err error) {
+	if name == PinXattr {
+		if !node.VFS().IsPinned(node.Path()) {
+			return nil, ENOATTR
+		}
+		return []byte("1"), nil
+	}
+	key, ok := xattrKey(name)
+	if !ok {
+		return nil, ENOATTR
+	}
+	metadata, err := nodeMetadata(node)
+	if err != nil {
+		return nil, err
+	}
+	v, found := metadata[key]
+	if !found {
+		return nil, ENOATTR
+	}
+	return []byte(v), nil
+}
+
+// Setxattr sets the extended attribute name of node to value
+//
+// It returns ENOTSUP if the attribute can't be set
+func Setxattr(node Node, name string, value []byte) error {
+	if name == PinXattr {
+		return translatePinError(node.VFS().PinInBackground(node.Path()))
+	}
+	key, ok := xattrKey(name)
+	file, isFile := node.(*File)
+	if !ok || !isFile {
+		return ENOTSUP
+	}
+	metadata, err := file.Metadata()
+	if err != nil {
+		return err
+	}
+	newMetadata := make(fs.Metadata, len(metadata)+1)
+	newMetadata.Merge(metadata)
+	newMetadata[key] = string(value)
+	return file.SetMetadata(newMetadata)
+}
+
+// Removexattr removes the extended attribute name from node
+//
+// It returns ENOATTR if the attribute isn't set
+func Removexattr(node Node, name string) error {
+	if name == PinXattr {
+		if !node.VFS().IsPinned(node.Path()) {
+			return ENOATTR
+		}
+		return translatePinError(node.VFS().Unpin(node.Path()))
+	}
+	key, ok := xattrKey(name)
+	file, isFile := node.(*File)
+	if !ok || !isFile {
+		return ENOATTR
+	}
+	metadata, err := file.Metadata()
+	if err != nil {
+		return err
+	}
+	if _, found := metadata[key]; !found {
+		return ENOATTR
+	}
+	newMetadata := make(fs.Metadata, len(metadata))
+	for k, v := range metadata {
+		if k != key {
+			newMetadata[k] = v
+		}
+	}
+	err = file.SetMetadata(newMetadata)
+	if err != nil {
+		return err
+	}
+	// Some backends merge the metadata on upload so can't remove
+	// keys - check it really went
+	metadata, err = file.Metadata()
+	if err != nil {
+		return err
+	}
+	if _, found := metadata[key]; found {
+		return ENOTSUP
+	}
+	return nil
+}
+
+// xattrKey returns the metadata key for the extended attribute name
+func xattrKey(name string) (key string, ok bool) {
+	if !strings.HasPrefix(name, XattrPrefix) {
+		return "", false
+	}
+	key = name[len(XattrPrefix):]
+	return key, key != ""
+}
+
+// nodeMetadata returns the metadata for node which may be nil
+func nodeMetadata(node Node) (fs.Metadata, error) {
+	file, ok := node.(*File)
+	if !ok {
+		return nil, nil
+	}
+	return file.Metadata()
+}
+
+// translatePinError turns errors from the pinning calls into errors
+// suitable for returning from the xattr calls
+func translatePinError(err error) error {
+	if err == ErrPinNeedsFullCache {
+		return ENOTSUP
+	}
+	return err
+}
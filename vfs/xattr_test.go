@@ -0,0 +1,138 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// remoteMetadata reads the metadata of remote directly from the backend
+func remoteMetadata(t *testing.T, f fs.Fs, remote string) fs.Metadata {
+	o, err := f.NewObject(context.Background(), remote)
+	require.NoError(t, err)
+	metadata, err := fs.GetMetadata(context.Background(), o)
+	require.NoError(t, err)
+	return metadata
+}
+
+func TestXattrMetadata(t *testing.T) {
+	r, vfs := newTestVFS(t)
+	if !r.Fremote.Features().UserMetadata {
+		t.Skip("Backend doesn't support user metadata")
+	}
+	ctx := context.Background()
+	r.WriteObject(ctx, "file1", "file1 contents", t1)
+
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+
+	// Non metadata attributes
+	_, err = Getxattr(node, "user.potato")
+	assert.Equal(t, ENOATTR, err)
+	assert.Equal(t, ENOTSUP, Setxattr(node, "user.potato", []byte("1")))
+	assert.Equal(t, ENOATTR, Removexattr(node, "user.potato"))
+
+	// Set an attribute
+	_, err = Getxattr(node, XattrPrefix+"potato")
+	assert.Equal(t, ENOATTR, err)
+	require.NoError(t, Setxattr(node, XattrPrefix+"potato", []byte("jersey royal")))
+	value, err := Getxattr(node, XattrPrefix+"potato")
+	require.NoError(t, err)
+	assert.Equal(t, "jersey royal", string(value))
+	names, err := Listxattr(node)
+	require.NoError(t, err)
+	assert.Contains(t, names, XattrPrefix+"potato")
+	assert.Equal(t, "jersey royal", remoteMetadata(t, r.Fremote, "file1")["potato"])
+
+	// The contents should be unchanged
+	data, err := vfs.ReadFile("file1")
+	require.NoError(t, err)
+	assert.Equal(t, "file1 contents", string(data))
+
+	// Remove it again - this isn't supported by backends which
+	// merge metadata on upload
+	err = Removexattr(node, XattrPrefix+"potato")
+	_, found := remoteMetadata(t, r.Fremote, "file1")["potato"]
+	if err == ENOTSUP {
+		assert.True(t, found)
+	} else {
+		require.NoError(t, err)
+		assert.False(t, found)
+		assert.Equal(t, ENOATTR, Removexattr(node, XattrPrefix+"potato"))
+		_, err = Getxattr(node, XattrPrefix+"potato")
+		assert.Equal(t, ENOATTR, err)
+	}
+
+	// Directories have no metadata
+	root, err := vfs.Root()
+	require.NoError(t, err)
+	assert.Equal(t, ENOTSUP, Setxattr(root, XattrPrefix+"potato", []byte("1")))
+}
+
+func TestXattrMetadataWhileWriting(t *testing.T) {
+	for _, cacheMode := range []vfscommon.CacheMode{vfscommon.CacheModeOff, vfscommon.CacheModeWrites} {
+		t.Run(cacheMode.String(), func(t *testing.T) {
+			opt := vfscommon.DefaultOpt
+			opt.CacheMode = cacheMode
+			r, vfs := newTestVFSOpt(t, &opt)
+			if !r.Fremote.Features().UserMetadata {
+				t.Skip("Backend doesn't support user metadata")
+			}
+
+			fh, err := vfs.OpenFile("file1", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
+			require.NoError(t, err)
+			_, err = fh.WriteString("hello")
+			require.NoError(t, err)
+
+			// Metadata set while writing is sent with the upload
+			require.NoError(t, Setxattr(fh.Node(), XattrPrefix+"potato", []byte("king edward")))
+			value, err := Getxattr(fh.Node(), XattrPrefix+"potato")
+			require.NoError(t, err)
+			assert.Equal(t, "king edward", string(value))
+			require.NoError(t, fh.Close())
+			vfs.WaitForWriters(waitForWritersDelay)
+
+			assert.Equal(t, "king edward", remoteMetadata(t, r.Fremote, "file1")["potato"])
+			data, err := vfs.ReadFile("file1")
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(data))
+		})
+	}
+}
+
+func TestXattrMetadataLargeFile(t *testing.T) {
+	r, vfs := newTestVFS(t)
+	if !r.Fremote.Features().UserMetadata {
+		t.Skip("Backend doesn't support user metadata")
+	}
+	ctx := context.Background()
+	r.WriteObject(ctx, "file1", strings.Repeat("x", maxMetadataUpdateSize+1), t1)
+
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+
+	// Large files aren't uploaded again just to set metadata, so
+	// setting it fails rather than the change being lost
+	assert.Equal(t, ENOTSUP, Setxattr(node, XattrPrefix+"potato", []byte("maris piper")))
+	_, found := remoteMetadata(t, r.Fremote, "file1")["potato"]
+	assert.False(t, found)
+	_, err = Getxattr(node, XattrPrefix+"potato")
+	assert.Equal(t, ENOATTR, err)
+
+	// but the metadata is sent with the upload if the file is open
+	// for writing
+	fh, err := vfs.OpenFile("file1", os.O_WRONLY|os.O_TRUNC, 0777)
+	require.NoError(t, err)
+	require.NoError(t, Setxattr(node, XattrPrefix+"potato", []byte("maris piper")))
+	_, err = fh.WriteString("hello")
+	require.NoError(t, err)
+	require.NoError(t, fh.Close())
+	vfs.WaitForWriters(waitForWritersDelay)
+	assert.Equal(t, "maris piper", remoteMetadata(t, r.Fremote, "file1")["potato"])
+}